@@ -2,14 +2,29 @@ package account
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/JonathanNithi/ecommerce/backend/account/pb"
+	"github.com/JonathanNithi/ecommerce/backend/audit"
+	"github.com/JonathanNithi/ecommerce/backend/authz"
+	"github.com/JonathanNithi/ecommerce/backend/migrate"
+	"github.com/JonathanNithi/ecommerce/backend/notification"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 // MockRepository for testing
@@ -47,45 +62,457 @@ func (m *MockRepository) ListAccounts(ctx context.Context, skip uint64, take uin
 	return args.Get(0).([]Account), args.Error(1)
 }
 
-func (m *MockRepository) UpdateAccountRole(ctx context.Context, id string, role string) (*Account, error) {
-	args := m.Called(ctx, id, role)
+func (m *MockRepository) UpdateAccountStatus(ctx context.Context, id string, status string, reason string) error {
+	args := m.Called(ctx, id, status, reason)
+	return args.Error(0)
+}
+
+func (m *MockRepository) PutDataRequest(ctx context.Context, d DataRequest) error {
+	args := m.Called(ctx, d)
+	return args.Error(0)
+}
+
+func (m *MockRepository) EraseAccount(ctx context.Context, a *Account, d DataRequest) error {
+	args := m.Called(ctx, a, d)
+	return args.Error(0)
+}
+
+func (m *MockRepository) SearchAccounts(ctx context.Context, f AccountFilter, afterID string, limit int) ([]Account, error) {
+	args := m.Called(ctx, f, afterID, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Account), args.Error(1)
+}
+
+func (m *MockRepository) CountAccounts(ctx context.Context, f AccountFilter) (int, error) {
+	args := m.Called(ctx, f)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockRepository) CreateRole(ctx context.Context, role Role) error {
+	args := m.Called(ctx, role)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GrantRole(ctx context.Context, accountID string, role string) error {
+	args := m.Called(ctx, accountID, role)
+	return args.Error(0)
+}
+
+func (m *MockRepository) RevokeRole(ctx context.Context, accountID string, role string) error {
+	args := m.Called(ctx, accountID, role)
+	return args.Error(0)
+}
+
+func (m *MockRepository) UpdatePasswordHash(ctx context.Context, email string, passwordHash string) (*Account, error) {
+	args := m.Called(ctx, email, passwordHash)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*Account), args.Error(1)
 }
 
-func (m *MockRepository) GetAccountByEmailAndName(ctx context.Context, firstName string, lastName string, email string) (*Account, error) {
-	args := m.Called(ctx, firstName, lastName, email)
+func (m *MockRepository) UpdateProfile(ctx context.Context, id string, firstName string, lastName string) (*Account, error) {
+	args := m.Called(ctx, id, firstName, lastName)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*Account), args.Error(1)
 }
 
-func (m *MockRepository) GetAccountById(ctx context.Context, id string) (*Account, error) {
+func (m *MockRepository) UpdateEmail(ctx context.Context, id string, email string) error {
+	args := m.Called(ctx, id, email)
+	return args.Error(0)
+}
+
+func (m *MockRepository) MarkEmailVerified(ctx context.Context, id string) error {
 	args := m.Called(ctx, id)
-	account, ok := args.Get(0).(*Account) // Use Get instead of Index
-	if !ok {
+	return args.Error(0)
+}
+
+func (m *MockRepository) PutRefreshToken(ctx context.Context, t RefreshToken) error {
+	args := m.Called(ctx, t)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetRefreshToken(ctx context.Context, id string) (*RefreshToken, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return account, args.Error(1)
+	return args.Get(0).(*RefreshToken), args.Error(1)
 }
 
-func (m *MockRepository) UpdatePasswordHash(ctx context.Context, email string, passwordHash string) (*Account, error) {
-	args := m.Called(ctx, email, passwordHash)
+func (m *MockRepository) RevokeRefreshToken(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockRepository) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	args := m.Called(ctx, familyID)
+	return args.Error(0)
+}
+
+func (m *MockRepository) RevokeRefreshTokensForAccount(ctx context.Context, accountID string) error {
+	args := m.Called(ctx, accountID)
+	return args.Error(0)
+}
+
+func (m *MockRepository) PutPasswordResetToken(ctx context.Context, t PasswordResetToken) error {
+	args := m.Called(ctx, t)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetPasswordResetToken(ctx context.Context, tokenHash string) (*PasswordResetToken, error) {
+	args := m.Called(ctx, tokenHash)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return args.Get(0).(*Account), args.Error(1)
+	return args.Get(0).(*PasswordResetToken), args.Error(1)
+}
+
+func (m *MockRepository) MarkPasswordResetTokenUsed(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockRepository) PutEmailChangeToken(ctx context.Context, t EmailChangeToken) error {
+	args := m.Called(ctx, t)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetEmailChangeToken(ctx context.Context, tokenHash string) (*EmailChangeToken, error) {
+	args := m.Called(ctx, tokenHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*EmailChangeToken), args.Error(1)
+}
+
+func (m *MockRepository) MarkEmailChangeTokenUsed(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockRepository) PutEmailVerificationToken(ctx context.Context, t EmailVerificationToken) error {
+	args := m.Called(ctx, t)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetEmailVerificationToken(ctx context.Context, tokenHash string) (*EmailVerificationToken, error) {
+	args := m.Called(ctx, tokenHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*EmailVerificationToken), args.Error(1)
+}
+
+func (m *MockRepository) MarkEmailVerificationTokenUsed(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockRepository) CountEmailVerificationTokensSince(ctx context.Context, accountID string, since time.Time) (int, error) {
+	args := m.Called(ctx, accountID, since)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockRepository) PutMfaSecret(ctx context.Context, accountID string, secret string) error {
+	args := m.Called(ctx, accountID, secret)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetMfaSecret(ctx context.Context, accountID string) (*MfaSecret, error) {
+	args := m.Called(ctx, accountID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*MfaSecret), args.Error(1)
+}
+
+func (m *MockRepository) ConfirmMfa(ctx context.Context, accountID string, step int64, recoveryCodeHashes []string) error {
+	args := m.Called(ctx, accountID, step, recoveryCodeHashes)
+	return args.Error(0)
+}
+
+func (m *MockRepository) UseMfaStep(ctx context.Context, accountID string, step int64) error {
+	args := m.Called(ctx, accountID, step)
+	return args.Error(0)
+}
+
+func (m *MockRepository) UseRecoveryCode(ctx context.Context, accountID string, codeHash string) error {
+	args := m.Called(ctx, accountID, codeHash)
+	return args.Error(0)
+}
+
+func (m *MockRepository) ListAddresses(ctx context.Context, accountID string) ([]Address, error) {
+	args := m.Called(ctx, accountID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Address), args.Error(1)
+}
+
+func (m *MockRepository) PutAddress(ctx context.Context, a Address) error {
+	args := m.Called(ctx, a)
+	return args.Error(0)
+}
+
+func (m *MockRepository) UpdateAddress(ctx context.Context, a Address) (*Address, error) {
+	args := m.Called(ctx, a)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*Address), args.Error(1)
+}
+
+func (m *MockRepository) DeleteAddress(ctx context.Context, accountID string, id string) error {
+	args := m.Called(ctx, accountID, id)
+	return args.Error(0)
+}
+
+func (m *MockRepository) SetDefaultAddress(ctx context.Context, accountID string, id string, usage string) error {
+	args := m.Called(ctx, accountID, id, usage)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetLoginThrottle(ctx context.Context, key string) (*LoginThrottle, error) {
+	args := m.Called(ctx, key)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*LoginThrottle), args.Error(1)
+}
+
+func (m *MockRepository) RecordLoginFailure(ctx context.Context, key string, windowStart time.Time) (int, error) {
+	args := m.Called(ctx, key, windowStart)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockRepository) LockLogin(ctx context.Context, key string, until time.Time) error {
+	args := m.Called(ctx, key, until)
+	return args.Error(0)
+}
+
+func (m *MockRepository) ResetLoginFailures(ctx context.Context, key string) error {
+	args := m.Called(ctx, key)
+	return args.Error(0)
+}
+
+func (m *MockRepository) PutAPIKey(ctx context.Context, k APIKey) error {
+	args := m.Called(ctx, k)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*APIKey), args.Error(1)
+}
+
+func (m *MockRepository) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	args := m.Called(ctx, hash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*APIKey), args.Error(1)
+}
+
+func (m *MockRepository) ListAPIKeys(ctx context.Context, accountID string) ([]APIKey, error) {
+	args := m.Called(ctx, accountID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]APIKey), args.Error(1)
+}
+
+func (m *MockRepository) RevokeAPIKey(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockRepository) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	args := m.Called(ctx, id, usedAt)
+	return args.Error(0)
 }
 
 func (m *MockRepository) Close() {
 }
 
+// MockNotifier for testing
+type MockNotifier struct {
+	mock.Mock
+}
+
+func (m *MockNotifier) Notify(ctx context.Context, to string, template string, data interface{}) error {
+	args := m.Called(ctx, to, template, data)
+	return args.Error(0)
+}
+
+// MockAuditRecorder for testing
+type MockAuditRecorder struct {
+	mock.Mock
+}
+
+func (m *MockAuditRecorder) Record(ctx context.Context, e audit.Event) error {
+	args := m.Called(ctx, e)
+	return args.Error(0)
+}
+
+// auditEvent matches the event recording action on target by the admin of adminContext
+func auditEvent(action string, target string) interface{} {
+	return mock.MatchedBy(func(e audit.Event) bool {
+		return e.Action == action && e.Target == target && e.Actor == "adminUser" && e.ID != ""
+	})
+}
+
+// memoryKeyStore keeps signing keys in memory, newest first like the Postgres store
+type memoryKeyStore struct {
+	keys []SigningKey
+}
+
+func (m *memoryKeyStore) ListSigningKeys(ctx context.Context) ([]SigningKey, error) {
+	return append([]SigningKey{}, m.keys...), nil
+}
+
+func (m *memoryKeyStore) RotateSigningKey(ctx context.Context, key SigningKey, staleBefore time.Time, retireAt time.Time) (bool, error) {
+	for i := range m.keys {
+		if m.keys[i].Status == KeyStatusActive {
+			if m.keys[i].CreatedAt.After(staleBefore) {
+				return false, nil
+			}
+			m.keys[i].Status = KeyStatusRetiring
+			m.keys[i].RetireAt = &retireAt
+		}
+	}
+	m.keys = append([]SigningKey{key}, m.keys...)
+	return true, nil
+}
+
+func (m *memoryKeyStore) DeleteRetiredSigningKeys(ctx context.Context, before time.Time) error {
+	kept := []SigningKey{}
+	for _, k := range m.keys {
+		if k.RetireAt == nil || !k.RetireAt.Before(before) {
+			kept = append(kept, k)
+		}
+	}
+	m.keys = kept
+	return nil
+}
+
+var testKeys = mustKeyRing(authz.AlgorithmEdDSA)
+
+// testVerification lets unverified accounts log in so login tests do not depend on verification
+var testVerification = VerificationPolicy{AllowUnverifiedLogin: true}
+
+// testMfa does not require MFA so admin tests can use password logins
+var testMfa = MfaPolicy{}
+
+// testPasswords keeps hashing fast and never rehashes the bcrypt hashes tests store
+var testPasswords = PasswordPolicy{Algorithm: PasswordHashBcrypt, BcryptCost: bcrypt.MinCost}
+
+func mustKeyRing(algorithm string) *KeyRing {
+	keys, err := NewKeyRing(context.Background(), &memoryKeyStore{}, algorithm)
+	if err != nil {
+		panic(err)
+	}
+	return keys
+}
+
+func TestKeyRing_SignsWithKid(t *testing.T) {
+	for _, algorithm := range []string{authz.AlgorithmEdDSA, authz.AlgorithmRS256} {
+		keys := mustKeyRing(algorithm)
+
+		token, err := keys.GenerateAccessToken("test@example.com", "user", nil)
+		assert.NoError(t, err)
+
+		parsed, _, err := jwt.NewParser().ParseUnverified(token, &Claims{})
+		assert.NoError(t, err)
+		assert.Equal(t, algorithm, parsed.Header["alg"])
+		assert.NotEmpty(t, parsed.Header["kid"])
+
+		claims, err := keys.ValidateToken(context.Background(), token)
+		assert.NoError(t, err)
+		assert.Equal(t, "test@example.com", claims.Username)
+	}
+}
+
+func TestKeyRing_RotateKeepsOldTokensValid(t *testing.T) {
+	ctx := context.Background()
+	keys := mustKeyRing(authz.AlgorithmEdDSA)
+
+	oldToken, _ := keys.GenerateAccessToken("test@example.com", "user", nil)
+
+	// A key younger than maxAge is kept
+	assert.NoError(t, keys.Rotate(ctx, time.Hour))
+	set, _ := keys.JWKS()
+	assert.Len(t, set.Keys, 1)
+
+	assert.NoError(t, keys.Rotate(ctx, 0))
+	set, _ = keys.JWKS()
+	assert.Len(t, set.Keys, 2)
+
+	newToken, _ := keys.GenerateAccessToken("test@example.com", "user", nil)
+	oldHeader, _, _ := jwt.NewParser().ParseUnverified(oldToken, &Claims{})
+	newHeader, _, _ := jwt.NewParser().ParseUnverified(newToken, &Claims{})
+	assert.NotEqual(t, oldHeader.Header["kid"], newHeader.Header["kid"])
+
+	// Services verifying through the published set accept tokens from both keys
+	verifier := authz.NewVerifier(set)
+	_, err := verifier.Verify(ctx, oldToken)
+	assert.NoError(t, err)
+	_, err = verifier.Verify(ctx, newToken)
+	assert.NoError(t, err)
+}
+
+func TestKeyRing_DropsExpiredRetiringKeys(t *testing.T) {
+	ctx := context.Background()
+	retired := time.Now().Add(-time.Minute)
+	old, _ := GenerateSigningKey(authz.AlgorithmEdDSA)
+	old.Status = KeyStatusRetiring
+	old.RetireAt = &retired
+	active, _ := GenerateSigningKey(authz.AlgorithmEdDSA)
+	keys, err := NewKeyRing(ctx, &memoryKeyStore{keys: []SigningKey{active, old}}, authz.AlgorithmEdDSA)
+	assert.NoError(t, err)
+
+	assert.NoError(t, keys.Rotate(ctx, time.Hour))
+
+	set, _ := keys.JWKS()
+	if assert.Len(t, set.Keys, 1) {
+		assert.Equal(t, active.ID, set.Keys[0].Kid)
+	}
+}
+
+func TestKeyRing_FileStore(t *testing.T) {
+	dir := t.TempDir()
+	for _, id := range []string{"current", "previous"} {
+		key, _ := GenerateSigningKey(authz.AlgorithmEdDSA)
+		encoded, _ := encodePrivateKey(key.PrivateKey)
+		if err := os.WriteFile(filepath.Join(dir, id+".pem"), []byte(encoded), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := NewKeyRing(context.Background(), NewFileKeyStore(dir, "current"), authz.AlgorithmEdDSA)
+	assert.NoError(t, err)
+
+	token, _ := keys.GenerateAccessToken("test@example.com", "user", nil)
+	parsed, _, _ := jwt.NewParser().ParseUnverified(token, &Claims{})
+	assert.Equal(t, "current", parsed.Header["kid"])
+	set, _ := keys.JWKS()
+	assert.Len(t, set.Keys, 2)
+
+	_, err = NewKeyRing(context.Background(), NewFileKeyStore(dir, "missing"), authz.AlgorithmEdDSA)
+	assert.ErrorIs(t, err, ErrReadOnlyKeyStore)
+}
+
 func TestAccountService_PostAccount_Success_NoHash(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	mockNotifier := new(MockNotifier)
+	service := NewService(mockRepo, mockNotifier, testKeys, testVerification, testMfa, testPasswords, nil, nil)
 	ctx := context.Background()
 
 	firstName := "John"
@@ -95,6 +522,9 @@ func TestAccountService_PostAccount_Success_NoHash(t *testing.T) {
 
 	// Expect PutAccount to be called with the unhashed password
 	mockRepo.On("PutAccount", ctx, mock.AnythingOfType("account.Account")).Return(nil)
+	mockRepo.On("CountEmailVerificationTokensSince", ctx, mock.Anything, mock.Anything).Return(0, nil).Once()
+	mockRepo.On("PutEmailVerificationToken", ctx, mock.AnythingOfType("account.EmailVerificationToken")).Return(nil).Once()
+	mockNotifier.On("Notify", ctx, email, notification.TemplateEmailVerification, mock.AnythingOfType("notification.EmailVerificationData")).Return(nil).Once()
 
 	account, err := service.PostAccount(ctx, firstName, lastName, email, password)
 
@@ -106,12 +536,14 @@ func TestAccountService_PostAccount_Success_NoHash(t *testing.T) {
 	assert.NotEmpty(t, account.PasswordHash)
 	assert.Equal(t, "user", account.Role)
 	assert.NotEmpty(t, account.ID)
+	assert.False(t, account.EmailVerified)
 	mockRepo.AssertExpectations(t)
+	mockNotifier.AssertExpectations(t)
 }
 
 func TestAccountService_PostAccount_HashingError(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
 	ctx := context.Background()
 
 	firstName := "Jane"
@@ -128,7 +560,7 @@ func TestAccountService_PostAccount_HashingError(t *testing.T) {
 
 func TestAccountService_PostAccount_RepositoryError(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
 	ctx := context.Background()
 
 	firstName := "Peter"
@@ -136,451 +568,2468 @@ func TestAccountService_PostAccount_RepositoryError(t *testing.T) {
 	email := "peter.jones@example.com"
 	password := "anotherSecurePassword"
 
-	mockRepo.On("PutAccount", ctx, mock.AnythingOfType("Account")).Return(errors.New("repository error")).Once()
+	mockRepo.On("PutAccount", ctx, mock.AnythingOfType("Account")).Return(errors.New("repository error")).Once()
+
+	account, err := service.PostAccount(ctx, firstName, lastName, email, password)
+
+	assert.Error(t, err)
+	assert.Nil(t, account)
+	assert.ErrorContains(t, err, "repository error")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_Login_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	email := "test@example.com"
+	password := "password123"
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	mockAccount := &Account{ID: "someID", Email: email, PasswordHash: string(hashedPassword), Role: "user"}
+
+	mockRepo.On("GetLoginThrottle", ctx, "email:test@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("GetLoginThrottle", ctx, "ip:10.0.0.1").Return(nil, ErrNotFound).Once()
+	mockRepo.On("GetAccountByEmail", ctx, email).Return(mockAccount, nil).Once()
+	mockRepo.On("ResetLoginFailures", ctx, "email:test@example.com").Return(nil).Once()
+	mockRepo.On("PutRefreshToken", ctx, mock.MatchedBy(func(rt RefreshToken) bool {
+		return rt.AccountID == mockAccount.ID && rt.ID != "" && rt.FamilyID != ""
+	})).Return(nil).Once()
+
+	res, err := service.Login(ctx, email, password, "10.0.0.1")
+
+	assert.NoError(t, err)
+	assert.NotNil(t, res.Account)
+	assert.NotEmpty(t, res.AccessToken)
+	assert.NotEmpty(t, res.RefreshToken)
+	assert.Empty(t, res.MfaChallengeToken)
+	assert.Equal(t, mockAccount.ID, res.Account.ID)
+	mockRepo.AssertExpectations(t)
+}
+
+// fastArgon2 is an Argon2id policy with parameters small enough for tests
+var fastArgon2 = PasswordPolicy{Algorithm: PasswordHashArgon2id, Argon2Time: 1, Argon2MemoryKiB: 1024, Argon2Threads: 1}
+
+func TestAccountService_Login_RehashesWeakHash(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, fastArgon2, nil, nil)
+	ctx := context.Background()
+
+	email := "test@example.com"
+	password := "correct horse battery"
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	mockAccount := &Account{ID: "someID", Email: email, PasswordHash: string(hashedPassword), Role: "user"}
+
+	mockRepo.On("GetLoginThrottle", ctx, "email:test@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("GetAccountByEmail", ctx, email).Return(mockAccount, nil).Once()
+	mockRepo.On("UpdatePasswordHash", ctx, email, mock.MatchedBy(func(hash string) bool {
+		ok, err := VerifyPassword(hash, password)
+		return ok && err == nil && strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$")
+	})).Return(mockAccount, nil).Once()
+	mockRepo.On("ResetLoginFailures", ctx, "email:test@example.com").Return(nil).Once()
+	mockRepo.On("PutRefreshToken", ctx, mock.Anything).Return(nil).Once()
+
+	_, err := service.Login(ctx, email, password, "")
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_Login_WrongPasswordDoesNotRehash(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, fastArgon2, nil, nil)
+	ctx := context.Background()
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("correct horse battery"), bcrypt.MinCost)
+	mockRepo.On("GetLoginThrottle", ctx, "email:test@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(&Account{ID: "someID", Email: "test@example.com", PasswordHash: string(hashedPassword)}, nil).Once()
+	mockRepo.On("RecordLoginFailure", ctx, "email:test@example.com", mock.Anything).Return(1, nil).Once()
+
+	_, err := service.Login(ctx, "test@example.com", "wrong horse battery", "")
+
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	mockRepo.AssertNotCalled(t, "UpdatePasswordHash", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestPasswordPolicy_HashAndVerify(t *testing.T) {
+	for _, policy := range []PasswordPolicy{fastArgon2, testPasswords} {
+		t.Run(policy.Algorithm, func(t *testing.T) {
+			hash, err := policy.Hash("correct horse battery")
+			assert.NoError(t, err)
+
+			ok, err := VerifyPassword(hash, "correct horse battery")
+			assert.NoError(t, err)
+			assert.True(t, ok)
+
+			ok, err = VerifyPassword(hash, "wrong horse battery")
+			assert.NoError(t, err)
+			assert.False(t, ok)
+			assert.False(t, policy.NeedsRehash(hash))
+		})
+	}
+
+	_, err := VerifyPassword("plaintext", "plaintext")
+	assert.ErrorIs(t, err, ErrUnknownPasswordHash)
+}
+
+func TestPasswordPolicy_NeedsRehash(t *testing.T) {
+	bcryptMin, _ := testPasswords.Hash("correct horse battery")
+	argon2Fast, _ := fastArgon2.Hash("correct horse battery")
+
+	assert.True(t, PasswordPolicy{Algorithm: PasswordHashBcrypt, BcryptCost: bcrypt.MinCost + 1}.NeedsRehash(bcryptMin))
+	assert.True(t, fastArgon2.NeedsRehash(bcryptMin))
+	assert.True(t, testPasswords.NeedsRehash(argon2Fast))
+	assert.True(t, PasswordPolicy{Algorithm: PasswordHashArgon2id, Argon2Time: 2, Argon2MemoryKiB: 1024, Argon2Threads: 1}.NeedsRehash(argon2Fast))
+	assert.False(t, PasswordPolicy{Algorithm: PasswordHashArgon2id, Argon2Time: 1, Argon2MemoryKiB: 512, Argon2Threads: 1}.NeedsRehash(argon2Fast))
+	assert.True(t, testPasswords.NeedsRehash("not a hash"))
+}
+
+func TestPasswordPolicy_Check(t *testing.T) {
+	policy := PasswordPolicy{}
+
+	assert.EqualError(t, policy.Check("short"), "password must be at least 8 characters")
+	assert.ErrorIs(t, policy.Check("Password123"), ErrCommonPassword)
+	assert.ErrorIs(t, policy.Check("QWERTYUIOP"), ErrCommonPassword)
+	assert.NoError(t, policy.Check("correct horse battery"))
+	assert.EqualError(t, PasswordPolicy{MinLength: 12}.Check("tenletters"), "password must be at least 12 characters")
+
+	// A custom checker replaces the defaults
+	policy.Checker = func(password string) error {
+		if !strings.ContainsAny(password, "0123456789") {
+			return errors.New("password must contain a digit")
+		}
+		return nil
+	}
+	assert.NoError(t, policy.Check("passw0rd"))
+	assert.EqualError(t, policy.Check("correct horse battery"), "password must contain a digit")
+}
+
+func TestAccountService_PostAccount_CommonPassword(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+
+	account, err := service.PostAccount(context.Background(), "John", "Doe", "john.doe@example.com", "iloveyou123")
+
+	assert.Nil(t, account)
+	assert.ErrorIs(t, err, ErrCommonPassword)
+	mockRepo.AssertNotCalled(t, "PutAccount", mock.Anything, mock.Anything)
+}
+
+func TestAccountService_Login_AccountNotFound(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	email := "test@example.com"
+	password := "password123"
+
+	mockRepo.On("GetLoginThrottle", ctx, "email:test@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("GetAccountByEmail", ctx, email).Return(nil, errors.New("account not found")).Once()
+	mockRepo.On("RecordLoginFailure", ctx, "email:test@example.com", mock.Anything).Return(1, nil).Once()
+
+	res, err := service.Login(ctx, email, password, "")
+
+	assert.Error(t, err)
+	assert.Nil(t, res)
+	// Unknown emails get the same error as wrong passwords
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_Login_InvalidPassword(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	email := "test@example.com"
+	password := "wrongpassword"
+	correctPassword := "correctpassword"
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(correctPassword), bcrypt.DefaultCost)
+	mockAccount := &Account{ID: "someID", Email: email, PasswordHash: string(hashedPassword), Role: "user"}
+
+	mockRepo.On("GetLoginThrottle", ctx, mock.Anything).Return(nil, ErrNotFound).Twice()
+	mockRepo.On("GetAccountByEmail", ctx, email).Return(mockAccount, nil).Once()
+	mockRepo.On("RecordLoginFailure", ctx, "email:test@example.com", mock.Anything).Return(1, nil).Once()
+	mockRepo.On("RecordLoginFailure", ctx, "ip:10.0.0.1", mock.Anything).Return(1, nil).Once()
+
+	res, err := service.Login(ctx, email, password, "10.0.0.1")
+
+	assert.Error(t, err)
+	assert.Nil(t, res)
+	assert.ErrorContains(t, err, "invalid email or password")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_Login_LocksAccountAtThreshold(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	email := "test@example.com"
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("correctpassword"), bcrypt.DefaultCost)
+	mockAccount := &Account{ID: "someID", Email: email, PasswordHash: string(hashedPassword), Role: "user"}
+
+	mockRepo.On("GetLoginThrottle", ctx, "email:test@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("GetAccountByEmail", ctx, email).Return(mockAccount, nil).Once()
+	mockRepo.On("RecordLoginFailure", ctx, "email:test@example.com", mock.Anything).Return(accountLockThreshold, nil).Once()
+	mockRepo.On("LockLogin", ctx, "email:test@example.com", mock.MatchedBy(func(until time.Time) bool {
+		return until.After(time.Now().Add(loginLockoutDuration - time.Minute))
+	})).Return(nil).Once()
+
+	_, err := service.Login(ctx, email, "wrongpassword", "")
+
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_Login_LockedOut(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	lockedUntil := time.Now().Add(10 * time.Minute)
+	throttle := &LoginThrottle{Key: "email:test@example.com", Failures: accountLockThreshold, LastFailureAt: time.Now(), LockedUntil: &lockedUntil}
+	mockRepo.On("GetLoginThrottle", ctx, "email:test@example.com").Return(throttle, nil).Once()
+
+	res, err := service.Login(ctx, "test@example.com", "password123", "")
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+	mockRepo.AssertNotCalled(t, "GetAccountByEmail", mock.Anything, mock.Anything)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_Login_ProgressiveDelay(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	// The fourth failure a moment ago means the client must wait two seconds
+	throttle := &LoginThrottle{Key: "ip:10.0.0.1", Failures: 4, LastFailureAt: time.Now()}
+	mockRepo.On("GetLoginThrottle", ctx, "email:test@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("GetLoginThrottle", ctx, "ip:10.0.0.1").Return(throttle, nil).Once()
+
+	_, err := service.Login(ctx, "test@example.com", "password123", "10.0.0.1")
+
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+	mockRepo.AssertNotCalled(t, "GetAccountByEmail", mock.Anything, mock.Anything)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestLoginDelay(t *testing.T) {
+	assert.Equal(t, time.Duration(0), loginDelay(loginDelayAfter-1))
+	assert.Equal(t, loginBaseDelay, loginDelay(loginDelayAfter))
+	assert.Equal(t, 2*loginBaseDelay, loginDelay(loginDelayAfter+1))
+	assert.Equal(t, loginMaxDelay, loginDelay(accountLockThreshold*2))
+}
+
+func TestAccountService_GetAccount_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	// Test data
+	accountID := "testID"
+	mockAccount := &Account{ID: accountID, Email: "test@example.com"}
+
+	// Mock the repository call to return the mock account
+	mockRepo.On("GetAccountByID", ctx, accountID).Return(mockAccount, nil).Once()
+
+	// Call the service method under test
+	account, err := service.GetAccount(ctx, accountID)
+
+	// Assertions
+	assert.NoError(t, err)
+	assert.NotNil(t, account)
+	assert.Equal(t, mockAccount.ID, account.ID)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_GetAccount_Unauthenticated(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	account, err := service.GetAccount(ctx, "testID")
+
+	assert.Error(t, err)
+	assert.Nil(t, account)
+	assert.ErrorIs(t, err, authz.ErrUnauthenticated)
+	mockRepo.AssertNotCalled(t, "GetAccountByID", mock.Anything, mock.Anything)
+}
+
+func TestAccountService_GetAccount_OtherAccount(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	otherAccount := &Account{ID: "otherID", Email: "other@example.com"}
+	mockRepo.On("GetAccountByID", ctx, "otherID").Return(otherAccount, nil).Once()
+
+	account, err := service.GetAccount(ctx, "otherID")
+
+	assert.Nil(t, account)
+	assert.ErrorIs(t, err, authz.ErrUnauthorized)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_GetAccount_OtherAccountAsAdmin(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := adminContext()
+
+	otherAccount := &Account{ID: "otherID", Email: "other@example.com"}
+	mockRepo.On("GetAccountByID", ctx, "otherID").Return(otherAccount, nil).Once()
+
+	account, err := service.GetAccount(ctx, "otherID")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "otherID", account.ID)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_GetAccount_AccountNotFound(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	accountID := "testID"
+
+	mockRepo.On("GetAccountByID", ctx, accountID).Return(nil, errors.New("account not found")).Once()
+
+	account, err := service.GetAccount(ctx, accountID)
+
+	assert.Error(t, err)
+	assert.Nil(t, account)
+	assert.ErrorContains(t, err, "account not found")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_GetAccounts_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := adminContext()
+
+	skip := uint64(0)
+	take := uint64(10)
+	mockAccounts := []Account{{ID: "1"}, {ID: "2"}}
+
+	mockRepo.On("ListAccounts", ctx, skip, take).Return(mockAccounts, nil).Once()
+
+	accounts, err := service.GetAccounts(ctx, skip, take)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, accounts)
+	assert.Len(t, accounts, 2)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_GetAccounts_Unauthorized(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	skip := uint64(0)
+	take := uint64(10)
+
+	accounts, err := service.GetAccounts(ctx, skip, take)
+
+	assert.Error(t, err)
+	assert.Nil(t, accounts)
+	assert.ErrorContains(t, err, "unauthorized")
+	mockRepo.AssertNotCalled(t, "ListAccounts", mock.Anything, mock.Anything)
+}
+
+func TestAccountService_GetAccounts_RepositoryError(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := adminContext()
+
+	skip := uint64(0)
+	take := uint64(10)
+
+	mockRepo.On("ListAccounts", ctx, skip, take).Return(nil, errors.New("repository error")).Once()
+
+	accounts, err := service.GetAccounts(ctx, skip, take)
+
+	assert.Error(t, err)
+	assert.Nil(t, accounts)
+	assert.ErrorContains(t, err, "repository error")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_SetAccountAsAdmin_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	auditLog := new(MockAuditRecorder)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, auditLog)
+	ctx := authz.ContextWithRequestID(adminContext(), "req-1")
+
+	accountID := "testID"
+	updatedAccount := &Account{ID: accountID, Role: "admin", Roles: []string{"admin", "user"}}
+
+	mockRepo.On("GetAccountByID", ctx, accountID).Return(&Account{ID: accountID, Role: "user", Roles: []string{"user"}}, nil).Once()
+	mockRepo.On("GrantRole", ctx, accountID, "admin").Return(nil).Once()
+	mockRepo.On("GetAccountByID", ctx, accountID).Return(updatedAccount, nil).Once()
+	auditLog.On("Record", ctx, mock.MatchedBy(func(e audit.Event) bool {
+		return e.Action == AuditGrantRole && e.Target == accountID && e.Actor == "adminUser" && e.RequestID == "req-1" &&
+			string(e.Before) == `{"roles":["user"]}` && string(e.After) == `{"roles":["admin","user"]}`
+	})).Return(nil).Once()
+
+	account, err := service.SetAccountAsAdmin(ctx, accountID)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, account)
+	assert.Equal(t, "admin", account.Role)
+	mockRepo.AssertExpectations(t)
+	auditLog.AssertExpectations(t)
+}
+
+func TestAccountService_SetAccountAsAdmin_AuditFailure(t *testing.T) {
+	mockRepo := new(MockRepository)
+	auditLog := new(MockAuditRecorder)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, auditLog)
+	ctx := adminContext()
+
+	mockRepo.On("GetAccountByID", ctx, "testID").Return(&Account{ID: "testID", Roles: []string{"user"}}, nil)
+	mockRepo.On("GrantRole", ctx, "testID", "admin").Return(nil).Once()
+	auditLog.On("Record", ctx, auditEvent(AuditGrantRole, "testID")).Return(errors.New("audit log unavailable")).Once()
+
+	account, err := service.SetAccountAsAdmin(ctx, "testID")
+
+	// The caller must learn the change went unrecorded
+	assert.Nil(t, account)
+	assert.ErrorContains(t, err, "audit log unavailable")
+}
+
+func TestAccountService_SetAccountAsAdmin_Unauthorized(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	accountID := "testID"
+
+	account, err := service.SetAccountAsAdmin(ctx, accountID)
+
+	assert.Error(t, err)
+	assert.Nil(t, account)
+	assert.ErrorContains(t, err, "unauthorized")
+	mockRepo.AssertNotCalled(t, "GrantRole", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAccountService_SetAccountAsAdmin_RepositoryError(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := adminContext()
+
+	accountID := "testID"
+
+	mockRepo.On("GetAccountByID", ctx, accountID).Return(&Account{ID: accountID}, nil).Once()
+	mockRepo.On("GrantRole", ctx, accountID, "admin").Return(errors.New("repository error")).Once()
+
+	account, err := service.SetAccountAsAdmin(ctx, accountID)
+
+	assert.Error(t, err)
+	assert.Nil(t, account)
+	assert.ErrorContains(t, err, "repository error")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_DemoteAdmin_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	auditLog := new(MockAuditRecorder)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, auditLog)
+	ctx := adminContext()
+
+	accountID := "testID"
+	adminAccount := &Account{ID: accountID, Email: "other@example.com", Role: "admin", Roles: []string{"admin", "user"}}
+	demotedAccount := &Account{ID: accountID, Email: "other@example.com", Role: "user", Roles: []string{"user"}}
+
+	mockRepo.On("GetAccountByID", ctx, accountID).Return(adminAccount, nil).Once()
+	mockRepo.On("RevokeRole", ctx, accountID, "admin").Return(nil).Once()
+	mockRepo.On("GetAccountByID", ctx, accountID).Return(demotedAccount, nil).Once()
+	auditLog.On("Record", ctx, auditEvent(AuditRevokeRole, accountID)).Return(nil).Once()
+
+	account, err := service.DemoteAdmin(ctx, accountID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "user", account.Role)
+	mockRepo.AssertExpectations(t)
+	auditLog.AssertExpectations(t)
+}
+
+func TestAccountService_DemoteAdmin_Self(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := adminContext()
+
+	accountID := "adminID"
+	self := &Account{ID: accountID, Email: "adminUser", Role: "admin", Roles: []string{"admin"}}
+
+	mockRepo.On("GetAccountByID", ctx, accountID).Return(self, nil).Once()
+
+	account, err := service.DemoteAdmin(ctx, accountID)
+
+	assert.Nil(t, account)
+	assert.ErrorContains(t, err, "cannot demote themselves")
+	mockRepo.AssertNotCalled(t, "RevokeRole", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAccountService_CreateRole_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	auditLog := new(MockAuditRecorder)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, auditLog)
+	ctx := adminContext()
+
+	permissions := []string{authz.CatalogWrite, authz.InventoryAdjust}
+
+	mockRepo.On("CreateRole", ctx, Role{Name: "merchandiser", Permissions: permissions}).Return(nil).Once()
+	auditLog.On("Record", ctx, auditEvent(AuditCreateRole, "merchandiser")).Return(nil).Once()
+
+	role, err := service.CreateRole(ctx, "merchandiser", permissions)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "merchandiser", role.Name)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_CreateRole_UnknownPermission(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := adminContext()
+
+	role, err := service.CreateRole(ctx, "merchandiser", []string{"catalog:everything"})
+
+	assert.Nil(t, role)
+	assert.ErrorContains(t, err, "unknown permission")
+	mockRepo.AssertNotCalled(t, "CreateRole", mock.Anything, mock.Anything)
+}
+
+func TestAccountService_GrantRole_MissingPermission(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	// Holds catalog:write but not roles:manage
+	ctx := authz.ContextWithClaims(context.Background(), &Claims{Username: "merchandiser", Role: "user", Permissions: []string{authz.CatalogWrite}})
+
+	account, err := service.GrantRole(ctx, "testID", "admin")
+
+	assert.Nil(t, account)
+	assert.ErrorIs(t, err, authz.ErrUnauthorized)
+	mockRepo.AssertNotCalled(t, "GrantRole", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAccountService_GrantRole_UnknownRole(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := adminContext()
+
+	mockRepo.On("GetAccountByID", ctx, "testID").Return(&Account{ID: "testID"}, nil).Once()
+	mockRepo.On("GrantRole", ctx, "testID", "nope").Return(ErrNotFound).Once()
+
+	account, err := service.GrantRole(ctx, "testID", "nope")
+
+	assert.Nil(t, account)
+	assert.ErrorContains(t, err, "account or role not found")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_UnlockAccount_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	auditLog := new(MockAuditRecorder)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, auditLog)
+	ctx := adminContext()
+
+	accountID := "testID"
+	lockedAccount := &Account{ID: accountID, Email: "Locked@Example.com", Role: "user"}
+
+	mockRepo.On("GetAccountByID", ctx, accountID).Return(lockedAccount, nil).Once()
+	mockRepo.On("ResetLoginFailures", ctx, "email:locked@example.com").Return(nil).Once()
+	auditLog.On("Record", ctx, auditEvent(AuditUnlock, accountID)).Return(nil).Once()
+
+	account, err := service.UnlockAccount(ctx, accountID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, accountID, account.ID)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_UnlockAccount_Unauthorized(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	account, err := service.UnlockAccount(ctx, "testID")
+
+	assert.Nil(t, account)
+	assert.ErrorContains(t, err, "unauthorized")
+	mockRepo.AssertNotCalled(t, "ResetLoginFailures", mock.Anything, mock.Anything)
+}
+
+func TestAccountService_ForgotPassword_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockNotifier := new(MockNotifier)
+	service := NewService(mockRepo, mockNotifier, testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	email := "test@example.com"
+	mockAccount := &Account{ID: "someID", Email: email}
+	var sentToken string
+
+	mockRepo.On("GetAccountByEmail", ctx, email).Return(mockAccount, nil).Once()
+	mockRepo.On("PutPasswordResetToken", ctx, mock.MatchedBy(func(rt PasswordResetToken) bool {
+		return rt.AccountID == mockAccount.ID && len(rt.TokenHash) == 64 && rt.ExpiresAt.After(time.Now())
+	})).Return(nil).Once()
+	mockNotifier.On("Notify", ctx, email, notification.TemplatePasswordReset, mock.AnythingOfType("notification.PasswordResetData")).Run(func(args mock.Arguments) {
+		sentToken = args.Get(3).(notification.PasswordResetData).Token
+	}).Return(nil).Once()
+
+	err := service.ForgotPassword(ctx, email)
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, sentToken)
+	// Only the hash of the token may be persisted
+	stored := mockRepo.Calls[1].Arguments.Get(1).(PasswordResetToken)
+	assert.NotEqual(t, sentToken, stored.TokenHash)
+	assert.Equal(t, hashResetToken(sentToken), stored.TokenHash)
+	mockRepo.AssertExpectations(t)
+	mockNotifier.AssertExpectations(t)
+}
+
+func TestAccountService_ForgotPassword_UnknownEmail(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockNotifier := new(MockNotifier)
+	service := NewService(mockRepo, mockNotifier, testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	email := "unknown@example.com"
+
+	mockRepo.On("GetAccountByEmail", ctx, email).Return(nil, errors.New("sql: no rows in result set")).Once()
+
+	err := service.ForgotPassword(ctx, email)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "PutPasswordResetToken", mock.Anything, mock.Anything)
+	mockNotifier.AssertNotCalled(t, "Notify", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAccountService_ForgotPassword_NotifierError(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockNotifier := new(MockNotifier)
+	service := NewService(mockRepo, mockNotifier, testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	email := "test@example.com"
+
+	mockRepo.On("GetAccountByEmail", ctx, email).Return(&Account{ID: "someID", Email: email}, nil).Once()
+	mockRepo.On("PutPasswordResetToken", ctx, mock.AnythingOfType("account.PasswordResetToken")).Return(nil).Once()
+	mockNotifier.On("Notify", ctx, email, notification.TemplatePasswordReset, mock.Anything).Return(errors.New("smtp unavailable")).Once()
+
+	err := service.ForgotPassword(ctx, email)
+
+	// Same result as for an unknown email
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockNotifier.AssertExpectations(t)
+}
+
+func TestAccountService_ResetPassword_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	accountID := "testID"
+	email := "test@example.com"
+	token := "resetToken"
+	newPassword := "newSecurePassword"
+	storedToken := &PasswordResetToken{ID: "tokenID", AccountID: accountID, TokenHash: hashResetToken(token), ExpiresAt: time.Now().Add(time.Hour)}
+	mockAccount := &Account{ID: accountID, Email: email}
+
+	mockRepo.On("GetPasswordResetToken", ctx, hashResetToken(token)).Return(storedToken, nil).Once()
+	mockRepo.On("GetAccountByID", ctx, accountID).Return(mockAccount, nil).Once()
+	mockRepo.On("MarkPasswordResetTokenUsed", ctx, "tokenID").Return(nil).Once()
+	mockRepo.On("UpdatePasswordHash", ctx, email, mock.MatchedBy(func(hash string) bool {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(newPassword)) == nil
+	})).Return(mockAccount, nil).Once()
+	mockRepo.On("RevokeRefreshTokensForAccount", ctx, accountID).Return(nil).Once()
+
+	err := service.ResetPassword(ctx, token, newPassword)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_ResetPassword_InvalidToken(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	token := "unknownToken"
+	newPassword := "newSecurePassword"
+
+	mockRepo.On("GetPasswordResetToken", ctx, hashResetToken(token)).Return(nil, errors.New("sql: no rows in result set")).Once()
+
+	err := service.ResetPassword(ctx, token, newPassword)
+
+	assert.ErrorIs(t, err, ErrInvalidResetToken)
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "UpdatePasswordHash", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAccountService_ResetPassword_ExpiredToken(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	token := "expiredToken"
+	newPassword := "newSecurePassword"
+	storedToken := &PasswordResetToken{ID: "tokenID", AccountID: "testID", TokenHash: hashResetToken(token), ExpiresAt: time.Now().Add(-time.Minute)}
+
+	mockRepo.On("GetPasswordResetToken", ctx, hashResetToken(token)).Return(storedToken, nil).Once()
+
+	err := service.ResetPassword(ctx, token, newPassword)
+
+	assert.ErrorIs(t, err, ErrInvalidResetToken)
+	mockRepo.AssertNotCalled(t, "MarkPasswordResetTokenUsed", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "UpdatePasswordHash", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAccountService_ResetPassword_TokenAlreadyUsed(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	token := "usedToken"
+	newPassword := "newSecurePassword"
+	usedAt := time.Now().Add(-time.Minute)
+	storedToken := &PasswordResetToken{ID: "tokenID", AccountID: "testID", TokenHash: hashResetToken(token), ExpiresAt: time.Now().Add(time.Hour), UsedAt: &usedAt}
+
+	mockRepo.On("GetPasswordResetToken", ctx, hashResetToken(token)).Return(storedToken, nil).Once()
+
+	err := service.ResetPassword(ctx, token, newPassword)
+
+	assert.ErrorIs(t, err, ErrInvalidResetToken)
+	mockRepo.AssertNotCalled(t, "UpdatePasswordHash", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAccountService_ResetPassword_WeakPassword(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	err := service.ResetPassword(ctx, "resetToken", "short")
+
+	assert.Error(t, err)
+	assert.ErrorContains(t, err, "password must be at least 8 characters")
+	mockRepo.AssertNotCalled(t, "GetPasswordResetToken", mock.Anything, mock.Anything)
+}
+
+func TestAccountService_ResetPassword_UpdateError(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	accountID := "testID"
+	email := "test@example.com"
+	token := "resetToken"
+	newPassword := "newSecurePassword"
+	storedToken := &PasswordResetToken{ID: "tokenID", AccountID: accountID, TokenHash: hashResetToken(token), ExpiresAt: time.Now().Add(time.Hour)}
+	mockAccount := &Account{ID: accountID, Email: email}
+
+	mockRepo.On("GetPasswordResetToken", ctx, hashResetToken(token)).Return(storedToken, nil).Once()
+	mockRepo.On("GetAccountByID", ctx, accountID).Return(mockAccount, nil).Once()
+	mockRepo.On("MarkPasswordResetTokenUsed", ctx, "tokenID").Return(nil).Once()
+	mockRepo.On("UpdatePasswordHash", ctx, email, mock.AnythingOfType("string")).Return(nil, errors.New("update failed")).Once()
+
+	err := service.ResetPassword(ctx, token, newPassword)
+
+	assert.Error(t, err)
+	assert.ErrorContains(t, err, "update failed")
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "RevokeRefreshTokensForAccount", mock.Anything, mock.Anything)
+}
+
+func TestAccountService_RefreshToken_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	username := "testuser"
+	role := "user"
+	refreshToken, _ := testKeys.GenerateRefreshToken(username, role, "refreshTokenID")
+	storedToken := &RefreshToken{ID: "refreshTokenID", AccountID: "testID", FamilyID: "familyID", ExpiresAt: time.Now().Add(time.Hour)}
+
+	mockAccount := &Account{ID: "testID", Email: username, Role: role, Permissions: []string{authz.CatalogWrite}}
+
+	mockRepo.On("GetRefreshToken", ctx, "refreshTokenID").Return(storedToken, nil).Once()
+	mockRepo.On("GetAccountByID", ctx, "testID").Return(mockAccount, nil).Once()
+	mockRepo.On("RevokeRefreshToken", ctx, "refreshTokenID").Return(nil).Once()
+	mockRepo.On("PutRefreshToken", ctx, mock.MatchedBy(func(rt RefreshToken) bool {
+		return rt.AccountID == "testID" && rt.FamilyID == "familyID" && rt.ID != "refreshTokenID"
+	})).Return(nil).Once()
+
+	accessToken, newRefreshToken, err := service.RefreshToken(ctx, refreshToken)
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, accessToken)
+	assert.NotEmpty(t, newRefreshToken)
+	assert.NotEqual(t, refreshToken, newRefreshToken)
+	mockRepo.AssertExpectations(t)
+
+	// Verify the claims of the new access token
+	claims, err := testKeys.ValidateToken(ctx, accessToken)
+
+	assert.NoError(t, err) // Ensure the token is valid
+
+	if assert.NotNil(t, claims) {
+		assert.Equal(t, username, claims.Username)
+		assert.Equal(t, role, claims.Role)
+		assert.Equal(t, []string{authz.CatalogWrite}, claims.Permissions) // Permissions come from the account, not the old token
+		assert.True(t, claims.ExpiresAt.Time.After(time.Now()))           // Ensure it's a new token with future expiration
+	}
+}
+
+func TestAccountService_RefreshToken_InvalidToken(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	invalidRefreshToken := "invalid.refresh.token"
+
+	accessToken, newRefreshToken, err := service.RefreshToken(ctx, invalidRefreshToken)
+
+	assert.Error(t, err)
+	assert.Empty(t, accessToken)
+	assert.Empty(t, newRefreshToken)
+	assert.ErrorContains(t, err, "invalid refresh token")
+}
+
+// Refresh and access tokens are signed by the same keys, only their audience tells them apart
+func TestAccountService_RefreshToken_TokenTypes(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	refreshToken := generateValidRefreshToken("refreshTokenID")
+	_, err := testKeys.ValidateToken(ctx, refreshToken)
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+
+	accessToken, _ := testKeys.GenerateAccessToken("testUser", "user", nil)
+	_, _, err = service.RefreshToken(ctx, accessToken)
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+	err = service.Logout(ctx, accessToken)
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+	mockRepo.AssertNotCalled(t, "GetRefreshToken", mock.Anything, mock.Anything)
+}
+
+func TestAccountService_RefreshToken_ReuseRevokesFamily(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	revokedAt := time.Now().Add(-time.Minute)
+	refreshToken := generateValidRefreshToken("rotatedTokenID")
+	storedToken := &RefreshToken{ID: "rotatedTokenID", AccountID: "testID", FamilyID: "familyID", ExpiresAt: time.Now().Add(time.Hour), RevokedAt: &revokedAt}
+
+	mockRepo.On("GetRefreshToken", ctx, "rotatedTokenID").Return(storedToken, nil).Once()
+	mockRepo.On("RevokeRefreshTokenFamily", ctx, "familyID").Return(nil).Once()
+
+	accessToken, newRefreshToken, err := service.RefreshToken(ctx, refreshToken)
+
+	assert.Error(t, err)
+	assert.Empty(t, accessToken)
+	assert.Empty(t, newRefreshToken)
+	assert.ErrorIs(t, err, ErrRefreshTokenReused)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_RefreshToken_UnknownToken(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	refreshToken := generateValidRefreshToken("unknownTokenID")
+
+	mockRepo.On("GetRefreshToken", ctx, "unknownTokenID").Return(nil, errors.New("sql: no rows in result set")).Once()
+
+	accessToken, newRefreshToken, err := service.RefreshToken(ctx, refreshToken)
+
+	assert.Error(t, err)
+	assert.Empty(t, accessToken)
+	assert.Empty(t, newRefreshToken)
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	mockRepo.AssertNotCalled(t, "RevokeRefreshTokenFamily", mock.Anything, mock.Anything)
+}
+
+func TestAccountService_Logout_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	refreshToken := generateValidRefreshToken("currentTokenID")
+	storedToken := &RefreshToken{ID: "currentTokenID", AccountID: "testID", FamilyID: "familyID", ExpiresAt: time.Now().Add(time.Hour)}
+
+	mockRepo.On("GetRefreshToken", ctx, "currentTokenID").Return(storedToken, nil).Once()
+	mockRepo.On("RevokeRefreshTokenFamily", ctx, "familyID").Return(nil).Once()
+
+	err := service.Logout(ctx, refreshToken)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_Logout_InvalidToken(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	err := service.Logout(ctx, "invalid.refresh.token")
+
+	assert.Error(t, err)
+	assert.ErrorContains(t, err, "invalid refresh token")
+	mockRepo.AssertNotCalled(t, "RevokeRefreshTokenFamily", mock.Anything, mock.Anything)
+}
+
+func TestAccountService_RevokeAllSessions_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	mockAccount := &Account{ID: "testID", Email: "test@example.com"}
+
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(mockAccount, nil).Once()
+	mockRepo.On("RevokeRefreshTokensForAccount", ctx, "testID").Return(nil).Once()
+
+	err := service.RevokeAllSessions(ctx)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_RevokeAllSessions_Unauthenticated(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	err := service.RevokeAllSessions(ctx)
+
+	assert.ErrorIs(t, err, authz.ErrUnauthenticated)
+	mockRepo.AssertNotCalled(t, "RevokeRefreshTokensForAccount", mock.Anything, mock.Anything)
+}
+
+func TestAccountService_UpdateProfile_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	mockAccount := &Account{ID: "testID", Email: "test@example.com"}
+	updated := &Account{ID: "testID", FirstName: "Jane", LastName: "Doe", Email: "test@example.com"}
+
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(mockAccount, nil).Once()
+	mockRepo.On("UpdateProfile", ctx, "testID", "Jane", "Doe").Return(updated, nil).Once()
+
+	account, err := service.UpdateProfile(ctx, " Jane ", "Doe")
+
+	assert.NoError(t, err)
+	assert.Equal(t, updated, account)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_UpdateProfile_EmptyName(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(&Account{ID: "testID", Email: "test@example.com"}, nil).Once()
+
+	account, err := service.UpdateProfile(ctx, "Jane", " ")
+
+	assert.Nil(t, account)
+	assert.EqualError(t, err, "last name cannot be empty")
+	mockRepo.AssertNotCalled(t, "UpdateProfile", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAccountService_UpdateProfile_Unauthenticated(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+
+	account, err := service.UpdateProfile(context.Background(), "Jane", "Doe")
+
+	assert.Nil(t, account)
+	assert.ErrorIs(t, err, authz.ErrUnauthenticated)
+}
+
+func TestAccountService_ChangeEmail_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockNotifier := new(MockNotifier)
+	service := NewService(mockRepo, mockNotifier, testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	passwordHash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+	mockAccount := &Account{ID: "testID", FirstName: "Jane", Email: "test@example.com", PasswordHash: string(passwordHash)}
+	var sentToken string
+
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(mockAccount, nil).Once()
+	mockRepo.On("GetLoginThrottle", ctx, "email:test@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("GetAccountByEmail", ctx, "new@example.com").Return(nil, errors.New("sql: no rows in result set")).Once()
+	mockRepo.On("PutEmailChangeToken", ctx, mock.MatchedBy(func(et EmailChangeToken) bool {
+		return et.AccountID == "testID" && et.NewEmail == "new@example.com" && len(et.TokenHash) == 64 && et.ExpiresAt.After(time.Now())
+	})).Return(nil).Once()
+	mockNotifier.On("Notify", ctx, "new@example.com", notification.TemplateEmailChange, mock.AnythingOfType("notification.EmailChangeData")).Run(func(args mock.Arguments) {
+		sentToken = args.Get(3).(notification.EmailChangeData).Token
+	}).Return(nil).Once()
+
+	err := service.ChangeEmail(ctx, "new@example.com", "password123")
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, sentToken)
+	// The email itself only changes once the token is confirmed
+	mockRepo.AssertNotCalled(t, "UpdateEmail", mock.Anything, mock.Anything, mock.Anything)
+	mockRepo.AssertExpectations(t)
+	mockNotifier.AssertExpectations(t)
+}
+
+func TestAccountService_ChangeEmail_WrongPassword(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockNotifier := new(MockNotifier)
+	service := NewService(mockRepo, mockNotifier, testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	passwordHash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+	mockAccount := &Account{ID: "testID", Email: "test@example.com", PasswordHash: string(passwordHash)}
+
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(mockAccount, nil).Once()
+	mockRepo.On("GetLoginThrottle", ctx, "email:test@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("RecordLoginFailure", ctx, "email:test@example.com", mock.Anything).Return(1, nil).Once()
+
+	err := service.ChangeEmail(ctx, "new@example.com", "wrongPassword")
+
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "PutEmailChangeToken", mock.Anything, mock.Anything)
+	mockNotifier.AssertNotCalled(t, "Notify", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAccountService_ChangeEmail_Taken(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	passwordHash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+	mockAccount := &Account{ID: "testID", Email: "test@example.com", PasswordHash: string(passwordHash)}
+
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(mockAccount, nil).Once()
+	mockRepo.On("GetLoginThrottle", ctx, "email:test@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("GetAccountByEmail", ctx, "other@example.com").Return(&Account{ID: "otherID"}, nil).Once()
+
+	err := service.ChangeEmail(ctx, "other@example.com", "password123")
+
+	assert.ErrorIs(t, err, ErrEmailTaken)
+	mockRepo.AssertNotCalled(t, "PutEmailChangeToken", mock.Anything, mock.Anything)
+}
+
+func TestAccountService_ConfirmEmailChange_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	token := "changeToken"
+	storedToken := &EmailChangeToken{ID: "tokenID", AccountID: "testID", NewEmail: "new@example.com", TokenHash: hashResetToken(token), ExpiresAt: time.Now().Add(time.Hour)}
+	updated := &Account{ID: "testID", Email: "new@example.com"}
+
+	mockRepo.On("GetEmailChangeToken", ctx, hashResetToken(token)).Return(storedToken, nil).Once()
+	mockRepo.On("GetAccountByEmail", ctx, "new@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("MarkEmailChangeTokenUsed", ctx, "tokenID").Return(nil).Once()
+	mockRepo.On("UpdateEmail", ctx, "testID", "new@example.com").Return(nil).Once()
+	mockRepo.On("RevokeRefreshTokensForAccount", ctx, "testID").Return(nil).Once()
+	mockRepo.On("GetAccountByID", ctx, "testID").Return(updated, nil).Once()
+
+	account, err := service.ConfirmEmailChange(ctx, token)
+
+	assert.NoError(t, err)
+	assert.Equal(t, updated, account)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_ConfirmEmailChange_ExpiredToken(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	token := "changeToken"
+	storedToken := &EmailChangeToken{ID: "tokenID", AccountID: "testID", NewEmail: "new@example.com", TokenHash: hashResetToken(token), ExpiresAt: time.Now().Add(-time.Minute)}
+
+	mockRepo.On("GetEmailChangeToken", ctx, hashResetToken(token)).Return(storedToken, nil).Once()
+
+	account, err := service.ConfirmEmailChange(ctx, token)
+
+	assert.Nil(t, account)
+	assert.ErrorIs(t, err, ErrInvalidEmailChangeToken)
+	mockRepo.AssertNotCalled(t, "UpdateEmail", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAccountService_ConfirmEmailChange_EmailTaken(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	token := "changeToken"
+	storedToken := &EmailChangeToken{ID: "tokenID", AccountID: "testID", NewEmail: "new@example.com", TokenHash: hashResetToken(token), ExpiresAt: time.Now().Add(time.Hour)}
+
+	mockRepo.On("GetEmailChangeToken", ctx, hashResetToken(token)).Return(storedToken, nil).Once()
+	mockRepo.On("GetAccountByEmail", ctx, "new@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("MarkEmailChangeTokenUsed", ctx, "tokenID").Return(nil).Once()
+	mockRepo.On("UpdateEmail", ctx, "testID", "new@example.com").Return(ErrEmailTaken).Once()
+
+	account, err := service.ConfirmEmailChange(ctx, token)
+
+	assert.Nil(t, account)
+	assert.ErrorIs(t, err, ErrEmailTaken)
+	mockRepo.AssertNotCalled(t, "RevokeRefreshTokensForAccount", mock.Anything, mock.Anything)
+}
+
+func TestAccountService_ConfirmEmailChange_EmailRegisteredSinceRequest(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	token := "changeToken"
+	storedToken := &EmailChangeToken{ID: "tokenID", AccountID: "testID", NewEmail: "new@example.com", TokenHash: hashResetToken(token), ExpiresAt: time.Now().Add(time.Hour)}
+
+	mockRepo.On("GetEmailChangeToken", ctx, hashResetToken(token)).Return(storedToken, nil).Once()
+	mockRepo.On("GetAccountByEmail", ctx, "new@example.com").Return(&Account{ID: "otherID"}, nil).Once()
+
+	account, err := service.ConfirmEmailChange(ctx, token)
+
+	assert.Nil(t, account)
+	assert.ErrorIs(t, err, ErrEmailTaken)
+	mockRepo.AssertNotCalled(t, "MarkEmailChangeTokenUsed", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "UpdateEmail", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAccountService_ChangePassword_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	passwordHash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+	mockAccount := &Account{ID: "testID", Email: "test@example.com", PasswordHash: string(passwordHash)}
+
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(mockAccount, nil).Once()
+	mockRepo.On("GetLoginThrottle", ctx, "email:test@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("UpdatePasswordHash", ctx, "test@example.com", mock.MatchedBy(func(hash string) bool {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte("newPassword456")) == nil
+	})).Return(mockAccount, nil).Once()
+	mockRepo.On("RevokeRefreshTokensForAccount", ctx, "testID").Return(nil).Once()
+
+	err := service.ChangePassword(ctx, "password123", "newPassword456")
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_ChangePassword_WrongCurrentPassword(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	passwordHash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+	mockAccount := &Account{ID: "testID", Email: "test@example.com", PasswordHash: string(passwordHash)}
+
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(mockAccount, nil).Once()
+	mockRepo.On("GetLoginThrottle", ctx, "email:test@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("RecordLoginFailure", ctx, "email:test@example.com", mock.Anything).Return(1, nil).Once()
+
+	err := service.ChangePassword(ctx, "wrongPassword", "newPassword456")
+
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "UpdatePasswordHash", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAccountService_ChangePassword_LockedOut(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	lockedUntil := time.Now().Add(time.Minute)
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(&Account{ID: "testID", Email: "test@example.com"}, nil).Once()
+	mockRepo.On("GetLoginThrottle", ctx, "email:test@example.com").Return(&LoginThrottle{Key: "email:test@example.com", Failures: 10, LastFailureAt: time.Now(), LockedUntil: &lockedUntil}, nil).Once()
+
+	err := service.ChangePassword(ctx, "password123", "newPassword456")
+
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+	mockRepo.AssertNotCalled(t, "UpdatePasswordHash", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAccountService_Login_UnverifiedEmailBlocked(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, VerificationPolicy{AllowUnverifiedLogin: false}, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.DefaultCost)
+	mockAccount := &Account{ID: "someID", Email: "test@example.com", PasswordHash: string(hashedPassword), Role: "user"}
+
+	mockRepo.On("GetLoginThrottle", ctx, "email:test@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(mockAccount, nil).Once()
+
+	res, err := service.Login(ctx, "test@example.com", "password123", "")
+
+	assert.ErrorIs(t, err, ErrEmailNotVerified)
+	assert.Nil(t, res)
+	mockRepo.AssertNotCalled(t, "PutRefreshToken", mock.Anything, mock.Anything)
+}
+
+func TestVerificationPolicy(t *testing.T) {
+	unverified := &Account{}
+	verified := &Account{EmailVerified: true}
+	strict := VerificationPolicy{}
+
+	assert.False(t, strict.CanLogin(unverified))
+	assert.False(t, strict.CanOrder(unverified))
+	assert.True(t, strict.CanLogin(verified))
+	assert.True(t, strict.CanOrder(verified))
+	assert.True(t, VerificationPolicy{AllowUnverifiedOrders: true}.CanOrder(unverified))
+}
+
+func TestAccountService_VerifyEmail_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	token := "verifyToken"
+	storedToken := &EmailVerificationToken{ID: "tokenID", AccountID: "testID", TokenHash: hashResetToken(token), ExpiresAt: time.Now().Add(time.Hour)}
+	verified := &Account{ID: "testID", Email: "test@example.com", EmailVerified: true}
+
+	mockRepo.On("GetEmailVerificationToken", ctx, hashResetToken(token)).Return(storedToken, nil).Once()
+	mockRepo.On("MarkEmailVerificationTokenUsed", ctx, "tokenID").Return(nil).Once()
+	mockRepo.On("MarkEmailVerified", ctx, "testID").Return(nil).Once()
+	mockRepo.On("GetAccountByID", ctx, "testID").Return(verified, nil).Once()
+
+	account, err := service.VerifyEmail(ctx, token)
+
+	assert.NoError(t, err)
+	assert.True(t, account.EmailVerified)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_VerifyEmail_TokenAlreadyUsed(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	token := "verifyToken"
+	storedToken := &EmailVerificationToken{ID: "tokenID", AccountID: "testID", TokenHash: hashResetToken(token), ExpiresAt: time.Now().Add(time.Hour)}
+
+	mockRepo.On("GetEmailVerificationToken", ctx, hashResetToken(token)).Return(storedToken, nil).Once()
+	mockRepo.On("MarkEmailVerificationTokenUsed", ctx, "tokenID").Return(ErrNotFound).Once()
+
+	account, err := service.VerifyEmail(ctx, token)
+
+	assert.Nil(t, account)
+	assert.ErrorIs(t, err, ErrInvalidVerificationToken)
+	mockRepo.AssertNotCalled(t, "MarkEmailVerified", mock.Anything, mock.Anything)
+}
+
+func TestAccountService_VerifyEmail_UnknownToken(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	mockRepo.On("GetEmailVerificationToken", ctx, hashResetToken("bogus")).Return(nil, errors.New("sql: no rows in result set")).Once()
+
+	account, err := service.VerifyEmail(ctx, "bogus")
+
+	assert.Nil(t, account)
+	assert.ErrorIs(t, err, ErrInvalidVerificationToken)
+}
+
+func TestAccountService_ResendVerificationEmail_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockNotifier := new(MockNotifier)
+	service := NewService(mockRepo, mockNotifier, testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	mockAccount := &Account{ID: "testID", FirstName: "Jane", Email: "test@example.com"}
+	var sentToken string
+
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(mockAccount, nil).Once()
+	mockRepo.On("CountEmailVerificationTokensSince", ctx, "testID", mock.MatchedBy(func(since time.Time) bool {
+		return since.Before(time.Now().Add(-verificationEmailWindow + time.Minute))
+	})).Return(1, nil).Once()
+	mockRepo.On("PutEmailVerificationToken", ctx, mock.MatchedBy(func(vt EmailVerificationToken) bool {
+		return vt.AccountID == "testID" && len(vt.TokenHash) == 64 && vt.ExpiresAt.After(time.Now())
+	})).Return(nil).Once()
+	mockNotifier.On("Notify", ctx, "test@example.com", notification.TemplateEmailVerification, mock.AnythingOfType("notification.EmailVerificationData")).Run(func(args mock.Arguments) {
+		sentToken = args.Get(3).(notification.EmailVerificationData).Token
+	}).Return(nil).Once()
+
+	err := service.ResendVerificationEmail(ctx, "test@example.com")
+
+	assert.NoError(t, err)
+	stored := mockRepo.Calls[2].Arguments.Get(1).(EmailVerificationToken)
+	assert.Equal(t, hashResetToken(sentToken), stored.TokenHash)
+	mockRepo.AssertExpectations(t)
+	mockNotifier.AssertExpectations(t)
+}
+
+func TestAccountService_ResendVerificationEmail_Throttled(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockNotifier := new(MockNotifier)
+	service := NewService(mockRepo, mockNotifier, testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(&Account{ID: "testID", Email: "test@example.com"}, nil).Once()
+	mockRepo.On("CountEmailVerificationTokensSince", ctx, "testID", mock.Anything).Return(verificationEmailLimit, nil).Once()
+
+	err := service.ResendVerificationEmail(ctx, "test@example.com")
+
+	assert.ErrorIs(t, err, ErrTooManyVerificationEmails)
+	mockRepo.AssertNotCalled(t, "PutEmailVerificationToken", mock.Anything, mock.Anything)
+	mockNotifier.AssertNotCalled(t, "Notify", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAccountService_ResendVerificationEmail_AlreadyVerified(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockNotifier := new(MockNotifier)
+	service := NewService(mockRepo, mockNotifier, testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(&Account{ID: "testID", Email: "test@example.com", EmailVerified: true}, nil).Once()
+
+	err := service.ResendVerificationEmail(ctx, "test@example.com")
+
+	assert.NoError(t, err)
+	mockRepo.AssertNotCalled(t, "CountEmailVerificationTokensSince", mock.Anything, mock.Anything, mock.Anything)
+	mockNotifier.AssertNotCalled(t, "Notify", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestTotpCode_RFC6238Vectors(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	// The RFC lists 8 digit codes, the 6 digit codes are their last digits
+	assert.Equal(t, "287082", totpCode(secret, totpStep(time.Unix(59, 0))))
+	assert.Equal(t, "081804", totpCode(secret, totpStep(time.Unix(1111111109, 0))))
+	assert.Equal(t, "005924", totpCode(secret, totpStep(time.Unix(1234567890, 0))))
+}
+
+func TestMatchTOTP_AllowsClockDrift(t *testing.T) {
+	secret, err := generateMfaSecret()
+	assert.NoError(t, err)
+	key, _ := base32NoPadding.DecodeString(secret)
+	now := time.Now()
+
+	step, ok := matchTOTP(secret, totpCode(key, totpStep(now)-1), now)
+	assert.True(t, ok)
+	assert.Equal(t, totpStep(now)-1, step)
+
+	_, ok = matchTOTP(secret, totpCode(key, totpStep(now)-3), now)
+	assert.False(t, ok)
+}
+
+func TestRecoveryCodes_HashIgnoresFormatting(t *testing.T) {
+	codes, err := generateRecoveryCodes()
+
+	assert.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+	assert.Regexp(t, `^[a-z2-7]{5}-[a-z2-7]{5}$`, codes[0])
+	assert.Equal(t, hashRecoveryCode(codes[0]), hashRecoveryCode(strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))))
+}
+
+// mfaAccount returns an account with MFA enabled together with a secret and its current code
+func mfaAccount(t *testing.T) (*Account, *MfaSecret, string) {
+	secret, err := generateMfaSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := base32NoPadding.DecodeString(secret)
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	account := &Account{ID: "adminID", Email: "admin@example.com", PasswordHash: string(hashedPassword), Role: RoleAdmin, Permissions: authz.All, MfaEnabled: true}
+	confirmedAt := time.Now()
+	return account, &MfaSecret{AccountID: "adminID", Secret: secret, ConfirmedAt: &confirmedAt}, totpCode(key, totpStep(time.Now()))
+}
+
+func TestAccountService_Login_MfaReturnsChallenge(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	account, _, _ := mfaAccount(t)
+	mockRepo.On("GetLoginThrottle", ctx, "email:admin@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("GetAccountByEmail", ctx, "admin@example.com").Return(account, nil).Once()
+
+	res, err := service.Login(ctx, "admin@example.com", "password123", "")
+
+	assert.NoError(t, err)
+	assert.Nil(t, res.Account)
+	assert.Empty(t, res.AccessToken)
+	assert.Empty(t, res.RefreshToken)
+	assert.NotEmpty(t, res.MfaChallengeToken)
+	// The challenge must not work as an access token
+	_, err = testKeys.Verifier().Verify(ctx, res.MfaChallengeToken)
+	assert.Error(t, err)
+	mockRepo.AssertNotCalled(t, "ResetLoginFailures", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "PutRefreshToken", mock.Anything, mock.Anything)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_CompleteMfaLogin_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, MfaPolicy{RequireForPrivileged: true}, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	account, secret, code := mfaAccount(t)
+	challenge, _ := testKeys.GenerateMfaChallengeToken(account.ID, account.Email)
+
+	mockRepo.On("GetLoginThrottle", ctx, "email:admin@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("GetAccountByID", ctx, "adminID").Return(account, nil).Once()
+	mockRepo.On("GetMfaSecret", ctx, "adminID").Return(secret, nil).Once()
+	mockRepo.On("UseMfaStep", ctx, "adminID", totpStep(time.Now())).Return(nil).Once()
+	mockRepo.On("ResetLoginFailures", ctx, "email:admin@example.com").Return(nil).Once()
+	mockRepo.On("PutRefreshToken", ctx, mock.AnythingOfType("account.RefreshToken")).Return(nil).Once()
+
+	res, err := service.CompleteMfaLogin(ctx, challenge, code)
+
+	assert.NoError(t, err)
+	assert.Equal(t, account, res.Account)
+	claims, err := testKeys.ValidateToken(ctx, res.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, claims.Can(authz.RolesManage))
+	assert.NotEmpty(t, res.RefreshToken)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_CompleteMfaLogin_RecoveryCode(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	account, _, _ := mfaAccount(t)
+	challenge, _ := testKeys.GenerateMfaChallengeToken(account.ID, account.Email)
+
+	mockRepo.On("GetLoginThrottle", ctx, "email:admin@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("GetAccountByID", ctx, "adminID").Return(account, nil).Once()
+	mockRepo.On("UseRecoveryCode", ctx, "adminID", hashRecoveryCode("abcde-fghij")).Return(nil).Once()
+	mockRepo.On("ResetLoginFailures", ctx, "email:admin@example.com").Return(nil).Once()
+	mockRepo.On("PutRefreshToken", ctx, mock.AnythingOfType("account.RefreshToken")).Return(nil).Once()
+
+	res, err := service.CompleteMfaLogin(ctx, challenge, "abcde-fghij")
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, res.AccessToken)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_CompleteMfaLogin_ReplayedCode(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	account, secret, code := mfaAccount(t)
+	challenge, _ := testKeys.GenerateMfaChallengeToken(account.ID, account.Email)
+
+	mockRepo.On("GetLoginThrottle", ctx, "email:admin@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("GetAccountByID", ctx, "adminID").Return(account, nil).Once()
+	mockRepo.On("GetMfaSecret", ctx, "adminID").Return(secret, nil).Once()
+	mockRepo.On("UseMfaStep", ctx, "adminID", mock.Anything).Return(ErrNotFound).Once()
+	mockRepo.On("RecordLoginFailure", ctx, "email:admin@example.com", mock.Anything).Return(1, nil).Once()
+
+	res, err := service.CompleteMfaLogin(ctx, challenge, code)
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, ErrInvalidMfaCode)
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "PutRefreshToken", mock.Anything, mock.Anything)
+}
+
+func TestAccountService_CompleteMfaLogin_RejectsAccessToken(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	accessToken, _ := testKeys.GenerateAccessToken("admin@example.com", RoleAdmin, authz.All)
+
+	res, err := service.CompleteMfaLogin(ctx, accessToken, "123456")
+
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, ErrInvalidChallenge)
+	mockRepo.AssertNotCalled(t, "GetAccountByID", mock.Anything, mock.Anything)
+}
+
+func TestAccountService_Login_PrivilegedWithoutMfaGetsNoPermissions(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, MfaPolicy{RequireForPrivileged: true}, testPasswords, nil, nil)
+	ctx := context.Background()
+
+	account, _, _ := mfaAccount(t)
+	account.MfaEnabled = false
+	mockRepo.On("GetLoginThrottle", ctx, "email:admin@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("GetAccountByEmail", ctx, "admin@example.com").Return(account, nil).Once()
+	mockRepo.On("ResetLoginFailures", ctx, "email:admin@example.com").Return(nil).Once()
+	mockRepo.On("PutRefreshToken", ctx, mock.AnythingOfType("account.RefreshToken")).Return(nil).Once()
+
+	res, err := service.Login(ctx, "admin@example.com", "password123", "")
+
+	assert.NoError(t, err)
+	claims, err := testKeys.ValidateToken(ctx, res.AccessToken)
+	assert.NoError(t, err)
+	assert.Empty(t, claims.Permissions)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_EnrollMfa_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(&Account{ID: "testID", Email: "test@example.com"}, nil).Once()
+	mockRepo.On("PutMfaSecret", ctx, "testID", mock.AnythingOfType("string")).Return(nil).Once()
+
+	secret, uri, err := service.EnrollMfa(ctx)
+
+	assert.NoError(t, err)
+	assert.Len(t, secret, 32)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Ecommerce:test@example.com?"))
+	assert.Contains(t, uri, "secret="+secret)
+	assert.Contains(t, uri, "issuer=Ecommerce")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_EnrollMfa_AlreadyEnabled(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(&Account{ID: "testID", Email: "test@example.com", MfaEnabled: true}, nil).Once()
+
+	_, _, err := service.EnrollMfa(ctx)
+
+	assert.ErrorIs(t, err, ErrMfaAlreadyEnabled)
+	mockRepo.AssertNotCalled(t, "PutMfaSecret", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAccountService_ConfirmMfa_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	_, secret, code := mfaAccount(t)
+	secret.ConfirmedAt = nil
+	var storedHashes []string
+
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(&Account{ID: "testID", Email: "test@example.com"}, nil).Once()
+	mockRepo.On("GetMfaSecret", ctx, "testID").Return(secret, nil).Once()
+	mockRepo.On("ConfirmMfa", ctx, "testID", totpStep(time.Now()), mock.Anything).Run(func(args mock.Arguments) {
+		storedHashes = args.Get(3).([]string)
+	}).Return(nil).Once()
+	mockRepo.On("RevokeRefreshTokensForAccount", ctx, "testID").Return(nil).Once()
+
+	codes, err := service.ConfirmMfa(ctx, code)
+
+	assert.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+	// Only hashes of the recovery codes are stored
+	assert.Equal(t, hashRecoveryCode(codes[0]), storedHashes[0])
+	assert.NotContains(t, storedHashes, codes[0])
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_ConfirmMfa_InvalidCode(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	_, secret, _ := mfaAccount(t)
+	secret.ConfirmedAt = nil
+
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(&Account{ID: "testID", Email: "test@example.com"}, nil).Once()
+	mockRepo.On("GetMfaSecret", ctx, "testID").Return(secret, nil).Once()
+
+	codes, err := service.ConfirmMfa(ctx, "000000x")
+
+	assert.Nil(t, codes)
+	assert.ErrorIs(t, err, ErrInvalidMfaCode)
+	mockRepo.AssertNotCalled(t, "ConfirmMfa", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestValidateAddress(t *testing.T) {
+	valid := func() Address {
+		return Address{FullName: "Jane Doe", Line1: "1 Main St", City: "Springfield", Region: "IL", PostalCode: "62701", Country: "us"}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(a *Address)
+		err    string
+	}{
+		{"valid US address", func(a *Address) {}, ""},
+		{"US ZIP+4", func(a *Address) { a.PostalCode = "62701-1234" }, ""},
+		{"missing name", func(a *Address) { a.FullName = "  " }, "full name cannot be empty"},
+		{"missing line 1", func(a *Address) { a.Line1 = "" }, "address line 1 cannot be empty"},
+		{"missing city", func(a *Address) { a.City = "" }, "city cannot be empty"},
+		{"invalid country", func(a *Address) { a.Country = "USA" }, "country must be a two letter ISO 3166-1 code"},
+		{"US needs a state", func(a *Address) { a.Region = "" }, "region is required for addresses in US"},
+		{"invalid US ZIP", func(a *Address) { a.PostalCode = "6270" }, "invalid postal code for US"},
+		{"GB postcode lower case", func(a *Address) { a.Country = "GB"; a.Region = ""; a.PostalCode = "sw1a 1aa" }, ""},
+		{"GB without postcode", func(a *Address) { a.Country = "GB"; a.PostalCode = "" }, "invalid postal code for GB"},
+		{"HK has no postal codes", func(a *Address) { a.Country = "HK"; a.PostalCode = "12345" }, "addresses in HK have no postal code"},
+		{"unknown country accepts any postal code", func(a *Address) { a.Country = "BR"; a.Region = ""; a.PostalCode = "" }, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := valid()
+			tt.modify(&a)
+			normalizeAddress(&a)
+			err := validateAddress(&a)
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestAccountService_AddAddress_FirstBecomesDefault(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(&Account{ID: "testID", Email: "test@example.com"}, nil).Once()
+	mockRepo.On("ListAddresses", ctx, "testID").Return([]Address{}, nil).Once()
+	mockRepo.On("PutAddress", ctx, mock.MatchedBy(func(a Address) bool {
+		return a.AccountID == "testID" && a.Country == "GB" && a.PostalCode == "SW1A 1AA" && a.DefaultShipping && a.DefaultBilling
+	})).Return(nil).Once()
+
+	a, err := service.AddAddress(ctx, Address{FullName: " Jane Doe ", Line1: "10 Downing St", City: "London", PostalCode: "sw1a 1aa", Country: "gb"})
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, a.ID)
+	assert.Equal(t, "Jane Doe", a.FullName)
+	assert.True(t, a.DefaultShipping)
+	assert.True(t, a.DefaultBilling)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_AddAddress_NotDefaultWhenOthersExist(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(&Account{ID: "testID", Email: "test@example.com"}, nil).Once()
+	mockRepo.On("ListAddresses", ctx, "testID").Return([]Address{{ID: "a1", DefaultShipping: true, DefaultBilling: true}}, nil).Once()
+	mockRepo.On("PutAddress", ctx, mock.MatchedBy(func(a Address) bool {
+		return !a.DefaultShipping && !a.DefaultBilling
+	})).Return(nil).Once()
+
+	_, err := service.AddAddress(ctx, Address{FullName: "Jane Doe", Line1: "1 Main St", City: "Colombo", PostalCode: "00100", Country: "LK"})
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_AddAddress_Invalid(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	a, err := service.AddAddress(ctx, Address{FullName: "Jane Doe", Line1: "1 Main St", City: "Springfield", PostalCode: "62701", Country: "US"})
+
+	assert.Nil(t, a)
+	assert.EqualError(t, err, "region is required for addresses in US")
+	mockRepo.AssertNotCalled(t, "PutAddress", mock.Anything, mock.Anything)
+}
+
+func TestAccountService_AddAddress_Limit(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(&Account{ID: "testID", Email: "test@example.com"}, nil).Once()
+	mockRepo.On("ListAddresses", ctx, "testID").Return(make([]Address, maxAddressesPerAccount), nil).Once()
+
+	_, err := service.AddAddress(ctx, Address{FullName: "Jane Doe", Line1: "1 Main St", City: "Berlin", PostalCode: "10115", Country: "DE"})
+
+	assert.ErrorIs(t, err, ErrTooManyAddresses)
+	mockRepo.AssertNotCalled(t, "PutAddress", mock.Anything, mock.Anything)
+}
+
+func TestAccountService_UpdateAddress_ScopedToCaller(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(&Account{ID: "testID", Email: "test@example.com"}, nil).Once()
+	mockRepo.On("UpdateAddress", ctx, mock.MatchedBy(func(a Address) bool {
+		return a.ID == "otherAddress" && a.AccountID == "testID"
+	})).Return(nil, ErrNotFound).Once()
+
+	a, err := service.UpdateAddress(ctx, Address{ID: "otherAddress", FullName: "Jane Doe", Line1: "1 Rue", City: "Paris", PostalCode: "75001", Country: "FR"})
+
+	assert.Nil(t, a)
+	assert.ErrorIs(t, err, ErrNotFound)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAccountService_ListAddresses_OtherAccountForbidden(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
+
+	mockRepo.On("GetAccountByID", ctx, "otherID").Return(&Account{ID: "otherID", Email: "other@example.com"}, nil).Once()
+
+	addresses, err := service.ListAddresses(ctx, "otherID")
+
+	assert.Nil(t, addresses)
+	assert.ErrorIs(t, err, authz.ErrUnauthorized)
+	mockRepo.AssertNotCalled(t, "ListAddresses", mock.Anything, mock.Anything)
+}
 
-	account, err := service.PostAccount(ctx, firstName, lastName, email, password)
+func TestAccountService_SetDefaultAddress(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
 
-	assert.Error(t, err)
-	assert.Nil(t, account)
-	assert.ErrorContains(t, err, "repository error")
+	updated := []Address{{ID: "a2", DefaultBilling: true}, {ID: "a1", DefaultShipping: true}}
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(&Account{ID: "testID", Email: "test@example.com"}, nil).Once()
+	mockRepo.On("SetDefaultAddress", ctx, "testID", "a2", AddressUsageBilling).Return(nil).Once()
+	mockRepo.On("ListAddresses", ctx, "testID").Return(updated, nil).Once()
+
+	addresses, err := service.SetDefaultAddress(ctx, "a2", AddressUsageBilling)
+
+	assert.NoError(t, err)
+	assert.Equal(t, updated, addresses)
 	mockRepo.AssertExpectations(t)
+
+	_, err = service.SetDefaultAddress(ctx, "a2", "gift")
+	assert.ErrorIs(t, err, ErrInvalidAddressUsage)
 }
 
-func TestAccountService_Login_Success(t *testing.T) {
+func TestAccountService_Login_SuspendedAccount(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
 	ctx := context.Background()
 
-	email := "test@example.com"
-	password := "password123"
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	mockAccount := &Account{ID: "someID", Email: email, PasswordHash: string(hashedPassword), Role: "user"}
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	mockAccount := &Account{ID: "someID", Email: "test@example.com", PasswordHash: string(hashedPassword), Status: StatusSuspended}
 
-	mockRepo.On("GetAccountByEmail", ctx, email).Return(mockAccount, nil).Once()
+	mockRepo.On("GetLoginThrottle", ctx, "email:test@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(mockAccount, nil).Once()
 
-	account, accessToken, refreshToken, err := service.Login(ctx, email, password)
+	res, err := service.Login(ctx, "test@example.com", "password123", "")
 
-	assert.NoError(t, err)
-	assert.NotNil(t, account)
-	assert.NotEmpty(t, accessToken)
-	assert.NotEmpty(t, refreshToken)
-	assert.Equal(t, mockAccount.ID, account.ID)
-	mockRepo.AssertExpectations(t)
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, ErrAccountSuspended)
+	mockRepo.AssertNotCalled(t, "PutRefreshToken", mock.Anything, mock.Anything)
 }
 
-func TestAccountService_Login_AccountNotFound(t *testing.T) {
+func TestAccountService_Login_ClosedAccountWrongPassword(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
 	ctx := context.Background()
 
-	email := "test@example.com"
-	password := "password123"
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	mockAccount := &Account{ID: "someID", Email: "test@example.com", PasswordHash: string(hashedPassword), Status: StatusClosed}
 
-	mockRepo.On("GetAccountByEmail", ctx, email).Return(nil, errors.New("account not found")).Once()
+	mockRepo.On("GetLoginThrottle", ctx, "email:test@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(mockAccount, nil).Once()
+	mockRepo.On("RecordLoginFailure", ctx, "email:test@example.com", mock.Anything).Return(1, nil).Once()
 
-	account, accessToken, refreshToken, err := service.Login(ctx, email, password)
+	_, err := service.Login(ctx, "test@example.com", "wrong", "")
 
-	assert.Error(t, err)
-	assert.Nil(t, account)
-	assert.Empty(t, accessToken)
-	assert.Empty(t, refreshToken)
-	assert.ErrorContains(t, err, "account not found")
-	mockRepo.AssertExpectations(t)
+	// The status is only revealed to someone who knows the password
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
 }
 
-func TestAccountService_Login_InvalidPassword(t *testing.T) {
+func TestAccountService_RefreshToken_SuspendedAccount(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
 	ctx := context.Background()
 
-	email := "test@example.com"
-	password := "wrongpassword"
-	correctPassword := "correctpassword"
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(correctPassword), bcrypt.DefaultCost)
-	mockAccount := &Account{ID: "someID", Email: email, PasswordHash: string(hashedPassword), Role: "user"}
-
-	mockRepo.On("GetAccountByEmail", ctx, email).Return(mockAccount, nil).Once()
+	refreshToken := generateValidRefreshToken("tokenID")
+	mockRepo.On("GetRefreshToken", ctx, "tokenID").Return(&RefreshToken{ID: "tokenID", AccountID: "someID", FamilyID: "familyID", ExpiresAt: time.Now().Add(time.Hour)}, nil).Once()
+	mockRepo.On("GetAccountByID", ctx, "someID").Return(&Account{ID: "someID", Email: "testUser", Status: StatusSuspended}, nil).Once()
 
-	account, accessToken, refreshToken, err := service.Login(ctx, email, password)
+	accessToken, newRefreshToken, err := service.RefreshToken(ctx, refreshToken)
 
-	assert.Error(t, err)
-	assert.Nil(t, account)
+	assert.ErrorIs(t, err, ErrAccountSuspended)
 	assert.Empty(t, accessToken)
-	assert.Empty(t, refreshToken)
-	assert.ErrorContains(t, err, "invalid email or password")
-	mockRepo.AssertExpectations(t)
+	assert.Empty(t, newRefreshToken)
+	mockRepo.AssertNotCalled(t, "RevokeRefreshToken", mock.Anything, mock.Anything)
 }
 
-func TestAccountService_GetAccount_Success(t *testing.T) {
+func TestActiveAccountCheck(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
 	ctx := context.Background()
+	check := ActiveAccountCheck(mockRepo)
 
-	// Test data
-	accountID := "testID"
-	accessToken := "validAccessToken"
-	refreshToken := "validRefreshToken"
-	mockAccount := &Account{ID: accountID, Email: "test@example.com"}
+	mockRepo.On("GetAccountByEmail", ctx, "active@example.com").Return(&Account{Status: StatusActive}, nil).Once()
+	mockRepo.On("GetAccountByEmail", ctx, "closed@example.com").Return(&Account{Status: StatusClosed}, nil).Once()
+	mockRepo.On("GetAccountByEmail", ctx, "gone@example.com").Return(nil, ErrNotFound).Once()
 
-	// Mock the repository call to return the mock account
-	mockRepo.On("GetAccountByID", ctx, accountID).Return(mockAccount, nil).Once()
+	assert.NoError(t, check(ctx, &Claims{Username: "active@example.com"}))
+	assert.ErrorIs(t, check(ctx, &Claims{Username: "closed@example.com"}), ErrAccountClosed)
+	assert.ErrorIs(t, check(ctx, &Claims{Username: "gone@example.com"}), authz.ErrUnauthenticated)
+}
 
-	// Mock the validateToken method to return the provided tokens and claims.  Crucially, return nil for the error.
-	// Call the service method under test
-	account, _, _, err := service.GetAccount(ctx, accountID, accessToken, refreshToken)
+func TestAccountService_SuspendAccount_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	auditLog := new(MockAuditRecorder)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, auditLog)
+	ctx := adminContext()
+
+	suspended := &Account{ID: "userID", Email: "user@example.com", Status: StatusSuspended, StatusReason: "chargeback fraud"}
+	mockRepo.On("GetAccountByID", ctx, "userID").Return(&Account{ID: "userID", Email: "user@example.com", Status: StatusActive}, nil).Once()
+	mockRepo.On("UpdateAccountStatus", ctx, "userID", StatusSuspended, "chargeback fraud").Return(nil).Once()
+	mockRepo.On("RevokeRefreshTokensForAccount", ctx, "userID").Return(nil).Once()
+	mockRepo.On("GetAccountByID", ctx, "userID").Return(suspended, nil).Once()
+	auditLog.On("Record", ctx, mock.MatchedBy(func(e audit.Event) bool {
+		return e.Action == AuditSuspend && e.Target == "userID" &&
+			string(e.Before) == `{"status":"active","status_reason":""}` &&
+			string(e.After) == `{"status":"suspended","status_reason":"chargeback fraud"}`
+	})).Return(nil).Once()
+
+	a, err := service.SuspendAccount(ctx, "userID", "  chargeback fraud ")
 
-	// Assertions
 	assert.NoError(t, err)
-	assert.NotNil(t, account)
-	assert.Equal(t, mockAccount.ID, account.ID)
-	// assert.Equal(t, accessToken, newAccessToken)
-	// assert.Equal(t, refreshToken, newRefreshToken)
+	assert.Equal(t, suspended, a)
 	mockRepo.AssertExpectations(t)
+	auditLog.AssertExpectations(t)
 }
 
-func TestAccountService_GetAccount_InvalidToken(t *testing.T) {
-	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
-	ctx := context.Background()
+func TestAccountService_SuspendAccount_Rejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		reason  string
+		account *Account
+		err     error
+	}{
+		{"not an admin", userContext(), "spam", nil, authz.ErrUnauthorized},
+		{"missing reason", adminContext(), " ", nil, ErrStatusReasonRequired},
+		{"own account", adminContext(), "testing", &Account{ID: "userID", Email: "adminUser"}, ErrCannotSuspendSelf},
+		{"closed account", adminContext(), "spam", &Account{ID: "userID", Email: "user@example.com", Status: StatusClosed}, ErrAccountClosed},
+	}
 
-	accountID := "testID"
-	accessToken := "invalidAccessToken"
-	refreshToken := "validRefreshToken"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepository)
+			service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+			if tt.account != nil {
+				mockRepo.On("GetAccountByID", tt.ctx, "userID").Return(tt.account, nil).Once()
+			}
 
-	account, newAccessToken, newRefreshToken, err := service.GetAccount(ctx, accountID, accessToken, refreshToken)
+			a, err := service.SuspendAccount(tt.ctx, "userID", tt.reason)
 
-	assert.Error(t, err)
-	assert.Nil(t, account)
-	assert.Empty(t, newAccessToken)
-	assert.Empty(t, newRefreshToken)
-	mockRepo.AssertNotCalled(t, "GetAccountByID", mock.Anything, mock.Anything)
+			assert.Nil(t, a)
+			assert.ErrorIs(t, err, tt.err)
+			mockRepo.AssertNotCalled(t, "UpdateAccountStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
 }
 
-func TestAccountService_GetAccount_AccountNotFound(t *testing.T) {
+func TestAccountService_ReactivateAccount(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
-	ctx := context.Background()
-
-	accountID := "testID"
-	accessToken := "validAccessToken"
-	refreshToken := "validRefreshToken"
+	auditLog := new(MockAuditRecorder)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, auditLog)
+	ctx := adminContext()
 
-	mockRepo.On("GetAccountByID", ctx, accountID).Return(nil, errors.New("account not found")).Once()
+	mockRepo.On("GetAccountByID", ctx, "userID").Return(&Account{ID: "userID", Status: StatusSuspended}, nil).Once()
+	mockRepo.On("UpdateAccountStatus", ctx, "userID", StatusActive, "appeal accepted").Return(nil).Once()
+	mockRepo.On("GetAccountByID", ctx, "userID").Return(&Account{ID: "userID", Status: StatusActive}, nil).Once()
+	auditLog.On("Record", ctx, auditEvent(AuditReactivate, "userID")).Return(nil).Once()
 
-	account, newAccessToken, newRefreshToken, err := service.GetAccount(ctx, accountID, accessToken, refreshToken)
+	a, err := service.ReactivateAccount(ctx, "userID", "appeal accepted")
 
-	assert.Error(t, err)
-	assert.Nil(t, account)
-	assert.NotEmpty(t, newAccessToken)
-	assert.NotEmpty(t, newRefreshToken)
-	assert.ErrorContains(t, err, "account not found")
+	assert.NoError(t, err)
+	assert.Equal(t, StatusActive, a.Status)
 	mockRepo.AssertExpectations(t)
+
+	// Closed accounts stay closed
+	mockRepo.On("GetAccountByID", ctx, "closedID").Return(&Account{ID: "closedID", Status: StatusClosed}, nil).Once()
+	_, err = service.ReactivateAccount(ctx, "closedID", "appeal accepted")
+	assert.ErrorIs(t, err, ErrAccountNotSuspended)
 }
 
-func TestAccountService_GetAccounts_Success(t *testing.T) {
+func TestAccountService_CloseAccount(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
-	ctx := context.Background()
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
 
-	skip := uint64(0)
-	take := uint64(10)
-	accessToken := generateValidAdminToken()
-	refreshToken := generateValidAdminToken()
-	mockAccounts := []Account{{ID: "1"}, {ID: "2"}}
+	passwordHash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	mockAccount := &Account{ID: "testID", Email: "test@example.com", PasswordHash: string(passwordHash), Status: StatusActive}
 
-	mockRepo.On("ListAccounts", ctx, skip, take).Return(mockAccounts, nil).Once()
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(mockAccount, nil).Once()
+	mockRepo.On("GetLoginThrottle", ctx, "email:test@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("UpdateAccountStatus", ctx, "testID", StatusClosed, mock.AnythingOfType("string")).Return(nil).Once()
+	mockRepo.On("RevokeRefreshTokensForAccount", ctx, "testID").Return(nil).Once()
 
-	accounts, newAccessToken, newRefreshToken, err := service.GetAccounts(ctx, skip, take, accessToken, refreshToken)
+	err := service.CloseAccount(ctx, "password123")
 
 	assert.NoError(t, err)
-	assert.NotNil(t, accounts)
-	assert.Len(t, accounts, 2)
-	assert.NotEmpty(t, newAccessToken)
-	assert.NotEmpty(t, newRefreshToken)
 	mockRepo.AssertExpectations(t)
 }
 
-func TestAccountService_GetAccounts_Unauthorized(t *testing.T) {
+func TestAccountService_CloseAccount_WrongPassword(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
-	ctx := context.Background()
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
 
-	skip := uint64(0)
-	take := uint64(10)
-	accessToken := generateValidUserToken()
-	refreshToken := generateValidUserToken()
+	passwordHash, _ := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	mockAccount := &Account{ID: "testID", Email: "test@example.com", PasswordHash: string(passwordHash)}
 
-	accounts, newAccessToken, newRefreshToken, err := service.GetAccounts(ctx, skip, take, accessToken, refreshToken)
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(mockAccount, nil).Once()
+	mockRepo.On("GetLoginThrottle", ctx, "email:test@example.com").Return(nil, ErrNotFound).Once()
+	mockRepo.On("RecordLoginFailure", ctx, "email:test@example.com", mock.Anything).Return(1, nil).Once()
 
-	assert.Error(t, err)
-	assert.Nil(t, accounts)
-	assert.Empty(t, newAccessToken)
-	assert.Empty(t, newRefreshToken)
-	assert.ErrorContains(t, err, "unauthorized")
-	mockRepo.AssertNotCalled(t, "ListAccounts", mock.Anything, mock.Anything)
+	err := service.CloseAccount(ctx, "wrong")
+
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	mockRepo.AssertNotCalled(t, "UpdateAccountStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 }
 
-func TestAccountService_GetAccounts_RepositoryError(t *testing.T) {
-	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
-	ctx := context.Background()
+// MockOrderSource stands in for the order service in data exports
+type MockOrderSource struct {
+	mock.Mock
+}
 
-	skip := uint64(0)
-	take := uint64(10)
-	accessToken := generateValidAdminToken()
-	refreshToken := generateValidAdminToken()
+func (m *MockOrderSource) ExportOrders(ctx context.Context, accountID string) (json.RawMessage, error) {
+	args := m.Called(ctx, accountID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(json.RawMessage), args.Error(1)
+}
 
-	mockRepo.On("ListAccounts", ctx, skip, take).Return(nil, errors.New("repository error")).Once()
+func TestAccountService_ExportAccountData_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	orders := new(MockOrderSource)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, orders, nil)
+	ctx := userContext()
 
-	accounts, newAccessToken, newRefreshToken, err := service.GetAccounts(ctx, skip, take, accessToken, refreshToken)
+	mockAccount := &Account{ID: "testID", FirstName: "Test", Email: "test@example.com", PasswordHash: "$2a$10$secret", Status: StatusActive}
+	mockRepo.On("GetAccountByID", ctx, "testID").Return(mockAccount, nil).Once()
+	mockRepo.On("ListAddresses", ctx, "testID").Return([]Address{{ID: "a1", City: "Colombo", Country: "LK"}}, nil).Once()
+	orders.On("ExportOrders", ctx, "testID").Return(json.RawMessage(`[{"id":"o1","total_price":12.5}]`), nil).Once()
+	mockRepo.On("PutDataRequest", ctx, mock.MatchedBy(func(d DataRequest) bool {
+		return d.AccountID == "testID" && d.Kind == DataRequestExport && d.RequestedBy == "test@example.com"
+	})).Return(nil).Once()
 
-	assert.Error(t, err)
-	assert.Nil(t, accounts)
-	assert.NotEmpty(t, newAccessToken)
-	assert.NotEmpty(t, newRefreshToken)
-	assert.ErrorContains(t, err, "repository error")
+	archive, err := service.ExportAccountData(ctx, "testID")
+
+	assert.NoError(t, err)
+	assert.NotContains(t, string(archive), "$2a$10$secret")
+	var export struct {
+		FormatVersion int `json:"format_version"`
+		Account       struct {
+			Email string `json:"email"`
+		} `json:"account"`
+		Addresses []Address `json:"addresses"`
+		Orders    []struct {
+			ID string `json:"id"`
+		} `json:"orders"`
+	}
+	assert.NoError(t, json.Unmarshal(archive, &export))
+	assert.Equal(t, dataExportFormatVersion, export.FormatVersion)
+	assert.Equal(t, "test@example.com", export.Account.Email)
+	assert.Len(t, export.Addresses, 1)
+	assert.Equal(t, "o1", export.Orders[0].ID)
 	mockRepo.AssertExpectations(t)
+	orders.AssertExpectations(t)
 }
 
-func TestAccountService_SetAccountAsAdmin_Success(t *testing.T) {
+func TestAccountService_ExportAccountData_OtherAccountForbidden(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
-	ctx := context.Background()
+	orders := new(MockOrderSource)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, orders, nil)
+	ctx := userContext()
 
-	accountID := "testID"
-	accessToken := generateValidAdminToken()
-	refreshToken := generateValidAdminToken()
-	updatedAccount := &Account{ID: accountID, Role: "admin"}
+	mockRepo.On("GetAccountByID", ctx, "otherID").Return(&Account{ID: "otherID", Email: "other@example.com"}, nil).Once()
 
-	mockRepo.On("UpdateAccountRole", ctx, accountID, "admin").Return(updatedAccount, nil).Once()
+	archive, err := service.ExportAccountData(ctx, "otherID")
+
+	assert.Nil(t, archive)
+	assert.ErrorIs(t, err, authz.ErrUnauthorized)
+	orders.AssertNotCalled(t, "ExportOrders", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "PutDataRequest", mock.Anything, mock.Anything)
+}
 
-	account, newAccessToken, newRefreshToken, err := service.SetAccountAsAdmin(ctx, accessToken, refreshToken, accountID)
+func TestAccountService_EraseAccount_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	auditLog := new(MockAuditRecorder)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, auditLog)
+	ctx := adminContext()
+
+	mockAccount := &Account{ID: "userID", Email: "user@example.com"}
+	mockRepo.On("GetAccountByID", ctx, "userID").Return(mockAccount, nil).Once()
+	mockRepo.On("EraseAccount", ctx, mockAccount, mock.MatchedBy(func(d DataRequest) bool {
+		return d.AccountID == "userID" && d.Kind == DataRequestErasure && d.RequestedBy == "adminUser" && d.Reason == "ticket 42"
+	})).Return(nil).Once()
+	// The snapshots must not keep the personal data that was just erased
+	auditLog.On("Record", ctx, mock.MatchedBy(func(e audit.Event) bool {
+		return e.Action == AuditErase && e.Target == "userID" && !strings.Contains(string(e.Before)+string(e.After), "user@example.com")
+	})).Return(nil).Once()
+
+	err := service.EraseAccount(ctx, "userID", " ticket 42 ")
 
 	assert.NoError(t, err)
-	assert.NotNil(t, account)
-	assert.Equal(t, "admin", account.Role)
-	assert.NotEmpty(t, newAccessToken)
-	assert.NotEmpty(t, newRefreshToken)
 	mockRepo.AssertExpectations(t)
+	auditLog.AssertExpectations(t)
 }
 
-func TestAccountService_SetAccountAsAdmin_Unauthorized(t *testing.T) {
+func TestAccountService_EraseAccount_Rejected(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
-	ctx := context.Background()
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
 
-	accountID := "testID"
-	accessToken := generateValidUserToken()
-	refreshToken := generateValidUserToken()
+	err := service.EraseAccount(userContext(), "userID", "ticket 42")
+	assert.ErrorIs(t, err, authz.ErrUnauthorized)
 
-	account, newAccessToken, newRefreshToken, err := service.SetAccountAsAdmin(ctx, accessToken, refreshToken, accountID)
+	err = service.EraseAccount(adminContext(), "userID", "")
+	assert.ErrorIs(t, err, ErrStatusReasonRequired)
 
-	assert.Error(t, err)
-	assert.Nil(t, account)
-	assert.Empty(t, newAccessToken)
-	assert.Empty(t, newRefreshToken)
-	assert.ErrorContains(t, err, "unauthorized")
-	mockRepo.AssertNotCalled(t, "UpdateAccountRole", mock.Anything, mock.Anything, mock.Anything)
+	mockRepo.On("GetAccountByID", mock.Anything, "userID").Return(&Account{ID: "userID", Email: erasedEmail("userID")}, nil).Once()
+	err = service.EraseAccount(adminContext(), "userID", "ticket 42")
+	assert.ErrorIs(t, err, ErrAlreadyErased)
+
+	mockRepo.AssertNotCalled(t, "EraseAccount", mock.Anything, mock.Anything, mock.Anything)
 }
 
-func TestAccountService_SetAccountAsAdmin_RepositoryError(t *testing.T) {
+func TestAccountService_SearchAccounts_Pages(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
-	ctx := context.Background()
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := adminContext()
 
-	accountID := "testID"
-	accessToken := generateValidAdminToken()
-	refreshToken := generateValidAdminToken()
+	filter := AccountFilter{Query: "smith", Status: StatusActive}
+	mockRepo.On("SearchAccounts", ctx, filter, "", 3).Return([]Account{{ID: "c"}, {ID: "b"}, {ID: "a"}}, nil).Once()
+	mockRepo.On("CountAccounts", ctx, filter).Return(5, nil).Twice()
+
+	page, err := service.SearchAccounts(ctx, AccountFilter{Query: " smith ", Status: StatusActive}, 2, "")
 
-	mockRepo.On("UpdateAccountRole", ctx, accountID, "admin").Return(nil, errors.New("repository error")).Once()
+	assert.NoError(t, err)
+	assert.Len(t, page.Accounts, 2)
+	assert.Equal(t, 5, page.TotalCount)
+	assert.Equal(t, AccountCursor("b"), page.NextCursor)
 
-	account, newAccessToken, newRefreshToken, err := service.SetAccountAsAdmin(ctx, accessToken, refreshToken, accountID)
+	// The cursor continues after the last account of the previous page
+	mockRepo.On("SearchAccounts", ctx, filter, "b", 3).Return([]Account{{ID: "a"}}, nil).Once()
 
-	assert.Error(t, err)
-	assert.Nil(t, account)
-	assert.NotEmpty(t, newAccessToken)
-	assert.NotEmpty(t, newRefreshToken)
-	assert.ErrorContains(t, err, "repository error")
+	page, err = service.SearchAccounts(ctx, filter, 2, page.NextCursor)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Account{{ID: "a"}}, page.Accounts)
+	assert.Empty(t, page.NextCursor)
 	mockRepo.AssertExpectations(t)
 }
 
-func TestAccountService_ForgotPassword_Success(t *testing.T) {
+func TestAccountService_SearchAccounts_PageSize(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
-	ctx := context.Background()
-
-	email := "test@example.com"
-	firstName := "Test"
-	lastName := "User"
-	mockAccount := &Account{ID: "someID", Email: email, FirstName: firstName, LastName: lastName}
-
-	mockRepo.On("GetAccountByEmailAndName", ctx, firstName, lastName, email).Return(mockAccount, nil).Once()
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := adminContext()
 
-	account, err := service.ForgotPassword(ctx, email, firstName, lastName)
+	mockRepo.On("SearchAccounts", ctx, AccountFilter{}, "", defaultSearchPageSize+1).Return([]Account{}, nil).Once()
+	mockRepo.On("SearchAccounts", ctx, AccountFilter{}, "", maxSearchPageSize+1).Return([]Account{}, nil).Once()
+	mockRepo.On("CountAccounts", ctx, AccountFilter{}).Return(0, nil)
 
+	_, err := service.SearchAccounts(ctx, AccountFilter{}, 0, "")
+	assert.NoError(t, err)
+	_, err = service.SearchAccounts(ctx, AccountFilter{}, 10000, "")
 	assert.NoError(t, err)
-	assert.NotNil(t, account)
-	assert.Equal(t, mockAccount.ID, account.ID)
 	mockRepo.AssertExpectations(t)
 }
 
-func TestAccountService_ForgotPassword_AccountNotFound(t *testing.T) {
+func TestAccountService_SearchAccounts_Rejected(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
-	ctx := context.Background()
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
 
-	email := "test@example.com"
-	firstName := "Test"
-	lastName := "User"
+	_, err := service.SearchAccounts(userContext(), AccountFilter{}, 10, "")
+	assert.ErrorIs(t, err, authz.ErrUnauthorized)
 
-	mockRepo.On("GetAccountByEmailAndName", ctx, firstName, lastName, email).Return(nil, errors.New("account not found")).Once()
+	_, err = service.SearchAccounts(adminContext(), AccountFilter{Status: "deleted"}, 10, "")
+	assert.ErrorIs(t, err, ErrInvalidStatus)
 
-	account, err := service.ForgotPassword(ctx, email, firstName, lastName)
+	_, err = service.SearchAccounts(adminContext(), AccountFilter{}, 10, "not base64!")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
 
-	assert.Error(t, err)
-	assert.Nil(t, account)
-	assert.ErrorContains(t, err, "account not found")
-	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "SearchAccounts", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 }
 
-func TestAccountService_ResetPassword_Success(t *testing.T) {
-	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
-	ctx := context.Background()
+func TestAccountFilterConditions(t *testing.T) {
+	after := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
 
-	accountID := "testID"
-	email := "test@example.com"
-	newPassword := "newSecurePassword"
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
-	mockAccount := &Account{ID: accountID, Email: email}
-	updatedAccount := &Account{ID: accountID, Email: email, PasswordHash: string(hashedPassword)}
+	conditions, args := accountFilterConditions(AccountFilter{Query: "50%_off", Role: "admin", CreatedAfter: &after}, nil)
 
-	mockRepo.On("GetAccountById", ctx, accountID).Return(mockAccount, nil).Once()
-	mockRepo.On("UpdatePasswordHash", ctx, email, string(hashedPassword)).Return(updatedAccount, nil).Once()
+	assert.Equal(t, []string{
+		"(a.email ILIKE $1 OR a.first_name ILIKE $1 OR a.last_name ILIKE $1 OR (a.first_name || ' ' || a.last_name) ILIKE $1)",
+		"EXISTS(SELECT 1 FROM account_roles ar WHERE ar.account_id = a.id AND ar.role = $2)",
+		"a.created_at >= $3",
+	}, conditions)
+	assert.Equal(t, []interface{}{`%50\%\_off%`, "admin", after}, args)
+	assert.Empty(t, whereClause(nil))
+}
+
+// Helper function to build a context carrying the claims of an admin, as set by the server interceptor
+func adminContext() context.Context {
+	return authz.ContextWithClaims(context.Background(), &Claims{Username: "adminUser", Role: "admin", Permissions: authz.All})
+}
 
-	account, err := service.ResetPassword(ctx, accountID, email, newPassword)
+func TestAccountService_CreateAPIKey(t *testing.T) {
+	mockRepo := new(MockRepository)
+	auditLog := new(MockAuditRecorder)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, auditLog)
+	ctx := userContext()
+
+	mockRepo.On("GetAccountByID", ctx, "testID").Return(&Account{ID: "testID", Email: "test@example.com", Permissions: []string{authz.OrdersReadAll}}, nil).Once()
+	mockRepo.On("ListAPIKeys", ctx, "testID").Return([]APIKey{}, nil).Once()
+	var stored APIKey
+	mockRepo.On("PutAPIKey", ctx, mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(1).(APIKey)
+	}).Return(nil).Once()
+	auditLog.On("Record", ctx, mock.MatchedBy(func(e audit.Event) bool {
+		return e.Action == AuditCreateAPIKey && e.Target == "testID" && !strings.Contains(string(e.After), stored.KeyHash)
+	})).Return(nil).Once()
+
+	k, key, err := service.CreateAPIKey(ctx, "testID", " reporting ", []string{authz.OrdersReadAll}, nil)
 
 	assert.NoError(t, err)
-	assert.NotNil(t, account)
-	assert.Equal(t, accountID, account.ID)
-	assert.Equal(t, email, account.Email)
-	assert.NotEmpty(t, account.PasswordHash)
+	assert.Equal(t, "reporting", k.Name)
+	assert.True(t, strings.HasPrefix(key, k.Prefix+"_"))
+	assert.Equal(t, hashResetToken(key), stored.KeyHash)
+	assert.NotContains(t, stored.KeyHash, key)
 	mockRepo.AssertExpectations(t)
+	auditLog.AssertExpectations(t)
 }
 
-func TestAccountService_ResetPassword_AccountNotFound(t *testing.T) {
+func TestAccountService_CreateAPIKey_ScopeNotHeld(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
-	ctx := context.Background()
-
-	accountID := "testID"
-	email := "test@example.com"
-	newPassword := "newSecurePassword"
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
 
-	mockRepo.On("GetAccountById", ctx, accountID).Return(nil, errors.New("account not found")).Once()
+	mockRepo.On("GetAccountByID", ctx, "testID").Return(&Account{ID: "testID", Email: "test@example.com", Permissions: []string{authz.OrdersReadAll}}, nil).Once()
 
-	account, err := service.ResetPassword(ctx, accountID, email, newPassword)
+	k, _, err := service.CreateAPIKey(ctx, "testID", "reporting", []string{authz.CatalogWrite}, nil)
 
-	assert.Error(t, err)
-	assert.Nil(t, account)
-	assert.ErrorContains(t, err, "account not found")
-	mockRepo.AssertExpectations(t)
+	assert.Nil(t, k)
+	assert.ErrorIs(t, err, authz.ErrUnauthorized)
+	mockRepo.AssertNotCalled(t, "PutAPIKey", mock.Anything, mock.Anything)
 }
 
-func TestAccountService_ResetPassword_HashingError(t *testing.T) {
+func TestAccountService_CreateAPIKey_Limit(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
-	ctx := context.Background()
-
-	accountID := "testID"
-	email := "test@example.com"
-	newPassword := "" // Will cause bcrypt error
-	mockAccount := &Account{ID: accountID, Email: email}
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := userContext()
 
-	mockRepo.On("GetAccountById", ctx, accountID).Return(mockAccount, nil).Once()
+	mockRepo.On("GetAccountByID", ctx, "testID").Return(&Account{ID: "testID", Email: "test@example.com"}, nil).Once()
+	mockRepo.On("ListAPIKeys", ctx, "testID").Return(make([]APIKey, maxAPIKeysPerAccount), nil).Once()
 
-	account, err := service.ResetPassword(ctx, accountID, email, newPassword)
+	_, _, err := service.CreateAPIKey(ctx, "testID", "reporting", nil, nil)
 
-	assert.Error(t, err)
-	assert.Nil(t, account)
-	assert.ErrorContains(t, err, "crypto/bcrypt: empty password")
-	mockRepo.AssertNotCalled(t, "UpdatePasswordHash", mock.Anything, mock.Anything)
+	assert.ErrorIs(t, err, ErrTooManyAPIKeys)
+	mockRepo.AssertNotCalled(t, "PutAPIKey", mock.Anything, mock.Anything)
 }
 
-func TestAccountService_ResetPassword_UpdateError(t *testing.T) {
+func TestAccountService_CreateAPIKey_RejectsKeyCallers(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
-	ctx := context.Background()
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := authz.ContextWithClaims(context.Background(), &Claims{Username: "test@example.com", Role: "user", APIKeyID: "keyID"})
 
-	accountID := "testID"
-	email := "test@example.com"
-	newPassword := "newSecurePassword"
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
-	mockAccount := &Account{ID: accountID, Email: email}
+	_, _, err := service.CreateAPIKey(ctx, "testID", "reporting", nil, nil)
 
-	mockRepo.On("GetAccountById", ctx, accountID).Return(mockAccount, nil).Once()
-	mockRepo.On("UpdatePasswordHash", ctx, email, string(hashedPassword)).Return(nil, errors.New("update failed")).Once()
+	assert.ErrorIs(t, err, ErrAPIKeyNotAllowed)
+	mockRepo.AssertNotCalled(t, "GetAccountByID", mock.Anything, mock.Anything)
+}
 
-	account, err := service.ResetPassword(ctx, accountID, email, newPassword)
+func TestAccountService_RevokeAPIKey(t *testing.T) {
+	mockRepo := new(MockRepository)
+	auditLog := new(MockAuditRecorder)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, auditLog)
+	ctx := adminContext()
 
-	assert.Error(t, err)
-	assert.Nil(t, account)
-	assert.ErrorContains(t, err, "update failed")
+	mockRepo.On("GetAPIKey", ctx, "keyID").Return(&APIKey{ID: "keyID", AccountID: "userID"}, nil).Once()
+	mockRepo.On("GetAccountByID", ctx, "userID").Return(&Account{ID: "userID", Email: "user@example.com"}, nil).Once()
+	mockRepo.On("RevokeAPIKey", ctx, "keyID").Return(nil).Once()
+	auditLog.On("Record", ctx, auditEvent(AuditRevokeAPIKey, "userID")).Return(nil).Once()
+
+	err := service.RevokeAPIKey(ctx, "keyID")
+
+	assert.NoError(t, err)
 	mockRepo.AssertExpectations(t)
+	auditLog.AssertExpectations(t)
 }
 
-func TestAccountService_RefreshToken_Success(t *testing.T) {
+func TestAccountService_AuthenticateAPIKey(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
 	ctx := context.Background()
+	key := "ek_0a1b2c3d_secret"
 
-	username := "testuser"
-	role := "user"
-	refreshToken, _ := GenerateRefreshToken(username, role)
+	mockRepo.On("GetAPIKeyByHash", ctx, hashResetToken(key)).Return(&APIKey{
+		ID:          "keyID",
+		AccountID:   "userID",
+		Permissions: []string{authz.OrdersReadAll, authz.CatalogWrite},
+	}, nil).Once()
+	// The account lost catalog:write after the key was created
+	mockRepo.On("GetAccountByID", ctx, "userID").Return(&Account{ID: "userID", Email: "user@example.com", Role: "user", Permissions: []string{authz.OrdersReadAll}}, nil).Once()
+	mockRepo.On("TouchAPIKey", ctx, "keyID", mock.Anything).Return(nil).Once()
 
-	accessToken, err := service.RefreshToken(ctx, refreshToken)
+	claims, err := service.AuthenticateAPIKey(ctx, key)
 
 	assert.NoError(t, err)
-	assert.NotEmpty(t, accessToken)
+	assert.Equal(t, "user@example.com", claims.Username)
+	assert.Equal(t, "keyID", claims.APIKeyID)
+	assert.Equal(t, []string{authz.OrdersReadAll}, claims.Permissions)
+	mockRepo.AssertExpectations(t)
+}
 
-	// Verify the claims of the new access token
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(accessToken, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
+func TestAccountService_AuthenticateAPIKey_Rejected(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	recent := time.Now()
+	tests := []struct {
+		name    string
+		key     *APIKey
+		account *Account
+		err     error
+	}{
+		{"revoked", &APIKey{ID: "keyID", AccountID: "userID", RevokedAt: &past}, nil, ErrInvalidAPIKey},
+		{"expired", &APIKey{ID: "keyID", AccountID: "userID", ExpiresAt: &past}, nil, ErrInvalidAPIKey},
+		{"suspended", &APIKey{ID: "keyID", AccountID: "userID", LastUsedAt: &recent}, &Account{ID: "userID", Status: StatusSuspended}, ErrAccountSuspended},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepository)
+			service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+			ctx := context.Background()
+
+			mockRepo.On("GetAPIKeyByHash", ctx, mock.Anything).Return(tt.key, nil).Once()
+			if tt.account != nil {
+				mockRepo.On("GetAccountByID", ctx, "userID").Return(tt.account, nil).Once()
+			}
+
+			claims, err := service.AuthenticateAPIKey(ctx, "ek_0a1b2c3d_secret")
+
+			assert.Nil(t, claims)
+			assert.ErrorIs(t, err, tt.err)
+			mockRepo.AssertNotCalled(t, "TouchAPIKey", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestAccountService_ImpersonateAccount(t *testing.T) {
+	mockRepo := new(MockRepository)
+	auditLog := new(MockAuditRecorder)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, auditLog)
+	ctx := adminContext()
+
+	mockRepo.On("GetAccountByID", ctx, "userID").Return(&Account{ID: "userID", Email: "user@example.com", Role: RoleUser, Status: StatusActive}, nil).Once()
+	auditLog.On("Record", ctx, mock.MatchedBy(func(e audit.Event) bool {
+		return e.Action == AuditStartImpersonation && e.Target == "userID" && e.Actor == "adminUser" && strings.Contains(string(e.After), "ticket 7")
+	})).Return(nil).Once()
+
+	i, err := service.ImpersonateAccount(ctx, "userID", "ticket 7")
 
 	assert.NoError(t, err)
-	assert.True(t, token.Valid) // Ensure the token is valid
+	assert.WithinDuration(t, time.Now().Add(impersonationTokenTTL), i.ExpiresAt, 5*time.Second)
+	claims, err := testKeys.Verifier().Verify(ctx, i.AccessToken)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "user@example.com", claims.Username)
+		assert.Equal(t, "adminUser", claims.Actor)
+		assert.Equal(t, i.SessionID, claims.ID)
+		assert.Empty(t, claims.Permissions)
+	}
+	mockRepo.AssertExpectations(t)
+	auditLog.AssertExpectations(t)
+}
 
-	if assert.NotNil(t, claims) {
-		assert.Equal(t, username, claims.Username)
-		assert.Equal(t, role, claims.Role)
-		assert.True(t, claims.ExpiresAt.Time.After(time.Now())) // Ensure it's a new token with future expiration
+func TestAccountService_ImpersonateAccount_Rejected(t *testing.T) {
+	impersonating := authz.ContextWithClaims(context.Background(), &Claims{Username: "user@example.com", Actor: "adminUser", Permissions: authz.All})
+	supportOnly := authz.ContextWithClaims(context.Background(), &Claims{Username: "support@example.com", Permissions: []string{authz.AccountsImpersonate}})
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		reason string
+		target *Account
+		err    error
+	}{
+		{"missing permission", userContext(), "ticket 7", nil, authz.ErrUnauthorized},
+		{"already impersonating", impersonating, "ticket 7", nil, authz.ErrImpersonating},
+		{"no reason", supportOnly, " ", nil, ErrImpersonationReasonRequired},
+		{"privileged target", supportOnly, "ticket 7", &Account{ID: "userID", Email: "admin@example.com", Permissions: []string{authz.AccountsManage}}, ErrCannotImpersonatePrivileged},
+		{"suspended target", supportOnly, "ticket 7", &Account{ID: "userID", Email: "user@example.com", Status: StatusSuspended}, ErrAccountSuspended},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepository)
+			service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+			if tt.target != nil {
+				mockRepo.On("GetAccountByID", tt.ctx, "userID").Return(tt.target, nil).Once()
+			}
+
+			i, err := service.ImpersonateAccount(tt.ctx, "userID", tt.reason)
+
+			assert.Nil(t, i)
+			assert.ErrorIs(t, err, tt.err)
+			mockRepo.AssertExpectations(t)
+		})
 	}
 }
 
-func TestAccountService_RefreshToken_InvalidToken(t *testing.T) {
+func TestAccountService_EndImpersonation(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
-	ctx := context.Background()
+	auditLog := new(MockAuditRecorder)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, auditLog)
+	claims := &Claims{Username: "user@example.com", Actor: "adminUser"}
+	claims.ID = "sessionID"
+	ctx := authz.ContextWithClaims(context.Background(), claims)
+
+	mockRepo.On("GetAccountByEmail", ctx, "user@example.com").Return(&Account{ID: "userID", Email: "user@example.com"}, nil).Once()
+	auditLog.On("Record", ctx, mock.MatchedBy(func(e audit.Event) bool {
+		return e.Action == AuditEndImpersonation && e.Target == "userID" && e.Actor == "adminUser" && strings.Contains(string(e.Before), "sessionID")
+	})).Return(nil).Once()
+
+	assert.NoError(t, service.EndImpersonation(ctx))
+	assert.ErrorIs(t, service.EndImpersonation(userContext()), ErrNotImpersonating)
+	mockRepo.AssertExpectations(t)
+	auditLog.AssertExpectations(t)
+}
 
-	invalidRefreshToken := "invalid.refresh.token"
+// Customers must change their own credentials, staff impersonating them are refused
+func TestAccountService_SensitiveActionsRejectImpersonation(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, new(MockNotifier), testKeys, testVerification, testMfa, testPasswords, nil, nil)
+	ctx := authz.ContextWithClaims(context.Background(), &Claims{Username: "user@example.com", Actor: "adminUser"})
+
+	assert.ErrorIs(t, service.ChangePassword(ctx, "correct horse battery", "another horse battery"), authz.ErrImpersonating)
+	assert.ErrorIs(t, service.ChangeEmail(ctx, "new@example.com", "correct horse battery"), authz.ErrImpersonating)
+	assert.ErrorIs(t, service.CloseAccount(ctx, "correct horse battery"), authz.ErrImpersonating)
+	_, _, err := service.CreateAPIKey(ctx, "userID", "reporting", nil, nil)
+	assert.ErrorIs(t, err, authz.ErrImpersonating)
+	mockRepo.AssertNotCalled(t, "GetAccountByEmail", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "GetAccountByID", mock.Anything, mock.Anything)
+}
 
-	accessToken, err := service.RefreshToken(ctx, invalidRefreshToken)
+// Migrations are numbered without gaps and can all be reverted
+func TestMigrations(t *testing.T) {
+	migrations, err := migrate.Load(Migrations())
 
-	assert.Error(t, err)
-	assert.Empty(t, accessToken)
-	assert.ErrorContains(t, err, "invalid refresh token")
+	assert.NoError(t, err)
+	for i, m := range migrations {
+		assert.Equal(t, int64(i+1), m.Version)
+		assert.NotEmpty(t, m.Down, m.Name)
+	}
+	if assert.Len(t, migrations, 9) {
+		// The baseline schema comes first so existing databases can be migrated from it
+		assert.Contains(t, migrations[0].Up, "role user_role NOT NULL")
+		assert.Contains(t, migrations[1].Up, "INSERT INTO account_roles (account_id, role) SELECT id, role::text FROM accounts")
+		assert.Contains(t, migrations[5].Up, "CREATE TABLE IF NOT EXISTS notification_outbox")
+		assert.Contains(t, migrations[6].Up, "CREATE TABLE IF NOT EXISTS audit_log")
+	}
 }
 
-// Helper function to generate a valid admin token for testing
-func generateValidAdminToken() string {
-	token, _ := GenerateAccessToken("adminUser", "admin")
-	return token
+// Helper function to build a context carrying the claims of a regular user
+func userContext() context.Context {
+	return authz.ContextWithClaims(context.Background(), &Claims{Username: "test@example.com", Role: "user"})
 }
 
-// Helper function to generate a valid user token for testing
-func generateValidUserToken() string {
-	token, _ := GenerateAccessToken("testUser", "user")
+// Helper function to generate a refresh token with the given ID for testing
+func generateValidRefreshToken(tokenID string) string {
+	token, _ := testKeys.GenerateRefreshToken("testUser", "user", tokenID)
 	return token
 }
+
+// assertNoCredentialFields fails if msg or any message it embeds has a field that could carry
+// password or hash material.
+func assertNoCredentialFields(t *testing.T, msg protoreflect.MessageDescriptor, seen map[protoreflect.FullName]bool) {
+	if seen[msg.FullName()] {
+		return
+	}
+	seen[msg.FullName()] = true
+
+	fields := msg.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		f := fields.Get(i)
+		name := strings.ToLower(string(f.Name()))
+		assert.False(t, strings.Contains(name, "password") || strings.Contains(name, "hash"), "%s.%s exposes credentials", msg.FullName(), f.Name())
+		if f.Message() != nil {
+			assertNoCredentialFields(t, f.Message(), seen)
+		}
+	}
+}
+
+func TestAccountProto_ResponsesHaveNoCredentialFields(t *testing.T) {
+	messages := pb.File_account_proto.Messages()
+	seen := map[protoreflect.FullName]bool{}
+	for i := 0; i < messages.Len(); i++ {
+		msg := messages.Get(i)
+		if strings.HasSuffix(string(msg.Name()), "Response") || msg.Name() == "AccountProfile" {
+			assertNoCredentialFields(t, msg, seen)
+		}
+	}
+}
+
+// Only a trusted gateway may name the address a login is throttled by
+func TestGrpcServer_ClientIP(t *testing.T) {
+	server := &grpcServer{gateways: GatewayPolicy{TrustedGateways: []string{"10.0.0.0/24", "192.0.2.1"}}}
+	forwarded := func(peerAddr string) context.Context {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(peerAddr), Port: 50000}})
+		return metadata.NewIncomingContext(ctx, metadata.Pairs(ClientIPMetadataKey, "203.0.113.7"))
+	}
+
+	assert.Equal(t, "203.0.113.7", server.clientIP(forwarded("10.0.0.5")))
+	assert.Equal(t, "203.0.113.7", server.clientIP(forwarded("192.0.2.1")))
+	assert.Equal(t, "198.51.100.9", server.clientIP(forwarded("198.51.100.9")))
+	assert.Equal(t, "10.0.0.5", server.clientIP(peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 50000}})))
+}
+
+func TestGrpcServer_ResponsesOmitPasswordHash(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockNotifier := new(MockNotifier)
+	auditLog := new(MockAuditRecorder)
+	server := &grpcServer{service: NewService(mockRepo, mockNotifier, testKeys, testVerification, testMfa, testPasswords, nil, auditLog)}
+	ctx := adminContext()
+
+	password := "correct horse battery"
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	stored := &Account{ID: "someID", Email: "test@example.com", PasswordHash: string(hashedPassword), Role: "user", Roles: []string{"user"}}
+
+	mockRepo.On("PutAccount", ctx, mock.Anything).Return(nil)
+	mockRepo.On("GetAccountByID", ctx, "someID").Return(stored, nil)
+	mockRepo.On("ListAccounts", ctx, mock.Anything, mock.Anything).Return([]Account{*stored}, nil)
+	mockRepo.On("GetAccountByEmail", ctx, "test@example.com").Return(stored, nil)
+	mockRepo.On("GetLoginThrottle", ctx, mock.Anything).Return(nil, ErrNotFound)
+	mockRepo.On("ResetLoginFailures", ctx, mock.Anything).Return(nil)
+	mockRepo.On("PutRefreshToken", ctx, mock.Anything).Return(nil)
+	mockRepo.On("GrantRole", ctx, "someID", mock.Anything).Return(nil)
+	mockRepo.On("CountEmailVerificationTokensSince", ctx, mock.Anything, mock.Anything).Return(0, nil)
+	mockRepo.On("PutEmailVerificationToken", ctx, mock.Anything).Return(nil)
+	mockNotifier.On("Notify", ctx, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	auditLog.On("Record", ctx, mock.Anything).Return(nil)
+
+	responses := []func() (proto.Message, error){
+		func() (proto.Message, error) {
+			return server.PostAccount(ctx, &pb.PostAccountRequest{FirstName: "John", LastName: "Doe", Email: "john.doe@example.com", Password: password})
+		},
+		func() (proto.Message, error) { return server.GetAccount(ctx, &pb.GetAccountRequest{Id: "someID"}) },
+		func() (proto.Message, error) { return server.GetAccounts(ctx, &pb.GetAccountsRequest{Take: 10}) },
+		func() (proto.Message, error) {
+			return server.Login(ctx, &pb.LoginRequest{Email: "test@example.com", Password: password})
+		},
+		func() (proto.Message, error) {
+			return server.SetAccountAsAdmin(ctx, &pb.SetAccountAsAdminRequest{Id: "someID"})
+		},
+		func() (proto.Message, error) {
+			return server.GrantRole(ctx, &pb.GrantRoleRequest{Id: "someID", Role: "user"})
+		},
+		func() (proto.Message, error) {
+			return server.UnlockAccount(ctx, &pb.UnlockAccountRequest{Id: "someID"})
+		},
+	}
+	for _, call := range responses {
+		res, err := call()
+		if !assert.NoError(t, err) {
+			continue
+		}
+		encoded, err := protojson.Marshal(res)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(encoded), "$2a$", "%T contains a bcrypt hash", res)
+		assert.NotContains(t, string(encoded), stored.PasswordHash)
+	}
+}
@@ -0,0 +1,197 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+const maxAddressesPerAccount = 20
+
+// Kinds of default address an account can have, one of each at most
+const (
+	AddressUsageShipping = "shipping"
+	AddressUsageBilling  = "billing"
+)
+
+var (
+	ErrTooManyAddresses    = fmt.Errorf("an account can have at most %d addresses", maxAddressesPerAccount)
+	ErrInvalidAddressUsage = errors.New("address usage must be shipping or billing")
+)
+
+// Address is a postal address of an account. Country is an ISO 3166-1 alpha-2 code.
+type Address struct {
+	ID              string    `json:"id"`
+	AccountID       string    `json:"account_id"`
+	FullName        string    `json:"full_name"`
+	Line1           string    `json:"line1"`
+	Line2           string    `json:"line2"`
+	City            string    `json:"city"`
+	Region          string    `json:"region"` // state, province or county
+	PostalCode      string    `json:"postal_code"`
+	Country         string    `json:"country"`
+	Phone           string    `json:"phone"`
+	DefaultShipping bool      `json:"default_shipping"`
+	DefaultBilling  bool      `json:"default_billing"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// addressRule is what a country requires on top of a name, first line and city.
+type addressRule struct {
+	requireRegion bool
+	postalCode    *regexp.Regexp // nil when the country has no postal codes
+}
+
+// addressRules covers the countries we ship to most. Other countries are accepted with an
+// optional postal code since we cannot tell what a valid one looks like.
+var addressRules = map[string]addressRule{
+	"US": {requireRegion: true, postalCode: regexp.MustCompile(`^\d{5}(-\d{4})?$`)},
+	"CA": {requireRegion: true, postalCode: regexp.MustCompile(`^[A-Z]\d[A-Z] ?\d[A-Z]\d$`)},
+	"AU": {requireRegion: true, postalCode: regexp.MustCompile(`^\d{4}$`)},
+	"IN": {requireRegion: true, postalCode: regexp.MustCompile(`^\d{6}$`)},
+	"JP": {requireRegion: true, postalCode: regexp.MustCompile(`^\d{3}-?\d{4}$`)},
+	"GB": {postalCode: regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? ?\d[A-Z]{2}$`)},
+	"DE": {postalCode: regexp.MustCompile(`^\d{5}$`)},
+	"FR": {postalCode: regexp.MustCompile(`^\d{5}$`)},
+	"NL": {postalCode: regexp.MustCompile(`^\d{4} ?[A-Z]{2}$`)},
+	"LK": {postalCode: regexp.MustCompile(`^\d{5}$`)},
+	"HK": {},
+	"AE": {},
+}
+
+var countryCodeRegex = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// normalizeAddress trims every field and upper cases the codes so they match addressRules.
+func normalizeAddress(a *Address) {
+	a.FullName = strings.TrimSpace(a.FullName)
+	a.Line1 = strings.TrimSpace(a.Line1)
+	a.Line2 = strings.TrimSpace(a.Line2)
+	a.City = strings.TrimSpace(a.City)
+	a.Region = strings.TrimSpace(a.Region)
+	a.PostalCode = strings.ToUpper(strings.TrimSpace(a.PostalCode))
+	a.Country = strings.ToUpper(strings.TrimSpace(a.Country))
+	a.Phone = strings.TrimSpace(a.Phone)
+}
+
+func validateAddress(a *Address) error {
+	if !countryCodeRegex.MatchString(a.Country) {
+		return errors.New("country must be a two letter ISO 3166-1 code")
+	}
+	if a.FullName == "" {
+		return errors.New("full name cannot be empty")
+	}
+	if a.Line1 == "" {
+		return errors.New("address line 1 cannot be empty")
+	}
+	if a.City == "" {
+		return errors.New("city cannot be empty")
+	}
+
+	rule, known := addressRules[a.Country]
+	if rule.requireRegion && a.Region == "" {
+		return fmt.Errorf("region is required for addresses in %s", a.Country)
+	}
+	switch {
+	case !known:
+		return nil
+	case rule.postalCode == nil:
+		if a.PostalCode != "" {
+			return fmt.Errorf("addresses in %s have no postal code", a.Country)
+		}
+	case !rule.postalCode.MatchString(a.PostalCode):
+		return fmt.Errorf("invalid postal code for %s", a.Country)
+	}
+	return nil
+}
+
+func validateAddressUsage(usage string) error {
+	if usage != AddressUsageShipping && usage != AddressUsageBilling {
+		return ErrInvalidAddressUsage
+	}
+	return nil
+}
+
+// ListAddresses returns the addresses of accountID, which must be the caller's account unless they manage accounts.
+func (s *accountService) ListAddresses(ctx context.Context, accountID string) ([]Address, error) {
+	if _, err := s.GetAccount(ctx, accountID); err != nil {
+		return nil, err
+	}
+	return s.repository.ListAddresses(ctx, accountID)
+}
+
+// AddAddress adds an address to the caller's account. The first address becomes the
+// default for both shipping and billing.
+func (s *accountService) AddAddress(ctx context.Context, a Address) (*Address, error) {
+	normalizeAddress(&a)
+	if err := validateAddress(&a); err != nil {
+		return nil, err
+	}
+
+	account, err := s.callerAccount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.repository.ListAddresses(ctx, account.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= maxAddressesPerAccount {
+		return nil, ErrTooManyAddresses
+	}
+
+	a.ID = ksuid.New().String()
+	a.AccountID = account.ID
+	a.CreatedAt = time.Now().UTC()
+	a.DefaultShipping = len(existing) == 0
+	a.DefaultBilling = len(existing) == 0
+	if err := s.repository.PutAddress(ctx, a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// UpdateAddress replaces the fields of one of the caller's addresses, keeping its defaults.
+func (s *accountService) UpdateAddress(ctx context.Context, a Address) (*Address, error) {
+	normalizeAddress(&a)
+	if err := validateAddress(&a); err != nil {
+		return nil, err
+	}
+
+	account, err := s.callerAccount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	a.AccountID = account.ID
+	return s.repository.UpdateAddress(ctx, a)
+}
+
+// DeleteAddress removes one of the caller's addresses. Deleting a default leaves the account without one.
+func (s *accountService) DeleteAddress(ctx context.Context, id string) error {
+	account, err := s.callerAccount(ctx)
+	if err != nil {
+		return err
+	}
+	return s.repository.DeleteAddress(ctx, account.ID, id)
+}
+
+// SetDefaultAddress makes one of the caller's addresses their default for usage and returns
+// all their addresses, since the previous default changed too.
+func (s *accountService) SetDefaultAddress(ctx context.Context, id string, usage string) ([]Address, error) {
+	if err := validateAddressUsage(usage); err != nil {
+		return nil, err
+	}
+
+	account, err := s.callerAccount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repository.SetDefaultAddress(ctx, account.ID, id, usage); err != nil {
+		return nil, err
+	}
+	return s.repository.ListAddresses(ctx, account.ID)
+}
@@ -0,0 +1,214 @@
+package account
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/JonathanNithi/ecommerce/backend/authz"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	// apiKeyPrefix marks API keys so they are recognizable, e.g. by secret scanners
+	apiKeyPrefix         = "ek_"
+	maxAPIKeysPerAccount = 20
+	maxAPIKeyNameLength  = 64
+	// apiKeyUsageInterval limits how often last_used_at is written for a busy key
+	apiKeyUsageInterval = time.Minute
+)
+
+var (
+	ErrInvalidAPIKey        = errors.New("invalid or revoked api key")
+	ErrTooManyAPIKeys       = errors.New("too many api keys, revoke one first")
+	ErrAPIKeyNameRequired   = errors.New("api key name must be between 1 and 64 characters")
+	ErrAPIKeyNotAllowed     = errors.New("api keys cannot manage api keys")
+	ErrAPIKeyAlreadyExpired = errors.New("api key expiry must be in the future")
+)
+
+// APIKey lets an integration call the services without logging in. Only a hash of the key is
+// stored, Prefix is the visible start of the key so its holder can tell keys apart.
+type APIKey struct {
+	ID          string     `json:"id"`
+	AccountID   string     `json:"account_id"`
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"`
+	KeyHash     string     `json:"-"`
+	Permissions []string   `json:"permissions"` // scopes, never more than the account holds
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+}
+
+// Active reports whether the key can still be used at now.
+func (k *APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || k.ExpiresAt.After(now))
+}
+
+// generateAPIKey returns a new key of the form ek_<prefix>_<secret> and its visible prefix.
+func generateAPIKey() (string, string, error) {
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	prefix := apiKeyPrefix + hex.EncodeToString(id)
+	return prefix + "_" + base64.RawURLEncoding.EncodeToString(secret), prefix, nil
+}
+
+// apiKeyManager returns the account whose keys the caller wants to manage. Keys can only be
+// managed from the account holder's own login session so a leaked key cannot be used to mint more.
+func (s *accountService) apiKeyManager(ctx context.Context, accountID string) (*Account, error) {
+	claims := authz.ClaimsFromContext(ctx)
+	if claims != nil && claims.APIKeyID != "" {
+		return nil, ErrAPIKeyNotAllowed
+	}
+	if err := authz.RejectImpersonation(claims); err != nil {
+		return nil, err
+	}
+	return s.GetAccount(ctx, accountID)
+}
+
+// CreateAPIKey creates a key for the account, which must be the caller's own unless they may
+// manage accounts. The key itself is only returned here and cannot be recovered later.
+func (s *accountService) CreateAPIKey(ctx context.Context, accountID string, name string, permissions []string, expiresAt *time.Time) (*APIKey, string, error) {
+	account, err := s.apiKeyManager(ctx, accountID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxAPIKeyNameLength {
+		return nil, "", ErrAPIKeyNameRequired
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", ErrAPIKeyAlreadyExpired
+	}
+	// A key never grants more than the account it acts for
+	for _, p := range permissions {
+		if !authz.Has(account.Permissions, p) {
+			return nil, "", fmt.Errorf("%w: account does not hold permission %s", authz.ErrUnauthorized, p)
+		}
+	}
+
+	keys, err := s.repository.ListAPIKeys(ctx, accountID)
+	if err != nil {
+		return nil, "", err
+	}
+	active := 0
+	for _, k := range keys {
+		if k.Active(time.Now()) {
+			active++
+		}
+	}
+	if active >= maxAPIKeysPerAccount {
+		return nil, "", ErrTooManyAPIKeys
+	}
+
+	key, prefix, err := generateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+	apiKey := &APIKey{
+		ID:          ksuid.New().String(),
+		AccountID:   accountID,
+		Name:        name,
+		Prefix:      prefix,
+		KeyHash:     hashResetToken(key),
+		Permissions: append([]string{}, permissions...),
+		CreatedAt:   time.Now().UTC(),
+		ExpiresAt:   expiresAt,
+	}
+	if err := s.repository.PutAPIKey(ctx, *apiKey); err != nil {
+		return nil, "", err
+	}
+	if err := s.record(ctx, AuditCreateAPIKey, accountID, nil, apiKey); err != nil {
+		return nil, "", err
+	}
+	return apiKey, key, nil
+}
+
+// ListAPIKeys lists the keys of an account, including revoked and expired ones.
+func (s *accountService) ListAPIKeys(ctx context.Context, accountID string) ([]APIKey, error) {
+	if _, err := s.apiKeyManager(ctx, accountID); err != nil {
+		return nil, err
+	}
+	return s.repository.ListAPIKeys(ctx, accountID)
+}
+
+// RevokeAPIKey stops a key from being accepted. Services caching resolved keys accept it
+// for at most their cache lifetime.
+func (s *accountService) RevokeAPIKey(ctx context.Context, id string) error {
+	apiKey, err := s.repository.GetAPIKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	if _, err := s.apiKeyManager(ctx, apiKey.AccountID); err != nil {
+		return err
+	}
+	if apiKey.RevokedAt != nil {
+		return nil
+	}
+
+	if err := s.repository.RevokeAPIKey(ctx, id); err != nil {
+		return err
+	}
+	return s.record(ctx, AuditRevokeAPIKey, apiKey.AccountID, apiKey, nil)
+}
+
+// AuthenticateAPIKey resolves a key to the claims it grants: the key's scopes that the account
+// still holds. It implements authz.APIKeyResolver for the account service.
+func (s *accountService) AuthenticateAPIKey(ctx context.Context, key string) (*authz.Claims, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+	apiKey, err := s.repository.GetAPIKeyByHash(ctx, hashResetToken(key))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if !apiKey.Active(now) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	account, err := s.repository.GetAccountByID(ctx, apiKey.AccountID)
+	if err != nil {
+		return nil, ErrInvalidAPIKey
+	}
+	if err := CheckAccountStatus(account); err != nil {
+		return nil, err
+	}
+
+	permissions := []string{}
+	for _, p := range apiKey.Permissions {
+		if authz.Has(account.Permissions, p) {
+			permissions = append(permissions, p)
+		}
+	}
+
+	if apiKey.LastUsedAt == nil || apiKey.LastUsedAt.Add(apiKeyUsageInterval).Before(now) {
+		if err := s.repository.TouchAPIKey(ctx, apiKey.ID, now.UTC()); err != nil {
+			log.Println("Error recording api key use: ", err)
+		}
+	}
+
+	return &authz.Claims{
+		Username:    account.Email,
+		Role:        account.Role,
+		Permissions: permissions,
+		APIKeyID:    apiKey.ID,
+	}, nil
+}
@@ -0,0 +1,46 @@
+package account
+
+import (
+	"context"
+
+	"github.com/JonathanNithi/ecommerce/backend/audit"
+)
+
+// Actions the account service records in its audit log
+const (
+	AuditCreateRole   = "account.create_role"
+	AuditGrantRole    = "account.grant_role"
+	AuditRevokeRole   = "account.revoke_role"
+	AuditUnlock       = "account.unlock"
+	AuditSuspend      = "account.suspend"
+	AuditReactivate   = "account.reactivate"
+	AuditErase        = "account.erase"
+	AuditCreateAPIKey = "account.create_api_key"
+	AuditRevokeAPIKey = "account.revoke_api_key"
+	// Impersonation sessions, the actor of both is the staff member
+	AuditStartImpersonation = "account.start_impersonation"
+	AuditEndImpersonation   = "account.end_impersonation"
+)
+
+// Snapshots hold only the state an action changes, so the audit log does not become
+// a second copy of personal data.
+type roleSnapshot struct {
+	Roles []string `json:"roles"`
+}
+
+type statusSnapshot struct {
+	Status       string `json:"status"`
+	StatusReason string `json:"status_reason"`
+}
+
+func rolesOf(a *Account) interface{} {
+	return roleSnapshot{Roles: a.Roles}
+}
+
+func statusOf(a *Account) interface{} {
+	return statusSnapshot{Status: a.Status, StatusReason: a.StatusReason}
+}
+
+func (s *accountService) record(ctx context.Context, action string, target string, before interface{}, after interface{}) error {
+	return audit.Record(ctx, s.audit, action, target, before, after)
+}
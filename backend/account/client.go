@@ -2,9 +2,12 @@ package account
 
 import (
 	"context"
+	"time"
 
 	"github.com/JonathanNithi/ecommerce/backend/account/pb"
+	"github.com/JonathanNithi/ecommerce/backend/authz"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 type Client struct {
@@ -12,8 +15,10 @@ type Client struct {
 	service pb.AccountServiceClient
 }
 
+// NewClient connects to the account service. Calls are authenticated with the
+// access token attached to their context by authz.ContextWithToken.
 func NewClient(url string) (*Client, error) {
-	conn, err := grpc.Dial(url, grpc.WithInsecure())
+	conn, err := grpc.Dial(url, append(authz.DialOptions(), grpc.WithInsecure())...)
 	if err != nil {
 		return nil, err
 	}
@@ -29,155 +34,740 @@ func (c *Client) PostAccount(ctx context.Context, first_name string, last_name s
 	r, err := c.service.PostAccount(
 		ctx,
 		&pb.PostAccountRequest{FirstName: first_name,
-			LastName:     last_name,
-			Email:        email,
-			PasswordHash: password,
+			LastName: last_name,
+			Email:    email,
+			Password: password,
 		},
 	)
 	if err != nil {
 		return nil, err
 	}
 	return &Account{
-		ID:           r.Account.Id,
-		FirstName:    r.Account.FirstName,
-		LastName:     r.Account.LastName,
-		Email:        r.Account.Email,
-		PasswordHash: r.Account.PasswordHash,
+		ID:            r.Account.Id,
+		FirstName:     r.Account.FirstName,
+		LastName:      r.Account.LastName,
+		Email:         r.Account.Email,
+		EmailVerified: r.Account.EmailVerified,
+		MfaEnabled:    r.Account.MfaEnabled,
+		Status:        r.Account.Status,
+		StatusReason:  r.Account.StatusReason,
+		CreatedAt:     unmarshalCreatedAt(r.Account.CreatedAt),
 	}, nil
 }
 
-func (c *Client) GetAccount(ctx context.Context, id string, accessToken string, refreshToken string) (*Account, string, string, error) {
+func (c *Client) GetAccount(ctx context.Context, id string) (*Account, error) {
 	r, err := c.service.GetAccount(
 		ctx,
-		&pb.GetAccountRequest{Id: id,
-			AccessToken:  accessToken,
-			RefreshToken: refreshToken},
+		&pb.GetAccountRequest{Id: id},
 	)
 	if err != nil {
-		return nil, "", "", err
+		return nil, err
 	}
 	return &Account{
-		ID:        r.Account.Id,
-		FirstName: r.Account.FirstName,
-		LastName:  r.Account.LastName,
-		Email:     r.Account.Email,
-		Role:      r.Account.Role,
-	}, r.AccessToken, r.RefreshToken, nil
+		ID:            r.Account.Id,
+		FirstName:     r.Account.FirstName,
+		LastName:      r.Account.LastName,
+		Email:         r.Account.Email,
+		EmailVerified: r.Account.EmailVerified,
+		MfaEnabled:    r.Account.MfaEnabled,
+		Status:        r.Account.Status,
+		StatusReason:  r.Account.StatusReason,
+		CreatedAt:     unmarshalCreatedAt(r.Account.CreatedAt),
+		Role:          r.Account.Role,
+		Roles:         r.Account.Roles,
+		Permissions:   r.Account.Permissions,
+	}, nil
 }
 
-func (c *Client) GetAccounts(ctx context.Context, skip uint64, take uint64, accessToken string, refreshToken string) ([]Account, string, string, error) {
+func (c *Client) GetAccounts(ctx context.Context, skip uint64, take uint64) ([]Account, error) {
 	r, err := c.service.GetAccounts(
 		ctx,
 		&pb.GetAccountsRequest{
-			Skip:         skip,
-			Take:         take,
-			AccessToken:  accessToken,
-			RefreshToken: refreshToken,
+			Skip: skip,
+			Take: take,
 		},
 	)
 	if err != nil {
-		return nil, "", "", err
+		return nil, err
 	}
 	accounts := []Account{}
 	for _, a := range r.Accounts {
 		accounts = append(accounts, Account{
-			ID:        a.Id,
-			FirstName: a.FirstName,
-			LastName:  a.LastName,
-			Email:     a.Email,
-			Role:      a.Role,
+			ID:            a.Id,
+			FirstName:     a.FirstName,
+			LastName:      a.LastName,
+			Email:         a.Email,
+			EmailVerified: a.EmailVerified,
+			MfaEnabled:    a.MfaEnabled,
+			Status:        a.Status,
+			StatusReason:  a.StatusReason,
+			CreatedAt:     unmarshalCreatedAt(a.CreatedAt),
+			Role:          a.Role,
+			Roles:         a.Roles,
+			Permissions:   a.Permissions,
 		})
 	}
-	return accounts, r.AccessToken, r.RefreshToken, nil
+	return accounts, nil
 }
 
-func (c *Client) Login(ctx context.Context, email string, password string) (*Account, string, string, error) {
+// Login returns the session tokens, or only an MFA challenge token to pass to CompleteMfaLogin.
+func (c *Client) Login(ctx context.Context, email string, password string, clientIP string) (*LoginResult, error) {
+	// Forward the end user's address so the account service can throttle per client
+	if clientIP != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, ClientIPMetadataKey, clientIP)
+	}
+
 	// Call the Login method on the service layer
 	r, err := c.service.Login(ctx, &pb.LoginRequest{
 		Email:    email,
 		Password: password,
 	})
 	if err != nil {
-		return nil, "", "", err
+		return nil, err
+	}
+	if r.MfaChallengeToken != "" {
+		return &LoginResult{MfaChallengeToken: r.MfaChallengeToken}, nil
 	}
 
 	// Return the Account details and the tokens
+	return &LoginResult{
+		Account:      accountFromProfile(r.Account),
+		AccessToken:  r.AccessToken,
+		RefreshToken: r.RefreshToken,
+	}, nil
+}
+
+func (c *Client) CompleteMfaLogin(ctx context.Context, challengeToken string, code string) (*LoginResult, error) {
+	r, err := c.service.CompleteMfaLogin(ctx, &pb.CompleteMfaLoginRequest{
+		MfaChallengeToken: challengeToken,
+		Code:              code,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{
+		Account:      accountFromProfile(r.Account),
+		AccessToken:  r.AccessToken,
+		RefreshToken: r.RefreshToken,
+	}, nil
+}
+
+// EnrollMfa returns the new TOTP secret and its otpauth URI.
+func (c *Client) EnrollMfa(ctx context.Context) (string, string, error) {
+	r, err := c.service.EnrollMfa(ctx, &pb.EnrollMfaRequest{})
+	if err != nil {
+		return "", "", err
+	}
+	return r.Secret, r.OtpauthUri, nil
+}
+
+// ConfirmMfa enables two-factor authentication and returns the recovery codes.
+func (c *Client) ConfirmMfa(ctx context.Context, code string) ([]string, error) {
+	r, err := c.service.ConfirmMfa(ctx, &pb.ConfirmMfaRequest{
+		Code: code,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.RecoveryCodes, nil
+}
+
+func unmarshalCreatedAt(b []byte) time.Time {
+	var t time.Time
+	t.UnmarshalBinary(b)
+	return t
+}
+
+// AccountEdge is an account of a search result with the cursor continuing after it.
+type AccountEdge struct {
+	Cursor  string
+	Account *Account
+}
+
+// AccountSearchResult is a page of SearchAccounts, EndCursor is empty on the last page.
+type AccountSearchResult struct {
+	Edges       []AccountEdge
+	EndCursor   string
+	HasNextPage bool
+	TotalCount  uint64
+}
+
+func (c *Client) SearchAccounts(ctx context.Context, f AccountFilter, first uint32, after string) (*AccountSearchResult, error) {
+	req := &pb.SearchAccountsRequest{
+		Query:  f.Query,
+		Role:   f.Role,
+		Status: f.Status,
+		First:  first,
+		After:  after,
+	}
+	if f.CreatedAfter != nil {
+		req.CreatedAfter = marshalTime(*f.CreatedAfter)
+	}
+	if f.CreatedBefore != nil {
+		req.CreatedBefore = marshalTime(*f.CreatedBefore)
+	}
+	r, err := c.service.SearchAccounts(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &AccountSearchResult{
+		Edges:       []AccountEdge{},
+		EndCursor:   r.EndCursor,
+		HasNextPage: r.HasNextPage,
+		TotalCount:  r.TotalCount,
+	}
+	for _, e := range r.Edges {
+		res.Edges = append(res.Edges, AccountEdge{Cursor: e.Cursor, Account: accountFromProfile(e.Account)})
+	}
+	return res, nil
+}
+
+func accountFromProfile(p *pb.AccountProfile) *Account {
 	return &Account{
-		ID:           r.Account.Id,
-		FirstName:    r.Account.FirstName,
-		LastName:     r.Account.LastName,
-		Email:        r.Account.Email,
-		PasswordHash: r.Account.PasswordHash,
-		Role:         r.Account.Role,
-	}, r.AccessToken, r.RefreshToken, nil
+		ID:            p.Id,
+		FirstName:     p.FirstName,
+		LastName:      p.LastName,
+		Email:         p.Email,
+		EmailVerified: p.EmailVerified,
+		MfaEnabled:    p.MfaEnabled,
+		Status:        p.Status,
+		StatusReason:  p.StatusReason,
+		CreatedAt:     unmarshalCreatedAt(p.CreatedAt),
+		Role:          p.Role,
+		Roles:         p.Roles,
+		Permissions:   p.Permissions,
+	}
 }
 
-func (c *Client) SetAccountAsAdmin(ctx context.Context, accessToken string, refreshToken string, userId string) (*Account, string, string, error) {
+func (c *Client) SetAccountAsAdmin(ctx context.Context, userId string) (*Account, error) {
 	r, err := c.service.SetAccountAsAdmin(
 		ctx,
 		&pb.SetAccountAsAdminRequest{
-			AccessToken:  accessToken,
-			RefreshToken: refreshToken,
-			Id:           userId,
+			Id: userId,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Account{
+		ID:            r.Account.Id,
+		FirstName:     r.Account.FirstName,
+		LastName:      r.Account.LastName,
+		Email:         r.Account.Email,
+		EmailVerified: r.Account.EmailVerified,
+		MfaEnabled:    r.Account.MfaEnabled,
+		Status:        r.Account.Status,
+		StatusReason:  r.Account.StatusReason,
+		CreatedAt:     unmarshalCreatedAt(r.Account.CreatedAt),
+		Role:          r.Account.Role,
+		Roles:         r.Account.Roles,
+		Permissions:   r.Account.Permissions,
+	}, nil
+}
+
+func (c *Client) DemoteAdmin(ctx context.Context, userId string) (*Account, error) {
+	r, err := c.service.DemoteAdmin(
+		ctx,
+		&pb.DemoteAdminRequest{
+			Id: userId,
 		},
 	)
 	if err != nil {
-		return nil, "", "", err
+		return nil, err
 	}
 	return &Account{
-		ID:           r.Account.Id,
-		FirstName:    r.Account.FirstName,
-		LastName:     r.Account.LastName,
-		Email:        r.Account.Email,
-		PasswordHash: r.Account.PasswordHash,
-		Role:         r.Account.Role,
-	}, r.AccessToken, r.RefreshToken, nil
+		ID:            r.Account.Id,
+		FirstName:     r.Account.FirstName,
+		LastName:      r.Account.LastName,
+		Email:         r.Account.Email,
+		EmailVerified: r.Account.EmailVerified,
+		MfaEnabled:    r.Account.MfaEnabled,
+		Status:        r.Account.Status,
+		StatusReason:  r.Account.StatusReason,
+		CreatedAt:     unmarshalCreatedAt(r.Account.CreatedAt),
+		Role:          r.Account.Role,
+		Roles:         r.Account.Roles,
+		Permissions:   r.Account.Permissions,
+	}, nil
 }
 
-func (c *Client) ForgotPassword(ctx context.Context, email string, firstName string, lastName string) (*Account, error) {
-	r, err := c.service.ForgotPassword(ctx, &pb.ForgotPasswordRequest{
-		Email:     email,
-		FirstName: firstName,
-		LastName:  lastName,
+func (c *Client) CreateRole(ctx context.Context, name string, permissions []string) (*Role, error) {
+	r, err := c.service.CreateRole(
+		ctx,
+		&pb.CreateRoleRequest{
+			Name:        name,
+			Permissions: permissions,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Role{
+		Name:        r.Role.Name,
+		Permissions: r.Role.Permissions,
+	}, nil
+}
+
+func (c *Client) GrantRole(ctx context.Context, userId string, role string) (*Account, error) {
+	r, err := c.service.GrantRole(
+		ctx,
+		&pb.GrantRoleRequest{
+			Id:   userId,
+			Role: role,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Account{
+		ID:            r.Account.Id,
+		FirstName:     r.Account.FirstName,
+		LastName:      r.Account.LastName,
+		Email:         r.Account.Email,
+		EmailVerified: r.Account.EmailVerified,
+		MfaEnabled:    r.Account.MfaEnabled,
+		Status:        r.Account.Status,
+		StatusReason:  r.Account.StatusReason,
+		CreatedAt:     unmarshalCreatedAt(r.Account.CreatedAt),
+		Role:          r.Account.Role,
+		Roles:         r.Account.Roles,
+		Permissions:   r.Account.Permissions,
+	}, nil
+}
+
+func (c *Client) RevokeRole(ctx context.Context, userId string, role string) (*Account, error) {
+	r, err := c.service.RevokeRole(
+		ctx,
+		&pb.RevokeRoleRequest{
+			Id:   userId,
+			Role: role,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Account{
+		ID:            r.Account.Id,
+		FirstName:     r.Account.FirstName,
+		LastName:      r.Account.LastName,
+		Email:         r.Account.Email,
+		EmailVerified: r.Account.EmailVerified,
+		MfaEnabled:    r.Account.MfaEnabled,
+		Status:        r.Account.Status,
+		StatusReason:  r.Account.StatusReason,
+		CreatedAt:     unmarshalCreatedAt(r.Account.CreatedAt),
+		Role:          r.Account.Role,
+		Roles:         r.Account.Roles,
+		Permissions:   r.Account.Permissions,
+	}, nil
+}
+
+func (c *Client) ForgotPassword(ctx context.Context, email string) error {
+	_, err := c.service.ForgotPassword(ctx, &pb.ForgotPasswordRequest{
+		Email: email,
+	})
+	return err
+}
+
+func (c *Client) ResetPassword(ctx context.Context, token string, password string) error {
+	_, err := c.service.ResetPassword(ctx, &pb.ResetPasswordRequest{
+		Token:    token,
+		Password: password,
+	})
+	return err
+}
+
+// RefreshToken returns a new access token and the refresh token that replaces the one presented.
+func (c *Client) RefreshToken(ctx context.Context, refreshToken string) (string, string, error) {
+	r, err := c.service.RefreshToken(ctx, &pb.RefreshTokenRequest{
+		RefreshToken: refreshToken,
 	})
+	if err != nil {
+		return "", "", err
+	}
+	return r.AccessToken, r.RefreshToken, nil
+}
+
+func (c *Client) Logout(ctx context.Context, refreshToken string) error {
+	_, err := c.service.Logout(ctx, &pb.LogoutRequest{
+		RefreshToken: refreshToken,
+	})
+	return err
+}
+
+func (c *Client) RevokeAllSessions(ctx context.Context) error {
+	_, err := c.service.RevokeAllSessions(ctx, &pb.RevokeAllSessionsRequest{})
+	return err
+}
+
+func (c *Client) UnlockAccount(ctx context.Context, userId string) (*Account, error) {
+	r, err := c.service.UnlockAccount(
+		ctx,
+		&pb.UnlockAccountRequest{
+			Id: userId,
+		},
+	)
 	if err != nil {
 		return nil, err
 	}
 	return &Account{
-		ID:           r.Account.Id,
-		FirstName:    r.Account.FirstName,
-		LastName:     r.Account.LastName,
-		Email:        r.Account.Email,
-		PasswordHash: r.Account.PasswordHash,
-		Role:         r.Account.Role,
+		ID:            r.Account.Id,
+		FirstName:     r.Account.FirstName,
+		LastName:      r.Account.LastName,
+		Email:         r.Account.Email,
+		EmailVerified: r.Account.EmailVerified,
+		MfaEnabled:    r.Account.MfaEnabled,
+		Status:        r.Account.Status,
+		StatusReason:  r.Account.StatusReason,
+		CreatedAt:     unmarshalCreatedAt(r.Account.CreatedAt),
+		Role:          r.Account.Role,
+		Roles:         r.Account.Roles,
+		Permissions:   r.Account.Permissions,
 	}, nil
 }
 
-func (c *Client) ResetPassword(ctx context.Context, id string, email string, password string) (*Account, error) {
-	r, err := c.service.ResetPassword(ctx, &pb.ResetPasswordRequest{
-		Id:       id,
-		Email:    email,
+// UpdateProfile changes the name of the account the context's access token belongs to.
+func (c *Client) UpdateProfile(ctx context.Context, firstName string, lastName string) (*Account, error) {
+	r, err := c.service.UpdateProfile(
+		ctx,
+		&pb.UpdateProfileRequest{
+			FirstName: firstName,
+			LastName:  lastName,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Account{
+		ID:            r.Account.Id,
+		FirstName:     r.Account.FirstName,
+		LastName:      r.Account.LastName,
+		Email:         r.Account.Email,
+		EmailVerified: r.Account.EmailVerified,
+		MfaEnabled:    r.Account.MfaEnabled,
+		Status:        r.Account.Status,
+		StatusReason:  r.Account.StatusReason,
+		CreatedAt:     unmarshalCreatedAt(r.Account.CreatedAt),
+		Role:          r.Account.Role,
+		Roles:         r.Account.Roles,
+		Permissions:   r.Account.Permissions,
+	}, nil
+}
+
+func (c *Client) ChangeEmail(ctx context.Context, newEmail string, password string) error {
+	_, err := c.service.ChangeEmail(ctx, &pb.ChangeEmailRequest{
+		NewEmail: newEmail,
 		Password: password,
 	})
+	return err
+}
+
+func (c *Client) ConfirmEmailChange(ctx context.Context, token string) (*Account, error) {
+	r, err := c.service.ConfirmEmailChange(ctx, &pb.ConfirmEmailChangeRequest{
+		Token: token,
+	})
 	if err != nil {
 		return nil, err
 	}
 	return &Account{
-		ID:           r.Account.Id,
-		FirstName:    r.Account.FirstName,
-		LastName:     r.Account.LastName,
-		Email:        r.Account.Email,
-		PasswordHash: r.Account.PasswordHash,
-		Role:         r.Account.Role,
+		ID:            r.Account.Id,
+		FirstName:     r.Account.FirstName,
+		LastName:      r.Account.LastName,
+		Email:         r.Account.Email,
+		EmailVerified: r.Account.EmailVerified,
+		MfaEnabled:    r.Account.MfaEnabled,
+		Status:        r.Account.Status,
+		StatusReason:  r.Account.StatusReason,
+		CreatedAt:     unmarshalCreatedAt(r.Account.CreatedAt),
+		Role:          r.Account.Role,
+		Roles:         r.Account.Roles,
+		Permissions:   r.Account.Permissions,
 	}, nil
 }
 
-func (c *Client) RefreshToken(ctx context.Context, refreshToken string) (string, error) {
-	r, err := c.service.RefreshToken(ctx, &pb.RefreshTokenRequest{
-		RefreshToken: refreshToken,
+func (c *Client) ChangePassword(ctx context.Context, currentPassword string, newPassword string) error {
+	_, err := c.service.ChangePassword(ctx, &pb.ChangePasswordRequest{
+		CurrentPassword: currentPassword,
+		NewPassword:     newPassword,
+	})
+	return err
+}
+
+func (c *Client) VerifyEmail(ctx context.Context, token string) (*Account, error) {
+	r, err := c.service.VerifyEmail(ctx, &pb.VerifyEmailRequest{
+		Token: token,
 	})
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	return &Account{
+		ID:            r.Account.Id,
+		FirstName:     r.Account.FirstName,
+		LastName:      r.Account.LastName,
+		Email:         r.Account.Email,
+		EmailVerified: r.Account.EmailVerified,
+		MfaEnabled:    r.Account.MfaEnabled,
+		Status:        r.Account.Status,
+		StatusReason:  r.Account.StatusReason,
+		CreatedAt:     unmarshalCreatedAt(r.Account.CreatedAt),
+		Role:          r.Account.Role,
+		Roles:         r.Account.Roles,
+		Permissions:   r.Account.Permissions,
+	}, nil
+}
+
+func (c *Client) ResendVerificationEmail(ctx context.Context, email string) error {
+	_, err := c.service.ResendVerificationEmail(ctx, &pb.ResendVerificationEmailRequest{
+		Email: email,
+	})
+	return err
+}
+
+// SuspendAccount blocks an account until it is reactivated, reason is recorded with the status.
+func (c *Client) SuspendAccount(ctx context.Context, userId string, reason string) (*Account, error) {
+	r, err := c.service.SuspendAccount(ctx, &pb.SuspendAccountRequest{
+		Id:     userId,
+		Reason: reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return accountFromProfile(r.Account), nil
+}
+
+// ImpersonateAccount starts acting as the account with userId. The returned access token is
+// used like the account's own until it expires.
+func (c *Client) ImpersonateAccount(ctx context.Context, userId string, reason string) (*Impersonation, error) {
+	r, err := c.service.ImpersonateAccount(ctx, &pb.ImpersonateAccountRequest{
+		Id:     userId,
+		Reason: reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+	i := &Impersonation{
+		SessionID:   r.SessionId,
+		AccessToken: r.AccessToken,
+		Account:     accountFromProfile(r.Account),
+	}
+	if err := i.ExpiresAt.UnmarshalBinary(r.ExpiresAt); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// EndImpersonation ends the session of the impersonation token in ctx.
+func (c *Client) EndImpersonation(ctx context.Context) error {
+	_, err := c.service.EndImpersonation(ctx, &pb.EndImpersonationRequest{})
+	return err
+}
+
+func (c *Client) ReactivateAccount(ctx context.Context, userId string, reason string) (*Account, error) {
+	r, err := c.service.ReactivateAccount(ctx, &pb.ReactivateAccountRequest{
+		Id:     userId,
+		Reason: reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return accountFromProfile(r.Account), nil
+}
+
+// CloseAccount closes the account the context's access token belongs to.
+func (c *Client) CloseAccount(ctx context.Context, password string) error {
+	_, err := c.service.CloseAccount(ctx, &pb.CloseAccountRequest{
+		Password: password,
+	})
+	return err
+}
+
+// ExportAccountData returns the JSON archive of everything held about an account.
+func (c *Client) ExportAccountData(ctx context.Context, userId string) ([]byte, error) {
+	r, err := c.service.ExportAccountData(ctx, &pb.ExportAccountDataRequest{
+		Id: userId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.Archive, nil
+}
+
+func (c *Client) EraseAccount(ctx context.Context, userId string, reason string) error {
+	_, err := c.service.EraseAccount(ctx, &pb.EraseAccountRequest{
+		Id:     userId,
+		Reason: reason,
+	})
+	return err
+}
+
+// ListAddresses returns the addresses of an account, the caller's own unless they manage accounts.
+func (c *Client) ListAddresses(ctx context.Context, accountID string) ([]Address, error) {
+	r, err := c.service.ListAddresses(ctx, &pb.ListAddressesRequest{
+		AccountId: accountID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return addressesFromMessages(r.Addresses), nil
+}
+
+func (c *Client) AddAddress(ctx context.Context, a Address) (*Address, error) {
+	r, err := c.service.AddAddress(ctx, &pb.AddAddressRequest{
+		Address: addressMessage(&a),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return addressFromResponse(r.Address), nil
+}
+
+func (c *Client) UpdateAddress(ctx context.Context, a Address) (*Address, error) {
+	r, err := c.service.UpdateAddress(ctx, &pb.UpdateAddressRequest{
+		Address: addressMessage(&a),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return addressFromResponse(r.Address), nil
+}
+
+func (c *Client) DeleteAddress(ctx context.Context, id string) error {
+	_, err := c.service.DeleteAddress(ctx, &pb.DeleteAddressRequest{
+		Id: id,
+	})
+	return err
+}
+
+// SetDefaultAddress returns all addresses of the caller with their updated defaults.
+func (c *Client) SetDefaultAddress(ctx context.Context, id string, usage string) ([]Address, error) {
+	r, err := c.service.SetDefaultAddress(ctx, &pb.SetDefaultAddressRequest{
+		Id:    id,
+		Usage: usage,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return addressesFromMessages(r.Addresses), nil
+}
+
+// addressFromResponse is addressFromMessage plus the default flags only the server sets.
+func addressFromResponse(m *pb.Address) *Address {
+	a := addressFromMessage(m)
+	a.DefaultShipping = m.GetDefaultShipping()
+	a.DefaultBilling = m.GetDefaultBilling()
+	return &a
+}
+
+func addressesFromMessages(messages []*pb.Address) []Address {
+	addresses := []Address{}
+	for _, m := range messages {
+		addresses = append(addresses, *addressFromResponse(m))
+	}
+	return addresses
+}
+
+// CreateAPIKey returns the created key and the key itself, which cannot be retrieved again.
+func (c *Client) CreateAPIKey(ctx context.Context, accountID string, name string, permissions []string, expiresAt *time.Time) (*APIKey, string, error) {
+	r, err := c.service.CreateApiKey(ctx, &pb.CreateApiKeyRequest{
+		AccountId:   accountID,
+		Name:        name,
+		Permissions: permissions,
+		ExpiresAt:   optionalTime(expiresAt),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	k, err := apiKeyFromMessage(r.ApiKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return k, r.Key, nil
+}
+
+func (c *Client) ListAPIKeys(ctx context.Context, accountID string) ([]APIKey, error) {
+	r, err := c.service.ListApiKeys(ctx, &pb.ListApiKeysRequest{
+		AccountId: accountID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := []APIKey{}
+	for _, m := range r.ApiKeys {
+		k, err := apiKeyFromMessage(m)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, nil
+}
+
+func (c *Client) RevokeAPIKey(ctx context.Context, id string) error {
+	_, err := c.service.RevokeApiKey(ctx, &pb.RevokeApiKeyRequest{
+		Id: id,
+	})
+	return err
+}
+
+// AuthenticateAPIKey implements authz.APIKeyResolver for services that accept API keys.
+func (c *Client) AuthenticateAPIKey(ctx context.Context, key string) (*authz.Claims, error) {
+	r, err := c.service.AuthenticateApiKey(ctx, &pb.AuthenticateApiKeyRequest{
+		Key: key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &authz.Claims{
+		Username:    r.Username,
+		Role:        r.Role,
+		Permissions: r.Permissions,
+		APIKeyID:    r.ApiKeyId,
+	}, nil
+}
+
+func apiKeyFromMessage(m *pb.ApiKey) (*APIKey, error) {
+	k := &APIKey{
+		ID:          m.GetId(),
+		AccountID:   m.GetAccountId(),
+		Name:        m.GetName(),
+		Prefix:      m.GetPrefix(),
+		Permissions: m.GetPermissions(),
+	}
+	if err := k.CreatedAt.UnmarshalBinary(m.GetCreatedAt()); err != nil {
+		return nil, err
+	}
+	var err error
+	if k.ExpiresAt, err = unmarshalTime(m.GetExpiresAt()); err != nil {
+		return nil, err
+	}
+	if k.LastUsedAt, err = unmarshalTime(m.GetLastUsedAt()); err != nil {
+		return nil, err
+	}
+	if k.RevokedAt, err = unmarshalTime(m.GetRevokedAt()); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// GetJWKS returns the key set that verifies access tokens issued by the account service.
+func (c *Client) GetJWKS(ctx context.Context) (authz.JWKS, error) {
+	r, err := c.service.GetJwks(ctx, &pb.GetJwksRequest{})
+	if err != nil {
+		return authz.JWKS{}, err
+	}
+	set := authz.JWKS{Keys: []authz.JWK{}}
+	for _, k := range r.Keys {
+		set.Keys = append(set.Keys, authz.JWK{
+			Kty: k.Kty,
+			Kid: k.Kid,
+			Use: k.Use,
+			Alg: k.Alg,
+			N:   k.N,
+			E:   k.E,
+			Crv: k.Crv,
+			X:   k.X,
+		})
 	}
-	return r.AccessToken, nil
+	return set, nil
 }
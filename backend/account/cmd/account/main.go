@@ -1,16 +1,36 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os"
 	"time"
 
 	"github.com/JonathanNithi/ecommerce/backend/account"
+	"github.com/JonathanNithi/ecommerce/backend/audit"
+	"github.com/JonathanNithi/ecommerce/backend/migrate"
+	"github.com/JonathanNithi/ecommerce/backend/notification"
+	"github.com/JonathanNithi/ecommerce/backend/order"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/tinrab/retry"
 )
 
 type Config struct {
 	DatabaseURL string `envconfig:"DATABASE_URL"`
+	// RS256 or EdDSA, used when a new signing key is generated
+	JWTAlgorithm string `envconfig:"JWT_ALGORITHM" default:"EdDSA"`
+	// Load signing keys from <kid>.pem files instead of Postgres, keys are then rotated by replacing the files
+	JWTKeysDir     string `envconfig:"JWT_KEYS_DIR"`
+	JWTActiveKeyID string `envconfig:"JWT_ACTIVE_KEY_ID"`
+	// Age at which the active key stored in Postgres is replaced
+	JWTKeyRotationPeriod time.Duration `envconfig:"JWT_KEY_ROTATION_PERIOD" default:"720h"`
+	// The order service is called for data exports
+	OrderURL string `envconfig:"ORDER_SERVICE_URL"`
+	account.VerificationPolicy
+	account.MfaPolicy
+	account.PasswordPolicy
+	account.GatewayPolicy
+	notification.Config
 }
 
 func main() {
@@ -20,6 +40,36 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// "account migrate [up | down [steps] | status]" only migrates, the service migrates on every start
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrator, err := migrate.NewPostgresMigrator(cfg.DatabaseURL, account.Migrations())
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer migrator.Close()
+		if err := migrate.Command(context.Background(), migrator, os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	retry.ForeverSleep(2*time.Second, func(_ int) error {
+		migrator, err := migrate.NewPostgresMigrator(cfg.DatabaseURL, account.Migrations())
+		if err != nil {
+			log.Println(err)
+			return err
+		}
+		defer migrator.Close()
+		applied, err := migrator.Up(context.Background())
+		for _, m := range applied {
+			log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Println(err)
+		}
+		return err
+	})
+
 	var r account.Repository
 	retry.ForeverSleep(2*time.Second, func(_ int) (err error) {
 		r, err = account.NewPostgresRepository(cfg.DatabaseURL)
@@ -30,7 +80,63 @@ func main() {
 	})
 	defer r.Close()
 
+	var outbox notification.Repository
+	retry.ForeverSleep(2*time.Second, func(_ int) (err error) {
+		outbox, err = notification.NewPostgresRepository(cfg.DatabaseURL)
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	})
+	defer outbox.Close()
+
+	var auditLog audit.Repository
+	retry.ForeverSleep(2*time.Second, func(_ int) (err error) {
+		auditLog, err = audit.NewPostgresRepository(cfg.DatabaseURL)
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	})
+	defer auditLog.Close()
+
+	var keyStore account.KeyStore
+	if cfg.JWTKeysDir != "" {
+		keyStore = account.NewFileKeyStore(cfg.JWTKeysDir, cfg.JWTActiveKeyID)
+	} else {
+		retry.ForeverSleep(2*time.Second, func(_ int) (err error) {
+			keyStore, err = account.NewPostgresKeyStore(cfg.DatabaseURL)
+			if err != nil {
+				log.Println(err)
+			}
+			return
+		})
+	}
+	keys, err := account.NewKeyRing(context.Background(), keyStore, cfg.JWTAlgorithm)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.JWTKeysDir == "" {
+		go keys.RunRotation(context.Background(), cfg.JWTKeyRotationPeriod, time.Hour)
+	}
+
+	renderer, err := notification.NewRenderer(cfg.AppURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sender, err := notification.NewSender(cfg.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go notification.NewWorker(outbox, sender).Run(context.Background())
+
+	orderClient, err := order.NewClient(cfg.OrderURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer orderClient.Close()
+
 	log.Println("Listening on port 8080...")
-	s := account.NewService(r)
-	log.Fatal(account.ListenGRPC(s, 8080))
+	s := account.NewService(r, notification.NewOutboxNotifier(outbox, renderer), keys, cfg.VerificationPolicy, cfg.MfaPolicy, cfg.PasswordPolicy, orderClient, auditLog)
+	log.Fatal(account.ListenGRPC(s, auditLog, keys.Verifier().WithClaimsCheck(account.ActiveAccountCheck(r)).WithAPIKeys(s.AuthenticateAPIKey), cfg.GatewayPolicy, 8080))
 }
@@ -0,0 +1,121 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/JonathanNithi/ecommerce/backend/notification"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	emailVerificationTokenTTL = 48 * time.Hour
+	verificationEmailWindow   = 1 * time.Hour
+	verificationEmailLimit    = 3 // Emails per window, including the one sent on signup
+)
+
+var (
+	ErrInvalidVerificationToken  = errors.New("invalid or expired email verification token")
+	ErrEmailNotVerified          = errors.New("email address is not verified")
+	ErrTooManyVerificationEmails = errors.New("too many verification emails requested, try again later")
+)
+
+// VerificationPolicy controls what accounts may do before their email address is verified.
+// The account service enforces the login rule and the order service the ordering rule.
+type VerificationPolicy struct {
+	AllowUnverifiedLogin  bool `envconfig:"ALLOW_UNVERIFIED_LOGIN" default:"true"`
+	AllowUnverifiedOrders bool `envconfig:"ALLOW_UNVERIFIED_ORDERS" default:"false"`
+}
+
+func (p VerificationPolicy) CanLogin(a *Account) bool {
+	return a.EmailVerified || p.AllowUnverifiedLogin
+}
+
+func (p VerificationPolicy) CanOrder(a *Account) bool {
+	return a.EmailVerified || p.AllowUnverifiedOrders
+}
+
+// EmailVerificationToken proves ownership of the email an account signed up with.
+// Like password reset tokens only the SHA-256 hash is stored.
+type EmailVerificationToken struct {
+	ID        string     `json:"id"`
+	AccountID string     `json:"account_id"`
+	TokenHash string     `json:"token_hash"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// sendVerificationEmail issues a new token for the account and mails it, refusing once
+// verificationEmailLimit emails were sent within verificationEmailWindow.
+func (s *accountService) sendVerificationEmail(ctx context.Context, account *Account) error {
+	now := time.Now().UTC()
+	sent, err := s.repository.CountEmailVerificationTokensSince(ctx, account.ID, now.Add(-verificationEmailWindow))
+	if err != nil {
+		return err
+	}
+	if sent >= verificationEmailLimit {
+		return ErrTooManyVerificationEmails
+	}
+
+	token, err := generateResetToken()
+	if err != nil {
+		return err
+	}
+
+	t := EmailVerificationToken{
+		ID:        ksuid.New().String(),
+		AccountID: account.ID,
+		TokenHash: hashResetToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(emailVerificationTokenTTL),
+	}
+	if err := s.repository.PutEmailVerificationToken(ctx, t); err != nil {
+		return err
+	}
+
+	return s.notifier.Notify(ctx, account.Email, notification.TemplateEmailVerification, notification.EmailVerificationData{
+		FirstName: account.FirstName,
+		Token:     token,
+	})
+}
+
+func (s *accountService) VerifyEmail(ctx context.Context, token string) (*Account, error) {
+	t, err := s.repository.GetEmailVerificationToken(ctx, hashResetToken(token))
+	if err != nil {
+		return nil, ErrInvalidVerificationToken
+	}
+	if t.UsedAt != nil || t.ExpiresAt.Before(time.Now()) {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	if err := s.repository.MarkEmailVerificationTokenUsed(ctx, t.ID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalidVerificationToken
+		}
+		return nil, err
+	}
+
+	if err := s.repository.MarkEmailVerified(ctx, t.AccountID); err != nil {
+		return nil, err
+	}
+
+	return s.repository.GetAccountByID(ctx, t.AccountID)
+}
+
+// ResendVerificationEmail takes an email rather than a token because unverified accounts
+// may not be allowed to log in. Unknown and already verified emails are silently ignored.
+func (s *accountService) ResendVerificationEmail(ctx context.Context, email string) error {
+	account, err := s.repository.GetAccountByEmail(ctx, email)
+	if err != nil {
+		log.Printf("Verification email requested for unknown email: %v", err)
+		return nil
+	}
+	if account.EmailVerified {
+		return nil
+	}
+
+	return s.sendVerificationEmail(ctx, account)
+}
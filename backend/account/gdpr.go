@@ -0,0 +1,128 @@
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/JonathanNithi/ecommerce/backend/authz"
+	"github.com/segmentio/ksuid"
+)
+
+// Kinds of data subject request kept in the audit trail
+const (
+	DataRequestExport  = "export"
+	DataRequestErasure = "erasure"
+)
+
+const dataExportFormatVersion = 1
+
+var ErrAlreadyErased = errors.New("account has already been erased")
+
+// OrderSource fetches the orders of an account for data exports. *order.Client implements it,
+// it is an interface because the order package already depends on this one.
+type OrderSource interface {
+	ExportOrders(ctx context.Context, accountID string) (json.RawMessage, error)
+}
+
+// DataRequest is the audit record of an export or erasure, naming who triggered it.
+type DataRequest struct {
+	ID          string    `json:"id"`
+	AccountID   string    `json:"account_id"`
+	Kind        string    `json:"kind"`
+	RequestedBy string    `json:"requested_by"` // username of the caller
+	Reason      string    `json:"reason"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AccountExport is the archive returned for a subject access request.
+type AccountExport struct {
+	FormatVersion int             `json:"format_version"`
+	GeneratedAt   time.Time       `json:"generated_at"`
+	Account       *Account        `json:"account"`
+	Addresses     []Address       `json:"addresses"`
+	Orders        json.RawMessage `json:"orders"`
+}
+
+// erasedEmail replaces the email of an erased account. It stays unique and cannot receive mail.
+func erasedEmail(id string) string {
+	return "erased-" + strings.ToLower(id) + "@erased.invalid"
+}
+
+// ExportAccountData returns everything held about an account as a JSON document. Account holders
+// can export their own data, anyone else needs to manage accounts.
+func (s *accountService) ExportAccountData(ctx context.Context, id string) ([]byte, error) {
+	account, err := s.GetAccount(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses, err := s.repository.ListAddresses(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// Forwarded with the caller's token, so the order service applies its own access rules
+	orders, err := s.orders.ExportOrders(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := json.MarshalIndent(AccountExport{
+		FormatVersion: dataExportFormatVersion,
+		GeneratedAt:   time.Now().UTC(),
+		Account:       account,
+		Addresses:     addresses,
+		Orders:        orders,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.repository.PutDataRequest(ctx, DataRequest{
+		ID:          ksuid.New().String(),
+		AccountID:   id,
+		Kind:        DataRequestExport,
+		RequestedBy: authz.ClaimsFromContext(ctx).Username,
+		CreatedAt:   time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// EraseAccount anonymizes the personal data of an account and closes it. The account row is kept
+// so orders still reference it for accounting, everything else about the account is deleted.
+func (s *accountService) EraseAccount(ctx context.Context, id string, reason string) error {
+	claims := authz.ClaimsFromContext(ctx)
+	if err := authz.Require(claims, authz.AccountsManage); err != nil {
+		return err
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return ErrStatusReasonRequired
+	}
+
+	account, err := s.repository.GetAccountByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if account.Email == erasedEmail(account.ID) {
+		return ErrAlreadyErased
+	}
+
+	err = s.repository.EraseAccount(ctx, account, DataRequest{
+		ID:          ksuid.New().String(),
+		AccountID:   id,
+		Kind:        DataRequestErasure,
+		RequestedBy: claims.Username,
+		Reason:      reason,
+		CreatedAt:   time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	return s.record(ctx, AuditErase, id, statusOf(account), statusSnapshot{Status: StatusClosed, StatusReason: reason})
+}
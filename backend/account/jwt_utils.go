@@ -2,98 +2,143 @@ package account
 
 import (
 	"context"
-	"os"
+	"errors"
 	"time"
 
+	"github.com/JonathanNithi/ecommerce/backend/authz"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/segmentio/ksuid"
 )
 
-var jwtKey = []byte(os.Getenv("SECRET_KEY"))
+const refreshTokenTTL = 7 * 24 * time.Hour // Refresh tokens expire in 7 days
 
-type Claims struct {
-	Username string `json:"username"`
-	Role     string `json:"role"`
-	jwt.RegisteredClaims
-}
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// Claims are shared with the other services, which verify access tokens through authz
+type Claims = authz.Claims
 
-// GenerateAccessToken generates a new access token
-func GenerateAccessToken(username string, role string) (string, error) {
+// GenerateAccessToken generates a new access token carrying the account's permissions
+func (k *KeyRing) GenerateAccessToken(username string, role string, permissions []string) (string, error) {
 	expirationTime := time.Now().Add(60 * time.Minute) // Access token expires in 60 minutes
 	claims := &Claims{
-		Username: username,
-		Role:     role,
+		Username:    username,
+		Role:        role,
+		Permissions: permissions,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+		},
+	}
+
+	return k.Sign(claims)
+}
+
+// GenerateImpersonationToken generates an access token for username on behalf of actor. It carries
+// the customer's role and permissions, expires after impersonationTokenTTL and comes without a
+// refresh token. sessionID is its jti, which the audit trail of the session refers to.
+func (k *KeyRing) GenerateImpersonationToken(username string, role string, permissions []string, actor string, sessionID string) (string, time.Time, error) {
+	expirationTime := time.Now().Add(impersonationTokenTTL)
+	claims := &Claims{
+		Username:    username,
+		Role:        role,
+		Permissions: permissions,
+		Actor:       actor,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sessionID,
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+	token, err := k.Sign(claims)
+	return token, expirationTime, err
 }
 
-// GenerateRefreshToken generates a new refresh token
-func GenerateRefreshToken(username string, role string) (string, error) {
-	expirationTime := time.Now().Add(7 * 24 * time.Hour) // Refresh token expires in 7 days
+// GenerateRefreshToken generates a new refresh token carrying tokenID as its jti.
+// The token is only honoured while a matching row exists in the refresh token store,
+// its audience keeps it from being accepted as an access token.
+func (k *KeyRing) GenerateRefreshToken(username string, role string, tokenID string) (string, error) {
+	expirationTime := time.Now().Add(refreshTokenTTL)
 	claims := &Claims{
 		Username: username,
 		Role:     role,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Audience:  jwt.ClaimStrings{authz.RefreshTokenAudience},
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+	return k.Sign(claims)
 }
 
-// ValidateToken validates a JWT token and returns the claims
-func ValidateToken(tokenString string) (*Claims, error) {
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
+// ValidateToken validates a JWT token signed by one of the ring's keys and returns the claims
+func (k *KeyRing) ValidateToken(ctx context.Context, tokenString string) (*Claims, error) {
+	return k.Verifier().Verify(ctx, tokenString)
+}
 
-	if err != nil {
-		return nil, err
-	}
+// ValidateRefreshToken validates a refresh token, access tokens are rejected.
+func (k *KeyRing) ValidateRefreshToken(ctx context.Context, tokenString string) (*Claims, error) {
+	return k.Verifier().VerifyAudience(ctx, tokenString, authz.RefreshTokenAudience)
+}
 
-	if !token.Valid {
-		return nil, jwt.ErrSignatureInvalid
+// issueRefreshToken persists a new refresh token in the given family and returns it signed.
+func (s *accountService) issueRefreshToken(ctx context.Context, accountID string, familyID string, username string, role string) (string, error) {
+	t := RefreshToken{
+		ID:        ksuid.New().String(),
+		AccountID: accountID,
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().UTC().Add(refreshTokenTTL),
 	}
-
-	if claims.ExpiresAt.Time.Before(time.Now()) {
-		return nil, jwt.ErrTokenExpired
+	if err := s.repository.PutRefreshToken(ctx, t); err != nil {
+		return "", err
 	}
-
-	return claims, nil
+	return s.keys.GenerateRefreshToken(username, role, t.ID)
 }
 
-func (s *accountService) validateAndRegenerateToken(ctx context.Context, accessToken string, refreshToken string) (newAccessToken string, newRefreshToken string, claims *Claims, err error) {
-	// Validate the access token (we might just check for expiry here)
-	accessClaims, accessErr := ValidateToken(accessToken)
-
-	// Validate the refresh token
-	refreshClaims, refreshErr := ValidateToken(refreshToken)
-	if refreshErr != nil {
-		return "", "", nil, refreshErr // Refresh token is invalid, force re-login
+// checkRefreshToken looks up the stored record behind already validated refresh token claims.
+// Presenting a token that was rotated out or revoked is treated as theft and revokes its whole family.
+func (s *accountService) checkRefreshToken(ctx context.Context, claims *Claims) (*RefreshToken, error) {
+	if claims.ID == "" {
+		return nil, ErrInvalidRefreshToken
 	}
-
-	if accessErr != nil && accessErr == jwt.ErrTokenExpired {
-		// Access token is expired, use the refresh token to get a new one
-		newAccessToken, err = GenerateAccessToken(refreshClaims.Username, refreshClaims.Role)
-		if err != nil {
-			return "", "", nil, err
-		}
-		newRefreshToken, err = GenerateRefreshToken(refreshClaims.Username, refreshClaims.Role) // Optional: Rotate refresh token
-		if err != nil {
-			return "", "", nil, err
+	t, err := s.repository.GetRefreshToken(ctx, claims.ID)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	if t.RevokedAt != nil {
+		if err := s.repository.RevokeRefreshTokenFamily(ctx, t.FamilyID); err != nil {
+			return nil, err
 		}
-		return newAccessToken, newRefreshToken, refreshClaims, nil
-	} else if accessErr != nil {
-		// Access token is invalid for other reasons
-		return "", "", nil, accessErr
+		return nil, ErrRefreshTokenReused
+	}
+	if t.ExpiresAt.Before(time.Now()) {
+		return nil, jwt.ErrTokenExpired
 	}
+	return t, nil
+}
 
-	// Access token is still valid
-	return accessToken, refreshToken, accessClaims, nil
+// rotateRefreshToken revokes the presented refresh token and issues its successor in the same family.
+// It returns the current state of the token's account alongside the new token.
+func (s *accountService) rotateRefreshToken(ctx context.Context, claims *Claims) (*Account, string, error) {
+	t, err := s.checkRefreshToken(ctx, claims)
+	if err != nil {
+		return nil, "", err
+	}
+	account, err := s.repository.GetAccountByID(ctx, t.AccountID)
+	if err != nil {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	if err := CheckAccountStatus(account); err != nil {
+		return nil, "", err
+	}
+	if err := s.repository.RevokeRefreshToken(ctx, t.ID); err != nil {
+		return nil, "", err
+	}
+	newRefreshToken, err := s.issueRefreshToken(ctx, t.AccountID, t.FamilyID, account.Email, account.Role)
+	if err != nil {
+		return nil, "", err
+	}
+	return account, newRefreshToken, nil
 }
@@ -0,0 +1,107 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	loginFailureWindow    = 15 * time.Minute // Failures older than this no longer count
+	loginDelayAfter       = 3                // Failures before progressive delays kick in
+	loginBaseDelay        = 1 * time.Second
+	loginMaxDelay         = 30 * time.Second
+	accountLockThreshold  = 10
+	clientIPLockThreshold = 50 // Higher because many customers can share one address
+	loginLockoutDuration  = 15 * time.Minute
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrTooManyAttempts    = errors.New("too many failed login attempts, try again later")
+)
+
+// LoginThrottle tracks recent failed logins for a throttling key (an email or a client IP).
+type LoginThrottle struct {
+	Key           string     `json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	LockedUntil   *time.Time `json:"locked_until"`
+}
+
+func emailThrottleKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func clientIPThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginDelay is how long a key must wait after its last failure before trying again.
+func loginDelay(failures int) time.Duration {
+	if failures < loginDelayAfter {
+		return 0
+	}
+	d := loginBaseDelay
+	for i := loginDelayAfter; i < failures; i++ {
+		d *= 2
+		if d >= loginMaxDelay {
+			return loginMaxDelay
+		}
+	}
+	return d
+}
+
+// checkLoginAllowed rejects the attempt while a key is locked out or still inside its delay.
+func (s *accountService) checkLoginAllowed(ctx context.Context, key string) error {
+	t, err := s.repository.GetLoginThrottle(ctx, key)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	now := time.Now()
+	if t.LockedUntil != nil && t.LockedUntil.After(now) {
+		return ErrTooManyAttempts
+	}
+	if t.LastFailureAt.Add(loginFailureWindow).Before(now) {
+		return nil
+	}
+	if t.LastFailureAt.Add(loginDelay(t.Failures)).After(now) {
+		return ErrTooManyAttempts
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failure against key and locks it once threshold is reached.
+func (s *accountService) recordLoginFailure(ctx context.Context, key string, threshold int) error {
+	failures, err := s.repository.RecordLoginFailure(ctx, key, time.Now().UTC().Add(-loginFailureWindow))
+	if err != nil {
+		return err
+	}
+	if failures >= threshold {
+		return s.repository.LockLogin(ctx, key, time.Now().UTC().Add(loginLockoutDuration))
+	}
+	return nil
+}
+
+func loginThrottleKeys(email string, clientIP string) []string {
+	keys := []string{emailThrottleKey(email)}
+	if clientIP != "" {
+		keys = append(keys, clientIPThrottleKey(clientIP))
+	}
+	return keys
+}
+
+func (s *accountService) recordLoginFailures(ctx context.Context, email string, clientIP string) error {
+	if err := s.recordLoginFailure(ctx, emailThrottleKey(email), accountLockThreshold); err != nil {
+		return err
+	}
+	if clientIP != "" {
+		return s.recordLoginFailure(ctx, clientIPThrottleKey(clientIP), clientIPLockThreshold)
+	}
+	return nil
+}
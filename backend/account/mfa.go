@@ -0,0 +1,298 @@
+package account
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/JonathanNithi/ecommerce/backend/authz"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// TOTP parameters from RFC 6238, the defaults every authenticator app understands
+const (
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+	totpSkew   = 1 // Steps accepted either side of the current one to allow for clock drift
+
+	mfaIssuer            = "Ecommerce"
+	mfaSecretSize        = 20 // 160 bits as recommended for HMAC-SHA1
+	mfaChallengeTTL      = 5 * time.Minute
+	recoveryCodeCount    = 10
+	recoveryCodeHalfSize = 5
+)
+
+var (
+	ErrMfaAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMfaNotEnrolled    = errors.New("two-factor authentication has not been enrolled")
+	ErrInvalidMfaCode    = errors.New("invalid two-factor authentication code")
+	ErrInvalidChallenge  = errors.New("invalid or expired two-factor authentication challenge")
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MfaPolicy decides which accounts must complete a second factor.
+type MfaPolicy struct {
+	// Accounts holding any permission only receive it in tokens issued after an MFA login.
+	// Until they enroll they can still log in, without their permissions, to do so.
+	RequireForPrivileged bool `envconfig:"REQUIRE_ADMIN_MFA" default:"true"`
+}
+
+func (p MfaPolicy) requiresMfa(a *Account) bool {
+	return p.RequireForPrivileged && len(a.Permissions) > 0
+}
+
+// MfaSecret is the TOTP enrollment of an account. The secret is only usable for logins once
+// confirmed with a code, which shows the authenticator app was set up correctly.
+type MfaSecret struct {
+	AccountID    string     `json:"account_id"`
+	Secret       string     `json:"-"` // base32, stored as is because codes are computed from it
+	ConfirmedAt  *time.Time `json:"confirmed_at"`
+	LastUsedStep int64      `json:"last_used_step"` // codes at or before this step are rejected as replays
+}
+
+// LoginResult is the outcome of Login and CompleteMfaLogin. When the account needs a second
+// factor Login only fills in MfaChallengeToken, which CompleteMfaLogin exchanges for tokens.
+type LoginResult struct {
+	Account           *Account
+	AccessToken       string
+	RefreshToken      string
+	MfaChallengeToken string
+}
+
+func totpStep(t time.Time) int64 {
+	return t.Unix() / int64(totpPeriod/time.Second)
+}
+
+// totpCode computes the code for a time step as described in RFC 4226 section 5.3.
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, code%1000000)
+}
+
+// matchTOTP returns the step code was generated for, or false if it is not valid around now.
+func matchTOTP(secret string, code string, now time.Time) (int64, bool) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if hmac.Equal([]byte(totpCode(key, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func generateMfaSecret() (string, error) {
+	b := make([]byte, mfaSecretSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(b), nil
+}
+
+// otpauthURI is the key URI format understood by authenticator apps, usually shown as a QR code.
+func otpauthURI(email string, secret string) string {
+	label := url.PathEscape(mfaIssuer + ":" + email)
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", mfaIssuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(totpDigits))
+	q.Set("period", fmt.Sprint(int(totpPeriod/time.Second)))
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// generateRecoveryCodes returns codes formatted as "xxxxx-xxxxx" for display.
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, recoveryCodeHalfSize*5/8*2)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		c := strings.ToLower(base32NoPadding.EncodeToString(b))
+		codes[i] = c[:recoveryCodeHalfSize] + "-" + c[recoveryCodeHalfSize:2*recoveryCodeHalfSize]
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode ignores case and separators so codes can be typed the way they are read.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateMfaChallengeToken issues the token that proves the password step of a login.
+// Its audience keeps it from being accepted as an access token anywhere.
+func (k *KeyRing) GenerateMfaChallengeToken(accountID string, username string) (string, error) {
+	claims := &Claims{
+		Username: username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   accountID,
+			Audience:  jwt.ClaimStrings{authz.MfaChallengeAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(mfaChallengeTTL)),
+		},
+	}
+	return k.Sign(claims)
+}
+
+func (k *KeyRing) ValidateMfaChallengeToken(ctx context.Context, token string) (*Claims, error) {
+	return k.Verifier().VerifyAudience(ctx, token, authz.MfaChallengeAudience)
+}
+
+// tokenPermissions are the permissions placed in access tokens for account. Privileged accounts
+// that must use MFA get none until they enrolled, since then every login went through MFA.
+func (s *accountService) tokenPermissions(account *Account) []string {
+	if s.mfa.requiresMfa(account) && !account.MfaEnabled {
+		return nil
+	}
+	return account.Permissions
+}
+
+// EnrollMfa starts TOTP enrollment for the caller, replacing any unconfirmed secret.
+// It returns the secret and its otpauth URI for the authenticator app.
+func (s *accountService) EnrollMfa(ctx context.Context) (string, string, error) {
+	account, err := s.ownAccount(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	if account.MfaEnabled {
+		return "", "", ErrMfaAlreadyEnabled
+	}
+
+	secret, err := generateMfaSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.repository.PutMfaSecret(ctx, account.ID, secret); err != nil {
+		return "", "", err
+	}
+
+	return secret, otpauthURI(account.Email, secret), nil
+}
+
+// ConfirmMfa enables MFA once the caller proves their app produces valid codes and returns
+// the recovery codes. They are shown this once, only their hashes are kept.
+func (s *accountService) ConfirmMfa(ctx context.Context, code string) ([]string, error) {
+	account, err := s.ownAccount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if account.MfaEnabled {
+		return nil, ErrMfaAlreadyEnabled
+	}
+
+	m, err := s.repository.GetMfaSecret(ctx, account.ID)
+	if err != nil {
+		return nil, ErrMfaNotEnrolled
+	}
+	step, ok := matchTOTP(m.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMfaCode
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = hashRecoveryCode(c)
+	}
+	if err := s.repository.ConfirmMfa(ctx, account.ID, step, hashes); err != nil {
+		return nil, err
+	}
+
+	// Sessions started with only a password must not keep working once MFA is on
+	if err := s.repository.RevokeRefreshTokensForAccount(ctx, account.ID); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// CompleteMfaLogin finishes a login started by Login with either a TOTP code or an unused recovery code.
+func (s *accountService) CompleteMfaLogin(ctx context.Context, challengeToken string, code string) (*LoginResult, error) {
+	claims, err := s.keys.ValidateMfaChallengeToken(ctx, challengeToken)
+	if err != nil {
+		return nil, ErrInvalidChallenge
+	}
+
+	// Wrong codes count towards the password lockout so the second factor cannot be brute forced
+	key := emailThrottleKey(claims.Username)
+	if err := s.checkLoginAllowed(ctx, key); err != nil {
+		return nil, err
+	}
+
+	account, err := s.repository.GetAccountByID(ctx, claims.Subject)
+	if err != nil || !account.MfaEnabled {
+		return nil, ErrInvalidChallenge
+	}
+	if err := CheckAccountStatus(account); err != nil {
+		return nil, err
+	}
+
+	if err := s.checkMfaCode(ctx, account, code); err != nil {
+		if errors.Is(err, ErrInvalidMfaCode) {
+			if err := s.recordLoginFailure(ctx, key, accountLockThreshold); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+
+	if err := s.repository.ResetLoginFailures(ctx, key); err != nil {
+		return nil, err
+	}
+	return s.startSession(ctx, account)
+}
+
+func (s *accountService) checkMfaCode(ctx context.Context, account *Account, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		m, err := s.repository.GetMfaSecret(ctx, account.ID)
+		if err != nil {
+			return err
+		}
+		step, ok := matchTOTP(m.Secret, code, time.Now())
+		if !ok {
+			return ErrInvalidMfaCode
+		}
+		// A code seen once, even by an attacker looking over a shoulder, cannot be used again
+		if err := s.repository.UseMfaStep(ctx, account.ID, step); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return ErrInvalidMfaCode
+			}
+			return err
+		}
+		return nil
+	}
+
+	if err := s.repository.UseRecoveryCode(ctx, account.ID, hashRecoveryCode(code)); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrInvalidMfaCode
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS accounts;
+DROP TYPE IF EXISTS user_role;
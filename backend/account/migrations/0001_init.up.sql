@@ -0,0 +1,16 @@
+-- The schema from before versioned migrations. Databases created back then already have it,
+-- so the type is only created when missing
+DO $$ BEGIN
+  CREATE TYPE user_role AS ENUM ('admin', 'user');
+EXCEPTION
+  WHEN duplicate_object THEN NULL;
+END $$;
+
+CREATE TABLE IF NOT EXISTS accounts (
+  id CHAR(27) PRIMARY KEY,
+  first_name VARCHAR(255) NOT NULL, 
+  last_name VARCHAR(255) NOT NULL, 
+  email VARCHAR(255) NOT NULL UNIQUE, 
+  password_hash VARCHAR(255) NOT NULL, 
+  role user_role NOT NULL 
+);
@@ -0,0 +1,11 @@
+CREATE TYPE user_role AS ENUM ('admin', 'user');
+
+-- Accounts holding several roles keep the most privileged one the old column can hold
+ALTER TABLE accounts ADD COLUMN role user_role NOT NULL DEFAULT 'user';
+UPDATE accounts SET role = 'admin'
+  WHERE id IN (SELECT account_id FROM account_roles WHERE role = 'admin');
+ALTER TABLE accounts ALTER COLUMN role DROP DEFAULT;
+
+DROP TABLE account_roles;
+DROP TABLE role_permissions;
+DROP TABLE roles;
@@ -0,0 +1,31 @@
+CREATE TABLE roles (
+  name VARCHAR(64) PRIMARY KEY
+);
+
+CREATE TABLE role_permissions (
+  role VARCHAR(64) NOT NULL REFERENCES roles (name) ON DELETE CASCADE,
+  permission VARCHAR(64) NOT NULL,
+  PRIMARY KEY (role, permission)
+);
+
+CREATE TABLE account_roles (
+  account_id CHAR(27) NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+  role VARCHAR(64) NOT NULL REFERENCES roles (name) ON DELETE CASCADE,
+  PRIMARY KEY (account_id, role)
+);
+
+INSERT INTO roles (name) VALUES ('admin'), ('user');
+
+INSERT INTO role_permissions (role, permission) VALUES
+  ('admin', 'catalog:write'),
+  ('admin', 'inventory:adjust'),
+  ('admin', 'orders:read_all'),
+  ('admin', 'accounts:manage'),
+  ('admin', 'roles:manage'),
+  ('admin', 'audit:read');
+
+-- Accounts keep the role they had, which is now one of their roles
+INSERT INTO account_roles (account_id, role) SELECT id, role::text FROM accounts;
+
+ALTER TABLE accounts DROP COLUMN role;
+DROP TYPE user_role;
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS accounts_created_at_idx;
+DROP INDEX IF EXISTS accounts_name_trgm_idx;
+DROP INDEX IF EXISTS accounts_email_trgm_idx;
+
+ALTER TABLE accounts
+  DROP COLUMN created_at,
+  DROP COLUMN status_changed_at,
+  DROP COLUMN status_reason,
+  DROP COLUMN status,
+  DROP COLUMN email_verified_at;
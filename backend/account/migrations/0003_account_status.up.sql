@@ -0,0 +1,15 @@
+ALTER TABLE accounts
+  ADD COLUMN email_verified_at TIMESTAMP WITH TIME ZONE,
+  ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'suspended', 'closed')),
+  ADD COLUMN status_reason TEXT NOT NULL DEFAULT '',
+  ADD COLUMN status_changed_at TIMESTAMP WITH TIME ZONE,
+  ADD COLUMN created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now();
+
+-- Accounts made before email verification could not verify, they are not locked out for it
+UPDATE accounts SET email_verified_at = now();
+
+-- Admin account search filters by substrings of these columns
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX accounts_email_trgm_idx ON accounts USING gin (email gin_trgm_ops);
+CREATE INDEX accounts_name_trgm_idx ON accounts USING gin ((first_name || ' ' || last_name) gin_trgm_ops);
+CREATE INDEX accounts_created_at_idx ON accounts (created_at);
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS signing_keys;
+DROP TABLE IF EXISTS login_throttles;
+DROP TABLE IF EXISTS mfa_recovery_codes;
+DROP TABLE IF EXISTS account_mfa;
+DROP TABLE IF EXISTS email_change_tokens;
+DROP TABLE IF EXISTS email_verification_tokens;
+DROP TABLE IF EXISTS password_reset_tokens;
+DROP TABLE IF EXISTS refresh_tokens;
@@ -0,0 +1,68 @@
+CREATE TABLE refresh_tokens (
+  id CHAR(27) PRIMARY KEY,
+  account_id CHAR(27) NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+  family_id CHAR(27) NOT NULL,
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+CREATE INDEX refresh_tokens_account_id_idx ON refresh_tokens (account_id);
+
+CREATE TABLE password_reset_tokens (
+  id CHAR(27) PRIMARY KEY,
+  account_id CHAR(27) NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+  token_hash CHAR(64) NOT NULL UNIQUE,
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  used_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE email_verification_tokens (
+  id CHAR(27) PRIMARY KEY,
+  account_id CHAR(27) NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+  token_hash CHAR(64) NOT NULL UNIQUE,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  used_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX email_verification_tokens_account_id_idx ON email_verification_tokens (account_id, created_at);
+
+CREATE TABLE email_change_tokens (
+  id CHAR(27) PRIMARY KEY,
+  account_id CHAR(27) NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+  new_email VARCHAR(255) NOT NULL,
+  token_hash CHAR(64) NOT NULL UNIQUE,
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  used_at TIMESTAMP WITH TIME ZONE
+);
+
+-- The TOTP secret has to be readable to compute codes, so unlike other credentials it is not hashed
+CREATE TABLE account_mfa (
+  account_id CHAR(27) PRIMARY KEY REFERENCES accounts (id) ON DELETE CASCADE,
+  secret VARCHAR(64) NOT NULL,
+  confirmed_at TIMESTAMP WITH TIME ZONE,
+  last_used_step BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE mfa_recovery_codes (
+  account_id CHAR(27) NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+  code_hash CHAR(64) NOT NULL,
+  used_at TIMESTAMP WITH TIME ZONE,
+  PRIMARY KEY (account_id, code_hash)
+);
+
+CREATE TABLE login_throttles (
+  key VARCHAR(300) PRIMARY KEY,
+  failures INT NOT NULL,
+  last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  locked_until TIMESTAMP WITH TIME ZONE
+);
+
+CREATE TABLE signing_keys (
+  id CHAR(27) PRIMARY KEY,
+  private_key TEXT NOT NULL,
+  status VARCHAR(16) NOT NULL CHECK (status IN ('active', 'retiring')),
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  retire_at TIMESTAMP WITH TIME ZONE
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS data_requests;
+DROP TABLE IF EXISTS addresses;
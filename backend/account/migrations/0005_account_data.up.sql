@@ -0,0 +1,47 @@
+CREATE TABLE addresses (
+  id CHAR(27) PRIMARY KEY,
+  account_id CHAR(27) NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+  full_name VARCHAR(255) NOT NULL,
+  line1 VARCHAR(255) NOT NULL,
+  line2 VARCHAR(255) NOT NULL DEFAULT '',
+  city VARCHAR(255) NOT NULL,
+  region VARCHAR(255) NOT NULL DEFAULT '',
+  postal_code VARCHAR(32) NOT NULL DEFAULT '',
+  country CHAR(2) NOT NULL,
+  phone VARCHAR(32) NOT NULL DEFAULT '',
+  default_shipping BOOLEAN NOT NULL DEFAULT FALSE,
+  default_billing BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX addresses_account_id_idx ON addresses (account_id);
+CREATE UNIQUE INDEX addresses_default_shipping_idx ON addresses (account_id) WHERE default_shipping;
+CREATE UNIQUE INDEX addresses_default_billing_idx ON addresses (account_id) WHERE default_billing;
+
+-- Audit trail of data exports and erasures, kept after the account was anonymized
+CREATE TABLE data_requests (
+  id CHAR(27) PRIMARY KEY,
+  account_id CHAR(27) NOT NULL REFERENCES accounts (id),
+  kind VARCHAR(16) NOT NULL CHECK (kind IN ('export', 'erasure')),
+  requested_by VARCHAR(255) NOT NULL,
+  reason TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX data_requests_account_id_idx ON data_requests (account_id);
+
+-- Only a hash of each key is kept, prefix is the visible start of the key
+CREATE TABLE api_keys (
+  id CHAR(27) PRIMARY KEY,
+  account_id CHAR(27) NOT NULL REFERENCES accounts (id) ON DELETE CASCADE,
+  name VARCHAR(64) NOT NULL,
+  prefix VARCHAR(16) NOT NULL,
+  key_hash CHAR(64) NOT NULL UNIQUE,
+  permissions TEXT[] NOT NULL DEFAULT '{}',
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  expires_at TIMESTAMP WITH TIME ZONE,
+  last_used_at TIMESTAMP WITH TIME ZONE,
+  revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX api_keys_account_id_idx ON api_keys (account_id);
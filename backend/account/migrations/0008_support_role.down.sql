@@ -0,0 +1,2 @@
+DELETE FROM role_permissions WHERE permission = 'accounts:impersonate';
+DELETE FROM roles WHERE name = 'support';
@@ -0,0 +1,7 @@
+-- Support staff impersonate customers to see what they see, admins can too
+INSERT INTO roles (name) VALUES ('support') ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+  ('support', 'accounts:impersonate'),
+  ('admin', 'accounts:impersonate')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,4 @@
+-- Only admins may delete products for good, catalog:write only hides them
+INSERT INTO role_permissions (role, permission) VALUES
+  ('admin', 'catalog:delete')
+ON CONFLICT DO NOTHING;
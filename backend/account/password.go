@@ -0,0 +1,232 @@
+package account
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Algorithms a PasswordPolicy can hash new passwords with. Hashes of either are always verified.
+const (
+	PasswordHashArgon2id = "argon2id"
+	PasswordHashBcrypt   = "bcrypt"
+)
+
+const (
+	defaultMinPasswordLength = 8
+	argon2SaltLength         = 16
+	argon2KeyLength          = 32
+)
+
+var (
+	ErrCommonPassword      = errors.New("password is too common, choose a less guessable one")
+	ErrUnknownPasswordHash = errors.New("unknown password hash format")
+)
+
+// PasswordChecker rejects passwords too weak to be set. Checks run on signup, reset and change,
+// never on login, so tightening them does not lock anyone out.
+type PasswordChecker func(password string) error
+
+// PasswordPolicy is the hashing new passwords get and the strength they need. Stored hashes made
+// with another algorithm or weaker parameters are replaced on the next successful login.
+type PasswordPolicy struct {
+	Algorithm  string `envconfig:"PASSWORD_HASH_ALGORITHM" default:"argon2id"`
+	BcryptCost int    `envconfig:"PASSWORD_BCRYPT_COST" default:"12"`
+	// Argon2id parameters, the defaults follow the OWASP recommendation for 64 MiB of memory
+	Argon2Time      uint32 `envconfig:"PASSWORD_ARGON2_TIME" default:"3"`
+	Argon2MemoryKiB uint32 `envconfig:"PASSWORD_ARGON2_MEMORY_KIB" default:"65536"`
+	Argon2Threads   uint8  `envconfig:"PASSWORD_ARGON2_THREADS" default:"2"`
+	MinLength       int    `envconfig:"PASSWORD_MIN_LENGTH" default:"8"`
+	// Checker replaces the default checks of MinLength and the common password list
+	Checker PasswordChecker `ignored:"true"`
+}
+
+// withDefaults fills in the zero fields so a zero PasswordPolicy hashes like the configured default.
+func (p PasswordPolicy) withDefaults() PasswordPolicy {
+	if p.Algorithm == "" {
+		p.Algorithm = PasswordHashArgon2id
+	}
+	if p.BcryptCost == 0 {
+		p.BcryptCost = 12
+	}
+	if p.Argon2Time == 0 {
+		p.Argon2Time = 3
+	}
+	if p.Argon2MemoryKiB == 0 {
+		p.Argon2MemoryKiB = 64 * 1024
+	}
+	if p.Argon2Threads == 0 {
+		p.Argon2Threads = 2
+	}
+	if p.MinLength == 0 {
+		p.MinLength = defaultMinPasswordLength
+	}
+	return p
+}
+
+// Check applies the policy's checker to a new password.
+func (p PasswordPolicy) Check(password string) error {
+	if p.Checker != nil {
+		return p.Checker(password)
+	}
+	p = p.withDefaults()
+	return CombinePasswordCheckers(MinLengthChecker(p.MinLength), CommonPasswordChecker())(password)
+}
+
+// Hash hashes password with the policy's algorithm, encoding the parameters in the result.
+func (p PasswordPolicy) Hash(password string) (string, error) {
+	p = p.withDefaults()
+	switch p.Algorithm {
+	case PasswordHashBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), p.BcryptCost)
+		return string(hash), err
+	case PasswordHashArgon2id:
+		salt := make([]byte, argon2SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		return argon2Params{p.Argon2MemoryKiB, p.Argon2Time, p.Argon2Threads}.hash(password, salt), nil
+	}
+	return "", fmt.Errorf("unsupported password hash algorithm %s", p.Algorithm)
+}
+
+// NeedsRehash reports whether hash was made with another algorithm or weaker parameters than the policy's.
+func (p PasswordPolicy) NeedsRehash(hash string) bool {
+	p = p.withDefaults()
+	switch {
+	case isBcryptHash(hash):
+		if p.Algorithm != PasswordHashBcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost < p.BcryptCost
+	case strings.HasPrefix(hash, "$argon2id$"):
+		if p.Algorithm != PasswordHashArgon2id {
+			return true
+		}
+		params, _, _, err := decodeArgon2id(hash)
+		return err != nil || params.memory < p.Argon2MemoryKiB || params.time < p.Argon2Time || params.threads < p.Argon2Threads
+	}
+	return true
+}
+
+// VerifyPassword reports whether password matches hash, whichever supported algorithm made it.
+func VerifyPassword(hash string, password string) (bool, error) {
+	switch {
+	case isBcryptHash(hash):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	case strings.HasPrefix(hash, "$argon2id$"):
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false, err
+		}
+		other := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1, nil
+	}
+	return false, ErrUnknownPasswordHash
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+}
+
+// hash returns the PHC string format used by the reference implementation:
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+func (a argon2Params) hash(password string, salt []byte) string {
+	key := argon2.IDKey([]byte(password), salt, a.time, a.memory, a.threads, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, a.memory, a.time, a.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func decodeArgon2id(hash string) (argon2Params, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return argon2Params{}, nil, nil, ErrUnknownPasswordHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return argon2Params{}, nil, nil, fmt.Errorf("unsupported argon2 version %s", parts[2])
+	}
+	params := argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return argon2Params{}, nil, nil, fmt.Errorf("invalid argon2 parameters %s", parts[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return argon2Params{}, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return argon2Params{}, nil, nil, err
+	}
+	return params, salt, key, nil
+}
+
+// MinLengthChecker rejects passwords shorter than n characters.
+func MinLengthChecker(n int) PasswordChecker {
+	return func(password string) error {
+		if len([]rune(password)) < n {
+			return fmt.Errorf("password must be at least %d characters", n)
+		}
+		return nil
+	}
+}
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+var (
+	commonPasswordsOnce sync.Once
+	commonPasswords     map[string]bool
+)
+
+// CommonPasswordChecker rejects passwords on the list of commonly used passwords shipped with
+// the service, ignoring case. No password is sent anywhere to be checked.
+func CommonPasswordChecker() PasswordChecker {
+	commonPasswordsOnce.Do(func() {
+		commonPasswords = map[string]bool{}
+		scanner := bufio.NewScanner(strings.NewReader(commonPasswordList))
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+				commonPasswords[strings.ToLower(line)] = true
+			}
+		}
+	})
+	return func(password string) error {
+		if commonPasswords[strings.ToLower(password)] {
+			return ErrCommonPassword
+		}
+		return nil
+	}
+}
+
+// CombinePasswordCheckers runs checks in order and returns the first rejection.
+func CombinePasswordCheckers(checks ...PasswordChecker) PasswordChecker {
+	return func(password string) error {
+		for _, check := range checks {
+			if err := check(password); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -3,20 +3,107 @@ package account
 import (
 	"context"
 	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+var ErrNotFound = errors.New("entity not found")
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// Migrations returns the schema migrations of the account database, applied with the migrate package.
+func Migrations() fs.FS {
+	sub, err := fs.Sub(migrationFS, "migrations")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// accountColumns selects an account together with its roles and the union of their permissions
+const accountColumns = `a.id, a.first_name, a.last_name, a.email, a.email_verified_at IS NOT NULL, a.password_hash, a.status, a.status_reason, a.created_at,
+  EXISTS(SELECT 1 FROM account_mfa m WHERE m.account_id = a.id AND m.confirmed_at IS NOT NULL),
+  ARRAY(SELECT ar.role FROM account_roles ar WHERE ar.account_id = a.id ORDER BY ar.role),
+  ARRAY(SELECT DISTINCT rp.permission FROM account_roles ar JOIN role_permissions rp ON rp.role = ar.role WHERE ar.account_id = a.id ORDER BY rp.permission)`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAccount(row rowScanner) (*Account, error) {
+	a := &Account{}
+	if err := row.Scan(&a.ID, &a.FirstName, &a.LastName, &a.Email, &a.EmailVerified, &a.PasswordHash, &a.Status, &a.StatusReason, &a.CreatedAt, &a.MfaEnabled, pq.Array(&a.Roles), pq.Array(&a.Permissions)); err != nil {
+		return nil, err
+	}
+	a.Role = RoleUser
+	for _, role := range a.Roles {
+		if role == RoleAdmin {
+			a.Role = RoleAdmin
+		}
+	}
+	return a, nil
+}
+
 type Repository interface {
 	Close()
 	PutAccount(ctx context.Context, a Account) error
 	GetAccountByID(ctx context.Context, id string) (*Account, error)
 	ListAccounts(ctx context.Context, skip uint64, take uint64) ([]Account, error)
+	SearchAccounts(ctx context.Context, f AccountFilter, afterID string, limit int) ([]Account, error)
+	CountAccounts(ctx context.Context, f AccountFilter) (int, error)
 	GetAccountByEmail(ctx context.Context, email string) (*Account, error)
-	GetAccountByEmailAndName(ctx context.Context, first_name string, last_name string, email string) (*Account, error)
-	GetAccountById(ctx context.Context, id string) (*Account, error)
-	UpdateAccountRole(ctx context.Context, id string, role string) (*Account, error)
+	CreateRole(ctx context.Context, role Role) error
+	GrantRole(ctx context.Context, accountID string, role string) error
+	RevokeRole(ctx context.Context, accountID string, role string) error
 	UpdatePasswordHash(ctx context.Context, email string, passwordHash string) (*Account, error)
+	UpdateProfile(ctx context.Context, id string, firstName string, lastName string) (*Account, error)
+	UpdateEmail(ctx context.Context, id string, email string) error
+	MarkEmailVerified(ctx context.Context, id string) error
+	UpdateAccountStatus(ctx context.Context, id string, status string, reason string) error
+	PutRefreshToken(ctx context.Context, t RefreshToken) error
+	GetRefreshToken(ctx context.Context, id string) (*RefreshToken, error)
+	RevokeRefreshToken(ctx context.Context, id string) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+	RevokeRefreshTokensForAccount(ctx context.Context, accountID string) error
+	PutPasswordResetToken(ctx context.Context, t PasswordResetToken) error
+	GetPasswordResetToken(ctx context.Context, tokenHash string) (*PasswordResetToken, error)
+	MarkPasswordResetTokenUsed(ctx context.Context, id string) error
+	PutEmailChangeToken(ctx context.Context, t EmailChangeToken) error
+	GetEmailChangeToken(ctx context.Context, tokenHash string) (*EmailChangeToken, error)
+	MarkEmailChangeTokenUsed(ctx context.Context, id string) error
+	PutEmailVerificationToken(ctx context.Context, t EmailVerificationToken) error
+	GetEmailVerificationToken(ctx context.Context, tokenHash string) (*EmailVerificationToken, error)
+	MarkEmailVerificationTokenUsed(ctx context.Context, id string) error
+	CountEmailVerificationTokensSince(ctx context.Context, accountID string, since time.Time) (int, error)
+	PutMfaSecret(ctx context.Context, accountID string, secret string) error
+	GetMfaSecret(ctx context.Context, accountID string) (*MfaSecret, error)
+	ConfirmMfa(ctx context.Context, accountID string, step int64, recoveryCodeHashes []string) error
+	UseMfaStep(ctx context.Context, accountID string, step int64) error
+	UseRecoveryCode(ctx context.Context, accountID string, codeHash string) error
+	PutDataRequest(ctx context.Context, d DataRequest) error
+	EraseAccount(ctx context.Context, a *Account, d DataRequest) error
+	ListAddresses(ctx context.Context, accountID string) ([]Address, error)
+	PutAddress(ctx context.Context, a Address) error
+	UpdateAddress(ctx context.Context, a Address) (*Address, error)
+	DeleteAddress(ctx context.Context, accountID string, id string) error
+	SetDefaultAddress(ctx context.Context, accountID string, id string, usage string) error
+	GetLoginThrottle(ctx context.Context, key string) (*LoginThrottle, error)
+	RecordLoginFailure(ctx context.Context, key string, windowStart time.Time) (int, error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	ResetLoginFailures(ctx context.Context, key string) error
+	PutAPIKey(ctx context.Context, k APIKey) error
+	GetAPIKey(ctx context.Context, id string) (*APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error)
+	ListAPIKeys(ctx context.Context, accountID string) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+	TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error
 }
 
 type postgresRepository struct {
@@ -46,31 +133,48 @@ func (r *postgresRepository) Ping() error {
 }
 
 func (r *postgresRepository) PutAccount(ctx context.Context, a Account) error {
-	_, err := r.db.ExecContext(
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(
 		ctx,
-		"INSERT INTO accounts(id, first_name, last_name, email, password_hash, role) VALUES ($1, $2, $3, $4, $5, $6)",
-		a.ID, a.FirstName, a.LastName, a.Email, a.PasswordHash, a.Role,
+		"INSERT INTO accounts(id, first_name, last_name, email, password_hash, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		a.ID, a.FirstName, a.LastName, a.Email, a.PasswordHash, a.CreatedAt,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	for _, role := range a.Roles {
+		_, err = tx.ExecContext(
+			ctx,
+			"INSERT INTO account_roles(account_id, role) VALUES ($1, $2)",
+			a.ID, role,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 func (r *postgresRepository) GetAccountByID(ctx context.Context, id string) (*Account, error) {
 	row := r.db.QueryRowContext(
 		ctx,
-		"SELECT id, first_name, last_name, email, password_hash, role FROM accounts WHERE id = $1",
+		"SELECT "+accountColumns+" FROM accounts a WHERE a.id = $1",
 		id,
 	)
-	a := &Account{}
-	if err := row.Scan(&a.ID, &a.FirstName, &a.LastName, &a.Email, &a.PasswordHash, &a.Role); err != nil {
-		return nil, err
-	}
-	return a, nil
+	return scanAccount(row)
 }
 
 func (r *postgresRepository) ListAccounts(ctx context.Context, skip uint64, take uint64) ([]Account, error) {
 	rows, err := r.db.QueryContext(
 		ctx,
-		"SELECT id, first_name, last_name, email, password_hash, role FROM accounts ORDER BY id DESC OFFSET $1 LIMIT $2",
+		"SELECT "+accountColumns+" FROM accounts a ORDER BY a.id DESC OFFSET $1 LIMIT $2",
 		skip, take,
 	)
 	if err != nil {
@@ -80,9 +184,8 @@ func (r *postgresRepository) ListAccounts(ctx context.Context, skip uint64, take
 
 	accounts := []Account{}
 	for rows.Next() {
-		a := &Account{}
 		// Scan the result into the Account struct
-		if err = rows.Scan(&a.ID, &a.FirstName, &a.LastName, &a.Email, &a.PasswordHash, &a.Role); err == nil {
+		if a, err := scanAccount(rows); err == nil {
 			accounts = append(accounts, *a)
 		}
 	}
@@ -94,81 +197,881 @@ func (r *postgresRepository) ListAccounts(ctx context.Context, skip uint64, take
 	return accounts, nil
 }
 
+// accountFilterConditions turns f into SQL conditions on the accounts table aliased a,
+// appending their parameters to args.
+func accountFilterConditions(f AccountFilter, args []interface{}) ([]string, []interface{}) {
+	conditions := []string{}
+	param := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.Query != "" {
+		like := param("%" + likeEscaper.Replace(f.Query) + "%")
+		conditions = append(conditions, "(a.email ILIKE "+like+" OR a.first_name ILIKE "+like+
+			" OR a.last_name ILIKE "+like+" OR (a.first_name || ' ' || a.last_name) ILIKE "+like+")")
+	}
+	if f.Role != "" {
+		conditions = append(conditions, "EXISTS(SELECT 1 FROM account_roles ar WHERE ar.account_id = a.id AND ar.role = "+param(f.Role)+")")
+	}
+	if f.Status != "" {
+		conditions = append(conditions, "a.status = "+param(f.Status))
+	}
+	if f.CreatedAfter != nil {
+		conditions = append(conditions, "a.created_at >= "+param(*f.CreatedAfter))
+	}
+	if f.CreatedBefore != nil {
+		conditions = append(conditions, "a.created_at < "+param(*f.CreatedBefore))
+	}
+	return conditions, args
+}
+
+// likeEscaper makes user input match literally inside a LIKE pattern
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// SearchAccounts returns up to limit accounts matching f with an id below afterID, highest id first.
+func (r *postgresRepository) SearchAccounts(ctx context.Context, f AccountFilter, afterID string, limit int) ([]Account, error) {
+	conditions, args := accountFilterConditions(f, nil)
+	if afterID != "" {
+		args = append(args, afterID)
+		conditions = append(conditions, fmt.Sprintf("a.id < $%d", len(args)))
+	}
+	args = append(args, limit)
+
+	rows, err := r.db.QueryContext(
+		ctx,
+		"SELECT "+accountColumns+" FROM accounts a"+whereClause(conditions)+fmt.Sprintf(" ORDER BY a.id DESC LIMIT $%d", len(args)),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []Account{}
+	for rows.Next() {
+		a, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, *a)
+	}
+	return accounts, rows.Err()
+}
+
+func (r *postgresRepository) CountAccounts(ctx context.Context, f AccountFilter) (int, error) {
+	conditions, args := accountFilterConditions(f, nil)
+	row := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM accounts a"+whereClause(conditions), args...)
+	var n int
+	if err := row.Scan(&n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
 func (r *postgresRepository) GetAccountByEmail(ctx context.Context, email string) (*Account, error) {
 	row := r.db.QueryRowContext(
 		ctx,
-		"SELECT id, first_name, last_name, email, password_hash, role FROM accounts WHERE email = $1",
+		"SELECT "+accountColumns+" FROM accounts a WHERE a.email = $1",
 		email,
 	)
-	account := &Account{}
-	if err := row.Scan(&account.ID, &account.FirstName, &account.LastName, &account.Email, &account.PasswordHash, &account.Role); err != nil {
-		return nil, err // Return error if no account is found
+	return scanAccount(row) // Returns an error if no account is found
+}
+
+func (r *postgresRepository) UpdatePasswordHash(ctx context.Context, email string, passwordHash string) (*Account, error) {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE accounts SET password_hash = $1 WHERE email = $2",
+		passwordHash, email,
+	)
+
+	account, scanErr := r.GetAccountByEmail(ctx, email)
+	if scanErr != nil {
+		return nil, scanErr // Return error if no account is found
 	}
-	return account, nil
+
+	return account, err
 }
 
-func (r *postgresRepository) GetAccountByEmailAndName(ctx context.Context, first_name string, last_name string, email string) (*Account, error) {
+func (r *postgresRepository) UpdateProfile(ctx context.Context, id string, firstName string, lastName string) (*Account, error) {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE accounts SET first_name = $1, last_name = $2 WHERE id = $3",
+		firstName, lastName, id,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetAccountByID(ctx, id)
+}
+
+// UpdateEmail changes the email of an account, returning ErrEmailTaken if another account already uses it.
+// The new email counts as verified because it is only applied once its confirmation token was presented.
+func (r *postgresRepository) UpdateEmail(ctx context.Context, id string, email string) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE accounts SET email = $1, email_verified_at = now() WHERE id = $2",
+		email, id,
+	)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" { // unique_violation
+		return ErrEmailTaken
+	}
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *postgresRepository) MarkEmailVerified(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE accounts SET email_verified_at = now() WHERE id = $1 AND email_verified_at IS NULL",
+		id,
+	)
+	return err
+}
+
+func (r *postgresRepository) UpdateAccountStatus(ctx context.Context, id string, status string, reason string) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE accounts SET status = $1, status_reason = $2, status_changed_at = now() WHERE id = $3",
+		status, reason, id,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *postgresRepository) CreateRole(ctx context.Context, role Role) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO roles(name) VALUES ($1)", role.Name); err != nil {
+		return err
+	}
+	for _, p := range role.Permissions {
+		_, err := tx.ExecContext(
+			ctx,
+			"INSERT INTO role_permissions(role, permission) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+			role.Name, p,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GrantRole assigns role to an account, returning ErrNotFound if either does not exist.
+// Granting a role the account already holds is not an error.
+func (r *postgresRepository) GrantRole(ctx context.Context, accountID string, role string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO account_roles(account_id, role) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		accountID, role,
+	)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" { // foreign_key_violation
+		return ErrNotFound
+	}
+	return err
+}
+
+// RevokeRole removes role from an account, returning ErrNotFound if it was not assigned.
+func (r *postgresRepository) RevokeRole(ctx context.Context, accountID string, role string) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		"DELETE FROM account_roles WHERE account_id = $1 AND role = $2",
+		accountID, role,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *postgresRepository) PutRefreshToken(ctx context.Context, t RefreshToken) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO refresh_tokens(id, account_id, family_id, expires_at) VALUES ($1, $2, $3, $4)",
+		t.ID, t.AccountID, t.FamilyID, t.ExpiresAt,
+	)
+	return err
+}
+
+func (r *postgresRepository) GetRefreshToken(ctx context.Context, id string) (*RefreshToken, error) {
 	row := r.db.QueryRowContext(
 		ctx,
-		"SELECT id, first_name, last_name, email, password_hash, role FROM accounts WHERE email = $1 AND first_name = $2 AND last_name = $3",
-		email, first_name, last_name,
+		"SELECT id, account_id, family_id, expires_at, revoked_at FROM refresh_tokens WHERE id = $1",
+		id,
 	)
-	account := &Account{}
-	if err := row.Scan(&account.ID, &account.FirstName, &account.LastName, &account.Email, &account.PasswordHash, &account.Role); err != nil {
-		return nil, err // Return error if no account is found
+	t := &RefreshToken{}
+	var revokedAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.AccountID, &t.FamilyID, &t.ExpiresAt, &revokedAt); err != nil {
+		return nil, err
 	}
-	return account, nil
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	return t, nil
+}
+
+func (r *postgresRepository) RevokeRefreshToken(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE refresh_tokens SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL",
+		id,
+	)
+	return err
 }
 
-func (r *postgresRepository) GetAccountById(ctx context.Context, id string) (*Account, error) {
+func (r *postgresRepository) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE refresh_tokens SET revoked_at = now() WHERE family_id = $1 AND revoked_at IS NULL",
+		familyID,
+	)
+	return err
+}
+
+func (r *postgresRepository) RevokeRefreshTokensForAccount(ctx context.Context, accountID string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE refresh_tokens SET revoked_at = now() WHERE account_id = $1 AND revoked_at IS NULL",
+		accountID,
+	)
+	return err
+}
+
+func (r *postgresRepository) PutPasswordResetToken(ctx context.Context, t PasswordResetToken) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO password_reset_tokens(id, account_id, token_hash, expires_at) VALUES ($1, $2, $3, $4)",
+		t.ID, t.AccountID, t.TokenHash, t.ExpiresAt,
+	)
+	return err
+}
+
+func (r *postgresRepository) GetPasswordResetToken(ctx context.Context, tokenHash string) (*PasswordResetToken, error) {
 	row := r.db.QueryRowContext(
 		ctx,
-		"SELECT id, first_name, last_name, email, password_hash, role FROM accounts WHERE id = $1",
+		"SELECT id, account_id, token_hash, expires_at, used_at FROM password_reset_tokens WHERE token_hash = $1",
+		tokenHash,
+	)
+	t := &PasswordResetToken{}
+	var usedAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.AccountID, &t.TokenHash, &t.ExpiresAt, &usedAt); err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		t.UsedAt = &usedAt.Time
+	}
+	return t, nil
+}
+
+// MarkPasswordResetTokenUsed consumes an unused token, returning ErrNotFound if it was already used.
+func (r *postgresRepository) MarkPasswordResetTokenUsed(ctx context.Context, id string) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE password_reset_tokens SET used_at = now() WHERE id = $1 AND used_at IS NULL",
 		id,
 	)
-	account := &Account{}
-	if err := row.Scan(&account.ID, &account.FirstName, &account.LastName, &account.Email, &account.PasswordHash, &account.Role); err != nil {
-		return nil, err // Return error if no account is found
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
 	}
-	return account, nil
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
-func (r *postgresRepository) UpdateAccountRole(ctx context.Context, id string, role string) (*Account, error) {
+func (r *postgresRepository) PutEmailChangeToken(ctx context.Context, t EmailChangeToken) error {
 	_, err := r.db.ExecContext(
 		ctx,
-		"UPDATE accounts SET role = $1 WHERE id = $2",
-		role, id,
+		"INSERT INTO email_change_tokens(id, account_id, new_email, token_hash, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		t.ID, t.AccountID, t.NewEmail, t.TokenHash, t.ExpiresAt,
 	)
+	return err
+}
 
+func (r *postgresRepository) GetEmailChangeToken(ctx context.Context, tokenHash string) (*EmailChangeToken, error) {
 	row := r.db.QueryRowContext(
 		ctx,
-		"SELECT id, first_name, last_name, email, password_hash, role FROM accounts WHERE id = $1",
+		"SELECT id, account_id, new_email, token_hash, expires_at, used_at FROM email_change_tokens WHERE token_hash = $1",
+		tokenHash,
+	)
+	t := &EmailChangeToken{}
+	var usedAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.AccountID, &t.NewEmail, &t.TokenHash, &t.ExpiresAt, &usedAt); err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		t.UsedAt = &usedAt.Time
+	}
+	return t, nil
+}
+
+// MarkEmailChangeTokenUsed consumes an unused token, returning ErrNotFound if it was already used.
+func (r *postgresRepository) MarkEmailChangeTokenUsed(ctx context.Context, id string) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE email_change_tokens SET used_at = now() WHERE id = $1 AND used_at IS NULL",
 		id,
 	)
-	account := &Account{}
-	if err := row.Scan(&account.ID, &account.FirstName, &account.LastName, &account.Email, &account.PasswordHash, &account.Role); err != nil {
-		return nil, err // Return error if no account is found
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
 	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
 
-	return account, err
+func (r *postgresRepository) PutEmailVerificationToken(ctx context.Context, t EmailVerificationToken) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO email_verification_tokens(id, account_id, token_hash, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)",
+		t.ID, t.AccountID, t.TokenHash, t.CreatedAt, t.ExpiresAt,
+	)
+	return err
 }
 
-func (r *postgresRepository) UpdatePasswordHash(ctx context.Context, email string, passwordHash string) (*Account, error) {
+func (r *postgresRepository) GetEmailVerificationToken(ctx context.Context, tokenHash string) (*EmailVerificationToken, error) {
+	row := r.db.QueryRowContext(
+		ctx,
+		"SELECT id, account_id, token_hash, created_at, expires_at, used_at FROM email_verification_tokens WHERE token_hash = $1",
+		tokenHash,
+	)
+	t := &EmailVerificationToken{}
+	var usedAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.AccountID, &t.TokenHash, &t.CreatedAt, &t.ExpiresAt, &usedAt); err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		t.UsedAt = &usedAt.Time
+	}
+	return t, nil
+}
+
+// MarkEmailVerificationTokenUsed consumes an unused token, returning ErrNotFound if it was already used.
+func (r *postgresRepository) MarkEmailVerificationTokenUsed(ctx context.Context, id string) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE email_verification_tokens SET used_at = now() WHERE id = $1 AND used_at IS NULL",
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *postgresRepository) CountEmailVerificationTokensSince(ctx context.Context, accountID string, since time.Time) (int, error) {
+	row := r.db.QueryRowContext(
+		ctx,
+		"SELECT COUNT(*) FROM email_verification_tokens WHERE account_id = $1 AND created_at >= $2",
+		accountID, since,
+	)
+	var n int
+	if err := row.Scan(&n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// PutMfaSecret stores a new unconfirmed secret. A confirmed enrollment is never replaced.
+func (r *postgresRepository) PutMfaSecret(ctx context.Context, accountID string, secret string) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO account_mfa(account_id, secret) VALUES ($1, $2)
+    ON CONFLICT (account_id) DO UPDATE SET secret = $2, last_used_step = 0
+    WHERE account_mfa.confirmed_at IS NULL`,
+		accountID, secret,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrMfaAlreadyEnabled
+	}
+	return nil
+}
+
+func (r *postgresRepository) GetMfaSecret(ctx context.Context, accountID string) (*MfaSecret, error) {
+	row := r.db.QueryRowContext(
+		ctx,
+		"SELECT account_id, secret, confirmed_at, last_used_step FROM account_mfa WHERE account_id = $1",
+		accountID,
+	)
+	m := &MfaSecret{}
+	var confirmedAt sql.NullTime
+	if err := row.Scan(&m.AccountID, &m.Secret, &confirmedAt, &m.LastUsedStep); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if confirmedAt.Valid {
+		m.ConfirmedAt = &confirmedAt.Time
+	}
+	return m, nil
+}
+
+// ConfirmMfa enables the stored secret and replaces the account's recovery codes.
+func (r *postgresRepository) ConfirmMfa(ctx context.Context, accountID string, step int64, recoveryCodeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(
+		ctx,
+		"UPDATE account_mfa SET confirmed_at = now(), last_used_step = $2 WHERE account_id = $1 AND confirmed_at IS NULL",
+		accountID, step,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrMfaAlreadyEnabled
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE account_id = $1", accountID); err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err := tx.ExecContext(
+			ctx,
+			"INSERT INTO mfa_recovery_codes(account_id, code_hash) VALUES ($1, $2)",
+			accountID, hash,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// UseMfaStep records that the code of step was used, returning ErrNotFound if it or a later one already was.
+func (r *postgresRepository) UseMfaStep(ctx context.Context, accountID string, step int64) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE account_mfa SET last_used_step = $2 WHERE account_id = $1 AND last_used_step < $2",
+		accountID, step,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UseRecoveryCode consumes an unused recovery code, returning ErrNotFound if there is none with codeHash.
+func (r *postgresRepository) UseRecoveryCode(ctx context.Context, accountID string, codeHash string) error {
+	res, err := r.db.ExecContext(
+		ctx,
+		"UPDATE mfa_recovery_codes SET used_at = now() WHERE account_id = $1 AND code_hash = $2 AND used_at IS NULL",
+		accountID, codeHash,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *postgresRepository) PutDataRequest(ctx context.Context, d DataRequest) error {
+	return putDataRequest(ctx, r.db, d)
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func putDataRequest(ctx context.Context, db execer, d DataRequest) error {
+	_, err := db.ExecContext(
+		ctx,
+		"INSERT INTO data_requests(id, account_id, kind, requested_by, reason, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		d.ID, d.AccountID, d.Kind, d.RequestedBy, d.Reason, d.CreatedAt,
+	)
+	return err
+}
+
+// erasedAccountTables hold nothing but data about the account and are emptied on erasure
+var erasedAccountTables = []string{
+	"addresses",
+	"api_keys",
+	"account_roles",
+	"refresh_tokens",
+	"password_reset_tokens",
+	"email_verification_tokens",
+	"email_change_tokens",
+	"account_mfa",
+	"mfa_recovery_codes",
+}
+
+// EraseAccount anonymizes the account row, deletes every other record about it and stores the
+// audit record d, all in one transaction.
+func (r *postgresRepository) EraseAccount(ctx context.Context, a *Account, d DataRequest) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The empty password hash matches no password, so the account can never log in again
+	res, err := tx.ExecContext(
+		ctx,
+		`UPDATE accounts SET first_name = 'Erased', last_name = 'Account', email = $2, password_hash = '',
+    email_verified_at = NULL, status = 'closed', status_reason = 'erased', status_changed_at = now()
+    WHERE id = $1`,
+		a.ID, erasedEmail(a.ID),
+	)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	for _, table := range erasedAccountTables {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE account_id = $1", a.ID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM login_throttles WHERE key = $1", emailThrottleKey(a.Email)); err != nil {
+		return err
+	}
+	// The outbox of the notification package lives in this database and keeps rendered emails
+	if _, err := tx.ExecContext(ctx, "DELETE FROM notification_outbox WHERE recipient = $1", a.Email); err != nil {
+		return err
+	}
+
+	if err := putDataRequest(ctx, tx, d); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+const addressColumns = "id, account_id, full_name, line1, line2, city, region, postal_code, country, phone, default_shipping, default_billing, created_at"
+
+func scanAddress(row rowScanner) (*Address, error) {
+	a := &Address{}
+	err := row.Scan(
+		&a.ID, &a.AccountID, &a.FullName, &a.Line1, &a.Line2, &a.City, &a.Region,
+		&a.PostalCode, &a.Country, &a.Phone, &a.DefaultShipping, &a.DefaultBilling, &a.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// ListAddresses returns the addresses of an account, defaults first and then the oldest first.
+func (r *postgresRepository) ListAddresses(ctx context.Context, accountID string) ([]Address, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		"SELECT "+addressColumns+" FROM addresses WHERE account_id = $1 ORDER BY default_shipping DESC, default_billing DESC, created_at, id",
+		accountID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := []Address{}
+	for rows.Next() {
+		a, err := scanAddress(rows)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, *a)
+	}
+	return addresses, rows.Err()
+}
+
+func (r *postgresRepository) PutAddress(ctx context.Context, a Address) error {
 	_, err := r.db.ExecContext(
 		ctx,
-		"UPDATE accounts SET password_hash = $1 WHERE email = $2",
-		passwordHash, email,
+		"INSERT INTO addresses("+addressColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)",
+		a.ID, a.AccountID, a.FullName, a.Line1, a.Line2, a.City, a.Region,
+		a.PostalCode, a.Country, a.Phone, a.DefaultShipping, a.DefaultBilling, a.CreatedAt,
 	)
+	return err
+}
 
+// UpdateAddress changes the postal fields of an address owned by a.AccountID, returning ErrNotFound otherwise.
+func (r *postgresRepository) UpdateAddress(ctx context.Context, a Address) (*Address, error) {
 	row := r.db.QueryRowContext(
 		ctx,
-		"SELECT id, first_name, last_name, email, password_hash, role FROM accounts WHERE email = $1",
-		email,
+		`UPDATE addresses SET full_name = $3, line1 = $4, line2 = $5, city = $6, region = $7,
+    postal_code = $8, country = $9, phone = $10
+    WHERE id = $1 AND account_id = $2
+    RETURNING `+addressColumns,
+		a.ID, a.AccountID, a.FullName, a.Line1, a.Line2, a.City, a.Region, a.PostalCode, a.Country, a.Phone,
 	)
-	account := &Account{}
-	if err := row.Scan(&account.ID, &account.FirstName, &account.LastName, &account.Email, &account.PasswordHash, &account.Role); err != nil {
-		return nil, err // Return error if no account is found
+	updated, err := scanAddress(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
 	}
+	return updated, err
+}
 
-	return account, err
+func (r *postgresRepository) DeleteAddress(ctx context.Context, accountID string, id string) error {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM addresses WHERE id = $1 AND account_id = $2", id, accountID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetDefaultAddress moves the shipping or billing default of an account to the address id.
+func (r *postgresRepository) SetDefaultAddress(ctx context.Context, accountID string, id string, usage string) error {
+	column := "default_shipping"
+	if usage == AddressUsageBilling {
+		column = "default_billing"
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Cleared first because the partial unique index allows one default per account at any time
+	if _, err := tx.ExecContext(ctx, "UPDATE addresses SET "+column+" = FALSE WHERE account_id = $1 AND "+column, accountID); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, "UPDATE addresses SET "+column+" = TRUE WHERE id = $1 AND account_id = $2", id, accountID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return tx.Commit()
+}
+
+func (r *postgresRepository) GetLoginThrottle(ctx context.Context, key string) (*LoginThrottle, error) {
+	row := r.db.QueryRowContext(
+		ctx,
+		"SELECT key, failures, last_failure_at, locked_until FROM login_throttles WHERE key = $1",
+		key,
+	)
+	t := &LoginThrottle{}
+	var lockedUntil sql.NullTime
+	if err := row.Scan(&t.Key, &t.Failures, &t.LastFailureAt, &lockedUntil); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if lockedUntil.Valid {
+		t.LockedUntil = &lockedUntil.Time
+	}
+	return t, nil
+}
+
+// RecordLoginFailure increments the failure count for key, starting over when the previous
+// failure happened before windowStart, and returns the new count.
+func (r *postgresRepository) RecordLoginFailure(ctx context.Context, key string, windowStart time.Time) (int, error) {
+	row := r.db.QueryRowContext(
+		ctx,
+		`INSERT INTO login_throttles(key, failures, last_failure_at) VALUES ($1, 1, now())
+    ON CONFLICT (key) DO UPDATE SET
+      failures = CASE WHEN login_throttles.last_failure_at < $2 THEN 1 ELSE login_throttles.failures + 1 END,
+      last_failure_at = now()
+    RETURNING failures`,
+		key, windowStart,
+	)
+	var failures int
+	if err := row.Scan(&failures); err != nil {
+		return 0, err
+	}
+	return failures, nil
+}
+
+func (r *postgresRepository) LockLogin(ctx context.Context, key string, until time.Time) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE login_throttles SET locked_until = $2 WHERE key = $1",
+		key, until,
+	)
+	return err
+}
+
+func (r *postgresRepository) ResetLoginFailures(ctx context.Context, key string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"DELETE FROM login_throttles WHERE key = $1",
+		key,
+	)
+	return err
+}
+
+const apiKeyColumns = "id, account_id, name, prefix, key_hash, permissions, created_at, expires_at, last_used_at, revoked_at"
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*APIKey, error) {
+	k := &APIKey{}
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&k.ID, &k.AccountID, &k.Name, &k.Prefix, &k.KeyHash, pq.Array(&k.Permissions), &k.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		k.RevokedAt = &revokedAt.Time
+	}
+	return k, nil
+}
+
+func (r *postgresRepository) PutAPIKey(ctx context.Context, k APIKey) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO api_keys(id, account_id, name, prefix, key_hash, permissions, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		k.ID, k.AccountID, k.Name, k.Prefix, k.KeyHash, pq.Array(k.Permissions), k.CreatedAt, k.ExpiresAt,
+	)
+	return err
+}
+
+func (r *postgresRepository) GetAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	return scanAPIKey(r.db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE id = $1", id))
+}
+
+func (r *postgresRepository) GetAPIKeyByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	return scanAPIKey(r.db.QueryRowContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE key_hash = $1", keyHash))
+}
+
+func (r *postgresRepository) ListAPIKeys(ctx context.Context, accountID string) ([]APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+apiKeyColumns+" FROM api_keys WHERE account_id = $1 ORDER BY created_at DESC, id", accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+func (r *postgresRepository) RevokeAPIKey(ctx context.Context, id string) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *postgresRepository) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = $2 WHERE id = $1", id, usedAt)
+	return err
 }
@@ -0,0 +1,102 @@
+package account
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/JonathanNithi/ecommerce/backend/authz"
+)
+
+const (
+	defaultSearchPageSize = 20
+	maxSearchPageSize     = 100
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid pagination cursor")
+	ErrInvalidStatus = errors.New("status must be active, suspended or closed")
+)
+
+// AccountFilter narrows SearchAccounts. Zero fields do not filter.
+type AccountFilter struct {
+	Query         string     // substring of the email, first, last or full name, case insensitive
+	Role          string     // accounts holding this role
+	Status        string     // StatusActive, StatusSuspended or StatusClosed
+	CreatedAfter  *time.Time // inclusive
+	CreatedBefore *time.Time // exclusive
+}
+
+// AccountPage is one page of SearchAccounts. NextCursor is empty on the last page.
+type AccountPage struct {
+	Accounts   []Account
+	NextCursor string
+	TotalCount int
+}
+
+// Cursors are opaque to clients but are simply the id of the last account of a page. Account ids
+// are KSUIDs, which sort by creation time, so paging by id is stable while accounts are added.
+func encodeCursor(id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	id, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(id) == 0 {
+		return "", ErrInvalidCursor
+	}
+	return string(id), nil
+}
+
+// AccountCursor is the cursor that continues a search after the account with id.
+func AccountCursor(id string) string {
+	return encodeCursor(id)
+}
+
+// SearchAccounts returns up to first accounts matching f, newest first, following the account
+// the after cursor points to.
+func (s *accountService) SearchAccounts(ctx context.Context, f AccountFilter, first int, after string) (*AccountPage, error) {
+	if err := authz.Require(authz.ClaimsFromContext(ctx), authz.AccountsManage); err != nil {
+		return nil, err
+	}
+
+	f.Query = strings.TrimSpace(f.Query)
+	switch f.Status {
+	case "", StatusActive, StatusSuspended, StatusClosed:
+	default:
+		return nil, ErrInvalidStatus
+	}
+	if first <= 0 {
+		first = defaultSearchPageSize
+	}
+	if first > maxSearchPageSize {
+		first = maxSearchPageSize
+	}
+	afterID := ""
+	if after != "" {
+		id, err := decodeCursor(after)
+		if err != nil {
+			return nil, err
+		}
+		afterID = id
+	}
+
+	// One extra row tells whether there is a next page
+	accounts, err := s.repository.SearchAccounts(ctx, f, afterID, first+1)
+	if err != nil {
+		return nil, err
+	}
+	total, err := s.repository.CountAccounts(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &AccountPage{Accounts: accounts, TotalCount: total}
+	if len(accounts) > first {
+		page.Accounts = accounts[:first]
+		page.NextCursor = encodeCursor(page.Accounts[first-1].ID)
+	}
+	return page, nil
+}
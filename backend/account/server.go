@@ -4,177 +4,681 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/JonathanNithi/ecommerce/backend/account/pb"
+	"github.com/JonathanNithi/ecommerce/backend/audit"
+	auditpb "github.com/JonathanNithi/ecommerce/backend/audit/pb"
+	"github.com/JonathanNithi/ecommerce/backend/authz"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/reflection"
 )
 
 type grpcServer struct {
 	pb.UnimplementedAccountServiceServer
-	service Service
+	service  Service
+	gateways GatewayPolicy
 }
 
-func ListenGRPC(s Service, port int) error {
+// ClientIPMetadataKey carries the address of the end user when a gateway calls on their behalf
+const ClientIPMetadataKey = "x-client-ip"
+
+// GatewayPolicy names the gateways trusted to forward the end user's address. Any other caller
+// is throttled by its own address, otherwise it could choose the address it is throttled by.
+type GatewayPolicy struct {
+	// IPs, CIDR ranges or host names, host names are resolved on every login
+	TrustedGateways []string `envconfig:"TRUSTED_GATEWAYS"`
+}
+
+// trusts reports whether the caller at ip is one of the trusted gateways.
+func (p GatewayPolicy) trusts(ctx context.Context, ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, gateway := range p.TrustedGateways {
+		if _, network, err := net.ParseCIDR(gateway); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+			continue
+		}
+		if gatewayIP := net.ParseIP(gateway); gatewayIP != nil {
+			if gatewayIP.Equal(addr) {
+				return true
+			}
+			continue
+		}
+		resolved, err := net.DefaultResolver.LookupIP(ctx, "ip", gateway)
+		if err != nil {
+			continue
+		}
+		for _, gatewayIP := range resolved {
+			if gatewayIP.Equal(addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// clientIP returns the address forwarded by a trusted gateway and otherwise the caller's address.
+func (s *grpcServer) clientIP(ctx context.Context) string {
+	var ip string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err == nil {
+			ip = host
+		} else {
+			ip = p.Addr.String()
+		}
+	}
+	if !s.gateways.trusts(ctx, ip) {
+		return ip
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(ClientIPMetadataKey); len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
+	}
+	return ip
+}
+
+// policy lists the RPCs that do not require a caller and the permissions the others need.
+// Methods that act on the caller's own account only need authentication, those the account
+// holder must take themselves also refuse staff impersonating them.
+var policy = authz.Policy{
+	pb.AccountService_PostAccount_FullMethodName:             authz.Public(),
+	pb.AccountService_Login_FullMethodName:                   authz.Public(),
+	pb.AccountService_CompleteMfaLogin_FullMethodName:        authz.Public(),
+	pb.AccountService_RefreshToken_FullMethodName:            authz.Public(),
+	pb.AccountService_ForgotPassword_FullMethodName:          authz.Public(),
+	pb.AccountService_ResetPassword_FullMethodName:           authz.Public(),
+	pb.AccountService_Logout_FullMethodName:                  authz.Public(),
+	pb.AccountService_GetJwks_FullMethodName:                 authz.Public(),
+	pb.AccountService_ConfirmEmailChange_FullMethodName:      authz.Public(),
+	pb.AccountService_VerifyEmail_FullMethodName:             authz.Public(),
+	pb.AccountService_ResendVerificationEmail_FullMethodName: authz.Public(),
+	pb.AccountService_GetAccount_FullMethodName:              authz.Authenticated(),
+	pb.AccountService_RevokeAllSessions_FullMethodName:       authz.Authenticated().WithoutImpersonation(),
+	pb.AccountService_UpdateProfile_FullMethodName:           authz.Authenticated(),
+	pb.AccountService_ChangeEmail_FullMethodName:             authz.Authenticated().WithoutImpersonation(),
+	pb.AccountService_ChangePassword_FullMethodName:          authz.Authenticated().WithoutImpersonation(),
+	pb.AccountService_CloseAccount_FullMethodName:            authz.Authenticated().WithoutImpersonation(),
+	pb.AccountService_ExportAccountData_FullMethodName:       authz.Authenticated().WithoutImpersonation(),
+	pb.AccountService_EnrollMfa_FullMethodName:               authz.Authenticated().WithoutImpersonation(),
+	pb.AccountService_ConfirmMfa_FullMethodName:              authz.Authenticated().WithoutImpersonation(),
+	pb.AccountService_ListAddresses_FullMethodName:           authz.Authenticated(),
+	pb.AccountService_AddAddress_FullMethodName:              authz.Authenticated(),
+	pb.AccountService_UpdateAddress_FullMethodName:           authz.Authenticated(),
+	pb.AccountService_DeleteAddress_FullMethodName:           authz.Authenticated(),
+	pb.AccountService_SetDefaultAddress_FullMethodName:       authz.Authenticated(),
+	pb.AccountService_CreateApiKey_FullMethodName:            authz.Authenticated().WithoutImpersonation(),
+	pb.AccountService_ListApiKeys_FullMethodName:             authz.Authenticated(),
+	pb.AccountService_RevokeApiKey_FullMethodName:            authz.Authenticated().WithoutImpersonation(),
+	pb.AccountService_AuthenticateApiKey_FullMethodName:      authz.Public(),
+	pb.AccountService_EndImpersonation_FullMethodName:        authz.Authenticated(),
+	pb.AccountService_ImpersonateAccount_FullMethodName:      authz.RequirePermissions(authz.AccountsImpersonate).WithoutImpersonation(),
+	pb.AccountService_GetAccounts_FullMethodName:             authz.RequirePermissions(authz.AccountsManage),
+	pb.AccountService_SearchAccounts_FullMethodName:          authz.RequirePermissions(authz.AccountsManage),
+	pb.AccountService_UnlockAccount_FullMethodName:           authz.RequirePermissions(authz.AccountsManage),
+	pb.AccountService_SuspendAccount_FullMethodName:          authz.RequirePermissions(authz.AccountsManage),
+	pb.AccountService_ReactivateAccount_FullMethodName:       authz.RequirePermissions(authz.AccountsManage),
+	pb.AccountService_EraseAccount_FullMethodName:            authz.RequirePermissions(authz.AccountsManage),
+	pb.AccountService_SetAccountAsAdmin_FullMethodName:       authz.RequirePermissions(authz.RolesManage),
+	pb.AccountService_DemoteAdmin_FullMethodName:             authz.RequirePermissions(authz.RolesManage),
+	pb.AccountService_CreateRole_FullMethodName:              authz.RequirePermissions(authz.RolesManage),
+	pb.AccountService_GrantRole_FullMethodName:               authz.RequirePermissions(authz.RolesManage),
+	pb.AccountService_RevokeRole_FullMethodName:              authz.RequirePermissions(authz.RolesManage),
+	auditpb.AuditService_ListAuditEvents_FullMethodName:      audit.PolicyRule,
+}
+
+func ListenGRPC(s Service, a audit.Repository, v *authz.Verifier, gateways GatewayPolicy, port int) error {
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return err
 	}
-	serv := grpc.NewServer()
+	serv := grpc.NewServer(authz.ServerOptions(v, policy)...)
 	pb.RegisterAccountServiceServer(serv, &grpcServer{
 		UnimplementedAccountServiceServer: pb.UnimplementedAccountServiceServer{},
-		service:                           s})
+		service:                           s,
+		gateways:                          gateways})
+	audit.RegisterServer(serv, a)
 	reflection.Register(serv)
 	return serv.Serve(lis)
 }
 
+// accountProfile is the only conversion from an account to its message so that credentials
+// cannot leak through a handler that copies fields by hand.
+func accountProfile(a *Account) *pb.AccountProfile {
+	return &pb.AccountProfile{
+		Id:            a.ID,
+		FirstName:     a.FirstName,
+		LastName:      a.LastName,
+		Email:         a.Email,
+		Role:          a.Role,
+		Roles:         a.Roles,
+		Permissions:   a.Permissions,
+		EmailVerified: a.EmailVerified,
+		MfaEnabled:    a.MfaEnabled,
+		Status:        a.Status,
+		StatusReason:  a.StatusReason,
+		CreatedAt:     marshalTime(a.CreatedAt),
+	}
+}
+
+func marshalTime(t time.Time) []byte {
+	b, _ := t.MarshalBinary()
+	return b
+}
+
+// unmarshalTime returns nil for empty fields, which do not filter
+func unmarshalTime(b []byte) (*time.Time, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	t := &time.Time{}
+	if err := t.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
 func (s *grpcServer) PostAccount(ctx context.Context, r *pb.PostAccountRequest) (*pb.PostAccountResponse, error) {
-	p, err := s.service.PostAccount(ctx, r.FirstName, r.LastName, r.Email, r.PasswordHash)
+	p, err := s.service.PostAccount(ctx, r.FirstName, r.LastName, r.Email, r.Password)
 	if err != nil {
 		return nil, err
 	}
-	return &pb.PostAccountResponse{Account: &pb.Account{
-		Id:           p.ID,
-		FirstName:    p.FirstName,
-		LastName:     p.LastName,
-		Email:        p.Email,
-		PasswordHash: p.PasswordHash,
-	}}, nil
+	return &pb.PostAccountResponse{Account: accountProfile(p)}, nil
 }
 
 func (s *grpcServer) GetAccount(ctx context.Context, r *pb.GetAccountRequest) (*pb.GetAccountResponse, error) {
-	p, newAccessToken, newRefreshToken, err := s.service.GetAccount(ctx, r.Id, r.AccessToken, r.RefreshToken)
+	p, err := s.service.GetAccount(ctx, r.Id)
 	if err != nil {
 		return nil, err
 	}
 	return &pb.GetAccountResponse{
-		Account: &pb.Account{
-			Id:           p.ID,
-			FirstName:    p.FirstName,
-			LastName:     p.LastName,
-			Email:        p.Email,
-			PasswordHash: p.PasswordHash,
-			Role:         p.Role,
-		},
-		AccessToken:  newAccessToken,
-		RefreshToken: newRefreshToken,
+		Account: accountProfile(p),
+	}, nil
+}
+
+func (s *grpcServer) SearchAccounts(ctx context.Context, r *pb.SearchAccountsRequest) (*pb.SearchAccountsResponse, error) {
+	createdAfter, err := unmarshalTime(r.CreatedAfter)
+	if err != nil {
+		return nil, err
+	}
+	createdBefore, err := unmarshalTime(r.CreatedBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := s.service.SearchAccounts(ctx, AccountFilter{
+		Query:         r.Query,
+		Role:          r.Role,
+		Status:        r.Status,
+		CreatedAfter:  createdAfter,
+		CreatedBefore: createdBefore,
+	}, int(r.First), r.After)
+	if err != nil {
+		return nil, err
+	}
+
+	edges := []*pb.AccountEdge{}
+	for i := range page.Accounts {
+		edges = append(edges, &pb.AccountEdge{
+			Cursor:  AccountCursor(page.Accounts[i].ID),
+			Account: accountProfile(&page.Accounts[i]),
+		})
+	}
+	return &pb.SearchAccountsResponse{
+		Edges:       edges,
+		EndCursor:   page.NextCursor,
+		HasNextPage: page.NextCursor != "",
+		TotalCount:  uint64(page.TotalCount),
 	}, nil
 }
 
 func (s *grpcServer) GetAccounts(ctx context.Context, r *pb.GetAccountsRequest) (*pb.GetAccountsResponse, error) {
-	res, newAccessToken, newRefreshToken, err := s.service.GetAccounts(ctx, r.Skip, r.Take, r.AccessToken, r.RefreshToken)
+	res, err := s.service.GetAccounts(ctx, r.Skip, r.Take)
 	if err != nil {
 		return nil, err
 	}
-	accounts := []*pb.Account{}
-	for _, p := range res {
-		accounts = append(
-			accounts,
-			&pb.Account{
-				Id:           p.ID,
-				FirstName:    p.FirstName,
-				LastName:     p.LastName,
-				Email:        p.Email,
-				PasswordHash: p.PasswordHash,
-				Role:         p.Role,
-			},
-		)
+	accounts := []*pb.AccountProfile{}
+	for i := range res {
+		accounts = append(accounts, accountProfile(&res[i]))
 	}
 	return &pb.GetAccountsResponse{
-		Accounts:     accounts,
-		AccessToken:  newAccessToken,
-		RefreshToken: newRefreshToken,
+		Accounts: accounts,
 	}, nil
 }
 
 func (s *grpcServer) Login(ctx context.Context, r *pb.LoginRequest) (*pb.LoginResponse, error) {
 	// Call the service layer login method
-	account, accessToken, refreshToken, err := s.service.Login(ctx, r.Email, r.Password)
+	res, err := s.service.Login(ctx, r.Email, r.Password, s.clientIP(ctx))
 	if err != nil {
 		return nil, err
 	}
+	if res.MfaChallengeToken != "" {
+		return &pb.LoginResponse{MfaChallengeToken: res.MfaChallengeToken}, nil
+	}
 
-	// Map the account to the protobuf Account type
+	// Map the account to its public profile
 	return &pb.LoginResponse{
-		Account: &pb.Account{
-			Id:           account.ID,
-			FirstName:    account.FirstName,
-			LastName:     account.LastName,
-			Email:        account.Email,
-			PasswordHash: account.PasswordHash,
-			Role:         account.Role,
-		},
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
+		Account:      accountProfile(res.Account),
+		AccessToken:  res.AccessToken,
+		RefreshToken: res.RefreshToken,
 	}, nil
 }
 
-func (s *grpcServer) RefreshToken(ctx context.Context, r *pb.RefreshTokenRequest) (*pb.RefreshTokenResponse, error) {
-	claims, err := ValidateToken(r.RefreshToken)
+func (s *grpcServer) CompleteMfaLogin(ctx context.Context, r *pb.CompleteMfaLoginRequest) (*pb.CompleteMfaLoginResponse, error) {
+	res, err := s.service.CompleteMfaLogin(ctx, r.MfaChallengeToken, r.Code)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.CompleteMfaLoginResponse{
+		Account:      accountProfile(res.Account),
+		AccessToken:  res.AccessToken,
+		RefreshToken: res.RefreshToken,
+	}, nil
+}
+
+func (s *grpcServer) EnrollMfa(ctx context.Context, r *pb.EnrollMfaRequest) (*pb.EnrollMfaResponse, error) {
+	secret, uri, err := s.service.EnrollMfa(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.EnrollMfaResponse{
+		Secret:     secret,
+		OtpauthUri: uri,
+	}, nil
+}
+
+func (s *grpcServer) ConfirmMfa(ctx context.Context, r *pb.ConfirmMfaRequest) (*pb.ConfirmMfaResponse, error) {
+	codes, err := s.service.ConfirmMfa(ctx, r.Code)
 	if err != nil {
 		return nil, err
 	}
+	return &pb.ConfirmMfaResponse{RecoveryCodes: codes}, nil
+}
 
-	// Generate a new access token
-	newAccessToken, err := GenerateAccessToken(claims.Username, claims.Role)
+func (s *grpcServer) RefreshToken(ctx context.Context, r *pb.RefreshTokenRequest) (*pb.RefreshTokenResponse, error) {
+	newAccessToken, newRefreshToken, err := s.service.RefreshToken(ctx, r.RefreshToken)
 	if err != nil {
 		return nil, err
 	}
 
 	return &pb.RefreshTokenResponse{
-		AccessToken: newAccessToken,
+		AccessToken:  newAccessToken,
+		RefreshToken: newRefreshToken,
 	}, nil
 }
 
 func (s *grpcServer) SetAccountAsAdmin(ctx context.Context, r *pb.SetAccountAsAdminRequest) (*pb.SetAccountAsAdminResponse, error) {
-	p, newAccessToken, newRefreshToken, err := s.service.SetAccountAsAdmin(ctx, r.AccessToken, r.RefreshToken, r.Id)
+	p, err := s.service.SetAccountAsAdmin(ctx, r.Id)
 	if err != nil {
 		return nil, err
 	}
 	return &pb.SetAccountAsAdminResponse{
-		Account: &pb.Account{
-			Id:           p.ID,
-			FirstName:    p.FirstName,
-			LastName:     p.LastName,
-			Email:        p.Email,
-			PasswordHash: p.PasswordHash,
-			Role:         p.Role,
-		},
-		AccessToken:  newAccessToken,
-		RefreshToken: newRefreshToken,
+		Account: accountProfile(p),
 	}, nil
 }
 
-func (s *grpcServer) ForgotPassword(ctx context.Context, r *pb.ForgotPasswordRequest) (*pb.ForgotPasswordResponse, error) {
-	account, err := s.service.ForgotPassword(ctx, r.Email, r.FirstName, r.LastName)
+func (s *grpcServer) DemoteAdmin(ctx context.Context, r *pb.DemoteAdminRequest) (*pb.DemoteAdminResponse, error) {
+	p, err := s.service.DemoteAdmin(ctx, r.Id)
 	if err != nil {
 		return nil, err
 	}
-	return &pb.ForgotPasswordResponse{
-		Account: &pb.Account{
-			Id:           account.ID,
-			FirstName:    account.FirstName,
-			LastName:     account.LastName,
-			Email:        account.Email,
-			PasswordHash: account.PasswordHash,
-			Role:         account.Role,
+	return &pb.DemoteAdminResponse{
+		Account: accountProfile(p),
+	}, nil
+}
+
+func (s *grpcServer) CreateRole(ctx context.Context, r *pb.CreateRoleRequest) (*pb.CreateRoleResponse, error) {
+	role, err := s.service.CreateRole(ctx, r.Name, r.Permissions)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.CreateRoleResponse{
+		Role: &pb.Role{
+			Name:        role.Name,
+			Permissions: role.Permissions,
 		},
 	}, nil
 }
 
+func (s *grpcServer) GrantRole(ctx context.Context, r *pb.GrantRoleRequest) (*pb.GrantRoleResponse, error) {
+	p, err := s.service.GrantRole(ctx, r.Id, r.Role)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.GrantRoleResponse{
+		Account: accountProfile(p),
+	}, nil
+}
+
+func (s *grpcServer) RevokeRole(ctx context.Context, r *pb.RevokeRoleRequest) (*pb.RevokeRoleResponse, error) {
+	p, err := s.service.RevokeRole(ctx, r.Id, r.Role)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.RevokeRoleResponse{
+		Account: accountProfile(p),
+	}, nil
+}
+
+func (s *grpcServer) ForgotPassword(ctx context.Context, r *pb.ForgotPasswordRequest) (*pb.ForgotPasswordResponse, error) {
+	if err := s.service.ForgotPassword(ctx, r.Email); err != nil {
+		return nil, err
+	}
+	return &pb.ForgotPasswordResponse{}, nil
+}
+
 func (s *grpcServer) ResetPassword(ctx context.Context, r *pb.ResetPasswordRequest) (*pb.ResetPasswordResponse, error) {
-	account, err := s.service.ResetPassword(ctx, r.Id, r.Email, r.Password)
+	if err := s.service.ResetPassword(ctx, r.Token, r.Password); err != nil {
+		return nil, err
+	}
+	return &pb.ResetPasswordResponse{}, nil
+}
+
+func (s *grpcServer) Logout(ctx context.Context, r *pb.LogoutRequest) (*pb.LogoutResponse, error) {
+	if err := s.service.Logout(ctx, r.RefreshToken); err != nil {
+		return nil, err
+	}
+	return &pb.LogoutResponse{}, nil
+}
+
+func (s *grpcServer) RevokeAllSessions(ctx context.Context, r *pb.RevokeAllSessionsRequest) (*pb.RevokeAllSessionsResponse, error) {
+	if err := s.service.RevokeAllSessions(ctx); err != nil {
+		return nil, err
+	}
+	return &pb.RevokeAllSessionsResponse{}, nil
+}
+
+func (s *grpcServer) UnlockAccount(ctx context.Context, r *pb.UnlockAccountRequest) (*pb.UnlockAccountResponse, error) {
+	p, err := s.service.UnlockAccount(ctx, r.Id)
 	if err != nil {
 		return nil, err
 	}
-	return &pb.ResetPasswordResponse{
-		Account: &pb.Account{
-			Id:           account.ID,
-			FirstName:    account.FirstName,
-			LastName:     account.LastName,
-			Email:        account.Email,
-			PasswordHash: account.PasswordHash,
-			Role:         account.Role,
-		},
+	return &pb.UnlockAccountResponse{
+		Account: accountProfile(p),
+	}, nil
+}
+
+func (s *grpcServer) UpdateProfile(ctx context.Context, r *pb.UpdateProfileRequest) (*pb.UpdateProfileResponse, error) {
+	p, err := s.service.UpdateProfile(ctx, r.FirstName, r.LastName)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.UpdateProfileResponse{
+		Account: accountProfile(p),
+	}, nil
+}
+
+func (s *grpcServer) ChangeEmail(ctx context.Context, r *pb.ChangeEmailRequest) (*pb.ChangeEmailResponse, error) {
+	if err := s.service.ChangeEmail(ctx, r.NewEmail, r.Password); err != nil {
+		return nil, err
+	}
+	return &pb.ChangeEmailResponse{}, nil
+}
+
+func (s *grpcServer) ConfirmEmailChange(ctx context.Context, r *pb.ConfirmEmailChangeRequest) (*pb.ConfirmEmailChangeResponse, error) {
+	p, err := s.service.ConfirmEmailChange(ctx, r.Token)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ConfirmEmailChangeResponse{
+		Account: accountProfile(p),
+	}, nil
+}
+
+func (s *grpcServer) ChangePassword(ctx context.Context, r *pb.ChangePasswordRequest) (*pb.ChangePasswordResponse, error) {
+	if err := s.service.ChangePassword(ctx, r.CurrentPassword, r.NewPassword); err != nil {
+		return nil, err
+	}
+	return &pb.ChangePasswordResponse{}, nil
+}
+
+func (s *grpcServer) VerifyEmail(ctx context.Context, r *pb.VerifyEmailRequest) (*pb.VerifyEmailResponse, error) {
+	p, err := s.service.VerifyEmail(ctx, r.Token)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.VerifyEmailResponse{
+		Account: accountProfile(p),
+	}, nil
+}
+
+func (s *grpcServer) ResendVerificationEmail(ctx context.Context, r *pb.ResendVerificationEmailRequest) (*pb.ResendVerificationEmailResponse, error) {
+	if err := s.service.ResendVerificationEmail(ctx, r.Email); err != nil {
+		return nil, err
+	}
+	return &pb.ResendVerificationEmailResponse{}, nil
+}
+
+func (s *grpcServer) SuspendAccount(ctx context.Context, r *pb.SuspendAccountRequest) (*pb.SuspendAccountResponse, error) {
+	p, err := s.service.SuspendAccount(ctx, r.Id, r.Reason)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SuspendAccountResponse{Account: accountProfile(p)}, nil
+}
+
+func (s *grpcServer) ImpersonateAccount(ctx context.Context, r *pb.ImpersonateAccountRequest) (*pb.ImpersonateAccountResponse, error) {
+	i, err := s.service.ImpersonateAccount(ctx, r.Id, r.Reason)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ImpersonateAccountResponse{
+		AccessToken: i.AccessToken,
+		ExpiresAt:   marshalTime(i.ExpiresAt),
+		SessionId:   i.SessionID,
+		Account:     accountProfile(i.Account),
+	}, nil
+}
+
+func (s *grpcServer) EndImpersonation(ctx context.Context, r *pb.EndImpersonationRequest) (*pb.EndImpersonationResponse, error) {
+	if err := s.service.EndImpersonation(ctx); err != nil {
+		return nil, err
+	}
+	return &pb.EndImpersonationResponse{}, nil
+}
+
+func (s *grpcServer) ReactivateAccount(ctx context.Context, r *pb.ReactivateAccountRequest) (*pb.ReactivateAccountResponse, error) {
+	p, err := s.service.ReactivateAccount(ctx, r.Id, r.Reason)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ReactivateAccountResponse{Account: accountProfile(p)}, nil
+}
+
+func (s *grpcServer) CloseAccount(ctx context.Context, r *pb.CloseAccountRequest) (*pb.CloseAccountResponse, error) {
+	if err := s.service.CloseAccount(ctx, r.Password); err != nil {
+		return nil, err
+	}
+	return &pb.CloseAccountResponse{}, nil
+}
+
+func (s *grpcServer) ExportAccountData(ctx context.Context, r *pb.ExportAccountDataRequest) (*pb.ExportAccountDataResponse, error) {
+	archive, err := s.service.ExportAccountData(ctx, r.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ExportAccountDataResponse{Archive: archive}, nil
+}
+
+func (s *grpcServer) EraseAccount(ctx context.Context, r *pb.EraseAccountRequest) (*pb.EraseAccountResponse, error) {
+	if err := s.service.EraseAccount(ctx, r.Id, r.Reason); err != nil {
+		return nil, err
+	}
+	return &pb.EraseAccountResponse{}, nil
+}
+
+// optionalTime is marshalTime for times that may be unset
+func optionalTime(t *time.Time) []byte {
+	if t == nil {
+		return nil
+	}
+	return marshalTime(*t)
+}
+
+func apiKeyMessage(k *APIKey) *pb.ApiKey {
+	return &pb.ApiKey{
+		Id:          k.ID,
+		AccountId:   k.AccountID,
+		Name:        k.Name,
+		Prefix:      k.Prefix,
+		Permissions: k.Permissions,
+		CreatedAt:   marshalTime(k.CreatedAt),
+		ExpiresAt:   optionalTime(k.ExpiresAt),
+		LastUsedAt:  optionalTime(k.LastUsedAt),
+		RevokedAt:   optionalTime(k.RevokedAt),
+	}
+}
+
+func (s *grpcServer) CreateApiKey(ctx context.Context, r *pb.CreateApiKeyRequest) (*pb.CreateApiKeyResponse, error) {
+	expiresAt, err := unmarshalTime(r.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	k, key, err := s.service.CreateAPIKey(ctx, r.AccountId, r.Name, r.Permissions, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.CreateApiKeyResponse{ApiKey: apiKeyMessage(k), Key: key}, nil
+}
+
+func (s *grpcServer) ListApiKeys(ctx context.Context, r *pb.ListApiKeysRequest) (*pb.ListApiKeysResponse, error) {
+	keys, err := s.service.ListAPIKeys(ctx, r.AccountId)
+	if err != nil {
+		return nil, err
+	}
+	res := []*pb.ApiKey{}
+	for i := range keys {
+		res = append(res, apiKeyMessage(&keys[i]))
+	}
+	return &pb.ListApiKeysResponse{ApiKeys: res}, nil
+}
+
+func (s *grpcServer) RevokeApiKey(ctx context.Context, r *pb.RevokeApiKeyRequest) (*pb.RevokeApiKeyResponse, error) {
+	if err := s.service.RevokeAPIKey(ctx, r.Id); err != nil {
+		return nil, err
+	}
+	return &pb.RevokeApiKeyResponse{}, nil
+}
+
+func (s *grpcServer) AuthenticateApiKey(ctx context.Context, r *pb.AuthenticateApiKeyRequest) (*pb.AuthenticateApiKeyResponse, error) {
+	claims, err := s.service.AuthenticateAPIKey(ctx, r.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.AuthenticateApiKeyResponse{
+		ApiKeyId:    claims.APIKeyID,
+		Username:    claims.Username,
+		Role:        claims.Role,
+		Permissions: claims.Permissions,
 	}, nil
 }
+
+func addressMessage(a *Address) *pb.Address {
+	return &pb.Address{
+		Id:              a.ID,
+		FullName:        a.FullName,
+		Line1:           a.Line1,
+		Line2:           a.Line2,
+		City:            a.City,
+		Region:          a.Region,
+		PostalCode:      a.PostalCode,
+		Country:         a.Country,
+		Phone:           a.Phone,
+		DefaultShipping: a.DefaultShipping,
+		DefaultBilling:  a.DefaultBilling,
+	}
+}
+
+func addressMessages(addresses []Address) []*pb.Address {
+	res := []*pb.Address{}
+	for i := range addresses {
+		res = append(res, addressMessage(&addresses[i]))
+	}
+	return res
+}
+
+// addressFromMessage copies the postal fields only, defaults are changed through SetDefaultAddress.
+func addressFromMessage(a *pb.Address) Address {
+	if a == nil {
+		return Address{}
+	}
+	return Address{
+		ID:         a.Id,
+		FullName:   a.FullName,
+		Line1:      a.Line1,
+		Line2:      a.Line2,
+		City:       a.City,
+		Region:     a.Region,
+		PostalCode: a.PostalCode,
+		Country:    a.Country,
+		Phone:      a.Phone,
+	}
+}
+
+func (s *grpcServer) ListAddresses(ctx context.Context, r *pb.ListAddressesRequest) (*pb.ListAddressesResponse, error) {
+	addresses, err := s.service.ListAddresses(ctx, r.AccountId)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ListAddressesResponse{Addresses: addressMessages(addresses)}, nil
+}
+
+func (s *grpcServer) AddAddress(ctx context.Context, r *pb.AddAddressRequest) (*pb.AddAddressResponse, error) {
+	a, err := s.service.AddAddress(ctx, addressFromMessage(r.Address))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.AddAddressResponse{Address: addressMessage(a)}, nil
+}
+
+func (s *grpcServer) UpdateAddress(ctx context.Context, r *pb.UpdateAddressRequest) (*pb.UpdateAddressResponse, error) {
+	a, err := s.service.UpdateAddress(ctx, addressFromMessage(r.Address))
+	if err != nil {
+		return nil, err
+	}
+	return &pb.UpdateAddressResponse{Address: addressMessage(a)}, nil
+}
+
+func (s *grpcServer) DeleteAddress(ctx context.Context, r *pb.DeleteAddressRequest) (*pb.DeleteAddressResponse, error) {
+	if err := s.service.DeleteAddress(ctx, r.Id); err != nil {
+		return nil, err
+	}
+	return &pb.DeleteAddressResponse{}, nil
+}
+
+func (s *grpcServer) SetDefaultAddress(ctx context.Context, r *pb.SetDefaultAddressRequest) (*pb.SetDefaultAddressResponse, error) {
+	addresses, err := s.service.SetDefaultAddress(ctx, r.Id, r.Usage)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SetDefaultAddressResponse{Addresses: addressMessages(addresses)}, nil
+}
+
+func (s *grpcServer) GetJwks(ctx context.Context, r *pb.GetJwksRequest) (*pb.GetJwksResponse, error) {
+	set, err := s.service.GetJWKS(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := []*pb.Jwk{}
+	for _, k := range set.Keys {
+		keys = append(keys, &pb.Jwk{
+			Kty: k.Kty,
+			Kid: k.Kid,
+			Use: k.Use,
+			Alg: k.Alg,
+			N:   k.N,
+			E:   k.E,
+			Crv: k.Crv,
+			X:   k.X,
+		})
+	}
+	return &pb.GetJwksResponse{Keys: keys}, nil
+}
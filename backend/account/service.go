@@ -2,72 +2,211 @@ package account
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"log"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/JonathanNithi/ecommerce/backend/audit"
+	"github.com/JonathanNithi/ecommerce/backend/authz"
+	"github.com/JonathanNithi/ecommerce/backend/notification"
 	"github.com/segmentio/ksuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// Service is the account business logic. Every method except PostAccount, Login, ForgotPassword,
+// ResetPassword, VerifyEmail, ResendVerificationEmail, ConfirmEmailChange, CompleteMfaLogin, RefreshToken
+// and Logout acts for the caller whose claims the gRPC interceptor placed in ctx.
 type Service interface {
 	PostAccount(ctx context.Context, first_name string, last_name string, email string, password string) (*Account, error)
-	Login(ctx context.Context, email string, password string) (*Account, string, string, error)
-	GetAccount(ctx context.Context, id string, accessToken string, refreshToken string) (*Account, string, string, error)
-	GetAccounts(ctx context.Context, skip uint64, take uint64, accessToken string, refreshToken string) ([]Account, string, string, error)
-	SetAccountAsAdmin(ctx context.Context, accessToken string, refreshToken string, id string) (*Account, string, string, error)
-	ForgotPassword(ctx context.Context, email string, firstName string, lastName string) (*Account, error)
-	ResetPassword(ctx context.Context, id string, email string, password string) (*Account, error)
-	RefreshToken(ctx context.Context, refreshToken string) (string, error)
+	Login(ctx context.Context, email string, password string, clientIP string) (*LoginResult, error)
+	CompleteMfaLogin(ctx context.Context, challengeToken string, code string) (*LoginResult, error)
+	GetAccount(ctx context.Context, id string) (*Account, error)
+	GetAccounts(ctx context.Context, skip uint64, take uint64) ([]Account, error)
+	SearchAccounts(ctx context.Context, f AccountFilter, first int, after string) (*AccountPage, error)
+	SetAccountAsAdmin(ctx context.Context, id string) (*Account, error)
+	DemoteAdmin(ctx context.Context, id string) (*Account, error)
+	CreateRole(ctx context.Context, name string, permissions []string) (*Role, error)
+	GrantRole(ctx context.Context, id string, role string) (*Account, error)
+	RevokeRole(ctx context.Context, id string, role string) (*Account, error)
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token string, password string) error
+	RefreshToken(ctx context.Context, refreshToken string) (string, string, error)
+	Logout(ctx context.Context, refreshToken string) error
+	RevokeAllSessions(ctx context.Context) error
+	UnlockAccount(ctx context.Context, id string) (*Account, error)
+	UpdateProfile(ctx context.Context, firstName string, lastName string) (*Account, error)
+	ChangeEmail(ctx context.Context, newEmail string, password string) error
+	ConfirmEmailChange(ctx context.Context, token string) (*Account, error)
+	ChangePassword(ctx context.Context, currentPassword string, newPassword string) error
+	VerifyEmail(ctx context.Context, token string) (*Account, error)
+	ResendVerificationEmail(ctx context.Context, email string) error
+	EnrollMfa(ctx context.Context) (string, string, error)
+	ConfirmMfa(ctx context.Context, code string) ([]string, error)
+	ListAddresses(ctx context.Context, accountID string) ([]Address, error)
+	AddAddress(ctx context.Context, a Address) (*Address, error)
+	UpdateAddress(ctx context.Context, a Address) (*Address, error)
+	DeleteAddress(ctx context.Context, id string) error
+	SetDefaultAddress(ctx context.Context, id string, usage string) ([]Address, error)
+	SuspendAccount(ctx context.Context, id string, reason string) (*Account, error)
+	ReactivateAccount(ctx context.Context, id string, reason string) (*Account, error)
+	CloseAccount(ctx context.Context, password string) error
+	ExportAccountData(ctx context.Context, id string) ([]byte, error)
+	EraseAccount(ctx context.Context, id string, reason string) error
+	CreateAPIKey(ctx context.Context, accountID string, name string, permissions []string, expiresAt *time.Time) (*APIKey, string, error)
+	ListAPIKeys(ctx context.Context, accountID string) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+	AuthenticateAPIKey(ctx context.Context, key string) (*authz.Claims, error)
+	ImpersonateAccount(ctx context.Context, id string, reason string) (*Impersonation, error)
+	EndImpersonation(ctx context.Context) error
+	GetJWKS(ctx context.Context) (authz.JWKS, error)
 }
 
 type Account struct {
-	ID           string `json:"id"`
-	FirstName    string `json:"first_name"`
-	LastName     string `json:"last_name"`
-	Email        string `json:"email"`
-	PasswordHash string `json:"password_hash"`
-	Role         string `json:"role"`
+	ID            string    `json:"id"`
+	FirstName     string    `json:"first_name"`
+	LastName      string    `json:"last_name"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	MfaEnabled    bool      `json:"mfa_enabled"`
+	Status        string    `json:"status"`        // StatusActive, StatusSuspended or StatusClosed
+	StatusReason  string    `json:"status_reason"` // why the status was last changed
+	CreatedAt     time.Time `json:"created_at"`
+	PasswordHash  string    `json:"-"`    // credential record, only read by Login and never sent to clients
+	Role          string    `json:"role"` // "admin" when the account holds the admin role, otherwise "user"
+	Roles         []string  `json:"roles"`
+	Permissions   []string  `json:"permissions"`
 }
 
+// Role is a named set of permissions that can be granted to accounts.
+type Role struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+// Built-in roles seeded by the first migration
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// RefreshToken is the server-side record of an issued refresh token. Tokens rotated
+// from the same login share a FamilyID so a replayed token can revoke the whole session.
+type RefreshToken struct {
+	ID        string     `json:"id"`
+	AccountID string     `json:"account_id"`
+	FamilyID  string     `json:"family_id"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// PasswordResetToken is a single-use credential for ResetPassword. Only the SHA-256
+// hash of the token is stored; the plain value is handed to the Notifier once.
+type PasswordResetToken struct {
+	ID        string     `json:"id"`
+	AccountID string     `json:"account_id"`
+	TokenHash string     `json:"token_hash"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+const passwordResetTokenTTL = 1 * time.Hour
+
+var ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+// EmailChangeToken is a pending change of an account's email address. The change only takes
+// effect once the token mailed to the new address is confirmed, so only its hash is stored.
+type EmailChangeToken struct {
+	ID        string     `json:"id"`
+	AccountID string     `json:"account_id"`
+	NewEmail  string     `json:"new_email"`
+	TokenHash string     `json:"token_hash"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+const emailChangeTokenTTL = 24 * time.Hour
+
+var (
+	ErrInvalidEmailChangeToken = errors.New("invalid or expired email change token")
+	ErrEmailTaken              = errors.New("email is already in use")
+)
+
+var roleNameRegex = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,63}$`)
+
 type accountService struct {
-	repository Repository
+	repository   Repository
+	notifier     notification.Notifier
+	keys         *KeyRing
+	verification VerificationPolicy
+	mfa          MfaPolicy
+	passwords    PasswordPolicy
+	orders       OrderSource
+	audit        audit.Recorder
+
+	dummyHashOnce sync.Once
+	dummyHash     string
 }
 
-func NewService(r Repository) Service {
-	return &accountService{r}
+func NewService(r Repository, n notification.Notifier, k *KeyRing, v VerificationPolicy, m MfaPolicy, p PasswordPolicy, o OrderSource, a audit.Recorder) Service {
+	return &accountService{
+		repository:   r,
+		notifier:     n,
+		keys:         k,
+		verification: v,
+		mfa:          m,
+		passwords:    p,
+		orders:       o,
+		audit:        a,
+	}
 }
 
 var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
 
-func validateAccountInput(firstName, lastName, email, password string) error {
+func validateAccountInput(firstName, lastName, email string) error {
+	if err := validateName(firstName, lastName); err != nil {
+		return err
+	}
+	return validateEmail(email)
+}
+
+func validateName(firstName, lastName string) error {
 	if strings.TrimSpace(firstName) == "" {
 		return errors.New("first name cannot be empty")
 	}
 	if strings.TrimSpace(lastName) == "" {
 		return errors.New("last name cannot be empty")
 	}
+	return nil
+}
+
+func validateEmail(email string) error {
 	if strings.TrimSpace(email) == "" {
 		return errors.New("email cannot be empty")
 	}
 	if !emailRegex.MatchString(email) {
 		return errors.New("invalid email format")
 	}
-	if len(password) < 8 {
-		return errors.New("password must be at least 8 characters")
-	}
 	return nil
 }
 
 func (s *accountService) PostAccount(ctx context.Context, first_name string, last_name string, email string, password string) (*Account, error) {
 
-	if err := validateAccountInput(first_name, last_name, email, password); err != nil {
+	if err := validateAccountInput(first_name, last_name, email); err != nil {
+		return nil, err
+	}
+	if err := s.passwords.Check(password); err != nil {
 		return nil, err
 	}
 
 	// Hash the password
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	passwordHash, err := s.passwords.Hash(password)
 	if err != nil {
 		return nil, err // Return error if password hashing fails
 	}
@@ -77,8 +216,11 @@ func (s *accountService) PostAccount(ctx context.Context, first_name string, las
 		FirstName:    first_name,
 		LastName:     last_name,
 		Email:        email,
-		PasswordHash: string(passwordHash), // Store the hashed password as a string
-		Role:         "user",
+		PasswordHash: passwordHash,
+		Role:         RoleUser,
+		Roles:        []string{RoleUser},
+		Status:       StatusActive,
+		CreatedAt:    time.Now().UTC(),
 		ID:           ksuid.New().String(),
 	}
 
@@ -87,121 +229,555 @@ func (s *accountService) PostAccount(ctx context.Context, first_name string, las
 		return nil, err
 	}
 
+	// The account starts unverified until the emailed token is presented to VerifyEmail
+	if err := s.sendVerificationEmail(ctx, a); err != nil {
+		return nil, err
+	}
+
 	return a, nil
 }
 
 func (s *accountService) ValidatePassword(storedHash string, password string) bool {
 	// Compare the provided password with the stored hash
-	err := bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(password))
-	return err == nil
+	ok, err := VerifyPassword(storedHash, password)
+	if err != nil {
+		log.Println("Error verifying password: ", err)
+	}
+	return ok
+}
+
+// upgradePasswordHash replaces a stored hash weaker than the policy with one of the password
+// that was just verified, the only moment the plain password is known. Failures keep the old hash.
+func (s *accountService) upgradePasswordHash(ctx context.Context, account *Account, password string) {
+	if !s.passwords.NeedsRehash(account.PasswordHash) {
+		return
+	}
+	passwordHash, err := s.passwords.Hash(password)
+	if err != nil {
+		log.Println("Error rehashing password: ", err)
+		return
+	}
+	if _, err := s.repository.UpdatePasswordHash(ctx, account.Email, passwordHash); err != nil {
+		log.Println("Error storing rehashed password: ", err)
+		return
+	}
+	account.PasswordHash = passwordHash
 }
 
-func (s *accountService) Login(ctx context.Context, email string, password string) (*Account, string, string, error) {
+// dummyPasswordHash is compared against when the email is unknown so that a missing
+// account takes as long to reject as a wrong password hashed with the policy.
+func (s *accountService) dummyPasswordHash() string {
+	s.dummyHashOnce.Do(func() {
+		s.dummyHash, _ = s.passwords.Hash("not-a-real-password")
+	})
+	return s.dummyHash
+}
+
+func (s *accountService) Login(ctx context.Context, email string, password string, clientIP string) (*LoginResult, error) {
+	// Refuse early while the email or the client address is locked out or throttled
+	for _, key := range loginThrottleKeys(email, clientIP) {
+		if err := s.checkLoginAllowed(ctx, key); err != nil {
+			return nil, err
+		}
+	}
+
 	// Fetch account by email
 	account, err := s.repository.GetAccountByEmail(ctx, email)
 	if err != nil {
-		return nil, "", "", err // If account is not found, return an error
+		// Burn the same hashing time as a wrong password so unknown emails are not distinguishable
+		s.ValidatePassword(s.dummyPasswordHash(), password)
+		if err := s.recordLoginFailures(ctx, email, clientIP); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredentials
 	}
 
 	// Validate password
 	if !s.ValidatePassword(account.PasswordHash, password) {
-		return nil, "", "", fmt.Errorf("invalid email or password")
+		if err := s.recordLoginFailures(ctx, email, clientIP); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredentials
 	}
 
-	// Generate access and refresh tokens
-	accessToken, err := GenerateAccessToken(account.Email, account.Role)
-	if err != nil {
-		return nil, "", "", err
+	// Checked only after the password so the errors do not reveal the state of accounts
+	if err := CheckAccountStatus(account); err != nil {
+		return nil, err
+	}
+	if !s.verification.CanLogin(account) {
+		return nil, ErrEmailNotVerified
+	}
+	s.upgradePasswordHash(ctx, account, password)
+
+	// The failures are only reset by CompleteMfaLogin, otherwise knowing the password
+	// would allow unlimited guesses of the second factor
+	if account.MfaEnabled {
+		challenge, err := s.keys.GenerateMfaChallengeToken(account.ID, account.Email)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MfaChallengeToken: challenge}, nil
 	}
 
-	refreshToken, err := GenerateRefreshToken(account.Email, account.Role)
-	if err != nil {
-		return nil, "", "", err
+	if err := s.repository.ResetLoginFailures(ctx, emailThrottleKey(email)); err != nil {
+		return nil, err
 	}
 
-	return account, accessToken, refreshToken, nil
+	return s.startSession(ctx, account)
 }
 
-func (s *accountService) GetAccount(ctx context.Context, id string, accessToken string, refreshToken string) (*Account, string, string, error) {
-	newAccessToken, newRefreshToken, _, err := s.validateAndRegenerateToken(ctx, accessToken, refreshToken)
+// startSession issues the tokens of a completed login. Every login starts a new refresh token family.
+func (s *accountService) startSession(ctx context.Context, account *Account) (*LoginResult, error) {
+	accessToken, err := s.keys.GenerateAccessToken(account.Email, account.Role, s.tokenPermissions(account))
 	if err != nil {
-		return nil, "", "", err
+		return nil, err
 	}
-	account, err := s.repository.GetAccountByID(ctx, id)
-	return account, newAccessToken, newRefreshToken, err
+
+	refreshToken, err := s.issueRefreshToken(ctx, account.ID, ksuid.New().String(), account.Email, account.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{
+		Account:      account,
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	}, nil
 }
 
-func (s *accountService) GetAccounts(ctx context.Context, skip uint64, take uint64, accessToken string, refreshToken string) ([]Account, string, string, error) {
-	newAccessToken, newRefreshToken, claims, err := s.validateAndRegenerateToken(ctx, accessToken, refreshToken)
+// GetAccount returns the caller's own account, or any account to callers allowed to manage accounts.
+func (s *accountService) GetAccount(ctx context.Context, id string) (*Account, error) {
+	claims := authz.ClaimsFromContext(ctx)
+	if claims == nil {
+		return nil, authz.ErrUnauthenticated
+	}
+
+	account, err := s.repository.GetAccountByID(ctx, id)
 	if err != nil {
-		return nil, "", "", err
+		return nil, err
 	}
 
-	if claims.Role != "admin" {
-		return nil, "", "", fmt.Errorf("unauthorized")
+	if account.Email != claims.Username && !claims.Can(authz.AccountsManage) {
+		return nil, authz.ErrUnauthorized
+	}
+	return account, nil
+}
+
+func (s *accountService) GetAccounts(ctx context.Context, skip uint64, take uint64) ([]Account, error) {
+	if err := authz.Require(authz.ClaimsFromContext(ctx), authz.AccountsManage); err != nil {
+		return nil, err
 	}
 
 	if take > 100 || (skip == 0 && take == 0) {
 		take = 100
 	}
 
-	accounts, err := s.repository.ListAccounts(ctx, skip, take)
-	return accounts, newAccessToken, newRefreshToken, err
+	return s.repository.ListAccounts(ctx, skip, take)
+}
+
+func (s *accountService) SetAccountAsAdmin(ctx context.Context, id string) (*Account, error) {
+	return s.GrantRole(ctx, id, RoleAdmin)
+}
+
+func (s *accountService) DemoteAdmin(ctx context.Context, id string) (*Account, error) {
+	return s.RevokeRole(ctx, id, RoleAdmin)
+}
+
+func (s *accountService) CreateRole(ctx context.Context, name string, permissions []string) (*Role, error) {
+	if err := authz.Require(authz.ClaimsFromContext(ctx), authz.RolesManage); err != nil {
+		return nil, err
+	}
+
+	if !roleNameRegex.MatchString(name) {
+		return nil, errors.New("invalid role name")
+	}
+	for _, p := range permissions {
+		if !authz.Has(authz.All, p) {
+			return nil, fmt.Errorf("unknown permission %q", p)
+		}
+	}
+
+	role := &Role{Name: name, Permissions: permissions}
+	if err := s.repository.CreateRole(ctx, *role); err != nil {
+		return nil, err
+	}
+	if err := s.record(ctx, AuditCreateRole, name, nil, role); err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+func (s *accountService) GrantRole(ctx context.Context, id string, role string) (*Account, error) {
+	if err := authz.Require(authz.ClaimsFromContext(ctx), authz.RolesManage); err != nil {
+		return nil, err
+	}
+
+	before, err := s.repository.GetAccountByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("account or role not found")
+	}
+
+	if err := s.repository.GrantRole(ctx, id, role); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("account or role not found")
+		}
+		return nil, err
+	}
+
+	return s.auditedAccount(ctx, AuditGrantRole, before, rolesOf)
 }
 
-func (s *accountService) SetAccountAsAdmin(ctx context.Context, accessToken string, refreshToken string, id string) (*Account, string, string, error) {
-	newAccessToken, newRefreshToken, claims, err := s.validateAndRegenerateToken(ctx, accessToken, refreshToken)
+// auditedAccount reloads an account changed by action and records which part of it changed,
+// as extracted by snapshot from before and after.
+func (s *accountService) auditedAccount(ctx context.Context, action string, before *Account, snapshot func(*Account) interface{}) (*Account, error) {
+	after, err := s.repository.GetAccountByID(ctx, before.ID)
 	if err != nil {
-		return nil, "", "", err
+		return nil, err
+	}
+	if err := s.record(ctx, action, before.ID, snapshot(before), snapshot(after)); err != nil {
+		return nil, err
 	}
+	return after, nil
+}
 
-	if claims.Role != "admin" {
-		return nil, "", "", fmt.Errorf("unauthorized")
+func (s *accountService) RevokeRole(ctx context.Context, id string, role string) (*Account, error) {
+	claims := authz.ClaimsFromContext(ctx)
+	if err := authz.Require(claims, authz.RolesManage); err != nil {
+		return nil, err
 	}
 
-	account, err := s.repository.UpdateAccountRole(ctx, id, "admin")
-	return account, newAccessToken, newRefreshToken, err
+	account, err := s.repository.GetAccountByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("account not found")
+	}
+
+	// Otherwise the last admin could lock everyone out of role management
+	if role == RoleAdmin && account.Email == claims.Username {
+		return nil, errors.New("admins cannot demote themselves")
+	}
+
+	if err := s.repository.RevokeRole(ctx, id, role); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("account does not have role %s", role)
+		}
+		return nil, err
+	}
+
+	return s.auditedAccount(ctx, AuditRevokeRole, account, rolesOf)
 }
 
-func (s *accountService) ForgotPassword(ctx context.Context, email string, firstName string, lastName string) (*Account, error) {
-	account, err := s.repository.GetAccountByEmailAndName(ctx, firstName, lastName, email)
+func (s *accountService) UnlockAccount(ctx context.Context, id string) (*Account, error) {
+	if err := authz.Require(authz.ClaimsFromContext(ctx), authz.AccountsManage); err != nil {
+		return nil, err
+	}
+
+	account, err := s.repository.GetAccountByID(ctx, id)
 	if err != nil {
 		return nil, fmt.Errorf("account not found")
 	}
-	// In a real scenario, you wouldn't directly return the account here.
+
+	if err := s.repository.ResetLoginFailures(ctx, emailThrottleKey(account.Email)); err != nil {
+		return nil, err
+	}
+	if err := s.record(ctx, AuditUnlock, id, nil, nil); err != nil {
+		return nil, err
+	}
 	return account, nil
 }
 
-func (s *accountService) ResetPassword(ctx context.Context, id string, email string, password string) (*Account, error) {
-	account, err := s.repository.GetAccountById(ctx, id)
+func (s *accountService) ForgotPassword(ctx context.Context, email string) error {
+	account, err := s.repository.GetAccountByEmail(ctx, email)
+	if err != nil {
+		// Do not reveal whether the email is registered
+		log.Printf("Password reset requested for unknown email: %v", err)
+		return nil
+	}
+
+	token, err := generateResetToken()
+	if err != nil {
+		return err
+	}
+
+	t := PasswordResetToken{
+		ID:        ksuid.New().String(),
+		AccountID: account.ID,
+		TokenHash: hashResetToken(token),
+		ExpiresAt: time.Now().UTC().Add(passwordResetTokenTTL),
+	}
+	if err := s.repository.PutPasswordResetToken(ctx, t); err != nil {
+		return err
+	}
+
+	err = s.notifier.Notify(ctx, account.Email, notification.TemplatePasswordReset, notification.PasswordResetData{
+		FirstName: account.FirstName,
+		Token:     token,
+	})
+	if err != nil {
+		// Failing only for registered emails would reveal which ones are
+		log.Printf("Failed to send password reset email for account %s: %v", account.ID, err)
+	}
+	return nil
+}
+
+func (s *accountService) ResetPassword(ctx context.Context, token string, password string) error {
+	if err := s.passwords.Check(password); err != nil {
+		return err
+	}
+
+	t, err := s.repository.GetPasswordResetToken(ctx, hashResetToken(token))
+	if err != nil {
+		return ErrInvalidResetToken
+	}
+	if t.UsedAt != nil || t.ExpiresAt.Before(time.Now()) {
+		return ErrInvalidResetToken
+	}
+
+	account, err := s.repository.GetAccountByID(ctx, t.AccountID)
+	if err != nil {
+		return ErrInvalidResetToken
+	}
+
+	passwordHash, err := s.passwords.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	// Consume the token before changing anything so it cannot be replayed concurrently
+	if err := s.repository.MarkPasswordResetTokenUsed(ctx, t.ID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+
+	if _, err := s.repository.UpdatePasswordHash(ctx, account.Email, passwordHash); err != nil {
+		return err
+	}
+
+	// Sessions started with the old password must not survive the reset
+	return s.repository.RevokeRefreshTokensForAccount(ctx, account.ID)
+}
+
+// generateResetToken returns a random URL-safe token for password reset links.
+func generateResetToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// RefreshToken exchanges a refresh token for a new access token and rotates the refresh token.
+// The access token carries the account's current permissions rather than those of the old token.
+func (s *accountService) RefreshToken(ctx context.Context, oldRefreshToken string) (string, string, error) {
+	claims, err := s.keys.ValidateRefreshToken(ctx, oldRefreshToken)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid refresh token: %w", err)
+	}
+
+	account, newRefreshToken, err := s.rotateRefreshToken(ctx, claims)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid refresh token: %w", err)
+	}
+
+	newAccessToken, err := s.keys.GenerateAccessToken(account.Email, account.Role, s.tokenPermissions(account))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate new access token: %w", err)
+	}
+
+	return newAccessToken, newRefreshToken, nil
+}
+
+func (s *accountService) Logout(ctx context.Context, refreshToken string) error {
+	claims, err := s.keys.ValidateRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return fmt.Errorf("invalid refresh token: %w", err)
+	}
+
+	t, err := s.checkRefreshToken(ctx, claims)
+	if err != nil {
+		return fmt.Errorf("invalid refresh token: %w", err)
+	}
+
+	// Logging out ends the session, so every token rotated from the same login goes with it
+	return s.repository.RevokeRefreshTokenFamily(ctx, t.FamilyID)
+}
+
+func (s *accountService) RevokeAllSessions(ctx context.Context) error {
+	account, err := s.ownAccount(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.repository.RevokeRefreshTokensForAccount(ctx, account.ID)
+}
+
+// callerAccount loads the account of the authenticated caller.
+func (s *accountService) callerAccount(ctx context.Context) (*Account, error) {
+	claims := authz.ClaimsFromContext(ctx)
+	if claims == nil {
+		return nil, authz.ErrUnauthenticated
+	}
+
+	account, err := s.repository.GetAccountByEmail(ctx, claims.Username)
 	if err != nil {
 		return nil, fmt.Errorf("account not found")
 	}
+	return account, nil
+}
+
+// ownAccount is callerAccount for actions the customer must take themselves, which are
+// refused to staff impersonating them.
+func (s *accountService) ownAccount(ctx context.Context) (*Account, error) {
+	if err := authz.RejectImpersonation(authz.ClaimsFromContext(ctx)); err != nil {
+		return nil, err
+	}
+	return s.callerAccount(ctx)
+}
+
+// checkCurrentPassword guards changes to credentials. Wrong guesses count towards the same
+// lockout as failed logins so a hijacked session cannot be used to brute force the password.
+func (s *accountService) checkCurrentPassword(ctx context.Context, account *Account, password string) error {
+	key := emailThrottleKey(account.Email)
+	if err := s.checkLoginAllowed(ctx, key); err != nil {
+		return err
+	}
+
+	if !s.ValidatePassword(account.PasswordHash, password) {
+		if err := s.recordLoginFailure(ctx, key, accountLockThreshold); err != nil {
+			return err
+		}
+		return ErrInvalidCredentials
+	}
+	return nil
+}
 
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+func (s *accountService) UpdateProfile(ctx context.Context, firstName string, lastName string) (*Account, error) {
+	account, err := s.callerAccount(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	account.PasswordHash = string(passwordHash)
-	account, err = s.repository.UpdatePasswordHash(ctx, email, string(passwordHash))
+	if err := validateName(firstName, lastName); err != nil {
+		return nil, err
+	}
+
+	return s.repository.UpdateProfile(ctx, account.ID, strings.TrimSpace(firstName), strings.TrimSpace(lastName))
+}
+
+// ChangeEmail mails a confirmation link to newEmail. The account keeps its current email
+// until ConfirmEmailChange is called with the token from that link.
+func (s *accountService) ChangeEmail(ctx context.Context, newEmail string, password string) error {
+	account, err := s.ownAccount(ctx)
+	if err != nil {
+		return err
+	}
+
+	newEmail = strings.TrimSpace(newEmail)
+	if err := validateEmail(newEmail); err != nil {
+		return err
+	}
+	if strings.EqualFold(newEmail, account.Email) {
+		return errors.New("new email must differ from the current one")
+	}
+
+	if err := s.checkCurrentPassword(ctx, account, password); err != nil {
+		return err
+	}
+
+	if _, err := s.repository.GetAccountByEmail(ctx, newEmail); err == nil {
+		return ErrEmailTaken
+	}
+
+	token, err := generateResetToken()
 	if err != nil {
+		return err
+	}
+
+	t := EmailChangeToken{
+		ID:        ksuid.New().String(),
+		AccountID: account.ID,
+		NewEmail:  newEmail,
+		TokenHash: hashResetToken(token),
+		ExpiresAt: time.Now().UTC().Add(emailChangeTokenTTL),
+	}
+	if err := s.repository.PutEmailChangeToken(ctx, t); err != nil {
+		return err
+	}
+
+	return s.notifier.Notify(ctx, newEmail, notification.TemplateEmailChange, notification.EmailChangeData{
+		FirstName: account.FirstName,
+		NewEmail:  newEmail,
+		Token:     token,
+	})
+}
+
+func (s *accountService) ConfirmEmailChange(ctx context.Context, token string) (*Account, error) {
+	t, err := s.repository.GetEmailChangeToken(ctx, hashResetToken(token))
+	if err != nil {
+		return nil, ErrInvalidEmailChangeToken
+	}
+	if t.UsedAt != nil || t.ExpiresAt.Before(time.Now()) {
+		return nil, ErrInvalidEmailChangeToken
+	}
+
+	// The address may have been registered since the change was requested
+	if _, err := s.repository.GetAccountByEmail(ctx, t.NewEmail); err == nil {
+		return nil, ErrEmailTaken
+	}
+
+	if err := s.repository.MarkEmailChangeTokenUsed(ctx, t.ID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrInvalidEmailChangeToken
+		}
 		return nil, err
 	}
 
-	return account, nil
+	if err := s.repository.UpdateEmail(ctx, t.AccountID, t.NewEmail); err != nil {
+		return nil, err
+	}
+
+	// Tokens name the account by email, so sessions issued for the old address are ended
+	if err := s.repository.RevokeRefreshTokensForAccount(ctx, t.AccountID); err != nil {
+		return nil, err
+	}
+
+	return s.repository.GetAccountByID(ctx, t.AccountID)
 }
 
-func (s *accountService) RefreshToken(ctx context.Context, oldRefreshToken string) (string, error) {
-	claims, err := ValidateToken(oldRefreshToken)
+func (s *accountService) ChangePassword(ctx context.Context, currentPassword string, newPassword string) error {
+	account, err := s.ownAccount(ctx)
 	if err != nil {
-		return "", fmt.Errorf("invalid refresh token: %w", err)
+		return err
+	}
+
+	if err := s.passwords.Check(newPassword); err != nil {
+		return err
+	}
+
+	if err := s.checkCurrentPassword(ctx, account, currentPassword); err != nil {
+		return err
 	}
 
-	newAccessToken, err := GenerateAccessToken(claims.Username, claims.Role)
+	passwordHash, err := s.passwords.Hash(newPassword)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate new access token: %w", err)
+		return err
 	}
 
-	return newAccessToken, nil
+	if _, err := s.repository.UpdatePasswordHash(ctx, account.Email, passwordHash); err != nil {
+		return err
+	}
+
+	// Sessions started with the old password must not survive the change
+	return s.repository.RevokeRefreshTokensForAccount(ctx, account.ID)
+}
+
+// GetJWKS returns the public keys other services verify access tokens with.
+func (s *accountService) GetJWKS(ctx context.Context) (authz.JWKS, error) {
+	return s.keys.JWKS()
 }
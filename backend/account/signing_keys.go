@@ -0,0 +1,399 @@
+package account
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JonathanNithi/ecommerce/backend/authz"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/segmentio/ksuid"
+)
+
+// Signing key states. Tokens are signed with the active key only; retiring keys are still
+// published so tokens they signed stay valid until they expire.
+const (
+	KeyStatusActive   = "active"
+	KeyStatusRetiring = "retiring"
+)
+
+// retiringKeyTTL keeps a replaced key around for as long as the longest lived token it signed
+const retiringKeyTTL = refreshTokenTTL
+
+var ErrReadOnlyKeyStore = errors.New("key store is read-only, rotate keys by replacing the key files")
+
+type SigningKey struct {
+	ID         string
+	PrivateKey crypto.Signer
+	Status     string
+	CreatedAt  time.Time
+	RetireAt   *time.Time
+}
+
+func (k SigningKey) signingMethod() (jwt.SigningMethod, error) {
+	switch k.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PrivateKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, fmt.Errorf("unsupported signing key type %T", k.PrivateKey)
+}
+
+// GenerateSigningKey creates a new active key for algorithm, either RS256 or EdDSA.
+func GenerateSigningKey(algorithm string) (SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case authz.AlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case authz.AlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return SigningKey{}, err
+	}
+	return SigningKey{
+		ID:         ksuid.New().String(),
+		PrivateKey: private,
+		Status:     KeyStatusActive,
+		CreatedAt:  time.Now().UTC(),
+	}, nil
+}
+
+func encodePrivateKey(key crypto.Signer) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+func decodePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// KeyStore persists the signing keys shared by all replicas of the account service.
+type KeyStore interface {
+	ListSigningKeys(ctx context.Context) ([]SigningKey, error)
+	// RotateSigningKey makes key the active key and retires the current one until retireAt.
+	// It does nothing and returns false when the active key was created after staleBefore,
+	// which happens when another replica rotated first.
+	RotateSigningKey(ctx context.Context, key SigningKey, staleBefore time.Time, retireAt time.Time) (bool, error)
+	DeleteRetiredSigningKeys(ctx context.Context, before time.Time) error
+}
+
+type postgresKeyStore struct {
+	db *sql.DB
+}
+
+func NewPostgresKeyStore(url string) (KeyStore, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		return nil, err
+	}
+
+	return &postgresKeyStore{db}, nil
+}
+
+func (s *postgresKeyStore) ListSigningKeys(ctx context.Context) ([]SigningKey, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, private_key, status, created_at, retire_at FROM signing_keys ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []SigningKey{}
+	for rows.Next() {
+		k := SigningKey{}
+		var private string
+		if err := rows.Scan(&k.ID, &private, &k.Status, &k.CreatedAt, &k.RetireAt); err != nil {
+			return nil, err
+		}
+		if k.PrivateKey, err = decodePrivateKey([]byte(private)); err != nil {
+			return nil, fmt.Errorf("signing key %s: %w", k.ID, err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (s *postgresKeyStore) RotateSigningKey(ctx context.Context, key SigningKey, staleBefore time.Time, retireAt time.Time) (bool, error) {
+	private, err := encodePrivateKey(key.PrivateKey)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Serialise rotations so replicas starting together create a single key
+	if _, err := tx.ExecContext(ctx, "LOCK TABLE signing_keys IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		return false, err
+	}
+
+	var fresh bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM signing_keys WHERE status = 'active' AND created_at > $1)", staleBefore).Scan(&fresh)
+	if err != nil {
+		return false, err
+	}
+	if fresh {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE signing_keys SET status = 'retiring', retire_at = $1 WHERE status = 'active'", retireAt); err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		"INSERT INTO signing_keys(id, private_key, status, created_at) VALUES($1, $2, 'active', $3)",
+		key.ID, private, key.CreatedAt,
+	)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (s *postgresKeyStore) DeleteRetiredSigningKeys(ctx context.Context, before time.Time) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM signing_keys WHERE status = 'retiring' AND retire_at < $1", before)
+	return err
+}
+
+// fileKeyStore reads PKCS#8 PEM files named <kid>.pem from a directory, typically a mounted
+// secret. The key named by activeID signs new tokens and the others are treated as retiring.
+type fileKeyStore struct {
+	dir      string
+	activeID string
+}
+
+func NewFileKeyStore(dir string, activeID string) KeyStore {
+	return &fileKeyStore{dir, activeID}
+}
+
+func (s *fileKeyStore) ListSigningKeys(ctx context.Context) ([]SigningKey, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []SigningKey{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		private, err := decodePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		k := SigningKey{
+			ID:         strings.TrimSuffix(filepath.Base(path), ".pem"),
+			PrivateKey: private,
+			Status:     KeyStatusRetiring,
+			CreatedAt:  info.ModTime(),
+		}
+		if k.ID == s.activeID {
+			k.Status = KeyStatusActive
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (s *fileKeyStore) RotateSigningKey(ctx context.Context, key SigningKey, staleBefore time.Time, retireAt time.Time) (bool, error) {
+	return false, ErrReadOnlyKeyStore
+}
+
+func (s *fileKeyStore) DeleteRetiredSigningKeys(ctx context.Context, before time.Time) error {
+	return nil
+}
+
+// KeyRing signs tokens with the active key and verifies them against every published key.
+// It implements authz.KeySource for the account service's own interceptor.
+type KeyRing struct {
+	store     KeyStore
+	algorithm string
+
+	mu     sync.RWMutex
+	active *SigningKey
+	keys   map[string]SigningKey
+}
+
+// NewKeyRing loads the keys in store, creating the first key with algorithm if there is none.
+func NewKeyRing(ctx context.Context, store KeyStore, algorithm string) (*KeyRing, error) {
+	k := &KeyRing{store: store, algorithm: algorithm}
+	if err := k.Load(ctx); err != nil {
+		return nil, err
+	}
+	if k.active == nil {
+		if _, err := k.rotate(ctx, time.Time{}); err != nil {
+			return nil, fmt.Errorf("no active signing key: %w", err)
+		}
+	}
+	return k, nil
+}
+
+// Load replaces the keys held in memory with the contents of the store.
+func (k *KeyRing) Load(ctx context.Context) error {
+	list, err := k.store.ListSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+	// Newest first so that the newest active key wins if a rotation raced
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+
+	keys := map[string]SigningKey{}
+	var active *SigningKey
+	for i, key := range list {
+		keys[key.ID] = key
+		if key.Status == KeyStatusActive && active == nil {
+			active = &list[i]
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = keys
+	k.active = active
+	return nil
+}
+
+func (k *KeyRing) rotate(ctx context.Context, staleBefore time.Time) (bool, error) {
+	key, err := GenerateSigningKey(k.algorithm)
+	if err != nil {
+		return false, err
+	}
+	rotated, err := k.store.RotateSigningKey(ctx, key, staleBefore, time.Now().UTC().Add(retiringKeyTTL))
+	if err != nil {
+		return false, err
+	}
+	return rotated, k.Load(ctx)
+}
+
+// Rotate replaces the active key when it is older than maxAge and drops retiring keys that are
+// no longer needed. Users stay logged in since the replaced key keeps verifying their tokens.
+func (k *KeyRing) Rotate(ctx context.Context, maxAge time.Duration) error {
+	rotated, err := k.rotate(ctx, time.Now().UTC().Add(-maxAge))
+	if err != nil {
+		return err
+	}
+	if rotated {
+		log.Println("Rotated JWT signing key")
+	}
+	if err := k.store.DeleteRetiredSigningKeys(ctx, time.Now().UTC()); err != nil {
+		return err
+	}
+	return k.Load(ctx)
+}
+
+// RunRotation calls Rotate every interval until ctx is cancelled. Keys rotated by other
+// replicas are picked up on the same schedule.
+func (k *KeyRing) RunRotation(ctx context.Context, maxAge time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := k.Rotate(ctx, maxAge); err != nil {
+				log.Println("Error rotating JWT signing key: ", err)
+			}
+		}
+	}
+}
+
+// Sign signs claims with the active key and names it in the kid header.
+func (k *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	active := k.active
+	k.mu.RUnlock()
+	if active == nil {
+		return "", errors.New("no active signing key")
+	}
+
+	method, err := active.signingMethod()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = active.ID
+	return token.SignedString(active.PrivateKey)
+}
+
+func (k *KeyRing) PublicKey(_ context.Context, kid string) (crypto.PublicKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", authz.ErrUnknownKey, kid)
+	}
+	return key.PrivateKey.Public(), nil
+}
+
+// JWKS returns the public half of the active and retiring keys.
+func (k *KeyRing) JWKS() (authz.JWKS, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	set := authz.JWKS{Keys: []authz.JWK{}}
+	for id, key := range k.keys {
+		jwk, err := authz.NewJWK(id, key.PrivateKey.Public())
+		if err != nil {
+			return authz.JWKS{}, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].Kid < set.Keys[j].Kid
+	})
+	return set, nil
+}
+
+// Verifier checks tokens against the keys of the ring.
+func (k *KeyRing) Verifier() *authz.Verifier {
+	return authz.NewVerifier(k)
+}
@@ -0,0 +1,120 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/JonathanNithi/ecommerce/backend/authz"
+)
+
+// Account statuses. Suspension is decided by an admin and can be undone, closing is
+// requested by the account holder and is final.
+const (
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+	StatusClosed    = "closed"
+)
+
+var (
+	ErrAccountSuspended     = errors.New("account is suspended")
+	ErrAccountClosed        = errors.New("account is closed")
+	ErrAccountNotSuspended  = errors.New("account is not suspended")
+	ErrStatusReasonRequired = errors.New("a reason is required")
+	ErrCannotSuspendSelf    = errors.New("you cannot suspend your own account")
+)
+
+// CheckAccountStatus returns the error explaining why account may not be used, if any.
+// The order service uses it to refuse orders for suspended and closed accounts.
+func CheckAccountStatus(account *Account) error {
+	switch account.Status {
+	case StatusSuspended:
+		return ErrAccountSuspended
+	case StatusClosed:
+		return ErrAccountClosed
+	}
+	return nil
+}
+
+// ActiveAccountCheck rejects access tokens of accounts suspended or closed after the token was issued.
+// Other services verify tokens offline and rely on their short lifetime and on refreshes being refused.
+func ActiveAccountCheck(r Repository) authz.ClaimsCheck {
+	return func(ctx context.Context, claims *authz.Claims) error {
+		account, err := r.GetAccountByEmail(ctx, claims.Username)
+		if err != nil {
+			return authz.ErrUnauthenticated
+		}
+		return CheckAccountStatus(account)
+	}
+}
+
+// SuspendAccount blocks an account from logging in and ends its sessions.
+func (s *accountService) SuspendAccount(ctx context.Context, id string, reason string) (*Account, error) {
+	claims := authz.ClaimsFromContext(ctx)
+	if err := authz.Require(claims, authz.AccountsManage); err != nil {
+		return nil, err
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrStatusReasonRequired
+	}
+
+	account, err := s.repository.GetAccountByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if account.Email == claims.Username {
+		return nil, ErrCannotSuspendSelf
+	}
+	if account.Status == StatusClosed {
+		return nil, ErrAccountClosed
+	}
+
+	if err := s.repository.UpdateAccountStatus(ctx, id, StatusSuspended, reason); err != nil {
+		return nil, err
+	}
+	if err := s.repository.RevokeRefreshTokensForAccount(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.auditedAccount(ctx, AuditSuspend, account, statusOf)
+}
+
+// ReactivateAccount lifts a suspension. Closed accounts cannot be reactivated.
+func (s *accountService) ReactivateAccount(ctx context.Context, id string, reason string) (*Account, error) {
+	if err := authz.Require(authz.ClaimsFromContext(ctx), authz.AccountsManage); err != nil {
+		return nil, err
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrStatusReasonRequired
+	}
+
+	account, err := s.repository.GetAccountByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if account.Status != StatusSuspended {
+		return nil, ErrAccountNotSuspended
+	}
+
+	if err := s.repository.UpdateAccountStatus(ctx, id, StatusActive, reason); err != nil {
+		return nil, err
+	}
+	return s.auditedAccount(ctx, AuditReactivate, account, statusOf)
+}
+
+// CloseAccount closes the caller's account after checking their password and ends all its sessions.
+func (s *accountService) CloseAccount(ctx context.Context, password string) error {
+	account, err := s.ownAccount(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.checkCurrentPassword(ctx, account, password); err != nil {
+		return err
+	}
+
+	if err := s.repository.UpdateAccountStatus(ctx, account.ID, StatusClosed, "closed by account holder"); err != nil {
+		return err
+	}
+	return s.repository.RevokeRefreshTokensForAccount(ctx, account.ID)
+}
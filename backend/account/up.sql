@@ -1,10 +0,0 @@
-CREATE TYPE user_role AS ENUM ('admin', 'user');
-
-CREATE TABLE IF NOT EXISTS accounts (
-  id CHAR(27) PRIMARY KEY,
-  first_name VARCHAR(255) NOT NULL, 
-  last_name VARCHAR(255) NOT NULL, 
-  email VARCHAR(255) NOT NULL UNIQUE, 
-  password_hash VARCHAR(255) NOT NULL, 
-  role user_role NOT NULL 
-);
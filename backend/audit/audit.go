@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/JonathanNithi/ecommerce/backend/authz"
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+// Event records one privileged action. Events are only ever appended, each service keeps
+// its own next to the data the action changed.
+type Event struct {
+	ID        string          `json:"id"`
+	Actor     string          `json:"actor"`  // username of the caller, the staff member when impersonating
+	Action    string          `json:"action"` // "<service>.<verb>", e.g. "account.grant_role"
+	Target    string          `json:"target"` // id of the account, product or order acted on
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	RequestID string          `json:"request_id"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Filter narrows ListEvents. Zero fields do not filter.
+type Filter struct {
+	Actor  string
+	Target string
+	Action string
+	Since  *time.Time // inclusive
+	Until  *time.Time // exclusive
+	Limit  int
+}
+
+// PageSize is the number of events to return, newest first.
+func (f Filter) PageSize() int {
+	if f.Limit <= 0 {
+		return defaultListLimit
+	}
+	if f.Limit > maxListLimit {
+		return maxListLimit
+	}
+	return f.Limit
+}
+
+// Recorder appends events to the audit log. Services depend on it rather than on
+// Repository since they never read their own log.
+type Recorder interface {
+	Record(ctx context.Context, e Event) error
+}
+
+// NewEvent describes action on target by the caller of ctx. before and after are the parts of
+// the target's state the action changed and are stored as JSON, nil means there was none.
+func NewEvent(ctx context.Context, action string, target string, before interface{}, after interface{}) (Event, error) {
+	e := Event{
+		ID:        ksuid.New().String(),
+		Action:    action,
+		Target:    target,
+		RequestID: authz.RequestIDFromContext(ctx),
+		CreatedAt: time.Now().UTC(),
+	}
+	if claims := authz.ClaimsFromContext(ctx); claims.Impersonating() {
+		// Actions taken while impersonating belong to the staff member, not the customer
+		e.Actor = claims.Actor
+	} else if claims != nil {
+		e.Actor = claims.Username
+	}
+
+	var err error
+	if e.Before, err = snapshot(before); err != nil {
+		return Event{}, err
+	}
+	if e.After, err = snapshot(after); err != nil {
+		return Event{}, err
+	}
+	return e, nil
+}
+
+// Record appends the event built by NewEvent to r.
+func Record(ctx context.Context, r Recorder, action string, target string, before interface{}, after interface{}) error {
+	e, err := NewEvent(ctx, action, target, before, after)
+	if err != nil {
+		return err
+	}
+	return r.Record(ctx, e)
+}
+
+func snapshot(state interface{}) (json.RawMessage, error) {
+	if state == nil {
+		return nil, nil
+	}
+	return json.Marshal(state)
+}
@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/JonathanNithi/ecommerce/backend/audit/pb"
+	"github.com/JonathanNithi/ecommerce/backend/authz"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// MockRepository for testing
+type MockRepository struct {
+	mock.Mock
+}
+
+func (m *MockRepository) Record(ctx context.Context, e Event) error {
+	args := m.Called(ctx, e)
+	return args.Error(0)
+}
+
+func (m *MockRepository) ListEvents(ctx context.Context, f Filter) ([]Event, error) {
+	args := m.Called(ctx, f)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Event), args.Error(1)
+}
+
+func (m *MockRepository) Close() {
+}
+
+func auditorContext() context.Context {
+	ctx := authz.ContextWithClaims(context.Background(), &authz.Claims{Username: "auditor@example.com", Permissions: []string{authz.AuditRead}})
+	return authz.ContextWithRequestID(ctx, "req-1")
+}
+
+func TestNewEvent(t *testing.T) {
+	e, err := NewEvent(auditorContext(), "catalog.update_stock", "productID", map[string]int{"stock": 1}, nil)
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, e.ID)
+	assert.Equal(t, "auditor@example.com", e.Actor)
+	assert.Equal(t, "catalog.update_stock", e.Action)
+	assert.Equal(t, "productID", e.Target)
+	assert.Equal(t, "req-1", e.RequestID)
+	assert.JSONEq(t, `{"stock":1}`, string(e.Before))
+	assert.Nil(t, e.After)
+	assert.WithinDuration(t, time.Now(), e.CreatedAt, time.Second)
+}
+
+func TestNewEvent_Anonymous(t *testing.T) {
+	e, err := NewEvent(context.Background(), "account.unlock", "accountID", nil, nil)
+
+	assert.NoError(t, err)
+	assert.Empty(t, e.Actor)
+	assert.Empty(t, e.RequestID)
+}
+
+func TestNewEvent_Impersonating(t *testing.T) {
+	ctx := authz.ContextWithClaims(context.Background(), &authz.Claims{Username: "customer@example.com", Actor: "support@example.com"})
+
+	e, err := NewEvent(ctx, "account.end_impersonation", "accountID", nil, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "support@example.com", e.Actor)
+}
+
+func TestFilter_PageSize(t *testing.T) {
+	assert.Equal(t, defaultListLimit, Filter{}.PageSize())
+	assert.Equal(t, 10, Filter{Limit: 10}.PageSize())
+	assert.Equal(t, maxListLimit, Filter{Limit: 10000}.PageSize())
+}
+
+func TestGrpcServer_ListAuditEvents(t *testing.T) {
+	mockRepo := new(MockRepository)
+	server := &grpcServer{repository: mockRepo}
+	ctx := auditorContext()
+
+	since := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	createdAt := since.Add(time.Hour)
+	mockRepo.On("ListEvents", ctx, mock.MatchedBy(func(f Filter) bool {
+		return f.Actor == "admin@example.com" && f.Since != nil && f.Since.Equal(since) && f.Until == nil && f.Limit == 5
+	})).Return([]Event{{ID: "eventID", Actor: "admin@example.com", Action: "account.grant_role", After: []byte(`{"roles":["admin"]}`), CreatedAt: createdAt}}, nil).Once()
+
+	res, err := server.ListAuditEvents(ctx, &pb.ListAuditEventsRequest{Actor: "admin@example.com", Since: marshalTime(&since), Limit: 5})
+
+	assert.NoError(t, err)
+	if assert.Len(t, res.Events, 1) {
+		assert.Equal(t, "eventID", res.Events[0].Id)
+		assert.Equal(t, `{"roles":["admin"]}`, string(res.Events[0].After))
+		got, _ := unmarshalTime(res.Events[0].CreatedAt)
+		assert.True(t, got.Equal(createdAt))
+	}
+	mockRepo.AssertExpectations(t)
+}
+
+func TestGrpcServer_ListAuditEvents_RequiresPermission(t *testing.T) {
+	mockRepo := new(MockRepository)
+	server := &grpcServer{repository: mockRepo}
+	ctx := authz.ContextWithClaims(context.Background(), &authz.Claims{Username: "admin@example.com", Permissions: []string{authz.AccountsManage}})
+
+	_, err := server.ListAuditEvents(ctx, &pb.ListAuditEventsRequest{})
+
+	assert.ErrorIs(t, err, authz.ErrUnauthorized)
+	mockRepo.AssertNotCalled(t, "ListEvents", mock.Anything, mock.Anything)
+}
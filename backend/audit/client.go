@@ -0,0 +1,59 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/JonathanNithi/ecommerce/backend/audit/pb"
+	"github.com/JonathanNithi/ecommerce/backend/authz"
+	"google.golang.org/grpc"
+)
+
+type Client struct {
+	conn    *grpc.ClientConn
+	service pb.AuditServiceClient
+}
+
+// NewClient connects to the audit log served by the service at url.
+func NewClient(url string) (*Client, error) {
+	conn, err := grpc.Dial(url, append(authz.DialOptions(), grpc.WithInsecure())...)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn, pb.NewAuditServiceClient(conn)}, nil
+}
+
+func (c *Client) Close() {
+	c.conn.Close()
+}
+
+func (c *Client) ListEvents(ctx context.Context, f Filter) ([]Event, error) {
+	r, err := c.service.ListAuditEvents(ctx, &pb.ListAuditEventsRequest{
+		Actor:  f.Actor,
+		Target: f.Target,
+		Action: f.Action,
+		Since:  marshalTime(f.Since),
+		Until:  marshalTime(f.Until),
+		Limit:  uint32(f.PageSize()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events := []Event{}
+	for _, e := range r.Events {
+		createdAt := time.Time{}
+		createdAt.UnmarshalBinary(e.CreatedAt)
+		events = append(events, Event{
+			ID:        e.Id,
+			Actor:     e.Actor,
+			Action:    e.Action,
+			Target:    e.Target,
+			Before:    e.Before,
+			After:     e.After,
+			RequestID: e.RequestId,
+			CreatedAt: createdAt,
+		})
+	}
+	return events, nil
+}
@@ -0,0 +1,197 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+const auditIndexName = "audit"
+
+type elasticRepository struct {
+	client *elasticsearch.Client
+}
+
+// eventDocument keeps the snapshots as JSON strings since their shape differs per action.
+type eventDocument struct {
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	Before    string    `json:"before,omitempty"`
+	After     string    `json:"after,omitempty"`
+	RequestID string    `json:"request_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// NewElasticRepository keeps the audit log of a service storing its data in Elasticsearch,
+// creating the audit index if needed.
+func NewElasticRepository(url string) (Repository, error) {
+	client, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses:     []string{url},
+		RetryOnStatus: []int{502, 503, 504, 429},
+		RetryBackoff:  func(i int) time.Duration { return time.Duration(i) * 100 * time.Millisecond },
+		MaxRetries:    5,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating Elasticsearch client: %w", err)
+	}
+
+	initCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := esapi.IndicesExistsRequest{Index: []string{auditIndexName}}.Do(initCtx, client)
+	if err != nil {
+		return nil, fmt.Errorf("error checking if index '%s' exists: %w", auditIndexName, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		createRes, err := esapi.IndicesCreateRequest{
+			Index: auditIndexName,
+			Body: strings.NewReader(`{
+				"settings": {
+					"number_of_shards": 1,
+					"number_of_replicas": 0
+				},
+				"mappings": {
+					"properties": {
+						"actor": { "type": "keyword" },
+						"action": { "type": "keyword" },
+						"target": { "type": "keyword" },
+						"before": { "type": "text", "index": false },
+						"after": { "type": "text", "index": false },
+						"request_id": { "type": "keyword" },
+						"created_at": { "type": "date" }
+					}
+				}
+			}`),
+		}.Do(initCtx, client)
+		if err != nil {
+			return nil, fmt.Errorf("error creating index '%s': %w", auditIndexName, err)
+		}
+		defer createRes.Body.Close()
+		if createRes.IsError() {
+			return nil, fmt.Errorf("error response during index '%s' creation: %s", auditIndexName, createRes.String())
+		}
+	} else if res.IsError() {
+		return nil, fmt.Errorf("error checking index '%s' existence: %s", auditIndexName, res.String())
+	}
+
+	return &elasticRepository{client}, nil
+}
+
+func (r *elasticRepository) Close() {
+}
+
+// Record creates the event document. Creating rather than indexing means an existing
+// event can never be overwritten.
+func (r *elasticRepository) Record(ctx context.Context, e Event) error {
+	doc, err := json.Marshal(eventDocument{
+		Actor:     e.Actor,
+		Action:    e.Action,
+		Target:    e.Target,
+		Before:    string(e.Before),
+		After:     string(e.After),
+		RequestID: e.RequestID,
+		CreatedAt: e.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := esapi.CreateRequest{
+		Index:      auditIndexName,
+		DocumentID: e.ID,
+		Body:       strings.NewReader(string(doc)),
+	}.Do(ctx, r.client)
+	if err != nil {
+		return fmt.Errorf("error executing create request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("error recording audit event: %s", res.String())
+	}
+	return nil
+}
+
+// ListEvents returns the events matching f, newest first.
+func (r *elasticRepository) ListEvents(ctx context.Context, f Filter) ([]Event, error) {
+	filters := []interface{}{}
+	for _, term := range [][2]string{{"actor", f.Actor}, {"target", f.Target}, {"action", f.Action}} {
+		if term[1] != "" {
+			filters = append(filters, map[string]interface{}{"term": map[string]interface{}{term[0]: term[1]}})
+		}
+	}
+	createdAt := map[string]interface{}{}
+	if f.Since != nil {
+		createdAt["gte"] = f.Since.UTC().Format(time.RFC3339Nano)
+	}
+	if f.Until != nil {
+		createdAt["lt"] = f.Until.UTC().Format(time.RFC3339Nano)
+	}
+	if len(createdAt) > 0 {
+		filters = append(filters, map[string]interface{}{"range": map[string]interface{}{"created_at": createdAt}})
+	}
+
+	query, err := json.Marshal(map[string]interface{}{
+		"size":  f.PageSize(),
+		"query": map[string]interface{}{"bool": map[string]interface{}{"filter": filters}},
+		"sort":  []interface{}{map[string]interface{}{"created_at": "desc"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := esapi.SearchRequest{
+		Index: []string{auditIndexName},
+		Body:  strings.NewReader(string(query)),
+	}.Do(ctx, r.client)
+	if err != nil {
+		return nil, fmt.Errorf("error executing search request: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error searching audit events: %s", res.String())
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				ID     string        `json:"_id"`
+				Source eventDocument `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error decoding search response: %w", err)
+	}
+
+	events := []Event{}
+	for _, hit := range result.Hits.Hits {
+		events = append(events, Event{
+			ID:        hit.ID,
+			Actor:     hit.Source.Actor,
+			Action:    hit.Source.Action,
+			Target:    hit.Source.Target,
+			Before:    rawJSON(hit.Source.Before),
+			After:     rawJSON(hit.Source.After),
+			RequestID: hit.Source.RequestID,
+			CreatedAt: hit.Source.CreatedAt,
+		})
+	}
+	return events, nil
+}
+
+func rawJSON(s string) json.RawMessage {
+	if s == "" {
+		return nil
+	}
+	return json.RawMessage(s)
+}
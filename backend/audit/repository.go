@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/lib/pq"
+)
+
+// Repository stores the audit log of one service.
+type Repository interface {
+	Recorder
+	Close()
+	ListEvents(ctx context.Context, f Filter) ([]Event, error)
+}
+
+type postgresRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresRepository connects to the database of the owning service. The migrations of
+// the service create the audit_log table, whose trigger keeps it append-only.
+func NewPostgresRepository(url string) (Repository, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		return nil, err
+	}
+
+	return &postgresRepository{db}, nil
+}
+
+func (r *postgresRepository) Close() {
+	r.db.Close()
+}
+
+func (r *postgresRepository) Record(ctx context.Context, e Event) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO audit_log(id, actor, action, target, before, after, request_id, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		e.ID, e.Actor, e.Action, e.Target, nullableJSON(e.Before), nullableJSON(e.After), e.RequestID, e.CreatedAt,
+	)
+	return err
+}
+
+// ListEvents returns the events matching f, newest first.
+func (r *postgresRepository) ListEvents(ctx context.Context, f Filter) ([]Event, error) {
+	conditions := []string{}
+	args := []interface{}{}
+	add := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if f.Actor != "" {
+		add("actor = $%d", f.Actor)
+	}
+	if f.Target != "" {
+		add("target = $%d", f.Target)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.Since != nil {
+		add("created_at >= $%d", *f.Since)
+	}
+	if f.Until != nil {
+		add("created_at < $%d", *f.Until)
+	}
+
+	query := "SELECT id, actor, action, target, before, after, request_id, created_at FROM audit_log"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, f.PageSize())
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		e := Event{}
+		var before, after []byte
+		if err = rows.Scan(&e.ID, &e.Actor, &e.Action, &e.Target, &before, &after, &e.RequestID, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Before, e.After = before, after
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// nullableJSON stores a missing snapshot as NULL rather than an invalid empty document.
+func nullableJSON(b []byte) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
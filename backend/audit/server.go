@@ -0,0 +1,84 @@
+package audit
+
+import (
+	"context"
+	"time"
+
+	"github.com/JonathanNithi/ecommerce/backend/audit/pb"
+	"github.com/JonathanNithi/ecommerce/backend/authz"
+	"google.golang.org/grpc"
+)
+
+// PolicyRule is the access rule services add to their policy for ListAuditEvents.
+var PolicyRule = authz.RequirePermissions(authz.AuditRead)
+
+type grpcServer struct {
+	pb.UnimplementedAuditServiceServer
+	repository Repository
+}
+
+// RegisterServer serves the audit log kept in r from serv. The owning service must add
+// pb.AuditService_ListAuditEvents_FullMethodName to its policy with PolicyRule.
+func RegisterServer(serv *grpc.Server, r Repository) {
+	pb.RegisterAuditServiceServer(serv, &grpcServer{repository: r})
+}
+
+func (s *grpcServer) ListAuditEvents(ctx context.Context, r *pb.ListAuditEventsRequest) (*pb.ListAuditEventsResponse, error) {
+	if err := authz.Require(authz.ClaimsFromContext(ctx), authz.AuditRead); err != nil {
+		return nil, err
+	}
+
+	f := Filter{
+		Actor:  r.Actor,
+		Target: r.Target,
+		Action: r.Action,
+		Limit:  int(r.Limit),
+	}
+	var err error
+	if f.Since, err = unmarshalTime(r.Since); err != nil {
+		return nil, err
+	}
+	if f.Until, err = unmarshalTime(r.Until); err != nil {
+		return nil, err
+	}
+
+	events, err := s.repository.ListEvents(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &pb.ListAuditEventsResponse{Events: []*pb.AuditEvent{}}
+	for _, e := range events {
+		createdAt, _ := e.CreatedAt.MarshalBinary()
+		res.Events = append(res.Events, &pb.AuditEvent{
+			Id:        e.ID,
+			Actor:     e.Actor,
+			Action:    e.Action,
+			Target:    e.Target,
+			Before:    e.Before,
+			After:     e.After,
+			RequestId: e.RequestID,
+			CreatedAt: createdAt,
+		})
+	}
+	return res, nil
+}
+
+func marshalTime(t *time.Time) []byte {
+	if t == nil {
+		return nil
+	}
+	b, _ := t.MarshalBinary()
+	return b
+}
+
+func unmarshalTime(b []byte) (*time.Time, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	t := time.Time{}
+	if err := t.UnmarshalBinary(b); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
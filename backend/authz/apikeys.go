@@ -0,0 +1,49 @@
+package authz
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+type cachedAPIKey struct {
+	claims    *Claims
+	expiresAt time.Time
+}
+
+// CacheAPIKeys remembers the claims resolved for a key for ttl, so services that resolve keys
+// remotely do not call the account service on every request. A revoked key keeps working for
+// at most ttl. Failures are not cached.
+func CacheAPIKeys(resolve APIKeyResolver, ttl time.Duration) APIKeyResolver {
+	var mu sync.Mutex
+	cache := map[[sha256.Size]byte]cachedAPIKey{}
+
+	return func(ctx context.Context, key string) (*Claims, error) {
+		// Keys are only held hashed, like the account service stores them
+		id := sha256.Sum256([]byte(key))
+		now := time.Now()
+
+		mu.Lock()
+		entry, ok := cache[id]
+		mu.Unlock()
+		if ok && now.Before(entry.expiresAt) {
+			return entry.claims, nil
+		}
+
+		claims, err := resolve(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+
+		mu.Lock()
+		for k, e := range cache {
+			if !now.Before(e.expiresAt) {
+				delete(cache, k)
+			}
+		}
+		cache[id] = cachedAPIKey{claims: claims, expiresAt: now.Add(ttl)}
+		mu.Unlock()
+		return claims, nil
+	}
+}
@@ -0,0 +1,198 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Permissions granted to roles. Roles are data in the account service; code only ever
+// checks for permissions so new roles never require a deploy.
+const (
+	CatalogWrite    = "catalog:write"
+	InventoryAdjust = "inventory:adjust"
+	OrdersReadAll   = "orders:read_all"
+	AccountsManage  = "accounts:manage"
+	RolesManage     = "roles:manage"
+	AuditRead       = "audit:read"
+	// Lets support staff act as a customer to see what they see
+	AccountsImpersonate = "accounts:impersonate"
+	// Removes products for good rather than hiding them, orders lose the details of removed products
+	CatalogDelete = "catalog:delete"
+)
+
+// All lists every permission known to the services, in the order they are documented.
+var All = []string{CatalogWrite, InventoryAdjust, OrdersReadAll, AccountsManage, RolesManage, AuditRead, AccountsImpersonate, CatalogDelete}
+
+var (
+	ErrUnauthenticated = errors.New("unauthenticated")
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrImpersonating   = errors.New("not allowed while impersonating an account")
+)
+
+// Claims are carried by access and refresh tokens issued by the account service.
+type Claims struct {
+	Username    string   `json:"username"`
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions,omitempty"`
+	// Set when the caller authenticated with an API key rather than a token
+	APIKeyID string `json:"api_key_id,omitempty"`
+	// Username of the staff member acting as Username in an impersonation token
+	Actor string `json:"act,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Impersonating reports whether the claims were issued to someone acting as another account.
+func (c *Claims) Impersonating() bool {
+	return c != nil && c.Actor != ""
+}
+
+// RejectImpersonation returns ErrImpersonating for impersonation claims. Actions a customer
+// must take themselves, such as changing credentials or paying, call it.
+func RejectImpersonation(claims *Claims) error {
+	if claims.Impersonating() {
+		return fmt.Errorf("%w as %s", ErrImpersonating, claims.Username)
+	}
+	return nil
+}
+
+// Can reports whether the claims grant permission.
+func (c *Claims) Can(permission string) bool {
+	if c == nil {
+		return false
+	}
+	return Has(c.Permissions, permission)
+}
+
+// Has reports whether permission is among granted.
+func Has(granted []string, permission string) bool {
+	for _, p := range granted {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Require returns nil only if claims grant every one of permissions.
+func Require(claims *Claims, permissions ...string) error {
+	if claims == nil {
+		return ErrUnauthenticated
+	}
+	for _, p := range permissions {
+		if !claims.Can(p) {
+			return fmt.Errorf("%w: missing permission %s", ErrUnauthorized, p)
+		}
+	}
+	return nil
+}
+
+// Verifier checks the signature and expiry of tokens issued by the account service.
+// Tokens must be signed with RS256 or EdDSA and name their key in the kid header.
+type Verifier struct {
+	keys    KeySource
+	check   ClaimsCheck
+	apiKeys APIKeyResolver
+}
+
+func NewVerifier(keys KeySource) *Verifier {
+	return &Verifier{keys: keys}
+}
+
+// ClaimsCheck rejects an otherwise valid access token based on state the token does not carry,
+// such as the account having been suspended since it was issued.
+type ClaimsCheck func(ctx context.Context, claims *Claims) error
+
+// WithClaimsCheck returns a copy of v whose Verify also runs check.
+func (v *Verifier) WithClaimsCheck(check ClaimsCheck) *Verifier {
+	return &Verifier{keys: v.keys, check: check, apiKeys: v.apiKeys}
+}
+
+// APIKeyResolver returns the claims granted to an API key, with APIKeyID set. Only the account
+// service can resolve keys, other services ask it through its client.
+type APIKeyResolver func(ctx context.Context, key string) (*Claims, error)
+
+var ErrAPIKeysNotAccepted = errors.New("api keys are not accepted")
+
+// WithAPIKeys returns a copy of v that also accepts API keys, resolved by resolve.
+func (v *Verifier) WithAPIKeys(resolve APIKeyResolver) *Verifier {
+	return &Verifier{keys: v.keys, check: v.check, apiKeys: resolve}
+}
+
+// VerifyAPIKey returns the claims granted to key.
+func (v *Verifier) VerifyAPIKey(ctx context.Context, key string) (*Claims, error) {
+	if v.apiKeys == nil {
+		return nil, ErrAPIKeysNotAccepted
+	}
+	claims, err := v.apiKeys(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if claims.APIKeyID == "" {
+		return nil, errors.New("api key resolver returned claims without a key id")
+	}
+	return claims, nil
+}
+
+// Tokens issued for a specific audience, such as MFA challenges and refresh tokens, are not
+// access tokens and are only accepted by VerifyAudience.
+const (
+	MfaChallengeAudience = "mfa-challenge"
+	RefreshTokenAudience = "refresh"
+)
+
+// Verify parses tokenString and returns its claims if it is a valid and unexpired access token.
+func (v *Verifier) Verify(ctx context.Context, tokenString string) (*Claims, error) {
+	claims, err := v.parse(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if len(claims.Audience) > 0 {
+		return nil, fmt.Errorf("%w: token is not an access token", jwt.ErrTokenInvalidAudience)
+	}
+	if v.check != nil {
+		if err := v.check(ctx, claims); err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+// VerifyAudience is Verify for tokens that were issued for audience only.
+func (v *Verifier) VerifyAudience(ctx context.Context, tokenString string, audience string) (*Claims, error) {
+	claims, err := v.parse(ctx, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != audience {
+		return nil, jwt.ErrTokenInvalidAudience
+	}
+	return claims, nil
+}
+
+func (v *Verifier) parse(ctx context.Context, tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no kid header")
+		}
+		return v.keys.PublicKey(ctx, kid)
+	}, jwt.WithValidMethods([]string{AlgorithmRS256, AlgorithmEdDSA}))
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, jwt.ErrSignatureInvalid
+	}
+
+	if claims.ExpiresAt == nil || claims.ExpiresAt.Time.Before(time.Now()) {
+		return nil, jwt.ErrTokenExpired
+	}
+
+	return claims, nil
+}
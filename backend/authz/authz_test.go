@@ -0,0 +1,494 @@
+package authz
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var testPublicKey, testPrivateKey, _ = ed25519.GenerateKey(rand.Reader)
+
+var testKeys = JWKS{Keys: []JWK{mustJWK("test-key", testPublicKey)}}
+
+func mustJWK(kid string, key crypto.PublicKey) JWK {
+	jwk, err := NewJWK(kid, key)
+	if err != nil {
+		panic(err)
+	}
+	return jwk
+}
+
+func signToken(t *testing.T, claims *Claims, method jwt.SigningMethod, kid string, key interface{}) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestRequire(t *testing.T) {
+	claims := &Claims{Username: "merchandiser", Permissions: []string{CatalogWrite, InventoryAdjust}}
+
+	assert.NoError(t, Require(claims, CatalogWrite))
+	assert.NoError(t, Require(claims, CatalogWrite, InventoryAdjust))
+	assert.ErrorIs(t, Require(claims, CatalogWrite, RolesManage), ErrUnauthorized)
+	assert.ErrorIs(t, Require(nil, CatalogWrite), ErrUnauthenticated)
+}
+
+func TestVerifier_Verify_Success(t *testing.T) {
+	token := signToken(t, &Claims{
+		Username:    "admin@example.com",
+		Permissions: []string{AccountsManage},
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}, jwt.SigningMethodEdDSA, "test-key", testPrivateKey)
+
+	claims, err := NewVerifier(testKeys).Verify(context.Background(), token)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "admin@example.com", claims.Username)
+	assert.True(t, claims.Can(AccountsManage))
+	assert.False(t, claims.Can(OrdersReadAll))
+}
+
+func TestVerifier_Verify_RS256(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := JWKS{Keys: []JWK{mustJWK("rsa-key", &rsaKey.PublicKey)}}
+	token := signToken(t, &Claims{
+		Username: "user@example.com",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}, jwt.SigningMethodRS256, "rsa-key", rsaKey)
+
+	claims, err := NewVerifier(keys).Verify(context.Background(), token)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "user@example.com", claims.Username)
+}
+
+func TestVerifier_Verify_Expired(t *testing.T) {
+	token := signToken(t, &Claims{
+		Username: "user@example.com",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		},
+	}, jwt.SigningMethodEdDSA, "test-key", testPrivateKey)
+
+	_, err := NewVerifier(testKeys).Verify(context.Background(), token)
+
+	assert.ErrorIs(t, err, jwt.ErrTokenExpired)
+}
+
+func TestVerifier_Verify_WrongKey(t *testing.T) {
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	token := signToken(t, &Claims{
+		Username: "user@example.com",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}, jwt.SigningMethodEdDSA, "test-key", otherKey)
+
+	_, err := NewVerifier(testKeys).Verify(context.Background(), token)
+
+	assert.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+}
+
+func TestVerifier_Verify_UnknownKid(t *testing.T) {
+	token := signToken(t, &Claims{
+		Username: "user@example.com",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}, jwt.SigningMethodEdDSA, "retired-key", testPrivateKey)
+
+	_, err := NewVerifier(testKeys).Verify(context.Background(), token)
+
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestVerifier_Verify_RejectsHMAC(t *testing.T) {
+	token := signToken(t, &Claims{
+		Username: "user@example.com",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}, jwt.SigningMethodHS256, "test-key", []byte("shared-secret"))
+
+	_, err := NewVerifier(testKeys).Verify(context.Background(), token)
+
+	assert.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+}
+
+func TestVerifier_Verify_MissingExpiry(t *testing.T) {
+	token := signToken(t, &Claims{Username: "user@example.com"}, jwt.SigningMethodEdDSA, "test-key", testPrivateKey)
+
+	_, err := NewVerifier(testKeys).Verify(context.Background(), token)
+
+	assert.ErrorIs(t, err, jwt.ErrTokenExpired)
+}
+
+func TestVerifier_Verify_RejectsAudienceTokens(t *testing.T) {
+	token := signToken(t, &Claims{
+		Username: "admin@example.com",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{MfaChallengeAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}, jwt.SigningMethodEdDSA, "test-key", testPrivateKey)
+	v := NewVerifier(testKeys)
+
+	_, err := v.Verify(context.Background(), token)
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+
+	claims, err := v.VerifyAudience(context.Background(), token, MfaChallengeAudience)
+	assert.NoError(t, err)
+	assert.Equal(t, "admin@example.com", claims.Username)
+}
+
+func TestVerifier_WithClaimsCheck(t *testing.T) {
+	token := signToken(t, &Claims{
+		Username: "suspended@example.com",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}, jwt.SigningMethodEdDSA, "test-key", testPrivateKey)
+	errSuspended := errors.New("account is suspended")
+	v := NewVerifier(testKeys)
+	checked := v.WithClaimsCheck(func(ctx context.Context, claims *Claims) error {
+		if claims.Username == "suspended@example.com" {
+			return errSuspended
+		}
+		return nil
+	})
+
+	_, err := checked.Verify(context.Background(), token)
+	assert.ErrorIs(t, err, errSuspended)
+
+	// The original verifier is left unchanged
+	_, err = v.Verify(context.Background(), token)
+	assert.NoError(t, err)
+}
+
+func TestVerifier_VerifyAudience_RejectsAccessTokens(t *testing.T) {
+	token := signToken(t, &Claims{
+		Username: "admin@example.com",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}, jwt.SigningMethodEdDSA, "test-key", testPrivateKey)
+
+	_, err := NewVerifier(testKeys).VerifyAudience(context.Background(), token, MfaChallengeAudience)
+
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+}
+
+func TestJWK_RoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []crypto.PublicKey{testPublicKey, &rsaKey.PublicKey} {
+		jwk, err := NewJWK("kid", key)
+		assert.NoError(t, err)
+
+		decoded, err := jwk.PublicKey()
+		assert.NoError(t, err)
+		assert.Equal(t, key, decoded)
+	}
+}
+
+func TestRemoteKeys_RefetchesUnknownKid(t *testing.T) {
+	published := JWKS{Keys: []JWK{}}
+	fetches := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(published)
+	}))
+	defer srv.Close()
+	keys := NewRemoteKeys(srv.URL)
+
+	_, err := keys.PublicKey(context.Background(), "test-key")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// The key is published but lookups are not allowed to refetch yet
+	published = testKeys
+	_, err = keys.PublicKey(context.Background(), "test-key")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, 1, fetches)
+
+	keys.fetchedAt = time.Now().Add(-minRefreshInterval)
+	key, err := keys.PublicKey(context.Background(), "test-key")
+	assert.NoError(t, err)
+	assert.Equal(t, testPublicKey, key)
+	assert.Equal(t, 2, fetches)
+}
+
+var testPolicy = Policy{
+	"/pb.TestService/Public": Public(),
+	"/pb.TestService/Admin":  RequirePermissions(AccountsManage),
+	"/pb.TestService/Pay":    Authenticated().WithoutImpersonation(),
+}
+
+type legacyRequest struct {
+	AccessToken string
+}
+
+func (r *legacyRequest) GetAccessToken() string {
+	return r.AccessToken
+}
+
+func validToken(t *testing.T, permissions ...string) string {
+	return signToken(t, &Claims{
+		Username:    "user@example.com",
+		Permissions: permissions,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}, jwt.SigningMethodEdDSA, "test-key", testPrivateKey)
+}
+
+// callUnary runs the server interceptor for method and returns the context seen by the handler
+func callUnary(ctx context.Context, method string, req interface{}) (context.Context, error) {
+	var handlerCtx context.Context
+	interceptor := UnaryServerInterceptor(NewVerifier(testKeys), testPolicy)
+	_, err := interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		handlerCtx = ctx
+		return nil, nil
+	})
+	return handlerCtx, err
+}
+
+func incomingBearer(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(AuthorizationMetadataKey, "Bearer "+token))
+}
+
+func TestUnaryServerInterceptor_PublicAnonymous(t *testing.T) {
+	ctx, err := callUnary(context.Background(), "/pb.TestService/Public", nil)
+
+	assert.NoError(t, err)
+	assert.Nil(t, ClaimsFromContext(ctx))
+}
+
+func TestUnaryServerInterceptor_MissingToken(t *testing.T) {
+	_, err := callUnary(context.Background(), "/pb.TestService/Unlisted", nil)
+
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestUnaryServerInterceptor_InvalidToken(t *testing.T) {
+	_, err := callUnary(incomingBearer("not.a.token"), "/pb.TestService/Unlisted", nil)
+
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestUnaryServerInterceptor_Authenticated(t *testing.T) {
+	token := validToken(t)
+
+	ctx, err := callUnary(incomingBearer(token), "/pb.TestService/Unlisted", nil)
+
+	assert.NoError(t, err)
+	if assert.NotNil(t, ClaimsFromContext(ctx)) {
+		assert.Equal(t, "user@example.com", ClaimsFromContext(ctx).Username)
+	}
+	assert.Equal(t, token, TokenFromContext(ctx))
+}
+
+func TestUnaryServerInterceptor_PermissionDenied(t *testing.T) {
+	_, err := callUnary(incomingBearer(validToken(t, CatalogWrite)), "/pb.TestService/Admin", nil)
+
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestUnaryServerInterceptor_PermissionGranted(t *testing.T) {
+	_, err := callUnary(incomingBearer(validToken(t, AccountsManage)), "/pb.TestService/Admin", nil)
+
+	assert.NoError(t, err)
+}
+
+func TestUnaryServerInterceptor_DeprecatedRequestField(t *testing.T) {
+	req := &legacyRequest{AccessToken: validToken(t, AccountsManage)}
+
+	ctx, err := callUnary(context.Background(), "/pb.TestService/Admin", req)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, ClaimsFromContext(ctx))
+}
+
+func TestUnaryServerInterceptor_MapsHandlerErrors(t *testing.T) {
+	interceptor := UnaryServerInterceptor(NewVerifier(testKeys), testPolicy)
+	info := &grpc.UnaryServerInfo{FullMethod: "/pb.TestService/Public"}
+
+	_, err := interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, fmt.Errorf("%w: not your account", ErrUnauthorized)
+	})
+
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestOutgoingContext_ForwardsToken(t *testing.T) {
+	ctx := outgoingContext(ContextWithToken(context.Background(), "abc"))
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	assert.Equal(t, []string{"Bearer abc"}, md.Get(AuthorizationMetadataKey))
+}
+
+func TestOutgoingContext_KeepsExplicitHeader(t *testing.T) {
+	ctx := metadata.AppendToOutgoingContext(context.Background(), AuthorizationMetadataKey, "Bearer explicit")
+
+	ctx = outgoingContext(ContextWithToken(ctx, "abc"))
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	assert.Equal(t, []string{"Bearer explicit"}, md.Get(AuthorizationMetadataKey))
+}
+
+func TestUnaryServerInterceptor_KeepsRequestID(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(RequestIDMetadataKey, "req-1"))
+
+	ctx, err := callUnary(ctx, "/pb.TestService/Public", nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "req-1", RequestIDFromContext(ctx))
+}
+
+func TestOutgoingContext_ForwardsRequestID(t *testing.T) {
+	ctx := outgoingContext(ContextWithRequestID(context.Background(), "req-1"))
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	assert.Equal(t, []string{"req-1"}, md.Get(RequestIDMetadataKey))
+	assert.Empty(t, md.Get(AuthorizationMetadataKey))
+}
+
+func testAPIKeys(ctx context.Context, key string) (*Claims, error) {
+	if key != "ek_valid" {
+		return nil, errors.New("unknown api key")
+	}
+	return &Claims{Username: "erp@example.com", Permissions: []string{InventoryAdjust}, APIKeyID: "keyID"}, nil
+}
+
+func incomingAPIKeyContext(key string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(APIKeyMetadataKey, key))
+}
+
+func TestUnaryServerInterceptor_APIKey(t *testing.T) {
+	var handlerCtx context.Context
+	interceptor := UnaryServerInterceptor(NewVerifier(testKeys).WithAPIKeys(testAPIKeys), Policy{
+		"/pb.TestService/Adjust": RequirePermissions(InventoryAdjust),
+		"/pb.TestService/Admin":  RequirePermissions(AccountsManage),
+	})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		handlerCtx = ctx
+		return nil, nil
+	}
+
+	_, err := interceptor(incomingAPIKeyContext("ek_valid"), nil, &grpc.UnaryServerInfo{FullMethod: "/pb.TestService/Adjust"}, handler)
+	assert.NoError(t, err)
+	if assert.NotNil(t, ClaimsFromContext(handlerCtx)) {
+		assert.Equal(t, "keyID", ClaimsFromContext(handlerCtx).APIKeyID)
+	}
+	assert.Equal(t, "ek_valid", APIKeyFromContext(handlerCtx))
+
+	// Keys only grant their scopes
+	_, err = interceptor(incomingAPIKeyContext("ek_valid"), nil, &grpc.UnaryServerInfo{FullMethod: "/pb.TestService/Admin"}, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = interceptor(incomingAPIKeyContext("ek_revoked"), nil, &grpc.UnaryServerInfo{FullMethod: "/pb.TestService/Adjust"}, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestUnaryServerInterceptor_APIKeysNotAccepted(t *testing.T) {
+	_, err := callUnary(incomingAPIKeyContext("ek_valid"), "/pb.TestService/Unlisted", nil)
+
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestUnaryServerInterceptor_DenyImpersonation(t *testing.T) {
+	impersonation := signToken(t, &Claims{
+		Username: "user@example.com",
+		Actor:    "support@example.com",
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}, jwt.SigningMethodEdDSA, "test-key", testPrivateKey)
+
+	_, err := callUnary(incomingBearer(impersonation), "/pb.TestService/Pay", nil)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Other authenticated methods see the customer with the actor attached
+	ctx, err := callUnary(incomingBearer(impersonation), "/pb.TestService/Unlisted", nil)
+	assert.NoError(t, err)
+	claims := ClaimsFromContext(ctx)
+	assert.True(t, claims.Impersonating())
+	assert.Equal(t, "support@example.com", claims.Actor)
+
+	_, err = callUnary(incomingBearer(validToken(t)), "/pb.TestService/Pay", nil)
+	assert.NoError(t, err)
+}
+
+func TestVerifier_WithAPIKeys_KeepsClaimsCheck(t *testing.T) {
+	rejected := errors.New("rejected")
+	v := NewVerifier(testKeys).WithClaimsCheck(func(ctx context.Context, claims *Claims) error { return rejected }).WithAPIKeys(testAPIKeys)
+
+	_, err := v.Verify(context.Background(), validToken(t))
+	assert.ErrorIs(t, err, rejected)
+}
+
+func TestCacheAPIKeys(t *testing.T) {
+	calls := 0
+	resolve := CacheAPIKeys(func(ctx context.Context, key string) (*Claims, error) {
+		calls++
+		return testAPIKeys(ctx, key)
+	}, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		claims, err := resolve(context.Background(), "ek_valid")
+		assert.NoError(t, err)
+		assert.Equal(t, "keyID", claims.APIKeyID)
+	}
+	assert.Equal(t, 1, calls)
+
+	// Failures are retried rather than remembered
+	_, err := resolve(context.Background(), "ek_revoked")
+	assert.Error(t, err)
+	_, err = resolve(context.Background(), "ek_revoked")
+	assert.Error(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestOutgoingContext_ForwardsAPIKey(t *testing.T) {
+	ctx := outgoingContext(ContextWithAPIKey(context.Background(), "ek_valid"))
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	assert.Equal(t, []string{"ek_valid"}, md.Get(APIKeyMetadataKey))
+
+	// A token wins over a key
+	ctx = outgoingContext(ContextWithToken(ContextWithAPIKey(context.Background(), "ek_valid"), "abc"))
+
+	md, _ = metadata.FromOutgoingContext(ctx)
+	assert.Empty(t, md.Get(APIKeyMetadataKey))
+	assert.Equal(t, []string{"Bearer abc"}, md.Get(AuthorizationMetadataKey))
+}
@@ -0,0 +1,312 @@
+package authz
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AuthorizationMetadataKey carries "Bearer <access token>" on every authenticated call
+const AuthorizationMetadataKey = "authorization"
+
+// APIKeyMetadataKey carries the API key of callers that authenticate with one instead of a token
+const APIKeyMetadataKey = "x-api-key"
+
+// RequestIDMetadataKey carries the id the gateway gave to the request a call is made for,
+// so the audit records of every service it reaches can be correlated
+const RequestIDMetadataKey = "x-request-id"
+
+type contextKey int
+
+const (
+	claimsContextKey contextKey = iota
+	tokenContextKey
+	requestIDContextKey
+	apiKeyContextKey
+)
+
+// ContextWithClaims returns a copy of ctx carrying the caller's verified claims.
+func ContextWithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsContextKey, claims)
+}
+
+// ClaimsFromContext returns the claims placed by the server interceptor, or nil for anonymous calls.
+func ClaimsFromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(claimsContextKey).(*Claims)
+	return claims
+}
+
+// ContextWithToken returns a copy of ctx whose outgoing gRPC calls are made with accessToken.
+func ContextWithToken(ctx context.Context, accessToken string) context.Context {
+	return context.WithValue(ctx, tokenContextKey, accessToken)
+}
+
+// TokenFromContext returns the access token that outgoing calls made with ctx will forward.
+func TokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(tokenContextKey).(string)
+	return token
+}
+
+// ContextWithAPIKey returns a copy of ctx whose outgoing gRPC calls are made with apiKey.
+// A token attached with ContextWithToken takes precedence.
+func ContextWithAPIKey(ctx context.Context, apiKey string) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey, apiKey)
+}
+
+// APIKeyFromContext returns the API key that outgoing calls made with ctx will forward.
+func APIKeyFromContext(ctx context.Context) string {
+	apiKey, _ := ctx.Value(apiKeyContextKey).(string)
+	return apiKey
+}
+
+// ContextWithRequestID returns a copy of ctx whose outgoing gRPC calls carry requestID.
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, requestID)
+}
+
+// RequestIDFromContext returns the id of the request being served, or "" if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey).(string)
+	return requestID
+}
+
+// incomingRequestID keeps the request id sent by the caller so it is forwarded on further calls.
+func incomingRequestID(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	if ids := md.Get(RequestIDMetadataKey); len(ids) > 0 && ids[0] != "" {
+		return ContextWithRequestID(ctx, ids[0])
+	}
+	return ctx
+}
+
+// Rule is the access policy of a single RPC. The zero Rule requires an authenticated caller.
+type Rule struct {
+	Public      bool
+	Permissions []string
+	// Rejects impersonation tokens, see RejectImpersonation
+	DenyImpersonation bool
+}
+
+// Public lets anonymous callers through. Claims are still attached when a valid token is sent.
+func Public() Rule {
+	return Rule{Public: true}
+}
+
+// Authenticated requires a valid access token and nothing more.
+func Authenticated() Rule {
+	return Rule{}
+}
+
+// RequirePermissions requires a valid access token granting every one of permissions.
+func RequirePermissions(permissions ...string) Rule {
+	return Rule{Permissions: permissions}
+}
+
+// WithoutImpersonation returns a copy of r that also rejects callers impersonating an account.
+func (r Rule) WithoutImpersonation() Rule {
+	r.DenyImpersonation = true
+	return r
+}
+
+// Policy maps full method names, e.g. "/pb.AccountService/Login", to their rule.
+// Methods missing from the policy require authentication so new RPCs fail closed.
+type Policy map[string]Rule
+
+func (p Policy) rule(fullMethod string) Rule {
+	// Reflection only describes the API and is used by tooling such as grpcurl
+	if strings.HasPrefix(fullMethod, "/grpc.reflection.") {
+		return Public()
+	}
+	return p[fullMethod]
+}
+
+// accessTokenGetter is implemented by request messages that still have the deprecated accessToken field
+type accessTokenGetter interface {
+	GetAccessToken() string
+}
+
+func bearerToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, v := range md.Get(AuthorizationMetadataKey) {
+		if len(v) > 7 && strings.EqualFold(v[:7], "bearer ") {
+			return strings.TrimSpace(v[7:])
+		}
+	}
+	return ""
+}
+
+func incomingAPIKey(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if keys := md.Get(APIKeyMetadataKey); len(keys) > 0 {
+		return strings.TrimSpace(keys[0])
+	}
+	return ""
+}
+
+// authenticateAPIKey is authenticate for callers that sent an API key instead of a token.
+func authenticateAPIKey(ctx context.Context, v *Verifier, rule Rule, apiKey string) (context.Context, error) {
+	claims, err := v.VerifyAPIKey(ctx, apiKey)
+	if err != nil {
+		if rule.Public {
+			return ctx, nil
+		}
+		return nil, status.Errorf(codes.Unauthenticated, "invalid api key: %v", err)
+	}
+
+	if err := Require(claims, rule.Permissions...); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	return ContextWithAPIKey(ContextWithClaims(ctx, claims), apiKey), nil
+}
+
+// authenticate applies the rule for fullMethod and returns ctx decorated with the caller's claims.
+func authenticate(ctx context.Context, v *Verifier, policy Policy, fullMethod string, req interface{}) (context.Context, error) {
+	rule := policy.rule(fullMethod)
+	ctx = incomingRequestID(ctx)
+
+	token := bearerToken(ctx)
+	if token == "" {
+		// Callers that have not moved to metadata yet still send the token in the message
+		if r, ok := req.(accessTokenGetter); ok {
+			token = r.GetAccessToken()
+		}
+	}
+
+	if token == "" {
+		if apiKey := incomingAPIKey(ctx); apiKey != "" {
+			return authenticateAPIKey(ctx, v, rule, apiKey)
+		}
+		if rule.Public {
+			return ctx, nil
+		}
+		return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+	}
+
+	claims, err := v.Verify(ctx, token)
+	if err != nil {
+		if rule.Public {
+			return ctx, nil
+		}
+		return nil, status.Errorf(codes.Unauthenticated, "invalid access token: %v", err)
+	}
+
+	if err := Require(claims, rule.Permissions...); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if rule.DenyImpersonation {
+		if err := RejectImpersonation(claims); err != nil {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+	}
+
+	return ContextWithToken(ContextWithClaims(ctx, claims), token), nil
+}
+
+// statusError gives the authorization errors returned by handlers their gRPC codes so
+// that clients can tell them apart from other failures.
+func statusError(err error) error {
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, ErrUnauthorized), errors.Is(err, ErrImpersonating):
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return err
+}
+
+// UnaryServerInterceptor authenticates unary calls according to policy.
+func UnaryServerInterceptor(v *Verifier, policy Policy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, v, policy, info.FullMethod, req)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := handler(ctx, req)
+		return resp, statusError(err)
+	}
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamServerInterceptor authenticates streaming calls according to policy.
+// Streams must send the token as metadata since there is no request message yet.
+func StreamServerInterceptor(v *Verifier, policy Policy) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), v, policy, info.FullMethod, nil)
+		if err != nil {
+			return err
+		}
+		return statusError(handler(srv, &authenticatedStream{ss, ctx}))
+	}
+}
+
+// outgoingContext attaches the token from ctx as bearer metadata unless the caller set one explicitly,
+// along with the id of the request being served. Without a token the API key from ctx is sent instead.
+func outgoingContext(ctx context.Context) context.Context {
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, RequestIDMetadataKey, requestID)
+	}
+	token := TokenFromContext(ctx)
+	if token == "" {
+		if apiKey := APIKeyFromContext(ctx); apiKey != "" {
+			return metadata.AppendToOutgoingContext(ctx, APIKeyMetadataKey, apiKey)
+		}
+		return ctx
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(AuthorizationMetadataKey)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, AuthorizationMetadataKey, "Bearer "+token)
+}
+
+// UnaryClientInterceptor forwards the access token found in the call's context.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(outgoingContext(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor forwards the access token found in the stream's context.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(outgoingContext(ctx), desc, cc, method, opts...)
+	}
+}
+
+// DialOptions installs the client interceptors on a connection.
+func DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(UnaryClientInterceptor()),
+		grpc.WithChainStreamInterceptor(StreamClientInterceptor()),
+	}
+}
+
+// ServerOptions installs the server interceptors enforcing policy.
+func ServerOptions(v *Verifier, policy Policy) []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(UnaryServerInterceptor(v, policy)),
+		grpc.ChainStreamInterceptor(StreamServerInterceptor(v, policy)),
+	}
+}
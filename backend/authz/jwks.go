@@ -0,0 +1,171 @@
+package authz
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Signing algorithms accepted for tokens issued by the account service
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// KeySource looks up the public key a token was signed with by its kid header.
+type KeySource interface {
+	PublicKey(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// JWK is a public key in the JSON Web Key format (RFC 7517). Only RSA and Ed25519 keys are supported.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA modulus and exponent
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519 curve and public key
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is the key set document served to services that verify tokens.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWK describes key as a JWK identified by kid.
+func NewJWK(kid string, key crypto.PublicKey) (JWK, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Kid: kid,
+			Use: "sig",
+			Alg: AlgorithmRS256,
+			N:   base64.RawURLEncoding.EncodeToString(k.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, nil
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Kid: kid,
+			Use: "sig",
+			Alg: AlgorithmEdDSA,
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(k),
+		}, nil
+	}
+	return JWK{}, fmt.Errorf("unsupported key type %T", key)
+}
+
+// PublicKey decodes the key described by j.
+func (j JWK) PublicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of key %s: %w", j.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent of key %s: %w", j.Kid, err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q of key %s", j.Crv, j.Kid)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key %s", j.Kid)
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q of key %s", j.Kty, j.Kid)
+}
+
+// PublicKey returns the key identified by kid so a fixed set can be used as a KeySource.
+func (s JWKS) PublicKey(_ context.Context, kid string) (crypto.PublicKey, error) {
+	for _, k := range s.Keys {
+		if k.Kid == kid {
+			return k.PublicKey()
+		}
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+}
+
+// minRefreshInterval limits how often tokens with an unknown kid can make RemoteKeys refetch the set
+const minRefreshInterval = 30 * time.Second
+
+// RemoteKeys is a KeySource backed by a JWKS document served over HTTP. The document is
+// fetched on first use and again whenever a token names a key it does not contain yet,
+// which is how verifiers pick up rotated keys.
+type RemoteKeys struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      JWKS
+	fetchedAt time.Time
+}
+
+func NewRemoteKeys(url string) *RemoteKeys {
+	return &RemoteKeys{
+		url:    url,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (r *RemoteKeys) PublicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key, err := r.keys.PublicKey(ctx, kid)
+	if err == nil || !errors.Is(err, ErrUnknownKey) {
+		return key, err
+	}
+	if time.Since(r.fetchedAt) < minRefreshInterval {
+		return nil, err
+	}
+	if err := r.fetch(ctx); err != nil {
+		return nil, err
+	}
+	return r.keys.PublicKey(ctx, kid)
+}
+
+func (r *RemoteKeys) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching key set: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching key set: unexpected status %s", res.Status)
+	}
+
+	var keys JWKS
+	if err := json.NewDecoder(res.Body).Decode(&keys); err != nil {
+		return fmt.Errorf("decoding key set: %w", err)
+	}
+	r.keys = keys
+	r.fetchedAt = time.Now()
+	return nil
+}
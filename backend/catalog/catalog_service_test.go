@@ -2,12 +2,23 @@ package catalog
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/JonathanNithi/ecommerce/backend/audit"
+	"github.com/JonathanNithi/ecommerce/backend/authz"
 	"github.com/JonathanNithi/ecommerce/backend/catalog/pb"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // MockRepository for testing
@@ -53,19 +64,26 @@ func (m *MockRepository) ListProductsWithIDs(ctx context.Context, ids []string)
 	return products, args.Error(1)
 }
 
-func (m *MockRepository) SearchProducts(ctx context.Context, query string, skip uint64, take uint64, category string, sort *pb.ProductSortInput) ([]Product, uint64, error) {
-	args := m.Called(ctx, query, skip, take, category, sort)
+func (m *MockRepository) SearchProducts(ctx context.Context, query string, filter ProductFilter, skip uint64, take uint64, sort *pb.ProductSortInput) ([]Product, uint64, Facets, error) {
+	args := m.Called(ctx, query, filter, skip, take, sort)
 	var products []Product
 	if arg := args.Get(0); arg != nil {
 		var ok bool
 		products, ok = arg.([]Product)
 		if !ok {
 			// Handle type assertion error if needed
-			return nil, 0, errors.New("mock: failed type assertion for products in SearchProducts")
+			return nil, 0, Facets{}, errors.New("mock: failed type assertion for products in SearchProducts")
 		}
 	}
 	total, _ := args.Get(1).(uint64)
-	return products, total, args.Error(2)
+	facets, _ := args.Get(2).(Facets)
+	return products, total, facets, args.Error(3)
+}
+
+func (m *MockRepository) SuggestProducts(ctx context.Context, prefix string, limit uint32) (*Suggestions, error) {
+	args := m.Called(ctx, prefix, limit)
+	suggestions, _ := args.Get(0).(*Suggestions)
+	return suggestions, args.Error(1)
 }
 
 func (m *MockRepository) DeductStock(ctx context.Context, productID string, quantity int64) error {
@@ -78,12 +96,231 @@ func (m *MockRepository) UpdateStock(ctx context.Context, productID string, newS
 	return args.Error(0)
 }
 
+func (m *MockRepository) UpdateProduct(ctx context.Context, product Product) error {
+	args := m.Called(ctx, product)
+	return args.Error(0)
+}
+
+func (m *MockRepository) SoftDeleteProduct(ctx context.Context, productID string) error {
+	args := m.Called(ctx, productID)
+	return args.Error(0)
+}
+
+func (m *MockRepository) DeleteProduct(ctx context.Context, productID string) error {
+	args := m.Called(ctx, productID)
+	return args.Error(0)
+}
+
 func (m *MockRepository) Close() {
 }
 
+func (m *MockRepository) ReserveStock(ctx context.Context, productID string, reservationID string, quantity int64, expiresAt time.Time) error {
+	args := m.Called(ctx, productID, reservationID, quantity, expiresAt)
+	return args.Error(0)
+}
+
+func (m *MockRepository) CommitReservedStock(ctx context.Context, productID string, reservationID string) error {
+	args := m.Called(ctx, productID, reservationID)
+	return args.Error(0)
+}
+
+func (m *MockRepository) ReleaseReservedStock(ctx context.Context, productID string, reservationID string) error {
+	args := m.Called(ctx, productID, reservationID)
+	return args.Error(0)
+}
+
+func (m *MockRepository) CreateReservation(ctx context.Context, r Reservation) error {
+	args := m.Called(ctx, r)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetReservation(ctx context.Context, id string) (*Reservation, error) {
+	args := m.Called(ctx, id)
+	reservation, _ := args.Get(0).(*Reservation)
+	return reservation, args.Error(1)
+}
+
+func (m *MockRepository) SetReservationStatus(ctx context.Context, id string, from string, to string) error {
+	args := m.Called(ctx, id, from, to)
+	return args.Error(0)
+}
+
+func (m *MockRepository) ListExpiredReservations(ctx context.Context, now time.Time, limit int) ([]Reservation, error) {
+	args := m.Called(ctx, now, limit)
+	reservations, _ := args.Get(0).([]Reservation)
+	return reservations, args.Error(1)
+}
+
+var testReservations = ReservationPolicy{TTL: time.Minute, SweepInterval: time.Minute}
+
+// fakeStockRepository keeps stock and reservations in memory behind the same
+// compare-and-set writes the Elasticsearch repository uses.
+type fakeStockRepository struct {
+	*MockRepository
+	mu           sync.Mutex
+	products     map[string]*fakeProduct
+	reservations map[string]Reservation
+}
+
+type fakeProduct struct {
+	level stockLevel
+	seqNo int
+}
+
+func newFakeStockRepository(stock map[string]int64) *fakeStockRepository {
+	f := &fakeStockRepository{MockRepository: new(MockRepository), products: map[string]*fakeProduct{}, reservations: map[string]Reservation{}}
+	for id, s := range stock {
+		f.products[id] = &fakeProduct{level: stockLevel{Stock: s}}
+	}
+	return f
+}
+
+// stock returns the stock of product id and the quantity reserved for it.
+func (f *fakeStockRepository) stock(id string) (int64, int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	level := f.products[id].level
+	return level.Stock, level.Stock - level.available(time.Now())
+}
+
+// expireStock lets the stock held for the reservation expire as if time ran out between
+// CommitReservation checking the reservation and committing the product.
+func (f *fakeStockRepository) expireStock(productID string, reservationID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	level := f.products[productID].level
+	r := level.Reservations[reservationID]
+	r.ExpiresAt = time.Now().Add(-time.Second)
+	level.Reservations[reservationID] = r
+}
+
+func (f *fakeStockRepository) readStock(ctx context.Context, id string) (stockLevel, stockVersion, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.products[id]
+	if !ok {
+		return stockLevel{}, stockVersion{}, ErrNotFound
+	}
+	level := stockLevel{Stock: p.level.Stock, Reservations: map[string]stockReservation{}}
+	for id, r := range p.level.Reservations {
+		level.Reservations[id] = r
+	}
+	return level, stockVersion{seqNo: p.seqNo, primaryTerm: 1}, nil
+}
+
+func (f *fakeStockRepository) writeStock(ctx context.Context, id string, level stockLevel, version stockVersion) error {
+	// Let other goroutines read in between so that writes conflict
+	runtime.Gosched()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p := f.products[id]
+	if version.seqNo != p.seqNo {
+		return errVersionConflict
+	}
+	p.level = level
+	p.seqNo++
+	return nil
+}
+
+func (f *fakeStockRepository) GetProductByID(ctx context.Context, id string) (*Product, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.products[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &Product{ID: id, Stock: p.level.Stock, Availability: p.level.available(time.Now()) > 0}, nil
+}
+
+func (f *fakeStockRepository) DeductStock(ctx context.Context, id string, quantity int64) error {
+	return changeStock(ctx, f, id, deductQuantity(quantity))
+}
+
+func (f *fakeStockRepository) UpdateStock(ctx context.Context, id string, change int64) error {
+	return changeStock(ctx, f, id, addStock(change))
+}
+
+func (f *fakeStockRepository) ReserveStock(ctx context.Context, productID string, reservationID string, quantity int64, expiresAt time.Time) error {
+	return changeStock(ctx, f, productID, reserveQuantity(reservationID, quantity, expiresAt))
+}
+
+func (f *fakeStockRepository) CommitReservedStock(ctx context.Context, productID string, reservationID string) error {
+	return changeStock(ctx, f, productID, commitQuantity(reservationID))
+}
+
+func (f *fakeStockRepository) ReleaseReservedStock(ctx context.Context, productID string, reservationID string) error {
+	return changeStock(ctx, f, productID, releaseQuantity(reservationID))
+}
+
+func (f *fakeStockRepository) CreateReservation(ctx context.Context, r Reservation) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reservations[r.ID] = r
+	return nil
+}
+
+func (f *fakeStockRepository) GetReservation(ctx context.Context, id string) (*Reservation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, ok := f.reservations[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &r, nil
+}
+
+func (f *fakeStockRepository) SetReservationStatus(ctx context.Context, id string, from string, to string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := f.reservations[id]
+	if r.Status != from {
+		return ErrReservationChanged
+	}
+	r.Status = to
+	f.reservations[id] = r
+	return nil
+}
+
+func (f *fakeStockRepository) ListExpiredReservations(ctx context.Context, now time.Time, limit int) ([]Reservation, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	expired := []Reservation{}
+	for _, r := range f.reservations {
+		if r.Status == ReservationActive && r.ExpiresAt.Before(now) && len(expired) < limit {
+			expired = append(expired, r)
+		}
+	}
+	return expired, nil
+}
+
+// conflictingStockStore loses every write to a concurrent change
+type conflictingStockStore struct {
+	writes int
+}
+
+func (c *conflictingStockStore) readStock(ctx context.Context, id string) (stockLevel, stockVersion, error) {
+	return stockLevel{Stock: 10}, stockVersion{}, nil
+}
+
+func (c *conflictingStockStore) writeStock(ctx context.Context, id string, level stockLevel, version stockVersion) error {
+	c.writes++
+	return errVersionConflict
+}
+
+// MockAuditRecorder for testing
+type MockAuditRecorder struct {
+	mock.Mock
+}
+
+func (m *MockAuditRecorder) Record(ctx context.Context, e audit.Event) error {
+	args := m.Called(ctx, e)
+	return args.Error(0)
+}
+
 func TestCatalogService_PostProduct_Success(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	auditLog := new(MockAuditRecorder)
+	service := NewService(mockRepo, auditLog, testReservations)
 	ctx := context.Background()
 
 	name := "Test Product"
@@ -95,6 +332,9 @@ func TestCatalogService_PostProduct_Success(t *testing.T) {
 	stock := int64(10)
 
 	mockRepo.On("PutProduct", ctx, mock.AnythingOfType("catalog.Product")).Return(nil).Once()
+	auditLog.On("Record", ctx, mock.MatchedBy(func(e audit.Event) bool {
+		return e.Action == AuditCreateProduct && e.Before == nil && len(e.After) > 0
+	})).Return(nil).Once()
 
 	product, err := service.PostProduct(ctx, name, description, price, category, imageUrl, tags, stock)
 
@@ -110,11 +350,13 @@ func TestCatalogService_PostProduct_Success(t *testing.T) {
 	assert.True(t, product.Availability)
 	assert.Equal(t, stock, product.Stock)
 	mockRepo.AssertExpectations(t)
+	auditLog.AssertExpectations(t)
 }
 
 func TestCatalogService_PostProduct_NoStock(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	auditLog := new(MockAuditRecorder)
+	service := NewService(mockRepo, auditLog, testReservations)
 	ctx := context.Background()
 
 	name := "Test Product"
@@ -126,6 +368,7 @@ func TestCatalogService_PostProduct_NoStock(t *testing.T) {
 	stock := int64(0)
 
 	mockRepo.On("PutProduct", ctx, mock.AnythingOfType("catalog.Product")).Return(nil).Once()
+	auditLog.On("Record", ctx, mock.Anything).Return(nil).Once()
 
 	product, err := service.PostProduct(ctx, name, description, price, category, imageUrl, tags, stock)
 
@@ -138,7 +381,7 @@ func TestCatalogService_PostProduct_NoStock(t *testing.T) {
 
 func TestCatalogService_PostProduct_RepositoryError(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, nil, testReservations)
 	ctx := context.Background()
 
 	name := "Test Product"
@@ -162,7 +405,7 @@ func TestCatalogService_PostProduct_RepositoryError(t *testing.T) {
 
 func TestCatalogService_GetProduct_Success(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, nil, testReservations)
 	ctx := context.Background()
 
 	productID := "testID"
@@ -179,7 +422,7 @@ func TestCatalogService_GetProduct_Success(t *testing.T) {
 
 func TestCatalogService_GetProduct_NotFound(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, nil, testReservations)
 	ctx := context.Background()
 
 	productID := "nonExistentID"
@@ -197,7 +440,7 @@ func TestCatalogService_GetProduct_NotFound(t *testing.T) {
 
 func TestCatalogService_GetProducts_Success(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, nil, testReservations)
 	ctx := context.Background()
 
 	skip := uint64(0)
@@ -218,7 +461,7 @@ func TestCatalogService_GetProducts_Success(t *testing.T) {
 
 func TestCatalogService_GetProducts_DefaultTake(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, nil, testReservations)
 	ctx := context.Background()
 
 	skip := uint64(0)
@@ -239,7 +482,7 @@ func TestCatalogService_GetProducts_DefaultTake(t *testing.T) {
 
 func TestCatalogService_GetProducts_TakeOverLimit(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, nil, testReservations)
 	ctx := context.Background()
 
 	skip := uint64(0)
@@ -260,7 +503,7 @@ func TestCatalogService_GetProducts_TakeOverLimit(t *testing.T) {
 
 func TestCatalogService_GetProducts_RepositoryError(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, nil, testReservations)
 	ctx := context.Background()
 
 	skip := uint64(0)
@@ -281,7 +524,7 @@ func TestCatalogService_GetProducts_RepositoryError(t *testing.T) {
 
 func TestCatalogService_GetProductsById_Success(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, nil, testReservations)
 	ctx := context.Background()
 
 	ids := []string{"1", "2"}
@@ -298,7 +541,7 @@ func TestCatalogService_GetProductsById_Success(t *testing.T) {
 
 func TestCatalogService_GetProductsById_RepositoryError(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, nil, testReservations)
 	ctx := context.Background()
 
 	ids := []string{"1", "2"}
@@ -316,98 +559,270 @@ func TestCatalogService_GetProductsById_RepositoryError(t *testing.T) {
 
 func TestCatalogService_SearchProducts_Success(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, nil, testReservations)
 	ctx := context.Background()
 
 	query := "test"
 	skip := uint64(0)
 	take := uint64(10)
-	category := "All"
+	filter := ProductFilter{Categories: []string{"All"}}
 	sort := &pb.ProductSortInput{}
 	expectedProducts := []Product{{ID: "1", Name: "Test Product 1"}, {ID: "2", Name: "Another Test"}}
 	expectedTotal := uint64(2)
+	expectedFacets := Facets{Categories: []FacetBucket{{Value: "All", Count: 2}}}
 
-	mockRepo.On("SearchProducts", ctx, query, skip, take, category, sort).Return(expectedProducts, expectedTotal, nil).Once()
+	mockRepo.On("SearchProducts", ctx, query, filter, skip, take, sort).Return(expectedProducts, expectedTotal, expectedFacets, nil).Once()
 
-	products, total, err := service.SearchProducts(ctx, query, skip, take, category, sort)
+	products, total, facets, err := service.SearchProducts(ctx, query, filter, skip, take, sort)
 
 	assert.NoError(t, err)
 	assert.Equal(t, expectedProducts, products)
 	assert.Equal(t, expectedTotal, total)
+	assert.Equal(t, expectedFacets, facets)
 	mockRepo.AssertExpectations(t)
 }
 
 func TestCatalogService_SearchProducts_DefaultTake(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, nil, testReservations)
 	ctx := context.Background()
 
 	query := "test"
 	skip := uint64(0)
 	take := uint64(0)
-	category := "All"
+	filter := ProductFilter{Categories: []string{"All"}}
 	sort := &pb.ProductSortInput{}
 	expectedProducts := []Product{{ID: "1", Name: "Test Product 1"}}
 	expectedTotal := uint64(1)
 
-	mockRepo.On("SearchProducts", ctx, query, skip, uint64(100), category, sort).Return(expectedProducts, expectedTotal, nil).Once()
+	mockRepo.On("SearchProducts", ctx, query, filter, skip, uint64(100), sort).Return(expectedProducts, expectedTotal, Facets{}, nil).Once()
 
-	products, total, err := service.SearchProducts(ctx, query, skip, take, category, sort)
+	products, total, facets, err := service.SearchProducts(ctx, query, filter, skip, take, sort)
 
 	assert.NoError(t, err)
 	assert.Equal(t, expectedProducts, products)
 	assert.Equal(t, expectedTotal, total)
+	assert.Empty(t, facets.Categories)
 	mockRepo.AssertExpectations(t)
 }
 
 func TestCatalogService_SearchProducts_RepositoryError(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, nil, testReservations)
 	ctx := context.Background()
 
 	query := "test"
 	skip := uint64(0)
 	take := uint64(10)
-	category := "All"
+	filter := ProductFilter{Categories: []string{"All"}}
 	sort := &pb.ProductSortInput{}
 	expectedError := errors.New("repository error")
 
-	mockRepo.On("SearchProducts", ctx, query, skip, take, category, sort).Return(nil, uint64(0), expectedError).Once()
+	mockRepo.On("SearchProducts", ctx, query, filter, skip, take, sort).Return(nil, uint64(0), Facets{}, expectedError).Once()
 
-	products, total, err := service.SearchProducts(ctx, query, skip, take, category, sort)
+	products, total, facets, err := service.SearchProducts(ctx, query, filter, skip, take, sort)
 
 	assert.Error(t, err)
 	assertNil(t, products)
 	assertEqual(t, uint64(0), total)
+	assert.Empty(t, facets.Categories)
 	assert.EqualError(t, err, "repository error")
 	mockRepo.AssertExpectations(t)
 }
 
-func TestCatalogService_DeductStock_Success(t *testing.T) {
+func TestCatalogService_SearchProducts_InvalidPriceRange(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, nil, testReservations)
+	min, max := 50.0, 10.0
+
+	_, _, _, err := service.SearchProducts(context.Background(), "", ProductFilter{MinPrice: &min, MaxPrice: &max}, 0, 10, nil)
+
+	assert.ErrorIs(t, err, ErrInvalidPriceRange)
+	mockRepo.AssertNotCalled(t, "SearchProducts", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSearchQuery_Facets(t *testing.T) {
+	min := 10.0
+	available := true
+	body := searchQuery("lamp", ProductFilter{Categories: []string{"Home"}, Tags: []string{"led", "desk"}, MinPrice: &min, Available: &available}, 0, 20, nil)
+	queryJSON, err := json.Marshal(body)
+	assert.NoError(t, err)
+
+	type boolFilter struct {
+		Bool struct {
+			Must   []json.RawMessage `json:"must"`
+			Filter []json.RawMessage `json:"filter"`
+		} `json:"bool"`
+	}
+	var q struct {
+		Query      boolFilter `json:"query"`
+		PostFilter boolFilter `json:"post_filter"`
+		Aggs       map[string]struct {
+			Filter boolFilter `json:"filter"`
+		} `json:"aggs"`
+	}
+	assert.NoError(t, json.Unmarshal(queryJSON, &q))
+
+	assert.Len(t, q.Query.Bool.Must, 1)
+	if assert.Len(t, q.Query.Bool.Filter, 1) {
+		assert.JSONEq(t, `{"term": {"availability": true}}`, string(q.Query.Bool.Filter[0]))
+	}
+	assert.Len(t, q.PostFilter.Bool.Filter, 3)
+	// Each facet is counted without its own filter
+	categories := q.Aggs["categories"].Filter.Bool.Filter
+	if assert.Len(t, categories, 2) {
+		assert.JSONEq(t, `{"terms": {"tags": ["led", "desk"]}}`, string(categories[0]))
+		assert.JSONEq(t, `{"range": {"price": {"gte": 10}}}`, string(categories[1]))
+	}
+	assert.Len(t, q.Aggs["tags"].Filter.Bool.Filter, 2)
+	prices := q.Aggs["price_ranges"].Filter.Bool.Filter
+	if assert.Len(t, prices, 2) {
+		assert.JSONEq(t, `{"terms": {"category": ["Home"]}}`, string(prices[0]))
+	}
+}
+
+func TestParseFacets(t *testing.T) {
+	facets, err := parseFacets(json.RawMessage(`{
+		"categories": {"doc_count": 3, "values": {"buckets": [{"key": "Home", "doc_count": 2}, {"key": "Books", "doc_count": 1}]}},
+		"tags": {"doc_count": 0, "values": {"buckets": []}},
+		"price_ranges": {"doc_count": 3, "values": {"buckets": [
+			{"key": "*-25.0", "to": 25.0, "doc_count": 1},
+			{"key": "25.0-50.0", "from": 25.0, "to": 50.0, "doc_count": 0},
+			{"key": "250.0-*", "from": 250.0, "doc_count": 2}
+		]}}
+	}`))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []FacetBucket{{Value: "Home", Count: 2}, {Value: "Books", Count: 1}}, facets.Categories)
+	assert.Empty(t, facets.Tags)
+	if assert.Len(t, facets.PriceRanges, 3) {
+		assert.Nil(t, facets.PriceRanges[0].From)
+		assert.Equal(t, 25.0, *facets.PriceRanges[0].To)
+		assert.Equal(t, 250.0, *facets.PriceRanges[2].From)
+		assert.Nil(t, facets.PriceRanges[2].To)
+		assert.Equal(t, uint64(2), facets.PriceRanges[2].Count)
+	}
+}
+
+func TestCatalogService_SuggestProducts(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, nil, testReservations)
 	ctx := context.Background()
+	expected := &Suggestions{
+		Products:   []ProductSuggestion{{ID: "1", Name: "Desk Lamp"}},
+		Categories: []string{"Desk"},
+	}
+
+	// Whitespace is collapsed and the limit capped before reaching the repository
+	mockRepo.On("SuggestProducts", ctx, "desk la", uint32(maxSuggestionLimit)).Return(expected, nil).Once()
+
+	suggestions, err := service.SuggestProducts(ctx, "  desk   la ", 100)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, suggestions)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCatalogService_SuggestProducts_EmptyPrefix(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, nil, testReservations)
+
+	suggestions, err := service.SuggestProducts(context.Background(), "   ", 0)
+
+	assert.NoError(t, err)
+	assert.Empty(t, suggestions.Products)
+	assert.Empty(t, suggestions.Categories)
+	mockRepo.AssertNotCalled(t, "SuggestProducts", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestSuggestionLimit(t *testing.T) {
+	assert.Equal(t, uint32(defaultSuggestionLimit), suggestionLimit(0))
+	assert.Equal(t, uint32(3), suggestionLimit(3))
+	assert.Equal(t, uint32(maxSuggestionLimit), suggestionLimit(maxSuggestionLimit+1))
+}
+
+func TestSuggestQuery(t *testing.T) {
+	queryJSON, err := json.Marshal(suggestQuery("desk la", 5))
+	assert.NoError(t, err)
+
+	var q struct {
+		Size  int `json:"size"`
+		Query struct {
+			Bool struct {
+				Must struct {
+					MultiMatch struct {
+						Query  string   `json:"query"`
+						Type   string   `json:"type"`
+						Fields []string `json:"fields"`
+					} `json:"multi_match"`
+				} `json:"must"`
+				MustNot []json.RawMessage `json:"must_not"`
+			} `json:"bool"`
+		} `json:"query"`
+		Aggs struct {
+			Categories struct {
+				Global *struct{} `json:"global"`
+				Aggs   struct {
+					Matching struct {
+						Filter struct {
+							Bool struct {
+								Filter  json.RawMessage   `json:"filter"`
+								MustNot []json.RawMessage `json:"must_not"`
+							} `json:"bool"`
+						} `json:"filter"`
+					} `json:"matching"`
+				} `json:"aggs"`
+			} `json:"categories"`
+		} `json:"aggs"`
+	}
+	assert.NoError(t, json.Unmarshal(queryJSON, &q))
+
+	assert.Equal(t, 5, q.Size)
+	assert.Equal(t, "desk la", q.Query.Bool.Must.MultiMatch.Query)
+	assert.Equal(t, "bool_prefix", q.Query.Bool.Must.MultiMatch.Type)
+	assert.Equal(t, suggestFields, q.Query.Bool.Must.MultiMatch.Fields)
+	assert.Len(t, q.Query.Bool.MustNot, 1)
+	// Categories are matched across the catalog, deleted products aside
+	assert.NotNil(t, q.Aggs.Categories.Global)
+	matching := q.Aggs.Categories.Aggs.Matching.Filter.Bool
+	assert.JSONEq(t, `{"prefix": {"category": {"value": "desk la", "case_insensitive": true}}}`, string(matching.Filter))
+	assert.Len(t, matching.MustNot, 1)
+}
+
+func TestCatalogService_DeductStock_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	auditLog := new(MockAuditRecorder)
+	service := NewService(mockRepo, auditLog, testReservations)
+	ctx := authz.ContextWithClaims(context.Background(), &authz.Claims{Username: "stock@example.com"})
 
 	productID := "testID"
 	quantity := int64(5)
 
+	mockRepo.On("GetProductByID", ctx, productID).Return(&Product{ID: productID, Stock: 5, Availability: true}, nil).Once()
 	mockRepo.On("DeductStock", ctx, productID, quantity).Return(nil).Once()
+	mockRepo.On("GetProductByID", ctx, productID).Return(&Product{ID: productID}, nil).Once()
+	auditLog.On("Record", ctx, mock.MatchedBy(func(e audit.Event) bool {
+		return e.Action == AuditDeductStock && e.Target == productID && e.Actor == "stock@example.com" &&
+			string(e.Before) == `{"stock":5,"availability":true}` && string(e.After) == `{"stock":0,"availability":false}`
+	})).Return(nil).Once()
 
 	err := service.DeductStock(ctx, productID, quantity)
 
 	assert.NoError(t, err)
 	mockRepo.AssertExpectations(t)
+	auditLog.AssertExpectations(t)
 }
 
 func TestCatalogService_DeductStock_RepositoryError(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, nil, testReservations)
 	ctx := context.Background()
 
 	productID := "testID"
 	quantity := int64(5)
 	expectedError := errors.New("repository error")
 
+	mockRepo.On("GetProductByID", ctx, productID).Return(&Product{ID: productID, Stock: 10, Availability: true}, nil).Once()
 	mockRepo.On("DeductStock", ctx, productID, quantity).Return(expectedError).Once()
 
 	err := service.DeductStock(ctx, productID, quantity)
@@ -417,34 +832,56 @@ func TestCatalogService_DeductStock_RepositoryError(t *testing.T) {
 	mockRepo.AssertExpectations(t)
 }
 
-func TestCatalogService_UpdateStock_Success(t *testing.T) {
+func TestCatalogService_DeductStock_InvalidQuantity(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, nil, testReservations)
 	ctx := context.Background()
 
+	for _, quantity := range []int64{0, -5} {
+		err := service.DeductStock(ctx, "testID", quantity)
+
+		assert.ErrorIs(t, err, ErrInvalidQuantity)
+		assert.Equal(t, codes.InvalidArgument, status.Code(statusError(err)))
+	}
+	mockRepo.AssertNotCalled(t, "DeductStock", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCatalogService_UpdateStock_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	auditLog := new(MockAuditRecorder)
+	service := NewService(mockRepo, auditLog, testReservations)
+	ctx := authz.ContextWithClaims(context.Background(), &authz.Claims{Username: "stock@example.com"})
+
 	productID := "testID"
 	newStock := int64(15)
 	expectedProduct := &Product{ID: productID, Name: "Test Product", Stock: newStock, Availability: true}
 
+	mockRepo.On("GetProductByID", ctx, productID).Return(&Product{ID: productID, Name: "Test Product"}, nil).Once()
 	mockRepo.On("UpdateStock", ctx, productID, newStock).Return(nil).Once()
 	mockRepo.On("GetProductByID", ctx, productID).Return(expectedProduct, nil).Once()
+	auditLog.On("Record", ctx, mock.MatchedBy(func(e audit.Event) bool {
+		return e.Action == AuditUpdateStock && e.Target == productID && e.Actor == "stock@example.com" &&
+			string(e.Before) == `{"stock":0,"availability":false}` && string(e.After) == `{"stock":15,"availability":true}`
+	})).Return(nil).Once()
 
 	updatedProduct, err := service.UpdateStock(ctx, productID, newStock)
 
 	assert.NoError(t, err)
 	assert.Equal(t, expectedProduct, updatedProduct)
 	mockRepo.AssertExpectations(t)
+	auditLog.AssertExpectations(t)
 }
 
 func TestCatalogService_UpdateStock_RepositoryUpdateError(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, nil, testReservations)
 	ctx := context.Background()
 
 	productID := "testID"
 	newStock := int64(15)
 	expectedError := errors.New("failed to update stock")
 
+	mockRepo.On("GetProductByID", ctx, productID).Return(&Product{ID: productID}, nil).Once()
 	mockRepo.On("UpdateStock", ctx, productID, newStock).Return(expectedError).Once()
 
 	updatedProduct, err := service.UpdateStock(ctx, productID, newStock)
@@ -457,13 +894,14 @@ func TestCatalogService_UpdateStock_RepositoryUpdateError(t *testing.T) {
 
 func TestCatalogService_UpdateStock_RepositoryGetError(t *testing.T) {
 	mockRepo := new(MockRepository)
-	service := NewService(mockRepo)
+	service := NewService(mockRepo, nil, testReservations)
 	ctx := context.Background()
 
 	productID := "testID"
 	newStock := int64(15)
 	expectedError := errors.New("failed to retrieve product")
 
+	mockRepo.On("GetProductByID", ctx, productID).Return(&Product{ID: productID}, nil).Once()
 	mockRepo.On("UpdateStock", ctx, productID, newStock).Return(nil).Once()
 	mockRepo.On("GetProductByID", ctx, productID).Return(nil, expectedError).Once()
 
@@ -475,6 +913,484 @@ func TestCatalogService_UpdateStock_RepositoryGetError(t *testing.T) {
 	mockRepo.AssertExpectations(t)
 }
 
+func TestCatalogService_GetProduct_Deleted(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, nil, testReservations)
+	ctx := context.Background()
+
+	mockRepo.On("GetProductByID", ctx, "testID").Return(&Product{ID: "testID", Deleted: true}, nil).Once()
+
+	product, err := service.GetProduct(ctx, "testID")
+
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Nil(t, product)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCatalogService_UpdateProduct_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	auditLog := new(MockAuditRecorder)
+	service := NewService(mockRepo, auditLog, testReservations)
+	ctx := authz.ContextWithClaims(context.Background(), &authz.Claims{Username: "editor@example.com"})
+
+	existing := &Product{ID: "testID", Name: "Old Name", Description: "Typo", Price: 10, Category: "Books", Tags: []string{"a"}, Stock: 3, Availability: true}
+	expected := Product{ID: "testID", Name: "New Name", Description: "Typo", Price: 12.5, Category: "Books", Tags: []string{"a"}, Stock: 3, Availability: true}
+
+	mockRepo.On("GetProductByID", ctx, "testID").Return(existing, nil).Once()
+	mockRepo.On("UpdateProduct", ctx, expected).Return(nil).Once()
+	auditLog.On("Record", ctx, mock.MatchedBy(func(e audit.Event) bool {
+		return e.Action == AuditUpdateProduct && e.Target == "testID" && e.Actor == "editor@example.com" && len(e.Before) > 0 && len(e.After) > 0
+	})).Return(nil).Once()
+
+	// Description and category are not in the mask so their new values are ignored
+	changes := Product{Name: " New Name ", Description: "", Price: 12.5, Category: "Games"}
+	product, err := service.UpdateProduct(ctx, "testID", changes, []string{ProductFieldName, ProductFieldPrice})
+
+	assert.NoError(t, err)
+	assert.Equal(t, &expected, product)
+	mockRepo.AssertExpectations(t)
+	auditLog.AssertExpectations(t)
+}
+
+func TestCatalogService_UpdateProduct_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes Product
+		mask    []string
+		err     error
+	}{
+		{"empty mask", Product{Name: "Name"}, nil, ErrEmptyUpdateMask},
+		{"unknown field", Product{Stock: 100}, []string{ProductFieldName, "stock"}, ErrUnknownProductField},
+		{"blank name", Product{Name: "  "}, []string{ProductFieldName}, ErrProductNameRequired},
+		{"negative price", Product{Price: -1}, []string{ProductFieldPrice}, ErrNegativePrice},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepository)
+			service := NewService(mockRepo, nil, testReservations)
+			ctx := context.Background()
+
+			mockRepo.On("GetProductByID", ctx, "testID").Return(&Product{ID: "testID", Name: "Name", Price: 10}, nil).Maybe()
+
+			product, err := service.UpdateProduct(ctx, "testID", tt.changes, tt.mask)
+
+			assert.ErrorIs(t, err, tt.err)
+			assert.Nil(t, product)
+			mockRepo.AssertNotCalled(t, "UpdateProduct", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestCatalogService_UpdateProduct_NameTaken(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, nil, testReservations)
+	ctx := context.Background()
+
+	mockRepo.On("GetProductByID", ctx, "testID").Return(&Product{ID: "testID", Name: "Old Name"}, nil).Once()
+	mockRepo.On("UpdateProduct", ctx, Product{ID: "testID", Name: "Taken"}).Return(errors.New("product with the same name 'Taken' already exists")).Once()
+
+	product, err := service.UpdateProduct(ctx, "testID", Product{Name: "Taken"}, []string{ProductFieldName})
+
+	assert.EqualError(t, err, "failed to update product testID: product with the same name 'Taken' already exists")
+	assert.Nil(t, product)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCatalogService_UpdateProduct_Deleted(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, nil, testReservations)
+	ctx := context.Background()
+
+	mockRepo.On("GetProductByID", ctx, "testID").Return(&Product{ID: "testID", Name: "Name", Deleted: true}, nil).Once()
+
+	product, err := service.UpdateProduct(ctx, "testID", Product{Name: "Other"}, []string{ProductFieldName})
+
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Nil(t, product)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCatalogService_DeleteProduct_Soft(t *testing.T) {
+	mockRepo := new(MockRepository)
+	auditLog := new(MockAuditRecorder)
+	service := NewService(mockRepo, auditLog, testReservations)
+	ctx := authz.ContextWithClaims(context.Background(), &authz.Claims{Username: "editor@example.com", Permissions: []string{authz.CatalogWrite}})
+
+	mockRepo.On("GetProductByID", ctx, "testID").Return(&Product{ID: "testID", Name: "Name"}, nil).Once()
+	mockRepo.On("SoftDeleteProduct", ctx, "testID").Return(nil).Once()
+	auditLog.On("Record", ctx, mock.MatchedBy(func(e audit.Event) bool {
+		return e.Action == AuditDeleteProduct && e.Target == "testID" && strings.Contains(string(e.After), `"deleted":true`)
+	})).Return(nil).Once()
+
+	err := service.DeleteProduct(ctx, "testID", false)
+
+	assert.NoError(t, err)
+	mockRepo.AssertNotCalled(t, "DeleteProduct", mock.Anything, mock.Anything)
+	mockRepo.AssertExpectations(t)
+	auditLog.AssertExpectations(t)
+}
+
+func TestCatalogService_DeleteProduct_SoftAlreadyDeleted(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, nil, testReservations)
+	ctx := context.Background()
+
+	mockRepo.On("GetProductByID", ctx, "testID").Return(&Product{ID: "testID", Deleted: true}, nil).Once()
+
+	err := service.DeleteProduct(ctx, "testID", false)
+
+	assert.ErrorIs(t, err, ErrNotFound)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCatalogService_DeleteProduct_Hard(t *testing.T) {
+	mockRepo := new(MockRepository)
+	auditLog := new(MockAuditRecorder)
+	service := NewService(mockRepo, auditLog, testReservations)
+	ctx := authz.ContextWithClaims(context.Background(), &authz.Claims{Username: "admin@example.com", Permissions: []string{authz.CatalogWrite, authz.CatalogDelete}})
+
+	// Products already soft deleted can still be removed for good
+	mockRepo.On("GetProductByID", ctx, "testID").Return(&Product{ID: "testID", Deleted: true}, nil).Once()
+	mockRepo.On("DeleteProduct", ctx, "testID").Return(nil).Once()
+	auditLog.On("Record", ctx, mock.MatchedBy(func(e audit.Event) bool {
+		return e.Action == AuditDeleteProduct && len(e.Before) > 0 && e.After == nil
+	})).Return(nil).Once()
+
+	err := service.DeleteProduct(ctx, "testID", true)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	auditLog.AssertExpectations(t)
+}
+
+func TestCatalogService_DeleteProduct_HardNeedsPermission(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo, nil, testReservations)
+	ctx := authz.ContextWithClaims(context.Background(), &authz.Claims{Username: "editor@example.com", Permissions: []string{authz.CatalogWrite}})
+
+	err := service.DeleteProduct(ctx, "testID", true)
+
+	assert.ErrorIs(t, err, authz.ErrUnauthorized)
+	mockRepo.AssertNotCalled(t, "GetProductByID", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "DeleteProduct", mock.Anything, mock.Anything)
+}
+
+func TestCatalogService_DeductStock_Concurrent(t *testing.T) {
+	repo := newFakeStockRepository(map[string]int64{"testID": 50})
+	auditLog := new(MockAuditRecorder)
+	service := NewService(repo, auditLog, testReservations)
+	ctx := context.Background()
+
+	auditLog.On("Record", ctx, mock.Anything).Return(nil)
+
+	var sold, soldOut, conflicts int64
+	var wg sync.WaitGroup
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := service.DeductStock(ctx, "testID", 1)
+			switch {
+			case err == nil:
+				atomic.AddInt64(&sold, 1)
+			case errors.Is(err, ErrInsufficientStock):
+				atomic.AddInt64(&soldOut, 1)
+			case errors.Is(err, ErrStockConflict):
+				atomic.AddInt64(&conflicts, 1)
+			default:
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	stock, _ := repo.stock("testID")
+	assert.GreaterOrEqual(t, stock, int64(0))
+	assert.Equal(t, int64(50)-sold, stock, "every successful deduction is reflected in the stock")
+	assert.Equal(t, int64(200), sold+soldOut+conflicts)
+	assert.Positive(t, sold)
+}
+
+func TestCatalogService_DeductStock_Insufficient(t *testing.T) {
+	repo := newFakeStockRepository(map[string]int64{"testID": 2})
+	service := NewService(repo, nil, testReservations)
+
+	err := service.DeductStock(context.Background(), "testID", 3)
+
+	assert.ErrorIs(t, err, ErrInsufficientStock)
+	stock, _ := repo.stock("testID")
+	assert.Equal(t, int64(2), stock)
+}
+
+func TestCatalogService_UpdateStock_CannotGoNegative(t *testing.T) {
+	repo := newFakeStockRepository(map[string]int64{"testID": 2})
+	service := NewService(repo, nil, testReservations)
+	ctx := context.Background()
+
+	product, err := service.UpdateStock(ctx, "testID", -3)
+
+	assert.ErrorIs(t, err, ErrInsufficientStock)
+	assert.Nil(t, product)
+	stock, _ := repo.stock("testID")
+	assert.Equal(t, int64(2), stock)
+}
+
+func TestChangeStock_BoundedRetries(t *testing.T) {
+	store := &conflictingStockStore{}
+
+	err := changeStock(context.Background(), store, "testID", deductQuantity(1))
+
+	assert.ErrorIs(t, err, ErrStockConflict)
+	assert.Equal(t, maxStockAttempts, store.writes)
+}
+
+func TestStatusError(t *testing.T) {
+	err := statusError(fmt.Errorf("failed to update stock for product testID: %w", ErrInsufficientStock))
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	err = statusError(ErrStockConflict)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	err = statusError(fmt.Errorf("failed to reserve product b: %w", ErrInsufficientStock))
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	err = statusError(ErrReservationExpired)
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	err = statusError(fmt.Errorf("%w: weight", ErrUnknownProductField))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	err = statusError(ErrNegativePrice)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	err = statusError(fmt.Errorf("failed to delete product testID: %w", ErrNotFound))
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	err = statusError(errors.New("other"))
+	assert.Equal(t, codes.Unknown, status.Code(err))
+}
+
+// A product that was soft deleted is reported as missing, not as an internal error
+func TestGrpcServer_GetProduct_Deleted(t *testing.T) {
+	mockRepo := new(MockRepository)
+	server := &grpcServer{service: NewService(mockRepo, nil, testReservations)}
+	ctx := context.Background()
+
+	mockRepo.On("GetProductByID", ctx, "testID").Return(&Product{ID: "testID", Deleted: true}, nil).Once()
+
+	res, err := server.GetProduct(ctx, &pb.GetProductRequest{Id: "testID"})
+
+	assert.Nil(t, res)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	mockRepo.AssertExpectations(t)
+}
+
+func shopperContext(username string) context.Context {
+	return authz.ContextWithClaims(context.Background(), &authz.Claims{Username: username})
+}
+
+func TestStockLevel_Available(t *testing.T) {
+	now := time.Now()
+	level := stockLevel{Stock: 10, Reservations: map[string]stockReservation{
+		"active":  {Quantity: 3, ExpiresAt: now.Add(time.Minute)},
+		"expired": {Quantity: 4, ExpiresAt: now.Add(-time.Second)},
+	}}
+
+	assert.Equal(t, int64(7), level.available(now))
+	assert.ErrorIs(t, deductQuantity(8)(&level, now), ErrInsufficientStock)
+	assert.ErrorIs(t, addStock(-8)(&level, now), ErrInsufficientStock)
+}
+
+func TestCatalogService_ReserveStock_CommitAndRelease(t *testing.T) {
+	repo := newFakeStockRepository(map[string]int64{"a": 5, "b": 5})
+	service := NewService(repo, nil, testReservations)
+	ctx := shopperContext("shopper@example.com")
+
+	// Items for the same product are held together
+	reservation, err := service.ReserveStock(ctx, []ReservationItem{{"a", 1}, {"b", 3}, {"a", 1}})
+	assert.NoError(t, err)
+	assert.Equal(t, []ReservationItem{{"a", 2}, {"b", 3}}, reservation.Items)
+	assert.Equal(t, "shopper@example.com", reservation.Owner)
+	stock, reserved := repo.stock("a")
+	assert.Equal(t, int64(5), stock)
+	assert.Equal(t, int64(2), reserved)
+
+	// Reserved stock cannot be bought by anyone else
+	assert.ErrorIs(t, service.DeductStock(ctx, "b", 3), ErrInsufficientStock)
+
+	assert.NoError(t, service.CommitReservation(ctx, reservation.ID))
+	stock, reserved = repo.stock("a")
+	assert.Equal(t, int64(3), stock)
+	assert.Zero(t, reserved)
+	stock, _ = repo.stock("b")
+	assert.Equal(t, int64(2), stock)
+
+	// Committing again deducts nothing more, releasing is too late
+	assert.NoError(t, service.CommitReservation(ctx, reservation.ID))
+	stock, _ = repo.stock("a")
+	assert.Equal(t, int64(3), stock)
+	assert.ErrorIs(t, service.ReleaseReservation(ctx, reservation.ID), ErrReservationCommitted)
+}
+
+func TestCatalogService_ReleaseReservation(t *testing.T) {
+	repo := newFakeStockRepository(map[string]int64{"a": 5})
+	service := NewService(repo, nil, testReservations)
+	ctx := shopperContext("shopper@example.com")
+
+	reservation, err := service.ReserveStock(ctx, []ReservationItem{{"a", 4}})
+	assert.NoError(t, err)
+
+	assert.NoError(t, service.ReleaseReservation(ctx, reservation.ID))
+	assert.NoError(t, service.ReleaseReservation(ctx, reservation.ID))
+	stock, reserved := repo.stock("a")
+	assert.Equal(t, int64(5), stock)
+	assert.Zero(t, reserved)
+	assert.ErrorIs(t, service.CommitReservation(ctx, reservation.ID), ErrReservationReleased)
+}
+
+func TestCatalogService_ReserveStock_AllOrNone(t *testing.T) {
+	repo := newFakeStockRepository(map[string]int64{"a": 5, "b": 1})
+	service := NewService(repo, nil, testReservations)
+	ctx := shopperContext("shopper@example.com")
+
+	reservation, err := service.ReserveStock(ctx, []ReservationItem{{"a", 2}, {"b", 3}})
+
+	assert.ErrorIs(t, err, ErrInsufficientStock)
+	assert.ErrorContains(t, err, "failed to reserve product b")
+	assert.Nil(t, reservation)
+	_, reserved := repo.stock("a")
+	assert.Zero(t, reserved, "stock reserved before the shortage is released")
+	for _, r := range repo.reservations {
+		assert.Equal(t, ReservationReleased, r.Status)
+	}
+}
+
+func TestCatalogService_ReserveStock_Invalid(t *testing.T) {
+	service := NewService(new(MockRepository), nil, testReservations)
+	ctx := shopperContext("shopper@example.com")
+
+	_, err := service.ReserveStock(ctx, nil)
+	assert.ErrorIs(t, err, ErrEmptyReservation)
+
+	_, err = service.ReserveStock(ctx, []ReservationItem{{"a", 1}, {"b", 0}})
+	assert.ErrorIs(t, err, ErrInvalidQuantity)
+
+	_, err = service.ReserveStock(context.Background(), []ReservationItem{{"a", 1}})
+	assert.ErrorIs(t, err, authz.ErrUnauthenticated)
+}
+
+func TestCatalogService_ReserveStock_Concurrent(t *testing.T) {
+	repo := newFakeStockRepository(map[string]int64{"a": 10, "b": 10})
+	service := NewService(repo, nil, testReservations)
+	ctx := shopperContext("shopper@example.com")
+
+	var reservedCount int64
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := service.ReserveStock(ctx, []ReservationItem{{"a", 1}, {"b", 1}}); err == nil {
+				atomic.AddInt64(&reservedCount, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	_, reservedA := repo.stock("a")
+	_, reservedB := repo.stock("b")
+	assert.LessOrEqual(t, reservedCount, int64(10))
+	assert.Equal(t, reservedCount, reservedA, "only complete reservations hold stock")
+	assert.Equal(t, reservedCount, reservedB, "only complete reservations hold stock")
+}
+
+func TestCatalogService_Reservation_OwnerOnly(t *testing.T) {
+	repo := newFakeStockRepository(map[string]int64{"a": 5})
+	service := NewService(repo, nil, testReservations)
+
+	reservation, err := service.ReserveStock(shopperContext("shopper@example.com"), []ReservationItem{{"a", 1}})
+	assert.NoError(t, err)
+
+	other := shopperContext("other@example.com")
+	assert.ErrorIs(t, service.CommitReservation(other, reservation.ID), authz.ErrUnauthorized)
+	assert.ErrorIs(t, service.ReleaseReservation(other, reservation.ID), authz.ErrUnauthorized)
+
+	staff := authz.ContextWithClaims(context.Background(), &authz.Claims{Username: "stock@example.com", Permissions: []string{authz.InventoryAdjust}})
+	assert.NoError(t, service.ReleaseReservation(staff, reservation.ID))
+}
+
+func TestCatalogService_ReservationExpiry(t *testing.T) {
+	repo := newFakeStockRepository(map[string]int64{"a": 5})
+	service := NewService(repo, nil, ReservationPolicy{TTL: 10 * time.Millisecond})
+	ctx := shopperContext("shopper@example.com")
+
+	reservation, err := service.ReserveStock(ctx, []ReservationItem{{"a", 5}})
+	assert.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+
+	// Expired reservations stop holding stock before the sweeper gets to them
+	_, reserved := repo.stock("a")
+	assert.Zero(t, reserved)
+	assert.ErrorIs(t, service.CommitReservation(ctx, reservation.ID), ErrReservationExpired)
+
+	released, err := service.ReleaseExpiredReservations(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, released)
+	assert.Equal(t, ReservationExpired, repo.reservations[reservation.ID].Status)
+	assert.Empty(t, repo.products["a"].level.Reservations)
+	assert.ErrorIs(t, service.CommitReservation(ctx, reservation.ID), ErrReservationExpired)
+
+	released, err = service.ReleaseExpiredReservations(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, released)
+}
+
+// Reservations expiring between the check in CommitReservation and the stock write only
+// commit stock that nobody else reserved since
+func TestCatalogService_CommitReservation_ExpiresWhileCommitting(t *testing.T) {
+	repo := newFakeStockRepository(map[string]int64{"a": 5, "b": 5})
+	service := NewService(repo, nil, testReservations)
+	ctx := shopperContext("shopper@example.com")
+
+	reservation, err := service.ReserveStock(ctx, []ReservationItem{{"a", 2}, {"b", 4}})
+	assert.NoError(t, err)
+	repo.expireStock("b", reservation.ID)
+	other, err := service.ReserveStock(shopperContext("other@example.com"), []ReservationItem{{"b", 3}})
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, service.CommitReservation(ctx, reservation.ID), ErrReservationExpired)
+
+	// Nothing of the reservation is deducted and nothing stays held for it
+	assert.Equal(t, ReservationReleased, repo.reservations[reservation.ID].Status)
+	stock, reserved := repo.stock("a")
+	assert.Equal(t, int64(5), stock)
+	assert.Zero(t, reserved)
+	stock, reserved = repo.stock("b")
+	assert.Equal(t, int64(5), stock)
+	assert.Equal(t, int64(3), reserved)
+	assert.NoError(t, service.ReleaseReservation(ctx, reservation.ID))
+	assert.NoError(t, service.CommitReservation(shopperContext("other@example.com"), other.ID))
+}
+
+func TestCatalogService_CommitReservation_ExpiredStockStillAvailable(t *testing.T) {
+	repo := newFakeStockRepository(map[string]int64{"a": 5})
+	service := NewService(repo, nil, testReservations)
+	ctx := shopperContext("shopper@example.com")
+
+	reservation, err := service.ReserveStock(ctx, []ReservationItem{{"a", 4}})
+	assert.NoError(t, err)
+	repo.expireStock("a", reservation.ID)
+
+	assert.NoError(t, service.CommitReservation(ctx, reservation.ID))
+	stock, reserved := repo.stock("a")
+	assert.Equal(t, int64(1), stock)
+	assert.Zero(t, reserved)
+}
+
+func TestPolicy_DeductStockNeedsInventoryAdjust(t *testing.T) {
+	assert.Equal(t, []string{authz.InventoryAdjust}, policy[pb.CatalogService_DeductStock_FullMethodName].Permissions)
+}
+
 // Helper function to assert equality with nil check
 func assertNil(t *testing.T, actual interface{}) {
 	assert.Nil(t, actual)
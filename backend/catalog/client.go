@@ -3,6 +3,7 @@ package catalog
 import (
 	"context"
 
+	"github.com/JonathanNithi/ecommerce/backend/authz"
 	"github.com/JonathanNithi/ecommerce/backend/catalog/pb"
 	"google.golang.org/grpc"
 )
@@ -12,8 +13,10 @@ type Client struct {
 	service pb.CatalogServiceClient
 }
 
+// NewClient connects to the catalog service. Calls are authenticated with the
+// access token attached to their context by authz.ContextWithToken.
 func NewClient(url string) (*Client, error) {
-	conn, err := grpc.Dial(url, grpc.WithInsecure())
+	conn, err := grpc.Dial(url, append(authz.DialOptions(), grpc.WithInsecure())...)
 	if err != nil {
 		return nil, err
 	}
@@ -54,6 +57,7 @@ func (c *Client) PostProduct(ctx context.Context, name, description string, pric
 		Tags:         r.Product.Tags,
 		Availability: r.Product.Availability,
 		Stock:        r.Product.Stock,
+		Deleted:      r.Product.Deleted,
 	}, nil
 }
 
@@ -78,6 +82,7 @@ func (c *Client) GetProduct(ctx context.Context, id string) (*Product, error) {
 		Tags:         r.Product.Tags,
 		Availability: r.Product.Availability,
 		Stock:        r.Product.Stock,
+		Deleted:      r.Product.Deleted,
 	}, nil
 }
 
@@ -108,11 +113,76 @@ func (c *Client) GetProducts(ctx context.Context, skip uint64, take uint64, ids
 			Tags:         p.Tags,
 			Availability: p.Availability,
 			Stock:        p.Stock,
+			Deleted:      p.Deleted,
 		})
 	}
 	return products, r.TotalCount, nil // Return the total count from the response
 }
 
+// SearchProducts returns a page of the products matching query and filter, how many match
+// in total and the facets to narrow the search further by.
+func (c *Client) SearchProducts(ctx context.Context, skip uint64, take uint64, query string, filter ProductFilter, sortBy *pb.ProductSortInput) ([]Product, uint64, *Facets, error) {
+	r, err := c.service.GetProducts(
+		ctx,
+		&pb.GetProductsRequest{
+			Skip:       skip,
+			Take:       take,
+			Query:      query,
+			Sort:       sortBy,
+			Categories: filter.Categories,
+			Tags:       filter.Tags,
+			MinPrice:   filter.MinPrice,
+			MaxPrice:   filter.MaxPrice,
+			Available:  filter.Available,
+		},
+	)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	products := []Product{}
+	for _, p := range r.Products {
+		products = append(products, Product{
+			ID:           p.Id,
+			Name:         p.Name,
+			Description:  p.Description,
+			Price:        p.Price,
+			Category:     p.Category,
+			ImageURL:     p.ImageUrl,
+			Tags:         p.Tags,
+			Availability: p.Availability,
+			Stock:        p.Stock,
+			Deleted:      p.Deleted,
+		})
+	}
+
+	facets := &Facets{Categories: []FacetBucket{}, Tags: []FacetBucket{}, PriceRanges: []PriceRangeBucket{}}
+	for _, b := range r.Facets.GetCategories() {
+		facets.Categories = append(facets.Categories, FacetBucket{Value: b.Value, Count: b.Count})
+	}
+	for _, b := range r.Facets.GetTags() {
+		facets.Tags = append(facets.Tags, FacetBucket{Value: b.Value, Count: b.Count})
+	}
+	for _, b := range r.Facets.GetPriceRanges() {
+		facets.PriceRanges = append(facets.PriceRanges, PriceRangeBucket{From: b.From, To: b.To, Count: b.Count})
+	}
+	return products, r.TotalCount, facets, nil
+}
+
+// SuggestProducts returns the product names and categories to offer for prefix, limit
+// is capped by the catalog and zero asks for its default.
+func (c *Client) SuggestProducts(ctx context.Context, prefix string, limit uint32) (*Suggestions, error) {
+	r, err := c.service.SuggestProducts(ctx, &pb.SuggestProductsRequest{Prefix: prefix, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	suggestions := &Suggestions{Products: []ProductSuggestion{}, Categories: []string{}}
+	for _, p := range r.Products {
+		suggestions.Products = append(suggestions.Products, ProductSuggestion{ID: p.Id, Name: p.Name})
+	}
+	suggestions.Categories = append(suggestions.Categories, r.Categories...)
+	return suggestions, nil
+}
+
 // GetProductsByIDs fetches products by their IDs
 func (c *Client) GetProductsById(ctx context.Context, ids []string) ([]Product, error) {
 	r, err := c.service.GetProductsById(
@@ -136,6 +206,7 @@ func (c *Client) GetProductsById(ctx context.Context, ids []string) ([]Product,
 			Tags:         p.Tags,
 			Availability: p.Availability,
 			Stock:        p.Stock,
+			Deleted:      p.Deleted,
 		})
 	}
 	return products, nil
@@ -176,5 +247,83 @@ func (c *Client) UpdateStock(ctx context.Context, id string, newStock int64) (*P
 		Tags:         r.Product.Tags,
 		Availability: r.Product.Availability,
 		Stock:        r.Product.Stock,
+		Deleted:      r.Product.Deleted,
+	}, nil
+}
+
+// UpdateProduct changes the fields of the product named in mask, see the ProductField constants.
+func (c *Client) UpdateProduct(ctx context.Context, id string, changes Product, mask []string) (*Product, error) {
+	r, err := c.service.UpdateProduct(
+		ctx,
+		&pb.UpdateProductRequest{
+			Id:          id,
+			Name:        changes.Name,
+			Description: changes.Description,
+			Price:       changes.Price,
+			Category:    changes.Category,
+			ImageUrl:    changes.ImageURL,
+			Tags:        changes.Tags,
+			UpdateMask:  mask,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Product{
+		ID:           r.Product.Id,
+		Name:         r.Product.Name,
+		Description:  r.Product.Description,
+		Price:        r.Product.Price,
+		Category:     r.Product.Category,
+		ImageURL:     r.Product.ImageUrl,
+		Tags:         r.Product.Tags,
+		Availability: r.Product.Availability,
+		Stock:        r.Product.Stock,
+		Deleted:      r.Product.Deleted,
 	}, nil
 }
+
+func (c *Client) DeleteProduct(ctx context.Context, id string, hard bool) error {
+	_, err := c.service.DeleteProduct(
+		ctx,
+		&pb.DeleteProductRequest{
+			Id:   id,
+			Hard: hard,
+		},
+	)
+	return err
+}
+
+// ReserveStock holds stock of all items for the caller, or fails without holding any.
+// The returned reservation has to be committed or released before it expires.
+func (c *Client) ReserveStock(ctx context.Context, items []ReservationItem) (*Reservation, error) {
+	req := &pb.ReserveStockRequest{}
+	for _, item := range items {
+		req.Items = append(req.Items, &pb.ReservationItem{ProductId: item.ProductID, Quantity: item.Quantity})
+	}
+	r, err := c.service.ReserveStock(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	reservation := &Reservation{
+		ID:     r.ReservationId,
+		Status: ReservationActive,
+		Items:  items,
+	}
+	if err := reservation.ExpiresAt.UnmarshalBinary(r.ExpiresAt); err != nil {
+		return nil, err
+	}
+	return reservation, nil
+}
+
+func (c *Client) CommitReservation(ctx context.Context, id string) error {
+	_, err := c.service.CommitReservation(ctx, &pb.CommitReservationRequest{ReservationId: id})
+	return err
+}
+
+func (c *Client) ReleaseReservation(ctx context.Context, id string) error {
+	_, err := c.service.ReleaseReservation(ctx, &pb.ReleaseReservationRequest{ReservationId: id})
+	return err
+}
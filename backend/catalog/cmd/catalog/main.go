@@ -1,16 +1,27 @@
 package main
 
 import (
+	"context"
 	"log"
 	"time"
 
+	"github.com/JonathanNithi/ecommerce/backend/account"
+	"github.com/JonathanNithi/ecommerce/backend/audit"
+	"github.com/JonathanNithi/ecommerce/backend/authz"
 	"github.com/JonathanNithi/ecommerce/backend/catalog"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/tinrab/retry"
 )
 
+// apiKeyCacheTTL bounds how long a revoked API key is still accepted
+const apiKeyCacheTTL = 30 * time.Second
+
 type Config struct {
 	DatabaseURL string `envconfig:"DATABASE_URL"`
+	AccountURL  string `envconfig:"ACCOUNT_SERVICE_URL"`
+	// Key set published by the gateway, tokens are verified without the account service's private keys
+	JWKSURL string `envconfig:"JWKS_URL"`
+	catalog.ReservationPolicy
 }
 
 func main() {
@@ -30,7 +41,25 @@ func main() {
 	})
 	defer r.Close()
 
+	var auditLog audit.Repository
+	retry.ForeverSleep(2*time.Second, func(_ int) (err error) {
+		auditLog, err = audit.NewElasticRepository(cfg.DatabaseURL)
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	})
+	defer auditLog.Close()
+
+	accountClient, err := account.NewClient(cfg.AccountURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer accountClient.Close()
+	verifier := authz.NewVerifier(authz.NewRemoteKeys(cfg.JWKSURL)).WithAPIKeys(authz.CacheAPIKeys(accountClient.AuthenticateAPIKey, apiKeyCacheTTL))
+
 	log.Println("Listening on port 8080...")
-	s := catalog.NewService(r)
-	log.Fatal(catalog.ListenGRPC(s, 8080))
+	s := catalog.NewService(r, auditLog, cfg.ReservationPolicy)
+	go catalog.RunReservationSweeper(context.Background(), s, cfg.SweepInterval)
+	log.Fatal(catalog.ListenGRPC(s, auditLog, verifier, 8080))
 }
@@ -19,15 +19,53 @@ var (
 	indexName   = "catalog"
 )
 
+const reservationIndexName = "catalog_reservations"
+
+// nameMapping is the mapping of product names, name.suggest backs SuggestProducts.
+// search_as_you_type is used over a completion field so deleted products can be left out.
+const nameMapping = `{
+	"type": "text",
+	"fields": {
+		"keyword": {
+			"type": "keyword",
+			"ignore_above": 256
+		},
+		"enum": {
+			"type": "keyword",
+			"ignore_above": 256
+		},
+		"suggest": {
+			"type": "search_as_you_type"
+		}
+	}
+}`
+
+// isDeleted matches soft deleted products, listings and searches exclude it with must_not.
+// Products indexed before soft deletes existed have no deleted field and are kept.
+var isDeleted = []map[string]interface{}{
+	{"term": map[string]interface{}{"deleted": true}},
+}
+
 type Repository interface {
 	Close()
 	PutProduct(ctx context.Context, p Product) error
 	GetProductByID(ctx context.Context, id string) (*Product, error)
 	ListProducts(ctx context.Context, skip uint64, take uint64, sort *pb.ProductSortInput) ([]Product, uint64, error)
 	ListProductsWithIDs(ctx context.Context, ids []string) ([]Product, error)
-	SearchProducts(ctx context.Context, query string, skip uint64, take uint64, category string, sort *pb.ProductSortInput) ([]Product, uint64, error)
+	SearchProducts(ctx context.Context, query string, filter ProductFilter, skip uint64, take uint64, sort *pb.ProductSortInput) ([]Product, uint64, Facets, error)
+	SuggestProducts(ctx context.Context, prefix string, limit uint32) (*Suggestions, error)
 	DeductStock(ctx context.Context, id string, newStock int64) error
 	UpdateStock(ctx context.Context, id string, newStock int64) error
+	UpdateProduct(ctx context.Context, p Product) error
+	SoftDeleteProduct(ctx context.Context, id string) error
+	DeleteProduct(ctx context.Context, id string) error
+	ReserveStock(ctx context.Context, productID string, reservationID string, quantity int64, expiresAt time.Time) error
+	CommitReservedStock(ctx context.Context, productID string, reservationID string) error
+	ReleaseReservedStock(ctx context.Context, productID string, reservationID string) error
+	CreateReservation(ctx context.Context, r Reservation) error
+	GetReservation(ctx context.Context, id string) (*Reservation, error)
+	SetReservationStatus(ctx context.Context, id string, from string, to string) error
+	ListExpiredReservations(ctx context.Context, now time.Time, limit int) ([]Reservation, error)
 }
 
 type elasticRepository struct {
@@ -43,6 +81,13 @@ type productDocument struct {
 	Tags         []string `json:"tags"`
 	Availability bool     `json:"availability"`
 	Stock        int64    `json:"stock"`
+	Deleted      bool     `json:"deleted,omitempty"`
+	// Stock held for reservations by reservation id, only kept in the source
+	Reservations map[string]stockReservation `json:"reservations,omitempty"`
+}
+
+func (d productDocument) stockLevel() stockLevel {
+	return stockLevel{Stock: d.Stock, Reservations: d.Reservations}
 }
 
 func NewElasticRepository(url string) (Repository, error) {
@@ -86,29 +131,19 @@ func NewElasticRepository(url string) (Repository, error) {
 			},
 			"mappings": {
 				"properties": {
-					"name": {
-						"type": "text",
-						"fields": {
-							"keyword": {
-								"type": "keyword",
-								"ignore_above": 256
-							},
-							"enum": {
-								"type": "keyword",
-								"ignore_above": 256
-							}
-						}
-					},
+					"name": %s,
 					"description": { "type": "text" },
 					"price": { "type": "float" },
 					"category": { "type": "keyword" },
 					"imageURL": { "type": "keyword" },
 					"tags": { "type": "keyword" },
 					"availability": { "type": "boolean" },
-					"stock": { "type": "integer" }
+					"stock": { "type": "integer" },
+					"deleted": { "type": "boolean" },
+					"reservations": { "type": "object", "enabled": false }
 				}
 			}
-		}`))
+		}`, nameMapping))
 
 		// Simple creation without specific mappings/settings:
 		createReq := esapi.IndicesCreateRequest{
@@ -135,54 +170,64 @@ func NewElasticRepository(url string) (Repository, error) {
 	} else {
 		// Index already exists
 		log.Printf("Index '%s' already exists.", indexName)
-	}
-
-	return &elasticRepository{client}, nil
-}
-
-func (r *elasticRepository) Close() {
-	// The Elasticsearch client does not require explicit closing.
-}
 
-func (r *elasticRepository) PutProduct(ctx context.Context, p Product) error {
-	// Step 1: Check for existing product with the same name (case-insensitive)
-	searchQuery := map[string]interface{}{
-		"query": map[string]interface{}{
-			"term": map[string]interface{}{
-				"name.enum": p.Name, // Normalize the query term
-			},
-		},
-		"size": 1,
+		// Indexes created before reservations would map every reservation id as a field,
+		// and ones created before suggestions lack name.suggest
+		mappingRes, err := esapi.IndicesPutMappingRequest{
+			Index: []string{indexName},
+			Body: strings.NewReader(fmt.Sprintf(`{"properties": {
+				"reservations": {"type": "object", "enabled": false},
+				"name": %s
+			}}`, nameMapping)),
+		}.Do(initCtx, client)
+		if err != nil {
+			return nil, fmt.Errorf("error updating index '%s' mapping: %w", indexName, err)
+		}
+		defer mappingRes.Body.Close()
+		if mappingRes.IsError() {
+			return nil, fmt.Errorf("error response updating index '%s' mapping: %s", indexName, mappingRes.String())
+		}
+		if err := reindexSuggestions(initCtx, client); err != nil {
+			return nil, err
+		}
 	}
 
-	queryJSON, err := json.Marshal(searchQuery)
-	if err != nil {
-		return fmt.Errorf("error marshaling search query: %v", err)
+	if err := createReservationIndex(initCtx, client); err != nil {
+		return nil, err
 	}
 
-	searchReq := esapi.SearchRequest{
-		Index: []string{"catalog"},
-		Body:  strings.NewReader(string(queryJSON)),
-	}
+	return &elasticRepository{client}, nil
+}
 
-	res, err := searchReq.Do(ctx, r.client)
+// reindexSuggestions starts reindexing the products indexed before name.suggest was mapped,
+// which are not suggested until then. It runs in the background, products changed meanwhile
+// are skipped since they were reindexed by the change.
+func reindexSuggestions(ctx context.Context, client *elasticsearch.Client) error {
+	waitForCompletion := false
+	res, err := esapi.UpdateByQueryRequest{
+		Index:             []string{indexName},
+		Body:              strings.NewReader(`{"query": {"bool": {"must_not": {"exists": {"field": "name.suggest"}}}}}`),
+		Conflicts:         "proceed",
+		WaitForCompletion: &waitForCompletion,
+	}.Do(ctx, client)
 	if err != nil {
-		return fmt.Errorf("error executing search request: %v", err)
+		return fmt.Errorf("error reindexing suggestions of index '%s': %w", indexName, err)
 	}
 	defer res.Body.Close()
-
 	if res.IsError() {
-		return fmt.Errorf("error searching for existing product: status=%s, response=%s", res.Status(), res.String())
+		return fmt.Errorf("error response reindexing suggestions of index '%s': %s", indexName, res.String())
 	}
+	return nil
+}
 
-	var result map[string]interface{}
-	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
-		return fmt.Errorf("error decoding search response: %v", err)
-	}
+func (r *elasticRepository) Close() {
+	// The Elasticsearch client does not require explicit closing.
+}
 
-	hits := result["hits"].(map[string]interface{})["hits"].([]interface{})
-	if len(hits) > 0 {
-		return fmt.Errorf("product with the same name '%s' already exists", p.Name)
+func (r *elasticRepository) PutProduct(ctx context.Context, p Product) error {
+	// Step 1: Check for existing product with the same name
+	if err := r.checkNameAvailable(ctx, p.Name, ""); err != nil {
+		return err
 	}
 
 	// Step 2: Index the new product
@@ -208,7 +253,7 @@ func (r *elasticRepository) PutProduct(ctx context.Context, p Product) error {
 		Refresh:    "true",
 	}
 
-	res, err = indexReq.Do(ctx, r.client)
+	res, err := indexReq.Do(ctx, r.client)
 	if err != nil {
 		return fmt.Errorf("error executing index request: %v", err)
 	}
@@ -251,10 +296,7 @@ func (r *elasticRepository) GetProductByID(ctx context.Context, id string) (*Pro
 		return nil, fmt.Errorf("error decoding response body: %v", err)
 	}
 
-	// Step 5: Log the product details (for debugging)
-	log.Println(getResponse.Source)
-
-	// Step 6: Map the productDocument to the Product struct
+	// Step 5: Map the productDocument to the Product struct
 	return &Product{
 		ID:           id,
 		Name:         getResponse.Source.Name,
@@ -264,7 +306,8 @@ func (r *elasticRepository) GetProductByID(ctx context.Context, id string) (*Pro
 		ImageURL:     getResponse.Source.ImageURL,
 		Tags:         getResponse.Source.Tags,
 		Availability: getResponse.Source.Availability,
-		Stock:        getResponse.Source.Stock,
+		Stock:        getResponse.Source.stockLevel().available(time.Now()),
+		Deleted:      getResponse.Source.Deleted,
 	}, nil
 }
 
@@ -273,7 +316,9 @@ func (r *elasticRepository) ListProducts(ctx context.Context, skip, take uint64,
 		"from": skip,
 		"size": take,
 		"query": map[string]interface{}{
-			"match_all": map[string]interface{}{},
+			"bool": map[string]interface{}{
+				"must_not": isDeleted,
+			},
 		},
 	}
 	if sort != nil {
@@ -357,7 +402,8 @@ func (r *elasticRepository) ListProducts(ctx context.Context, skip, take uint64,
 			ImageURL:     p.ImageURL,
 			Tags:         p.Tags,
 			Availability: p.Availability,
-			Stock:        p.Stock,
+			Stock:        p.stockLevel().available(time.Now()),
+			Deleted:      p.Deleted,
 		})
 	}
 
@@ -421,168 +467,322 @@ func (r *elasticRepository) ListProductsWithIDs(ctx context.Context, ids []strin
 			ImageURL:     p.ImageURL,
 			Tags:         p.Tags,
 			Availability: p.Availability,
-			Stock:        p.Stock,
+			Stock:        p.stockLevel().available(time.Now()),
+			Deleted:      p.Deleted,
 		})
 	}
 
 	return products, nil
 }
 
-func (r *elasticRepository) SearchProducts(ctx context.Context, query string, skip, take uint64, category string, sort *pb.ProductSortInput) ([]Product, uint64, error) {
-	searchQuery := map[string]interface{}{
-		"from": skip,
-		"size": take,
-		"query": map[string]interface{}{
-			"bool": map[string]interface{}{
-				"must":   []map[string]interface{}{}, // Initialize as an empty slice
-				"filter": []map[string]interface{}{}, // Initialize as an empty slice for filters
+// SearchProducts returns a page of the products matching query and filter, how many match
+// in total and the facets of the matching products.
+func (r *elasticRepository) SearchProducts(ctx context.Context, query string, filter ProductFilter, skip, take uint64, sort *pb.ProductSortInput) ([]Product, uint64, Facets, error) {
+	queryJSON, err := json.Marshal(searchQuery(query, filter, skip, take, sort))
+	if err != nil {
+		return nil, 0, Facets{}, err
+	}
+
+	req := esapi.SearchRequest{
+		Index: []string{"catalog"},
+		Body:  strings.NewReader(string(queryJSON)),
+	}
+
+	res, err := req.Do(ctx, r.client)
+	if err != nil {
+		return nil, 0, Facets{}, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, 0, Facets{}, fmt.Errorf("error searching documents: %s", res.String())
+	}
+
+	var result struct {
+		Hits struct {
+			Total struct {
+				Value uint64 `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				ID     string          `json:"_id"`
+				Source productDocument `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+		Aggregations json.RawMessage `json:"aggregations"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, 0, Facets{}, err
+	}
+
+	products := []Product{}
+	for _, hit := range result.Hits.Hits {
+		p := hit.Source
+		products = append(products, Product{
+			ID:           hit.ID,
+			Name:         p.Name,
+			Description:  p.Description,
+			Price:        p.Price,
+			Category:     p.Category,
+			ImageURL:     p.ImageURL,
+			Tags:         p.Tags,
+			Availability: p.Availability,
+			Stock:        p.stockLevel().available(time.Now()),
+			Deleted:      p.Deleted,
+		})
+	}
+
+	facets, err := parseFacets(result.Aggregations)
+	if err != nil {
+		return nil, 0, Facets{}, err
+	}
+	return products, result.Hits.Total.Value, facets, nil
+}
+
+func (r *elasticRepository) SuggestProducts(ctx context.Context, prefix string, limit uint32) (*Suggestions, error) {
+	queryJSON, err := json.Marshal(suggestQuery(prefix, limit))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := esapi.SearchRequest{
+		Index: []string{indexName},
+		Body:  strings.NewReader(string(queryJSON)),
+	}.Do(ctx, r.client)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error suggesting products: %s", res.String())
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				ID     string `json:"_id"`
+				Source struct {
+					Name string `json:"name"`
+				} `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+		Aggregations struct {
+			Categories struct {
+				Matching termsFacet `json:"matching"`
+			} `json:"categories"`
+		} `json:"aggregations"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	suggestions := &Suggestions{Products: []ProductSuggestion{}, Categories: []string{}}
+	for _, hit := range result.Hits.Hits {
+		suggestions.Products = append(suggestions.Products, ProductSuggestion{ID: hit.ID, Name: hit.Source.Name})
+	}
+	for _, b := range result.Aggregations.Categories.Matching.buckets() {
+		suggestions.Categories = append(suggestions.Categories, b.Value)
+	}
+	return suggestions, nil
+}
+
+// DeductStock takes quantity off the product's stock, failing with ErrInsufficientStock
+// rather than letting it go negative.
+func (r *elasticRepository) DeductStock(ctx context.Context, id string, quantity int64) error {
+	return changeStock(ctx, r, id, deductQuantity(quantity))
+}
+
+// UpdateStock adds change, which may be negative, to the product's stock.
+func (r *elasticRepository) UpdateStock(ctx context.Context, id string, change int64) error {
+	return changeStock(ctx, r, id, addStock(change))
+}
+
+// readStock returns the stock level of the product together with the version of the document it was read from.
+func (r *elasticRepository) readStock(ctx context.Context, id string) (stockLevel, stockVersion, error) {
+	req := esapi.GetRequest{
+		Index:      "catalog",
+		DocumentID: id,
+	}
+
+	res, err := req.Do(ctx, r.client)
+	if err != nil {
+		return stockLevel{}, stockVersion{}, fmt.Errorf("error retrieving product: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		if res.StatusCode == 404 {
+			return stockLevel{}, stockVersion{}, fmt.Errorf("product with ID %s: %w", id, ErrNotFound)
+		}
+		return stockLevel{}, stockVersion{}, fmt.Errorf("error retrieving product: %s", res.String())
+	}
+
+	var getResponse struct {
+		SeqNo       int             `json:"_seq_no"`
+		PrimaryTerm int             `json:"_primary_term"`
+		Source      productDocument `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&getResponse); err != nil {
+		return stockLevel{}, stockVersion{}, fmt.Errorf("error decoding response body: %v", err)
+	}
+	if getResponse.Source.Deleted {
+		return stockLevel{}, stockVersion{}, fmt.Errorf("product with ID %s: %w", id, ErrNotFound)
+	}
+
+	return getResponse.Source.stockLevel(), stockVersion{getResponse.SeqNo, getResponse.PrimaryTerm}, nil
+}
+
+// writeStock sets the stock level of the product unless its document changed since version was read.
+// The reservations are replaced by a script since a partial update would merge them.
+func (r *elasticRepository) writeStock(ctx context.Context, id string, level stockLevel, version stockVersion) error {
+	reservations := level.Reservations
+	if reservations == nil {
+		reservations = map[string]stockReservation{}
+	}
+	updatePayloadJSON, err := json.Marshal(map[string]interface{}{
+		"script": map[string]interface{}{
+			"source": "ctx._source.stock = params.stock; ctx._source.reservations = params.reservations; ctx._source.availability = params.availability",
+			"params": map[string]interface{}{
+				"stock":        level.Stock,
+				"reservations": reservations,
+				"availability": level.available(time.Now()) > 0,
 			},
 		},
+	})
+	if err != nil {
+		return fmt.Errorf("error marshaling update payload: %v", err)
 	}
 
-	if query != "" {
-		searchQuery["query"].(map[string]interface{})["bool"].(map[string]interface{})["must"] = append(
-			searchQuery["query"].(map[string]interface{})["bool"].(map[string]interface{})["must"].([]map[string]interface{}),
-			map[string]interface{}{
-				"multi_match": map[string]interface{}{
-					"query":  query,
-					"fields": []string{"name", "description"},
-				},
-			},
-		)
+	updateReq := esapi.UpdateRequest{
+		Index:         "catalog",
+		DocumentID:    id,
+		Body:          strings.NewReader(string(updatePayloadJSON)),
+		IfSeqNo:       &version.seqNo,
+		IfPrimaryTerm: &version.primaryTerm,
+		Refresh:       "true", // Ensure the change is immediately visible
 	}
 
-	if category != "" {
-		searchQuery["query"].(map[string]interface{})["bool"].(map[string]interface{})["filter"] = append(
-			searchQuery["query"].(map[string]interface{})["bool"].(map[string]interface{})["filter"].([]map[string]interface{}),
-			map[string]interface{}{
-				"term": map[string]interface{}{
-					"category": category,
-				},
-			},
-		)
+	res, err := updateReq.Do(ctx, r.client)
+	if err != nil {
+		return fmt.Errorf("error executing update request: %v", err)
 	}
+	defer res.Body.Close()
 
-	if sort != nil {
-		sortField := ""
-		switch sort.Field {
-		case pb.ProductSortField_NAME:
-			sortField = "name.keyword"
-		case pb.ProductSortField_PRICE:
-			sortField = "price"
-		default:
-			sortField = "_id" // Default sorting
+	if res.IsError() {
+		if res.StatusCode == 409 {
+			return errVersionConflict
 		}
+		return fmt.Errorf("error updating product stock: status=%s, response=%s", res.Status(), res.String())
+	}
 
-		sortDirection := "asc"
-		if sort.Direction == pb.SortDirection_DESC {
-			sortDirection = "desc"
-		}
+	return nil
+}
 
-		searchQuery["sort"] = []map[string]interface{}{
-			{
-				sortField: map[string]interface{}{
-					"order": sortDirection,
+// checkNameAvailable returns an error when a product other than the one with id, which is empty
+// for new products, is named name. Soft deleted products give up their names.
+func (r *elasticRepository) checkNameAvailable(ctx context.Context, name string, id string) error {
+	mustNot := append([]map[string]interface{}{}, isDeleted...)
+	if id != "" {
+		mustNot = append(mustNot, map[string]interface{}{
+			"ids": map[string]interface{}{"values": []string{id}},
+		})
+	}
+	searchQuery := map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []map[string]interface{}{
+					{"term": map[string]interface{}{"name.enum": name}},
 				},
+				"must_not": mustNot,
 			},
-		}
+		},
+		"size": 1,
 	}
 
 	queryJSON, err := json.Marshal(searchQuery)
 	if err != nil {
-		return nil, 0, err
+		return fmt.Errorf("error marshaling search query: %v", err)
 	}
 
-	req := esapi.SearchRequest{
+	searchReq := esapi.SearchRequest{
 		Index: []string{"catalog"},
 		Body:  strings.NewReader(string(queryJSON)),
 	}
 
-	res, err := req.Do(ctx, r.client)
+	res, err := searchReq.Do(ctx, r.client)
 	if err != nil {
-		return nil, 0, err
+		return fmt.Errorf("error executing search request: %v", err)
 	}
 	defer res.Body.Close()
 
 	if res.IsError() {
-		return nil, 0, fmt.Errorf("error searching documents: %s", res.String())
+		return fmt.Errorf("error searching for existing product: status=%s, response=%s", res.Status(), res.String())
 	}
 
 	var result map[string]interface{}
 	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
-		return nil, 0, err
+		return fmt.Errorf("error decoding search response: %v", err)
 	}
 
-	products := []Product{}
-	hits := result["hits"].(map[string]interface{})
-	hitsArray := hits["hits"].([]interface{})
-	totalHits := uint64(hits["total"].(map[string]interface{})["value"].(float64)) // Extract total count
-
-	for _, hit := range hitsArray {
-		source := hit.(map[string]interface{})["_source"]
-		sourceJSON, err := json.Marshal(source)
-		if err != nil {
-			return nil, 0, err
-		}
-
-		var p productDocument
-		if err := json.Unmarshal(sourceJSON, &p); err != nil {
-			return nil, 0, err
-		}
+	hits := result["hits"].(map[string]interface{})["hits"].([]interface{})
+	if len(hits) > 0 {
+		return fmt.Errorf("product with the same name '%s' already exists", name)
+	}
+	return nil
+}
 
-		products = append(products, Product{
-			ID:           hit.(map[string]interface{})["_id"].(string),
-			Name:         p.Name,
-			Description:  p.Description,
-			Price:        p.Price,
-			Category:     p.Category,
-			ImageURL:     p.ImageURL,
-			Tags:         p.Tags,
-			Availability: p.Availability,
-			Stock:        p.Stock,
-		})
+// UpdateProduct replaces the descriptive fields of p, stock and availability are left to the stock methods.
+func (r *elasticRepository) UpdateProduct(ctx context.Context, p Product) error {
+	if err := r.checkNameAvailable(ctx, p.Name, p.ID); err != nil {
+		return err
 	}
+	return r.updateFields(ctx, p.ID, map[string]interface{}{
+		"name":        p.Name,
+		"description": p.Description,
+		"price":       p.Price,
+		"category":    p.Category,
+		"image_url":   p.ImageURL,
+		"tags":        p.Tags,
+	})
+}
 
-	return products, totalHits, nil // Return products and total count
+// SoftDeleteProduct hides the product from listings while orders can still show its details.
+func (r *elasticRepository) SoftDeleteProduct(ctx context.Context, id string) error {
+	return r.updateFields(ctx, id, map[string]interface{}{
+		"deleted": true,
+	})
 }
 
-func (r *elasticRepository) DeductStock(ctx context.Context, id string, quantity int64) error {
-	// Step 1: Get the existing product by ID
-	product, err := r.GetProductByID(ctx, id)
-	if err != nil {
-		if err == ErrNotFound {
-			return fmt.Errorf("product with ID %s not found", id)
-		}
-		return fmt.Errorf("error retrieving product: %v", err)
+func (r *elasticRepository) DeleteProduct(ctx context.Context, id string) error {
+	deleteReq := esapi.DeleteRequest{
+		Index:      "catalog",
+		DocumentID: id,
+		Refresh:    "true",
 	}
 
-	// Step 2: Check if the requested quantity exceeds the available stock
-	if quantity > product.Stock {
-		return fmt.Errorf("no stock. required quantity %d exceeds available stock %d. try again later", quantity, product.Stock)
+	res, err := deleteReq.Do(ctx, r.client)
+	if err != nil {
+		return fmt.Errorf("error executing delete request: %v", err)
 	}
+	defer res.Body.Close()
 
-	// Step 3: Calculate the new stock and update availability
-	newStock := product.Stock - quantity
-	availability := true
-	if newStock == 0 {
-		availability = false
+	if res.IsError() {
+		if res.StatusCode == 404 {
+			return ErrNotFound
+		}
+		return fmt.Errorf("error deleting product: status=%s, response=%s", res.Status(), res.String())
 	}
 
-	// Step 4: Prepare the update payload
-	updatePayload := map[string]interface{}{
-		"doc": map[string]interface{}{
-			"stock":        newStock,
-			"availability": availability,
-		},
-	}
+	return nil
+}
 
-	// Step 5: Marshal the update payload to JSON
-	updatePayloadJSON, err := json.Marshal(updatePayload)
+// updateFields partially updates the product with id, returning ErrNotFound if there is none.
+func (r *elasticRepository) updateFields(ctx context.Context, id string, fields map[string]interface{}) error {
+	updatePayloadJSON, err := json.Marshal(map[string]interface{}{"doc": fields})
 	if err != nil {
 		return fmt.Errorf("error marshaling update payload: %v", err)
 	}
 
-	// Step 6: Perform a partial update using UpdateRequest
 	updateReq := esapi.UpdateRequest{
 		Index:      "catalog",
 		DocumentID: id,
@@ -597,59 +797,236 @@ func (r *elasticRepository) DeductStock(ctx context.Context, id string, quantity
 	defer res.Body.Close()
 
 	if res.IsError() {
+		if res.StatusCode == 404 {
+			return ErrNotFound
+		}
 		return fmt.Errorf("error updating product: status=%s, response=%s", res.Status(), res.String())
 	}
 
 	return nil
 }
 
-func (r *elasticRepository) UpdateStock(ctx context.Context, id string, newStock int64) error {
-	// Step 1: Get the current product by ID
-	product, err := r.GetProductByID(ctx, id)
+func (r *elasticRepository) ReserveStock(ctx context.Context, productID string, reservationID string, quantity int64, expiresAt time.Time) error {
+	return changeStock(ctx, r, productID, reserveQuantity(reservationID, quantity, expiresAt))
+}
+
+func (r *elasticRepository) CommitReservedStock(ctx context.Context, productID string, reservationID string) error {
+	return changeStock(ctx, r, productID, commitQuantity(reservationID))
+}
+
+func (r *elasticRepository) ReleaseReservedStock(ctx context.Context, productID string, reservationID string) error {
+	return changeStock(ctx, r, productID, releaseQuantity(reservationID))
+}
+
+type reservationDocument struct {
+	Owner     string            `json:"owner"`
+	Status    string            `json:"status"`
+	Items     []ReservationItem `json:"items"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+func (d reservationDocument) reservation(id string) Reservation {
+	return Reservation{
+		ID:        id,
+		Owner:     d.Owner,
+		Status:    d.Status,
+		Items:     d.Items,
+		ExpiresAt: d.ExpiresAt,
+		CreatedAt: d.CreatedAt,
+	}
+}
+
+// createReservationIndex makes sure the index of reservations exists.
+func createReservationIndex(ctx context.Context, client *elasticsearch.Client) error {
+	res, err := esapi.IndicesExistsRequest{Index: []string{reservationIndexName}}.Do(ctx, client)
 	if err != nil {
-		if err == ErrNotFound {
-			return fmt.Errorf("product with ID %s not found", id)
+		return fmt.Errorf("error checking if index '%s' exists: %w", reservationIndexName, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 404 {
+		createRes, err := esapi.IndicesCreateRequest{
+			Index: reservationIndexName,
+			Body: strings.NewReader(`{
+				"settings": {
+					"number_of_shards": 1,
+					"number_of_replicas": 0
+				},
+				"mappings": {
+					"properties": {
+						"owner": { "type": "keyword" },
+						"status": { "type": "keyword" },
+						"items": { "type": "object", "enabled": false },
+						"expires_at": { "type": "date" },
+						"created_at": { "type": "date" }
+					}
+				}
+			}`),
+		}.Do(ctx, client)
+		if err != nil {
+			return fmt.Errorf("error creating index '%s': %w", reservationIndexName, err)
+		}
+		defer createRes.Body.Close()
+		if createRes.IsError() {
+			return fmt.Errorf("error response during index '%s' creation: %s", reservationIndexName, createRes.String())
 		}
-		return fmt.Errorf("error retrieving product: %v", err)
+	} else if res.IsError() {
+		return fmt.Errorf("error checking index '%s' existence: %s", reservationIndexName, res.String())
 	}
+	return nil
+}
 
-	// Step 2: Calculate the new stock
-	updatedStock := product.Stock + newStock
+func (r *elasticRepository) CreateReservation(ctx context.Context, reservation Reservation) error {
+	docJSON, err := json.Marshal(reservationDocument{
+		Owner:     reservation.Owner,
+		Status:    reservation.Status,
+		Items:     reservation.Items,
+		ExpiresAt: reservation.ExpiresAt,
+		CreatedAt: reservation.CreatedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("error marshaling reservation document: %v", err)
+	}
 
-	// Step 3: Determine the new availability
-	availability := updatedStock > 0
+	res, err := esapi.CreateRequest{
+		Index:      reservationIndexName,
+		DocumentID: reservation.ID,
+		Body:       strings.NewReader(string(docJSON)),
+		Refresh:    "true",
+	}.Do(ctx, r.client)
+	if err != nil {
+		return fmt.Errorf("error executing create request: %v", err)
+	}
+	defer res.Body.Close()
 
-	// Step 4: Prepare the update payload
-	updatePayload := map[string]interface{}{
-		"doc": map[string]interface{}{
-			"stock":        updatedStock,
-			"availability": availability,
-		},
+	if res.IsError() {
+		return fmt.Errorf("error creating reservation: status=%s, response=%s", res.Status(), res.String())
 	}
+	return nil
+}
+
+func (r *elasticRepository) GetReservation(ctx context.Context, id string) (*Reservation, error) {
+	res, err := esapi.GetRequest{
+		Index:      reservationIndexName,
+		DocumentID: id,
+	}.Do(ctx, r.client)
+	if err != nil {
+		return nil, fmt.Errorf("error executing GetRequest: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		if res.StatusCode == 404 {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("error fetching reservation: %s", res.String())
+	}
+
+	var getResponse struct {
+		Source reservationDocument `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&getResponse); err != nil {
+		return nil, fmt.Errorf("error decoding response body: %v", err)
+	}
+	reservation := getResponse.Source.reservation(id)
+	return &reservation, nil
+}
 
-	// Step 5: Marshal the update payload to JSON
-	updatePayloadJSON, err := json.Marshal(updatePayload)
+// SetReservationStatus moves the reservation from status from to status to. A script checks
+// from so that of two concurrent changes only one succeeds, the other gets ErrReservationChanged.
+func (r *elasticRepository) SetReservationStatus(ctx context.Context, id string, from string, to string) error {
+	updatePayloadJSON, err := json.Marshal(map[string]interface{}{
+		"script": map[string]interface{}{
+			"source": "if (ctx._source.status == params.from) { ctx._source.status = params.to } else { ctx.op = 'noop' }",
+			"params": map[string]interface{}{
+				"from": from,
+				"to":   to,
+			},
+		},
+	})
 	if err != nil {
 		return fmt.Errorf("error marshaling update payload: %v", err)
 	}
 
-	// Step 6: Perform a partial update using UpdateRequest
-	updateReq := esapi.UpdateRequest{
-		Index:      "catalog",
+	res, err := esapi.UpdateRequest{
+		Index:      reservationIndexName,
 		DocumentID: id,
 		Body:       strings.NewReader(string(updatePayloadJSON)),
-		Refresh:    "true", // Ensure the change is immediately visible
-	}
-
-	res, err := updateReq.Do(ctx, r.client)
+		Refresh:    "true",
+	}.Do(ctx, r.client)
 	if err != nil {
 		return fmt.Errorf("error executing update request: %v", err)
 	}
 	defer res.Body.Close()
 
 	if res.IsError() {
-		return fmt.Errorf("error updating product stock: status=%s, response=%s", res.Status(), res.String())
+		if res.StatusCode == 404 {
+			return ErrNotFound
+		}
+		return fmt.Errorf("error updating reservation: status=%s, response=%s", res.Status(), res.String())
 	}
 
+	var updateResponse struct {
+		Result string `json:"result"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&updateResponse); err != nil {
+		return fmt.Errorf("error decoding response body: %v", err)
+	}
+	if updateResponse.Result == "noop" {
+		return ErrReservationChanged
+	}
 	return nil
 }
+
+// ListExpiredReservations returns up to limit active reservations that expired before now, oldest first.
+func (r *elasticRepository) ListExpiredReservations(ctx context.Context, now time.Time, limit int) ([]Reservation, error) {
+	queryJSON, err := json.Marshal(map[string]interface{}{
+		"size": limit,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []map[string]interface{}{
+					{"term": map[string]interface{}{"status": ReservationActive}},
+					{"range": map[string]interface{}{"expires_at": map[string]interface{}{"lt": now}}},
+				},
+			},
+		},
+		"sort": []map[string]interface{}{
+			{"expires_at": map[string]interface{}{"order": "asc"}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := esapi.SearchRequest{
+		Index: []string{reservationIndexName},
+		Body:  strings.NewReader(string(queryJSON)),
+	}.Do(ctx, r.client)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("error searching reservations: %s", res.String())
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				ID     string              `json:"_id"`
+				Source reservationDocument `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	reservations := []Reservation{}
+	for _, hit := range result.Hits.Hits {
+		reservations = append(reservations, hit.Source.reservation(hit.ID))
+	}
+	return reservations, nil
+}
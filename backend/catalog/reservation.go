@@ -0,0 +1,286 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/JonathanNithi/ecommerce/backend/authz"
+	"github.com/segmentio/ksuid"
+)
+
+// Statuses of a reservation. Active reservations hold stock until they are committed,
+// released or expire; the other statuses are final.
+const (
+	ReservationActive    = "active"
+	ReservationCommitted = "committed"
+	ReservationReleased  = "released"
+	ReservationExpired   = "expired"
+)
+
+// sweepBatchSize is how many expired reservations a sweep releases at most
+const sweepBatchSize = 100
+
+var (
+	ErrEmptyReservation     = errors.New("a reservation needs at least one product")
+	ErrInvalidQuantity      = errors.New("quantity must be positive")
+	ErrReservationExpired   = errors.New("reservation has expired")
+	ErrReservationReleased  = errors.New("reservation has been released")
+	ErrReservationCommitted = errors.New("reservation has already been committed")
+	ErrReservationChanged   = errors.New("reservation was committed or released concurrently")
+)
+
+// ReservationPolicy is how long reservations hold stock and how often expired ones are released.
+type ReservationPolicy struct {
+	TTL           time.Duration `envconfig:"STOCK_RESERVATION_TTL" default:"10m"`
+	SweepInterval time.Duration `envconfig:"STOCK_RESERVATION_SWEEP_INTERVAL" default:"1m"`
+}
+
+// withDefaults fills in the zero fields so a zero ReservationPolicy behaves like the configured default.
+func (p ReservationPolicy) withDefaults() ReservationPolicy {
+	if p.TTL == 0 {
+		p.TTL = 10 * time.Minute
+	}
+	if p.SweepInterval == 0 {
+		p.SweepInterval = time.Minute
+	}
+	return p
+}
+
+type ReservationItem struct {
+	ProductID string `json:"product_id"`
+	Quantity  int64  `json:"quantity"`
+}
+
+// Reservation holds stock of several products for Owner, the user that reserved it,
+// so that an order either gets all of them or none.
+type Reservation struct {
+	ID        string
+	Owner     string
+	Status    string
+	Items     []ReservationItem
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// ReserveStock holds the quantities of all items or, if any product has too little stock
+// available, of none of them. The stock is held until the reservation is committed or
+// released, or it expires after the policy's TTL.
+func (s *catalogService) ReserveStock(ctx context.Context, items []ReservationItem) (*Reservation, error) {
+	claims := authz.ClaimsFromContext(ctx)
+	if claims == nil {
+		return nil, authz.ErrUnauthenticated
+	}
+	items, err := mergeReservationItems(items)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	reservation := &Reservation{
+		ID:        ksuid.New().String(),
+		Owner:     claims.Username,
+		Status:    ReservationActive,
+		Items:     items,
+		ExpiresAt: now.Add(s.reservations.TTL),
+		CreatedAt: now,
+	}
+	// Stored first so the sweeper releases whatever is held should reserving be interrupted
+	if err := s.repository.CreateReservation(ctx, *reservation); err != nil {
+		return nil, err
+	}
+
+	for i, item := range items {
+		err := s.repository.ReserveStock(ctx, item.ProductID, reservation.ID, item.Quantity, reservation.ExpiresAt)
+		if err != nil {
+			s.abandonReservation(ctx, reservation.ID, items[:i])
+			return nil, fmt.Errorf("failed to reserve product %s: %w", item.ProductID, err)
+		}
+	}
+	return reservation, nil
+}
+
+// abandonReservation releases the items reserved before another could not be. Failures
+// are only logged, the sweeper releases the stock once the reservation expires.
+func (s *catalogService) abandonReservation(ctx context.Context, id string, reserved []ReservationItem) {
+	ctx = context.WithoutCancel(ctx)
+	if err := s.repository.SetReservationStatus(ctx, id, ReservationActive, ReservationReleased); err != nil {
+		log.Printf("Error releasing reservation %s: %v", id, err)
+		return
+	}
+	if err := s.releaseItems(ctx, id, reserved); err != nil {
+		log.Printf("Error releasing reservation %s: %v", id, err)
+	}
+}
+
+// CommitReservation turns the stock held by the reservation into a deduction. Committing
+// again finishes a commit that failed part way. Should a product's stock have gone to
+// others because the reservation expired while committing, the commit is reverted and
+// the reservation released.
+func (s *catalogService) CommitReservation(ctx context.Context, id string) error {
+	reservation, err := s.reservationForCaller(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	switch reservation.Status {
+	case ReservationActive:
+		if !time.Now().Before(reservation.ExpiresAt) {
+			return ErrReservationExpired
+		}
+		if err := s.repository.SetReservationStatus(ctx, id, ReservationActive, ReservationCommitted); err != nil {
+			return err
+		}
+	case ReservationCommitted:
+	case ReservationExpired:
+		return ErrReservationExpired
+	default:
+		return ErrReservationReleased
+	}
+
+	for i, item := range reservation.Items {
+		if err := s.repository.CommitReservedStock(ctx, item.ProductID, id); err != nil {
+			if errors.Is(err, ErrReservationExpired) {
+				s.revertCommit(ctx, id, reservation.Items, reservation.Items[:i])
+			}
+			return fmt.Errorf("failed to commit reserved stock of product %s: %w", item.ProductID, err)
+		}
+	}
+	return nil
+}
+
+// revertCommit returns the stock of the committed items and releases the others, so that
+// a commit that cannot finish deducts nothing. Failures are only logged.
+func (s *catalogService) revertCommit(ctx context.Context, id string, items []ReservationItem, committed []ReservationItem) {
+	ctx = context.WithoutCancel(ctx)
+	if err := s.repository.SetReservationStatus(ctx, id, ReservationCommitted, ReservationReleased); err != nil {
+		log.Printf("Error reverting commit of reservation %s: %v", id, err)
+		return
+	}
+	for _, item := range committed {
+		if err := s.repository.UpdateStock(ctx, item.ProductID, item.Quantity); err != nil {
+			log.Printf("Error returning stock of product %s to reservation %s: %v", item.ProductID, id, err)
+		}
+	}
+	if err := s.releaseItems(ctx, id, items); err != nil {
+		log.Printf("Error reverting commit of reservation %s: %v", id, err)
+	}
+}
+
+// ReleaseReservation returns the stock held by the reservation. Releasing a reservation
+// that was already released or expired is not an error.
+func (s *catalogService) ReleaseReservation(ctx context.Context, id string) error {
+	reservation, err := s.reservationForCaller(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	switch reservation.Status {
+	case ReservationActive:
+		if err := s.repository.SetReservationStatus(ctx, id, ReservationActive, ReservationReleased); err != nil {
+			return err
+		}
+	case ReservationCommitted:
+		return ErrReservationCommitted
+	}
+	return s.releaseItems(ctx, id, reservation.Items)
+}
+
+// ReleaseExpiredReservations releases a batch of reservations that expired while active
+// and returns how many it released.
+func (s *catalogService) ReleaseExpiredReservations(ctx context.Context) (int, error) {
+	reservations, err := s.repository.ListExpiredReservations(ctx, time.Now().UTC(), sweepBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for _, r := range reservations {
+		err := s.repository.SetReservationStatus(ctx, r.ID, ReservationActive, ReservationExpired)
+		if errors.Is(err, ErrReservationChanged) {
+			// Committed or released since it was listed
+			continue
+		}
+		if err != nil {
+			return released, err
+		}
+		if err := s.releaseItems(ctx, r.ID, r.Items); err != nil {
+			return released, err
+		}
+		released++
+	}
+	return released, nil
+}
+
+// RunReservationSweeper releases expired reservations every interval until ctx is cancelled.
+// Expired reservations stop holding stock right away, the sweeper tidies up after them.
+func RunReservationSweeper(ctx context.Context, s Service, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			released, err := s.ReleaseExpiredReservations(ctx)
+			if err != nil {
+				log.Println("Error releasing expired reservations: ", err)
+			}
+			if released > 0 {
+				log.Printf("Released %d expired reservations", released)
+			}
+		}
+	}
+}
+
+func (s *catalogService) releaseItems(ctx context.Context, id string, items []ReservationItem) error {
+	for _, item := range items {
+		err := s.repository.ReleaseReservedStock(ctx, item.ProductID, id)
+		// Nothing is held for products deleted in the meantime
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("failed to release reserved stock of product %s: %w", item.ProductID, err)
+		}
+	}
+	return nil
+}
+
+// reservationForCaller returns the reservation if the caller made it or may adjust inventory.
+func (s *catalogService) reservationForCaller(ctx context.Context, id string) (*Reservation, error) {
+	claims := authz.ClaimsFromContext(ctx)
+	if claims == nil {
+		return nil, authz.ErrUnauthenticated
+	}
+	reservation, err := s.repository.GetReservation(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if reservation.Owner != claims.Username && !claims.Can(authz.InventoryAdjust) {
+		return nil, authz.ErrUnauthorized
+	}
+	return reservation, nil
+}
+
+// mergeReservationItems adds up the quantities of products listed more than once, since a
+// reservation holds a single quantity per product.
+func mergeReservationItems(items []ReservationItem) ([]ReservationItem, error) {
+	if len(items) == 0 {
+		return nil, ErrEmptyReservation
+	}
+	quantities := map[string]int64{}
+	for _, item := range items {
+		if item.Quantity <= 0 {
+			return nil, fmt.Errorf("%w, got %d for product %s", ErrInvalidQuantity, item.Quantity, item.ProductID)
+		}
+		quantities[item.ProductID] += item.Quantity
+	}
+
+	merged := make([]ReservationItem, 0, len(quantities))
+	for id, quantity := range quantities {
+		merged = append(merged, ReservationItem{ProductID: id, Quantity: quantity})
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].ProductID < merged[j].ProductID })
+	return merged, nil
+}
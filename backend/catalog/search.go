@@ -0,0 +1,222 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/JonathanNithi/ecommerce/backend/catalog/pb"
+)
+
+const facetSize = 50
+
+// priceRangeBounds split prices into the ranges counted by Facets.PriceRanges
+var priceRangeBounds = []float64{25, 50, 100, 250}
+
+var ErrInvalidPriceRange = errors.New("minimum price cannot be above maximum price")
+
+// ProductFilter narrows a search. Any of the categories or tags given matches, products
+// must match every filter that is set.
+type ProductFilter struct {
+	Categories []string
+	Tags       []string
+	MinPrice   *float64
+	MaxPrice   *float64
+	// Available matches the availability stored with the product, which is recomputed
+	// whenever its stock or reservations are written. Reservations are not indexed, so a
+	// product whose last stock was held by a reservation that expired still counts as
+	// unavailable until the reservation sweeper releases it, usually within a sweep interval.
+	Available *bool
+}
+
+// Facets count the products matching a search by the values shoppers can filter on. Each
+// facet ignores its own filter, so choosing a category still shows what the others hold.
+type Facets struct {
+	Categories  []FacetBucket
+	Tags        []FacetBucket
+	PriceRanges []PriceRangeBucket
+}
+
+type FacetBucket struct {
+	Value string
+	Count uint64
+}
+
+// PriceRangeBucket counts the products priced from From up to but excluding To, either
+// of which is nil for the open ended ranges.
+type PriceRangeBucket struct {
+	From  *float64
+	To    *float64
+	Count uint64
+}
+
+// searchQuery builds the Elasticsearch request of a search. Filters a facet counts by are
+// applied as a post filter so that the facet's aggregation can leave its own filter out.
+func searchQuery(query string, filter ProductFilter, skip uint64, take uint64, sort *pb.ProductSortInput) map[string]interface{} {
+	must := []map[string]interface{}{}
+	if query != "" {
+		must = append(must, map[string]interface{}{
+			"multi_match": map[string]interface{}{
+				"query":  query,
+				"fields": []string{"name", "description"},
+			},
+		})
+	}
+	// Availability is not a facet so it narrows the counts as well
+	queryFilters := []map[string]interface{}{}
+	if filter.Available != nil {
+		queryFilters = append(queryFilters, map[string]interface{}{
+			"term": map[string]interface{}{"availability": *filter.Available},
+		})
+	}
+
+	facetFilters := map[string]map[string]interface{}{}
+	if len(filter.Categories) > 0 {
+		facetFilters["categories"] = map[string]interface{}{"terms": map[string]interface{}{"category": filter.Categories}}
+	}
+	if len(filter.Tags) > 0 {
+		facetFilters["tags"] = map[string]interface{}{"terms": map[string]interface{}{"tags": filter.Tags}}
+	}
+	if filter.MinPrice != nil || filter.MaxPrice != nil {
+		price := map[string]interface{}{}
+		if filter.MinPrice != nil {
+			price["gte"] = *filter.MinPrice
+		}
+		if filter.MaxPrice != nil {
+			price["lte"] = *filter.MaxPrice
+		}
+		facetFilters["price_ranges"] = map[string]interface{}{"range": map[string]interface{}{"price": price}}
+	}
+	// allFacetFiltersExcept returns the facet filters other than the one of facet
+	allFacetFiltersExcept := func(facet string) []map[string]interface{} {
+		filters := []map[string]interface{}{}
+		for _, name := range []string{"categories", "tags", "price_ranges"} {
+			if f, ok := facetFilters[name]; ok && name != facet {
+				filters = append(filters, f)
+			}
+		}
+		return filters
+	}
+
+	ranges := []map[string]interface{}{}
+	for i, bound := range priceRangeBounds {
+		r := map[string]interface{}{"to": bound}
+		if i > 0 {
+			r["from"] = priceRangeBounds[i-1]
+		}
+		ranges = append(ranges, r)
+	}
+	ranges = append(ranges, map[string]interface{}{"from": priceRangeBounds[len(priceRangeBounds)-1]})
+
+	facetAggregation := func(facet string, values map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"filter": map[string]interface{}{
+				"bool": map[string]interface{}{"filter": allFacetFiltersExcept(facet)},
+			},
+			"aggs": map[string]interface{}{"values": values},
+		}
+	}
+
+	body := map[string]interface{}{
+		"from": skip,
+		"size": take,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must":     must,
+				"filter":   queryFilters,
+				"must_not": isDeleted,
+			},
+		},
+		"post_filter": map[string]interface{}{
+			"bool": map[string]interface{}{"filter": allFacetFiltersExcept("")},
+		},
+		"aggs": map[string]interface{}{
+			"categories": facetAggregation("categories", map[string]interface{}{
+				"terms": map[string]interface{}{"field": "category", "size": facetSize},
+			}),
+			"tags": facetAggregation("tags", map[string]interface{}{
+				"terms": map[string]interface{}{"field": "tags", "size": facetSize},
+			}),
+			"price_ranges": facetAggregation("price_ranges", map[string]interface{}{
+				"range": map[string]interface{}{"field": "price", "ranges": ranges},
+			}),
+		},
+	}
+
+	if sort != nil {
+		sortField := ""
+		switch sort.Field {
+		case pb.ProductSortField_NAME:
+			sortField = "name.keyword"
+		case pb.ProductSortField_PRICE:
+			sortField = "price"
+		default:
+			sortField = "_id" // Default sorting
+		}
+
+		sortDirection := "asc"
+		if sort.Direction == pb.SortDirection_DESC {
+			sortDirection = "desc"
+		}
+
+		body["sort"] = []map[string]interface{}{
+			{
+				sortField: map[string]interface{}{
+					"order": sortDirection,
+				},
+			},
+		}
+	}
+
+	return body
+}
+
+// parseFacets reads the facets from the aggregations of a response to searchQuery.
+func parseFacets(aggregations json.RawMessage) (Facets, error) {
+	var result struct {
+		Categories  termsFacet `json:"categories"`
+		Tags        termsFacet `json:"tags"`
+		PriceRanges struct {
+			Values struct {
+				Buckets []struct {
+					From     *float64 `json:"from"`
+					To       *float64 `json:"to"`
+					DocCount uint64   `json:"doc_count"`
+				} `json:"buckets"`
+			} `json:"values"`
+		} `json:"price_ranges"`
+	}
+	if len(aggregations) == 0 {
+		return Facets{}, nil
+	}
+	if err := json.Unmarshal(aggregations, &result); err != nil {
+		return Facets{}, fmt.Errorf("error decoding aggregations: %v", err)
+	}
+
+	facets := Facets{
+		Categories:  result.Categories.buckets(),
+		Tags:        result.Tags.buckets(),
+		PriceRanges: []PriceRangeBucket{},
+	}
+	for _, b := range result.PriceRanges.Values.Buckets {
+		facets.PriceRanges = append(facets.PriceRanges, PriceRangeBucket{From: b.From, To: b.To, Count: b.DocCount})
+	}
+	return facets, nil
+}
+
+type termsFacet struct {
+	Values struct {
+		Buckets []struct {
+			Key      string `json:"key"`
+			DocCount uint64 `json:"doc_count"`
+		} `json:"buckets"`
+	} `json:"values"`
+}
+
+func (f termsFacet) buckets() []FacetBucket {
+	buckets := []FacetBucket{}
+	for _, b := range f.Values.Buckets {
+		buckets = append(buckets, FacetBucket{Value: b.Key, Count: b.DocCount})
+	}
+	return buckets
+}
@@ -2,13 +2,19 @@ package catalog
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 
+	"github.com/JonathanNithi/ecommerce/backend/audit"
+	auditpb "github.com/JonathanNithi/ecommerce/backend/audit/pb"
+	"github.com/JonathanNithi/ecommerce/backend/authz"
 	"github.com/JonathanNithi/ecommerce/backend/catalog/pb"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
 type grpcServer struct {
@@ -16,24 +22,66 @@ type grpcServer struct {
 	service Service
 }
 
-func ListenGRPC(s Service, port int) error {
+// policy keeps browsing public while changes to the catalog need the matching permission.
+// Reservations are used by the order service on behalf of the customer placing an order, the
+// service only lets the customer who reserved stock commit or release it. Orders no longer
+// deduct stock directly, so DeductStock is left to staff adjusting inventory.
+// Hard deletes additionally need catalog:delete, which the service checks.
+var policy = authz.Policy{
+	pb.CatalogService_GetProduct_FullMethodName:         authz.Public(),
+	pb.CatalogService_GetProducts_FullMethodName:        authz.Public(),
+	pb.CatalogService_GetProductsById_FullMethodName:    authz.Public(),
+	pb.CatalogService_SuggestProducts_FullMethodName:    authz.Public(),
+	pb.CatalogService_PostProduct_FullMethodName:        authz.RequirePermissions(authz.CatalogWrite),
+	pb.CatalogService_UpdateStock_FullMethodName:        authz.RequirePermissions(authz.InventoryAdjust),
+	pb.CatalogService_UpdateProduct_FullMethodName:      authz.RequirePermissions(authz.CatalogWrite),
+	pb.CatalogService_DeleteProduct_FullMethodName:      authz.RequirePermissions(authz.CatalogWrite),
+	pb.CatalogService_DeductStock_FullMethodName:        authz.RequirePermissions(authz.InventoryAdjust),
+	pb.CatalogService_ReserveStock_FullMethodName:       authz.Authenticated(),
+	pb.CatalogService_CommitReservation_FullMethodName:  authz.Authenticated(),
+	pb.CatalogService_ReleaseReservation_FullMethodName: authz.Authenticated(),
+	auditpb.AuditService_ListAuditEvents_FullMethodName: audit.PolicyRule,
+}
+
+func ListenGRPC(s Service, a audit.Repository, v *authz.Verifier, port int) error {
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return err
 	}
-	serv := grpc.NewServer()
+	serv := grpc.NewServer(authz.ServerOptions(v, policy)...)
 	pb.RegisterCatalogServiceServer(serv, &grpcServer{
 		UnimplementedCatalogServiceServer: pb.UnimplementedCatalogServiceServer{},
 		service:                           s})
+	audit.RegisterServer(serv, a)
 	reflection.Register(serv)
 	return serv.Serve(lis)
 }
 
+// statusError gives catalog errors their gRPC codes so that callers can tell a product that
+// is missing or ran out, a reservation that is gone or an invalid request from a failure.
+// Conflicting changes are Aborted as retrying them may succeed.
+func statusError(err error) error {
+	switch {
+	case errors.Is(err, ErrInsufficientStock), errors.Is(err, ErrReservationExpired),
+		errors.Is(err, ErrReservationReleased), errors.Is(err, ErrReservationCommitted):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, ErrStockConflict), errors.Is(err, ErrReservationChanged):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, ErrEmptyReservation), errors.Is(err, ErrInvalidQuantity), errors.Is(err, ErrInvalidPriceRange),
+		errors.Is(err, ErrEmptyUpdateMask), errors.Is(err, ErrUnknownProductField), errors.Is(err, ErrProductNameRequired),
+		errors.Is(err, ErrNegativePrice):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return err
+}
+
 func (s *grpcServer) PostProduct(ctx context.Context, r *pb.PostProductRequest) (*pb.PostProductResponse, error) {
 	p, err := s.service.PostProduct(ctx, r.Name, r.Description, r.Price, r.Category, r.ImageUrl, r.Tags, r.Stock)
 	if err != nil {
 		log.Println(err)
-		return nil, err
+		return nil, statusError(err)
 	}
 	return &pb.PostProductResponse{Product: &pb.Product{
 		Id:           p.ID,
@@ -45,6 +93,7 @@ func (s *grpcServer) PostProduct(ctx context.Context, r *pb.PostProductRequest)
 		Tags:         p.Tags,
 		Availability: p.Availability,
 		Stock:        p.Stock,
+		Deleted:      p.Deleted,
 	}}, nil
 }
 
@@ -52,7 +101,7 @@ func (s *grpcServer) GetProduct(ctx context.Context, r *pb.GetProductRequest) (*
 	p, err := s.service.GetProduct(ctx, r.Id)
 	if err != nil {
 		log.Println(err)
-		return nil, err
+		return nil, statusError(err)
 	}
 	return &pb.GetProductResponse{
 		Product: &pb.Product{
@@ -65,6 +114,7 @@ func (s *grpcServer) GetProduct(ctx context.Context, r *pb.GetProductRequest) (*
 			Tags:         p.Tags,
 			Availability: p.Availability,
 			Stock:        p.Stock,
+			Deleted:      p.Deleted,
 		},
 	}, nil
 }
@@ -73,27 +123,40 @@ func (s *grpcServer) GetProducts(ctx context.Context, r *pb.GetProductsRequest)
 	var res []Product
 	var err error
 	var count uint64
+	var facets *pb.ProductFacets
 
 	var sortBy *pb.ProductSortInput
 	if r.Sort != nil {
 		sortBy = r.Sort
 	}
 
-	if r.Query != "" || r.Category != "" {
-		// Assuming your service layer has a SearchProducts that now accepts sort
-		res, count, err = s.service.SearchProducts(ctx, r.Query, r.Skip, r.Take, r.Category, sortBy)
-	} else if len(r.Ids) != 0 {
+	filter := ProductFilter{
+		Categories: r.Categories,
+		Tags:       r.Tags,
+		MinPrice:   r.MinPrice,
+		MaxPrice:   r.MaxPrice,
+		Available:  r.Available,
+	}
+	if r.Category != "" {
+		filter.Categories = append(filter.Categories, r.Category)
+	}
+	searching := r.Query != "" || len(filter.Categories) > 0 || len(filter.Tags) > 0 ||
+		filter.MinPrice != nil || filter.MaxPrice != nil || filter.Available != nil
+
+	if len(r.Ids) != 0 && !searching {
 		// Assuming your service layer can fetch by IDs without explicit sorting
 		res, err = s.service.GetProductsById(ctx, r.Ids)
 		count = 1 //ID matches with only one product
 	} else {
-		// Assuming your service layer's GetProducts now accepts sort
-		res, count, err = s.service.GetProducts(ctx, r.Skip, r.Take, sortBy)
+		// Listing without a query or filters is a search too, so shoppers get the facets to filter by
+		var f Facets
+		res, count, f, err = s.service.SearchProducts(ctx, r.Query, filter, r.Skip, r.Take, sortBy)
+		facets = facetsToProto(f)
 	}
 
 	if err != nil {
 		log.Println(err)
-		return nil, err
+		return nil, statusError(err)
 	}
 
 	products := []*pb.Product{}
@@ -110,10 +173,25 @@ func (s *grpcServer) GetProducts(ctx context.Context, r *pb.GetProductsRequest)
 				Tags:         p.Tags,
 				Availability: p.Availability,
 				Stock:        p.Stock,
+				Deleted:      p.Deleted,
 			},
 		)
 	}
-	return &pb.GetProductsResponse{Products: products, TotalCount: count}, nil
+	return &pb.GetProductsResponse{Products: products, TotalCount: count, Facets: facets}, nil
+}
+
+func facetsToProto(f Facets) *pb.ProductFacets {
+	facets := &pb.ProductFacets{}
+	for _, b := range f.Categories {
+		facets.Categories = append(facets.Categories, &pb.FacetBucket{Value: b.Value, Count: b.Count})
+	}
+	for _, b := range f.Tags {
+		facets.Tags = append(facets.Tags, &pb.FacetBucket{Value: b.Value, Count: b.Count})
+	}
+	for _, b := range f.PriceRanges {
+		facets.PriceRanges = append(facets.PriceRanges, &pb.PriceRangeBucket{From: b.From, To: b.To, Count: b.Count})
+	}
+	return facets
 }
 
 func (s *grpcServer) GetProductsById(ctx context.Context, req *pb.GetProductsByIdRequest) (*pb.GetProductsByIdResponse, error) {
@@ -125,7 +203,7 @@ func (s *grpcServer) GetProductsById(ctx context.Context, req *pb.GetProductsByI
 	products, err := s.service.GetProductsById(ctx, productIDs)
 	if err != nil {
 		log.Printf("Error fetching products by IDs: %v", err)
-		return nil, err
+		return nil, statusError(err)
 	}
 
 	// Map your service/repository Product type to the gRPC pb.Product type
@@ -141,18 +219,33 @@ func (s *grpcServer) GetProductsById(ctx context.Context, req *pb.GetProductsByI
 			Tags:         p.Tags,
 			Availability: p.Availability,
 			Stock:        p.Stock,
+			Deleted:      p.Deleted,
 		}
 	}
 
 	return &pb.GetProductsByIdResponse{Products: pbProducts}, nil
 }
 
+func (s *grpcServer) SuggestProducts(ctx context.Context, r *pb.SuggestProductsRequest) (*pb.SuggestProductsResponse, error) {
+	suggestions, err := s.service.SuggestProducts(ctx, r.Prefix, r.Limit)
+	if err != nil {
+		log.Printf("Error suggesting products for %q: %v", r.Prefix, err)
+		return nil, statusError(err)
+	}
+
+	products := []*pb.ProductSuggestion{}
+	for _, p := range suggestions.Products {
+		products = append(products, &pb.ProductSuggestion{Id: p.ID, Name: p.Name})
+	}
+	return &pb.SuggestProductsResponse{Products: products, Categories: suggestions.Categories}, nil
+}
+
 // create a method DeductStock to deduct stock from the product
 func (s *grpcServer) DeductStock(ctx context.Context, r *pb.DeductStockRequest) (*pb.DeductStockResponse, error) {
 	err := s.service.DeductStock(ctx, r.Id, r.Quantity)
 	if err != nil {
 		log.Println(err)
-		return nil, err
+		return nil, statusError(err)
 	}
 	return &pb.DeductStockResponse{}, nil
 }
@@ -161,7 +254,7 @@ func (s *grpcServer) UpdateStock(ctx context.Context, r *pb.UpdateStockRequest)
 	updatedProduct, err := s.service.UpdateStock(ctx, r.Id, r.NewStock)
 	if err != nil {
 		log.Println(err)
-		return nil, err
+		return nil, statusError(err)
 	}
 	return &pb.UpdateStockResponse{
 		Product: &pb.Product{
@@ -174,6 +267,79 @@ func (s *grpcServer) UpdateStock(ctx context.Context, r *pb.UpdateStockRequest)
 			Tags:         updatedProduct.Tags,
 			Availability: updatedProduct.Availability,
 			Stock:        updatedProduct.Stock,
+			Deleted:      updatedProduct.Deleted,
 		},
 	}, nil
 }
+
+func (s *grpcServer) UpdateProduct(ctx context.Context, r *pb.UpdateProductRequest) (*pb.UpdateProductResponse, error) {
+	changes := Product{
+		Name:        r.Name,
+		Description: r.Description,
+		Price:       r.Price,
+		Category:    r.Category,
+		ImageURL:    r.ImageUrl,
+		Tags:        r.Tags,
+	}
+	p, err := s.service.UpdateProduct(ctx, r.Id, changes, r.UpdateMask)
+	if err != nil {
+		log.Println(err)
+		return nil, statusError(err)
+	}
+	return &pb.UpdateProductResponse{
+		Product: &pb.Product{
+			Id:           p.ID,
+			Name:         p.Name,
+			Description:  p.Description,
+			Price:        p.Price,
+			Category:     p.Category,
+			ImageUrl:     p.ImageURL,
+			Tags:         p.Tags,
+			Availability: p.Availability,
+			Stock:        p.Stock,
+			Deleted:      p.Deleted,
+		},
+	}, nil
+}
+
+func (s *grpcServer) DeleteProduct(ctx context.Context, r *pb.DeleteProductRequest) (*pb.DeleteProductResponse, error) {
+	if err := s.service.DeleteProduct(ctx, r.Id, r.Hard); err != nil {
+		log.Println(err)
+		return nil, statusError(err)
+	}
+	return &pb.DeleteProductResponse{}, nil
+}
+
+func (s *grpcServer) ReserveStock(ctx context.Context, r *pb.ReserveStockRequest) (*pb.ReserveStockResponse, error) {
+	items := []ReservationItem{}
+	for _, item := range r.Items {
+		items = append(items, ReservationItem{ProductID: item.ProductId, Quantity: item.Quantity})
+	}
+	reservation, err := s.service.ReserveStock(ctx, items)
+	if err != nil {
+		log.Println(err)
+		return nil, statusError(err)
+	}
+
+	expiresAt, err := reservation.ExpiresAt.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return &pb.ReserveStockResponse{ReservationId: reservation.ID, ExpiresAt: expiresAt}, nil
+}
+
+func (s *grpcServer) CommitReservation(ctx context.Context, r *pb.CommitReservationRequest) (*pb.CommitReservationResponse, error) {
+	if err := s.service.CommitReservation(ctx, r.ReservationId); err != nil {
+		log.Println(err)
+		return nil, statusError(err)
+	}
+	return &pb.CommitReservationResponse{}, nil
+}
+
+func (s *grpcServer) ReleaseReservation(ctx context.Context, r *pb.ReleaseReservationRequest) (*pb.ReleaseReservationResponse, error) {
+	if err := s.service.ReleaseReservation(ctx, r.ReservationId); err != nil {
+		log.Println(err)
+		return nil, statusError(err)
+	}
+	return &pb.ReleaseReservationResponse{}, nil
+}
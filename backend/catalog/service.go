@@ -2,8 +2,12 @@ package catalog
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/JonathanNithi/ecommerce/backend/audit"
+	"github.com/JonathanNithi/ecommerce/backend/authz"
 	"github.com/JonathanNithi/ecommerce/backend/catalog/pb"
 	"github.com/segmentio/ksuid"
 )
@@ -13,11 +17,19 @@ type Service interface {
 	GetProduct(ctx context.Context, id string) (*Product, error)
 	GetProducts(ctx context.Context, skip uint64, take uint64, sort *pb.ProductSortInput) ([]Product, uint64, error)
 	GetProductsById(ctx context.Context, ids []string) ([]Product, error)
-	SearchProducts(ctx context.Context, query string, skip uint64, take uint64, category string, sort *pb.ProductSortInput) ([]Product, uint64, error)
+	SearchProducts(ctx context.Context, query string, filter ProductFilter, skip uint64, take uint64, sort *pb.ProductSortInput) ([]Product, uint64, Facets, error)
+	SuggestProducts(ctx context.Context, prefix string, limit uint32) (*Suggestions, error)
 	DeductStock(ctx context.Context, productID string, quantity int64) error
 	UpdateStock(ctx context.Context, productID string, newStock int64) (*Product, error)
+	UpdateProduct(ctx context.Context, productID string, changes Product, mask []string) (*Product, error)
+	DeleteProduct(ctx context.Context, productID string, hard bool) error
+	ReserveStock(ctx context.Context, items []ReservationItem) (*Reservation, error)
+	CommitReservation(ctx context.Context, id string) error
+	ReleaseReservation(ctx context.Context, id string) error
+	ReleaseExpiredReservations(ctx context.Context) (int, error)
 }
 
+// Product as shoppers see it, Stock is what they can still buy and leaves out stock held by reservations.
 type Product struct {
 	ID           string   `json:"id"`
 	Name         string   `json:"name"`
@@ -28,14 +40,51 @@ type Product struct {
 	Tags         []string `json:"tags"`
 	Availability bool     `json:"availability"`
 	Stock        int64    `json:"stock"`
+	// Soft deleted products are only returned when looked up by id, so orders keep their details
+	Deleted bool `json:"deleted"`
+}
+
+// Fields of a product UpdateProduct changes, as named in its mask. Stock and availability
+// are changed by UpdateStock and DeductStock only.
+const (
+	ProductFieldName        = "name"
+	ProductFieldDescription = "description"
+	ProductFieldPrice       = "price"
+	ProductFieldCategory    = "category"
+	ProductFieldImageURL    = "image_url"
+	ProductFieldTags        = "tags"
+)
+
+var (
+	ErrEmptyUpdateMask     = errors.New("update mask must name at least one field")
+	ErrUnknownProductField = errors.New("unknown product field")
+	ErrProductNameRequired = errors.New("product name is required")
+	ErrNegativePrice       = errors.New("price cannot be negative")
+)
+
+// Actions the catalog service records in its audit log
+const (
+	AuditCreateProduct = "catalog.create_product"
+	AuditUpdateStock   = "catalog.update_stock"
+	AuditDeductStock   = "catalog.deduct_stock"
+	AuditUpdateProduct = "catalog.update_product"
+	AuditDeleteProduct = "catalog.delete_product"
+)
+
+// stockSnapshot is the part of a product UpdateStock and DeductStock change
+type stockSnapshot struct {
+	Stock        int64 `json:"stock"`
+	Availability bool  `json:"availability"`
 }
 
 type catalogService struct {
-	repository Repository
+	repository   Repository
+	audit        audit.Recorder
+	reservations ReservationPolicy
 }
 
-func NewService(r Repository) Service {
-	return &catalogService{r}
+func NewService(r Repository, a audit.Recorder, p ReservationPolicy) Service {
+	return &catalogService{r, a, p.withDefaults()}
 }
 
 func (s *catalogService) PostProduct(ctx context.Context, name, description string, price float64, category string, imageUrl string, tags []string, stock int64) (*Product, error) {
@@ -61,11 +110,21 @@ func (s *catalogService) PostProduct(ctx context.Context, name, description stri
 	if err := s.repository.PutProduct(ctx, *p); err != nil {
 		return nil, err
 	}
+	if err := audit.Record(ctx, s.audit, AuditCreateProduct, p.ID, nil, p); err != nil {
+		return nil, err
+	}
 	return p, nil
 }
 
 func (s *catalogService) GetProduct(ctx context.Context, id string) (*Product, error) {
-	return s.repository.GetProductByID(ctx, id)
+	p, err := s.repository.GetProductByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if p.Deleted {
+		return nil, ErrNotFound
+	}
+	return p, nil
 }
 
 func (s *catalogService) GetProducts(ctx context.Context, skip uint64, take uint64, sort *pb.ProductSortInput) ([]Product, uint64, error) {
@@ -79,19 +138,53 @@ func (s *catalogService) GetProductsById(ctx context.Context, ids []string) ([]P
 	return s.repository.ListProductsWithIDs(ctx, ids)
 }
 
-func (s *catalogService) SearchProducts(ctx context.Context, query string, skip uint64, take uint64, category string, sort *pb.ProductSortInput) ([]Product, uint64, error) {
+func (s *catalogService) SearchProducts(ctx context.Context, query string, filter ProductFilter, skip uint64, take uint64, sort *pb.ProductSortInput) ([]Product, uint64, Facets, error) {
 	if take > 100 || (skip == 0 && take == 0) {
 		take = 100
 	}
-	return s.repository.SearchProducts(ctx, query, skip, take, category, sort)
+	if filter.MinPrice != nil && filter.MaxPrice != nil && *filter.MinPrice > *filter.MaxPrice {
+		return nil, 0, Facets{}, ErrInvalidPriceRange
+	}
+	return s.repository.SearchProducts(ctx, query, filter, skip, take, sort)
+}
+
+// SuggestProducts returns the products whose name starts with the words of prefix and the
+// categories starting with it, at most limit of each.
+func (s *catalogService) SuggestProducts(ctx context.Context, prefix string, limit uint32) (*Suggestions, error) {
+	prefix = normalizePrefix(prefix)
+	if prefix == "" {
+		return &Suggestions{Products: []ProductSuggestion{}, Categories: []string{}}, nil
+	}
+	return s.repository.SuggestProducts(ctx, prefix, suggestionLimit(limit))
 }
 
 func (s *catalogService) DeductStock(ctx context.Context, productID string, quantity int64) error {
-	return s.repository.DeductStock(ctx, productID, quantity)
+	// A negative deduction would add stock, which only UpdateStock does
+	if quantity <= 0 {
+		return fmt.Errorf("%w, got %d for product %s", ErrInvalidQuantity, quantity, productID)
+	}
+	product, err := s.repository.GetProductByID(ctx, productID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve product %s: %w", productID, err)
+	}
+	if err := s.repository.DeductStock(ctx, productID, quantity); err != nil {
+		return err
+	}
+	updatedProduct, err := s.repository.GetProductByID(ctx, productID)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve updated product %s: %w", productID, err)
+	}
+	return audit.Record(ctx, s.audit, AuditDeductStock, productID,
+		stockSnapshot{product.Stock, product.Availability},
+		stockSnapshot{updatedProduct.Stock, updatedProduct.Availability})
 }
 
 func (s *catalogService) UpdateStock(ctx context.Context, productID string, newStock int64) (*Product, error) {
-	err := s.repository.UpdateStock(ctx, productID, newStock)
+	product, err := s.repository.GetProductByID(ctx, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve product %s: %w", productID, err)
+	}
+	err = s.repository.UpdateStock(ctx, productID, newStock)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update stock for product %s: %w", productID, err)
 	}
@@ -99,5 +192,88 @@ func (s *catalogService) UpdateStock(ctx context.Context, productID string, newS
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve updated product %s: %w", productID, err)
 	}
+	err = audit.Record(ctx, s.audit, AuditUpdateStock, productID,
+		stockSnapshot{product.Stock, product.Availability},
+		stockSnapshot{updatedProduct.Stock, updatedProduct.Availability})
+	if err != nil {
+		return nil, err
+	}
 	return updatedProduct, nil
 }
+
+// UpdateProduct sets the fields of the product named in mask to their values in changes,
+// leaving the others as they are.
+func (s *catalogService) UpdateProduct(ctx context.Context, productID string, changes Product, mask []string) (*Product, error) {
+	if len(mask) == 0 {
+		return nil, ErrEmptyUpdateMask
+	}
+	product, err := s.GetProduct(ctx, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := *product
+	for _, field := range mask {
+		switch field {
+		case ProductFieldName:
+			updated.Name = strings.TrimSpace(changes.Name)
+		case ProductFieldDescription:
+			updated.Description = changes.Description
+		case ProductFieldPrice:
+			updated.Price = changes.Price
+		case ProductFieldCategory:
+			updated.Category = changes.Category
+		case ProductFieldImageURL:
+			updated.ImageURL = changes.ImageURL
+		case ProductFieldTags:
+			updated.Tags = changes.Tags
+		default:
+			return nil, fmt.Errorf("%w %s", ErrUnknownProductField, field)
+		}
+	}
+	if updated.Name == "" {
+		return nil, ErrProductNameRequired
+	}
+	if updated.Price < 0 {
+		return nil, ErrNegativePrice
+	}
+
+	if err := s.repository.UpdateProduct(ctx, updated); err != nil {
+		return nil, fmt.Errorf("failed to update product %s: %w", productID, err)
+	}
+	if err := audit.Record(ctx, s.audit, AuditUpdateProduct, productID, product, updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+// DeleteProduct hides the product from the catalog, or with hard removes it for good which
+// needs the catalog:delete permission on top of catalog:write.
+func (s *catalogService) DeleteProduct(ctx context.Context, productID string, hard bool) error {
+	if hard {
+		if err := authz.Require(authz.ClaimsFromContext(ctx), authz.CatalogDelete); err != nil {
+			return err
+		}
+	}
+	product, err := s.repository.GetProductByID(ctx, productID)
+	if err != nil {
+		return err
+	}
+
+	if !hard {
+		if product.Deleted {
+			return ErrNotFound
+		}
+		if err := s.repository.SoftDeleteProduct(ctx, productID); err != nil {
+			return fmt.Errorf("failed to delete product %s: %w", productID, err)
+		}
+		deleted := *product
+		deleted.Deleted = true
+		return audit.Record(ctx, s.audit, AuditDeleteProduct, productID, product, deleted)
+	}
+
+	if err := s.repository.DeleteProduct(ctx, productID); err != nil {
+		return fmt.Errorf("failed to delete product %s: %w", productID, err)
+	}
+	return audit.Record(ctx, s.audit, AuditDeleteProduct, productID, product, nil)
+}
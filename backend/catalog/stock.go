@@ -0,0 +1,160 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// maxStockAttempts bounds how often a stock change is retried when other changes to the
+// same product keep winning, after which it fails with ErrStockConflict.
+const maxStockAttempts = 5
+
+var (
+	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrStockConflict     = errors.New("stock is being changed by too many requests at once")
+)
+
+// errVersionConflict is returned by stockStore.writeStock when the product changed after it was read.
+var errVersionConflict = errors.New("product was changed concurrently")
+
+// stockVersion identifies the revision of a product document, as Elasticsearch's
+// sequence number and primary term do.
+type stockVersion struct {
+	seqNo       int
+	primaryTerm int
+}
+
+// stockReservation is the quantity of a product held for a reservation until it is
+// committed, released or expires.
+type stockReservation struct {
+	Quantity  int64     `json:"quantity"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// stockLevel is the stock of a product and the reservations held against it, by reservation id.
+type stockLevel struct {
+	Stock        int64
+	Reservations map[string]stockReservation
+}
+
+// available is the stock shoppers can still buy, expired reservations no longer hold any.
+func (l stockLevel) available(now time.Time) int64 {
+	available := l.Stock
+	for _, r := range l.Reservations {
+		if now.Before(r.ExpiresAt) {
+			available -= r.Quantity
+		}
+	}
+	return available
+}
+
+// stockStore reads stock and writes it back only if the product is unchanged since,
+// which is all changeStock needs to change stock without losing concurrent updates.
+type stockStore interface {
+	readStock(ctx context.Context, id string) (stockLevel, stockVersion, error)
+	writeStock(ctx context.Context, id string, level stockLevel, version stockVersion) error
+}
+
+// changeStock applies change to the current stock level of product id and writes the result.
+// Writes that lose a race to another change are recomputed from the new level, so concurrent
+// deductions never oversell. Errors from change are returned as they are.
+func changeStock(ctx context.Context, s stockStore, id string, change func(level *stockLevel, now time.Time) error) error {
+	for attempt := 0; attempt < maxStockAttempts; attempt++ {
+		if attempt > 0 {
+			// Jittered so the changes that conflicted do not collide again
+			backoff := time.Duration(attempt)*10*time.Millisecond + time.Duration(rand.Int63n(int64(10*time.Millisecond)))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+		}
+
+		level, version, err := s.readStock(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := change(&level, time.Now()); err != nil {
+			return err
+		}
+		err = s.writeStock(ctx, id, level, version)
+		if !errors.Is(err, errVersionConflict) {
+			return err
+		}
+	}
+	return fmt.Errorf("%w, product %s", ErrStockConflict, id)
+}
+
+// deductQuantity is the stock change of DeductStock, reserved stock cannot be deducted.
+func deductQuantity(quantity int64) func(*stockLevel, time.Time) error {
+	return func(level *stockLevel, now time.Time) error {
+		available := level.available(now)
+		if quantity > available {
+			return fmt.Errorf("%w: required quantity %d exceeds available stock %d. try again later", ErrInsufficientStock, quantity, available)
+		}
+		level.Stock -= quantity
+		return nil
+	}
+}
+
+// addStock is the stock change of UpdateStock, change may be negative but cannot take
+// away stock that is reserved.
+func addStock(change int64) func(*stockLevel, time.Time) error {
+	return func(level *stockLevel, now time.Time) error {
+		available := level.available(now)
+		if available+change < 0 {
+			return fmt.Errorf("%w: cannot remove %d from available stock %d", ErrInsufficientStock, -change, available)
+		}
+		level.Stock += change
+		return nil
+	}
+}
+
+// reserveQuantity holds quantity for the reservation until expiresAt. Reserving again for
+// the same reservation changes nothing, so retries are safe.
+func reserveQuantity(reservationID string, quantity int64, expiresAt time.Time) func(*stockLevel, time.Time) error {
+	return func(level *stockLevel, now time.Time) error {
+		if _, ok := level.Reservations[reservationID]; ok {
+			return nil
+		}
+		available := level.available(now)
+		if quantity > available {
+			return fmt.Errorf("%w: required quantity %d exceeds available stock %d", ErrInsufficientStock, quantity, available)
+		}
+		if level.Reservations == nil {
+			level.Reservations = map[string]stockReservation{}
+		}
+		level.Reservations[reservationID] = stockReservation{Quantity: quantity, ExpiresAt: expiresAt}
+		return nil
+	}
+}
+
+// commitQuantity deducts the quantity held for the reservation from the stock. Once
+// committed or released there is nothing held and nothing changes. A reservation that
+// expired meanwhile only commits if its stock was not reserved by others since.
+func commitQuantity(reservationID string) func(*stockLevel, time.Time) error {
+	return func(level *stockLevel, now time.Time) error {
+		r, ok := level.Reservations[reservationID]
+		if !ok {
+			return nil
+		}
+		// Expired reservations no longer count towards available
+		if !now.Before(r.ExpiresAt) && r.Quantity > level.available(now) {
+			return fmt.Errorf("%w, its stock was reserved by others", ErrReservationExpired)
+		}
+		level.Stock -= r.Quantity
+		delete(level.Reservations, reservationID)
+		return nil
+	}
+}
+
+// releaseQuantity returns the quantity held for the reservation to the available stock.
+func releaseQuantity(reservationID string) func(*stockLevel, time.Time) error {
+	return func(level *stockLevel, now time.Time) error {
+		delete(level.Reservations, reservationID)
+		return nil
+	}
+}
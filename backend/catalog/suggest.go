@@ -0,0 +1,87 @@
+package catalog
+
+import "strings"
+
+const (
+	defaultSuggestionLimit = 5
+	maxSuggestionLimit     = 10
+)
+
+// suggestFields are the name subfields of the search_as_you_type mapping. Matching all of
+// them lets the last word typed match as a prefix and the words before it as shingles.
+var suggestFields = []string{"name.suggest", "name.suggest._2gram", "name.suggest._3gram"}
+
+// Suggestions are the products and categories offered while a shopper types a search.
+type Suggestions struct {
+	Products   []ProductSuggestion
+	Categories []string
+}
+
+type ProductSuggestion struct {
+	ID   string
+	Name string
+}
+
+// suggestionLimit bounds how many products and categories are suggested, zero asks for the default.
+func suggestionLimit(limit uint32) uint32 {
+	if limit == 0 {
+		return defaultSuggestionLimit
+	}
+	if limit > maxSuggestionLimit {
+		return maxSuggestionLimit
+	}
+	return limit
+}
+
+// suggestQuery builds the Elasticsearch request of a suggestion. Products are matched by
+// name, categories by prefix across the whole catalog rather than only the matched products.
+func suggestQuery(prefix string, limit uint32) map[string]interface{} {
+	return map[string]interface{}{
+		"size":    limit,
+		"_source": []string{"name"},
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": map[string]interface{}{
+					"multi_match": map[string]interface{}{
+						"query":  prefix,
+						"type":   "bool_prefix",
+						"fields": suggestFields,
+					},
+				},
+				"must_not": isDeleted,
+			},
+		},
+		"aggs": map[string]interface{}{
+			"categories": map[string]interface{}{
+				"global": map[string]interface{}{},
+				"aggs": map[string]interface{}{
+					"matching": map[string]interface{}{
+						"filter": map[string]interface{}{
+							"bool": map[string]interface{}{
+								"filter": map[string]interface{}{
+									"prefix": map[string]interface{}{
+										"category": map[string]interface{}{
+											"value":            prefix,
+											"case_insensitive": true,
+										},
+									},
+								},
+								"must_not": isDeleted,
+							},
+						},
+						"aggs": map[string]interface{}{
+							"values": map[string]interface{}{
+								"terms": map[string]interface{}{"field": "category", "size": limit},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// normalizePrefix trims the prefix and collapses its whitespace, an empty result suggests nothing.
+func normalizePrefix(prefix string) string {
+	return strings.Join(strings.Fields(prefix), " ")
+}
@@ -4,7 +4,10 @@ package main
 import (
 	"context"
 	"log"
+	"strings"
 	"time"
+
+	"github.com/JonathanNithi/ecommerce/backend/account"
 )
 
 type accountResolver struct {
@@ -20,6 +23,7 @@ func (r *accountResolver) Orders(ctx context.Context, obj *Account) ([]*Order, e
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
+	ctx = withAccessToken(ctx, obj.accessToken)
 	orderList, err := r.server.orderClient.GetOrdersForAccount(ctx, obj.ID)
 	if err != nil {
 		log.Println(err)
@@ -49,3 +53,79 @@ func (r *accountResolver) Orders(ctx context.Context, obj *Account) ([]*Order, e
 
 	return orders, nil
 }
+
+func (r *accountResolver) Addresses(ctx context.Context, obj *Account) ([]*Address, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	ctx = withAccessToken(ctx, obj.accessToken)
+	addresses, err := r.server.accountClient.ListAddresses(ctx, obj.ID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return toAddresses(addresses), nil
+}
+
+func toAddress(a *account.Address) *Address {
+	return &Address{
+		ID:              a.ID,
+		FullName:        a.FullName,
+		Line1:           a.Line1,
+		Line2:           a.Line2,
+		City:            a.City,
+		Region:          a.Region,
+		PostalCode:      a.PostalCode,
+		Country:         a.Country,
+		Phone:           a.Phone,
+		DefaultShipping: a.DefaultShipping,
+		DefaultBilling:  a.DefaultBilling,
+	}
+}
+
+func toAPIKey(k *account.APIKey) *APIKey {
+	return &APIKey{
+		ID:          k.ID,
+		Name:        k.Name,
+		Prefix:      k.Prefix,
+		Permissions: k.Permissions,
+		CreatedAt:   k.CreatedAt,
+		ExpiresAt:   k.ExpiresAt,
+		LastUsedAt:  k.LastUsedAt,
+		RevokedAt:   k.RevokedAt,
+	}
+}
+
+func toAddresses(addresses []account.Address) []*Address {
+	res := []*Address{}
+	for i := range addresses {
+		res = append(res, toAddress(&addresses[i]))
+	}
+	return res
+}
+
+func addressFromInput(id string, in AddressInput) account.Address {
+	optional := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return *s
+	}
+	return account.Address{
+		ID:         id,
+		FullName:   in.FullName,
+		Line1:      in.Line1,
+		Line2:      optional(in.Line2),
+		City:       in.City,
+		Region:     optional(in.Region),
+		PostalCode: optional(in.PostalCode),
+		Country:    in.Country,
+		Phone:      optional(in.Phone),
+	}
+}
+
+// addressUsage maps the GraphQL enum to the values the account service expects
+func addressUsage(u AddressUsage) string {
+	return strings.ToLower(string(u))
+}
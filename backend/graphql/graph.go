@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
+
 	"github.com/99designs/gqlgen/graphql"
 	"github.com/JonathanNithi/ecommerce/backend/account"
+	"github.com/JonathanNithi/ecommerce/backend/audit"
+	"github.com/JonathanNithi/ecommerce/backend/authz"
 	"github.com/JonathanNithi/ecommerce/backend/catalog"
 	"github.com/JonathanNithi/ecommerce/backend/order"
 )
@@ -11,6 +15,8 @@ type Server struct {
 	accountClient *account.Client
 	catalogClient *catalog.Client
 	orderClient   *order.Client
+	// Every service keeps its own audit log, the gateway merges them
+	auditClients []*audit.Client
 }
 
 func NewGraphQLServer(accountUrl, catalogURL, orderURL string) (*Server, error) {
@@ -35,13 +41,48 @@ func NewGraphQLServer(accountUrl, catalogURL, orderURL string) (*Server, error)
 		return nil, err
 	}
 
+	// Connect to the audit log of each service
+	auditClients := []*audit.Client{}
+	for _, url := range []string{accountUrl, catalogURL, orderURL} {
+		auditClient, err := audit.NewClient(url)
+		if err != nil {
+			accountClient.Close()
+			catalogClient.Close()
+			orderClient.Close()
+			for _, c := range auditClients {
+				c.Close()
+			}
+			return nil, err
+		}
+		auditClients = append(auditClients, auditClient)
+	}
+
 	return &Server{
 		accountClient,
 		catalogClient,
 		orderClient,
+		auditClients,
 	}, nil
 }
 
+// withAccessToken makes the service clients send accessToken instead of the Authorization
+// header, such as the token an account was resolved with.
+func withAccessToken(ctx context.Context, accessToken string) context.Context {
+	if accessToken == "" {
+		return ctx
+	}
+	return authz.ContextWithToken(ctx, accessToken)
+}
+
+// withTokenArgument is withAccessToken for the deprecated accessToken argument of the operations
+// that predate the Authorization header. Without it the header is used like everywhere else.
+func withTokenArgument(ctx context.Context, accessToken *string) context.Context {
+	if accessToken == nil {
+		return ctx
+	}
+	return withAccessToken(ctx, *accessToken)
+}
+
 func (s *Server) Mutation() MutationResolver {
 	return &mutationResolver{
 		server: s,
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/JonathanNithi/ecommerce/backend/account"
+)
+
+// jwksCacheTTL bounds how long a rotated key takes to reach the services verifying tokens
+const jwksCacheTTL = 30 * time.Second
+
+// JWKSHandler serves the public keys of the account service as a JWKS document so that other
+// services can verify access tokens without holding the private keys.
+type JWKSHandler struct {
+	accountClient *account.Client
+
+	mu        sync.Mutex
+	keys      []byte
+	fetchedAt time.Time
+}
+
+func NewJWKSHandler(accountClient *account.Client) *JWKSHandler {
+	return &JWKSHandler{accountClient: accountClient}
+}
+
+func (h *JWKSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.document(r.Context())
+	if err != nil {
+		log.Println("Error fetching key set: ", err)
+		http.Error(w, "key set unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=30")
+	w.Write(keys)
+}
+
+func (h *JWKSHandler) document(ctx context.Context) ([]byte, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.keys != nil && time.Since(h.fetchedAt) < jwksCacheTTL {
+		return h.keys, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	set, err := h.accountClient.GetJWKS(ctx)
+	if err != nil {
+		// Keep serving the last known keys while the account service is unreachable
+		if h.keys != nil {
+			return h.keys, nil
+		}
+		return nil, err
+	}
+
+	keys, err := json.Marshal(set)
+	if err != nil {
+		return nil, err
+	}
+	h.keys = keys
+	h.fetchedAt = time.Now()
+	return keys, nil
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 
 	"github.com/99designs/gqlgen/graphql/playground"
 	"github.com/99designs/gqlgen/handler"
+	"github.com/JonathanNithi/ecommerce/backend/authz"
 	"github.com/kelseyhightower/envconfig"
+	"github.com/segmentio/ksuid"
 )
 
 // AppConfig struct for storing environment variables
@@ -14,6 +19,92 @@ type AppConfig struct {
 	AccountURL string `envconfig:"ACCOUNT_SERVICE_URL"`
 	CatalogURL string `envconfig:"CATALOG_SERVICE_URL"`
 	OrderURL   string `envconfig:"ORDER_SERVICE_URL"`
+	// Number of proxies in front of the gateway that append to X-Forwarded-For, zero ignores the header
+	TrustedProxies int `envconfig:"TRUSTED_PROXIES"`
+}
+
+type contextKey string
+
+const clientIPContextKey contextKey = "clientIP"
+
+// ClientIPMiddleware stores the caller's address in the request context so that
+// resolvers can forward it to the account service for login throttling
+func ClientIPMiddleware(trustedProxies int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if forwarded := forwardedClientIP(r.Header.Values("X-Forwarded-For"), trustedProxies); forwarded != "" {
+			ip = forwarded
+		}
+
+		ctx := context.WithValue(r.Context(), clientIPContextKey, ip)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// forwardedClientIP returns the address the outermost of trustedProxies saw connecting to it.
+// Each proxy appends the address of its peer, so entries left of that one are set by the caller
+// and cannot be trusted.
+func forwardedClientIP(headers []string, trustedProxies int) string {
+	if trustedProxies <= 0 {
+		return ""
+	}
+	var entries []string
+	for _, header := range headers {
+		for _, entry := range strings.Split(header, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	if len(entries) == 0 {
+		return ""
+	}
+	i := len(entries) - trustedProxies
+	if i < 0 {
+		i = 0
+	}
+	return entries[i]
+}
+
+func clientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPContextKey).(string)
+	return ip
+}
+
+// AuthorizationMiddleware stores the bearer token or API key of the request in its context so
+// that the service clients forward it on every call made while resolving the request
+func AuthorizationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+			ctx := authz.ContextWithToken(r.Context(), strings.TrimSpace(header[7:]))
+			r = r.WithContext(ctx)
+		}
+		if key := strings.TrimSpace(r.Header.Get("X-API-Key")); key != "" {
+			r = r.WithContext(authz.ContextWithAPIKey(r.Context(), key))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+const maxRequestIDLength = 128
+
+// RequestIDMiddleware gives every request an id that the service clients forward, so the audit
+// events it causes in different services can be correlated. An X-Request-ID set by a proxy is kept.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = ksuid.New().String()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+
+		ctx := authz.ContextWithRequestID(r.Context(), requestID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
 }
 
 // CorsMiddleware adds CORS headers to the response
@@ -22,7 +113,7 @@ func CorsMiddleware(next http.Handler) http.Handler {
 		// Set CORS headers
 		w.Header().Set("Access-Control-Allow-Origin", "*") // Allow your Next.js frontend origin
 		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, X-Request-ID")
 
 		// Handle preflight requests
 		if r.Method == "OPTIONS" {
@@ -52,11 +143,12 @@ func main() {
 	graphqlHandler := handler.GraphQL(s.ToExecutableSchema())
 
 	// Wrap the GraphQL handler with the CORS middleware
-	corsHandler := CorsMiddleware(graphqlHandler)
+	corsHandler := CorsMiddleware(RequestIDMiddleware(ClientIPMiddleware(cfg.TrustedProxies, AuthorizationMiddleware(graphqlHandler))))
 
 	// Set up the routes
 	http.Handle("/graphql", corsHandler)
 	http.Handle("/playground", playground.Handler("Playground", "/graphql"))
+	http.Handle("/.well-known/jwks.json", NewJWKSHandler(s.accountClient))
 
 	// Start the server on port 8080
 	log.Fatal(http.ListenAndServe(":8080", nil))
@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+// Only the entries appended by trusted proxies count, anything left of them is set by the caller
+func TestForwardedClientIP(t *testing.T) {
+	tests := []struct {
+		headers        []string
+		trustedProxies int
+		want           string
+	}{
+		{[]string{"203.0.113.7"}, 0, ""},
+		{[]string{"203.0.113.7"}, 1, "203.0.113.7"},
+		{[]string{"10.9.9.9, 203.0.113.7"}, 1, "203.0.113.7"},
+		{[]string{"10.9.9.9, 203.0.113.7, 10.0.0.2"}, 2, "203.0.113.7"},
+		{[]string{"10.9.9.9", "203.0.113.7"}, 1, "203.0.113.7"},
+		{[]string{"203.0.113.7"}, 3, "203.0.113.7"},
+		{nil, 1, ""},
+	}
+	for _, tt := range tests {
+		if got := forwardedClientIP(tt.headers, tt.trustedProxies); got != tt.want {
+			t.Errorf("forwardedClientIP(%q, %d) = %q, want %q", tt.headers, tt.trustedProxies, got, tt.want)
+		}
+	}
+}
@@ -1,13 +1,24 @@
 package main
 
+import "time"
+
 type Account struct {
-	ID           string  `json:"id"`
-	FirstName    string  `json:"first_name"`
-	LastName     string  `json:"last_name"`
-	Email        string  `json:"email"`
-	PasswordHash string  `json:"password_hash"`
-	Role         Role    `json:"role"`
-	Orders       []Order `json:"orders"`
+	ID            string        `json:"id"`
+	FirstName     string        `json:"first_name"`
+	LastName      string        `json:"last_name"`
+	Email         string        `json:"email"`
+	EmailVerified bool          `json:"email_verified"`
+	MfaEnabled    bool          `json:"mfa_enabled"`
+	Status        AccountStatus `json:"status"`
+	StatusReason  string        `json:"status_reason"`
+	CreatedAt     time.Time     `json:"created_at"`
+	Role          Role          `json:"role"`
+	Roles         []string      `json:"roles"`
+	Permissions   []string      `json:"permissions"`
+	Orders        []Order       `json:"orders"`
+
+	// accessToken is the token the account was loaded with, the orders resolver forwards it
+	accessToken string
 }
 
 // Role type matching the GraphQL enum "Role"
@@ -9,6 +9,25 @@ import (
 	"time"
 )
 
+type AccountConnection struct {
+	Edges      []*AccountEdge `json:"edges"`
+	PageInfo   *PageInfo      `json:"pageInfo"`
+	TotalCount int            `json:"totalCount"`
+}
+
+type AccountEdge struct {
+	Cursor string   `json:"cursor"`
+	Node   *Account `json:"node"`
+}
+
+type AccountFilter struct {
+	Query         *string        `json:"query,omitempty"`
+	Role          *string        `json:"role,omitempty"`
+	Status        *AccountStatus `json:"status,omitempty"`
+	CreatedAfter  *time.Time     `json:"createdAfter,omitempty"`
+	CreatedBefore *time.Time     `json:"createdBefore,omitempty"`
+}
+
 type AccountInput struct {
 	FirstName string `json:"first_name"`
 	LastName  string `json:"last_name"`
@@ -16,16 +35,109 @@ type AccountInput struct {
 	Password  string `json:"password"`
 }
 
+type Address struct {
+	ID              string `json:"id"`
+	FullName        string `json:"fullName"`
+	Line1           string `json:"line1"`
+	Line2           string `json:"line2"`
+	City            string `json:"city"`
+	Region          string `json:"region"`
+	PostalCode      string `json:"postalCode"`
+	Country         string `json:"country"`
+	Phone           string `json:"phone"`
+	DefaultShipping bool   `json:"defaultShipping"`
+	DefaultBilling  bool   `json:"defaultBilling"`
+}
+
+type AddressInput struct {
+	FullName   string  `json:"fullName"`
+	Line1      string  `json:"line1"`
+	Line2      *string `json:"line2,omitempty"`
+	City       string  `json:"city"`
+	Region     *string `json:"region,omitempty"`
+	PostalCode *string `json:"postalCode,omitempty"`
+	Country    string  `json:"country"`
+	Phone      *string `json:"phone,omitempty"`
+}
+
+type APIKey struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Prefix      string     `json:"prefix"`
+	Permissions []string   `json:"permissions"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	LastUsedAt  *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt   *time.Time `json:"revokedAt,omitempty"`
+}
+
+type AuditEvent struct {
+	ID        string    `json:"id"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target"`
+	Before    *string   `json:"before,omitempty"`
+	After     *string   `json:"after,omitempty"`
+	RequestID string    `json:"requestId"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type AuditFilter struct {
+	Actor  *string    `json:"actor,omitempty"`
+	Target *string    `json:"target,omitempty"`
+	Action *string    `json:"action,omitempty"`
+	Since  *time.Time `json:"since,omitempty"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+type ChangeEmailInput struct {
+	NewEmail string `json:"newEmail"`
+	Password string `json:"password"`
+}
+
+type ChangePasswordInput struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+type CreateAPIKeyInput struct {
+	AccountID   string     `json:"accountId"`
+	Name        string     `json:"name"`
+	Permissions []string   `json:"permissions"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+}
+
+type CreatedAPIKey struct {
+	APIKey *APIKey `json:"apiKey"`
+	Key    string  `json:"key"`
+}
+
+type FacetBucket struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
 type ForgotPasswordInput struct {
-	Email     string `json:"email"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
+	Email string `json:"email"`
+}
+
+type Impersonation struct {
+	AccessToken string    `json:"accessToken"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	SessionID   string    `json:"sessionId"`
+	Account     *Account  `json:"account"`
 }
 
 type LoginResponse struct {
-	Account      *Account `json:"account"`
-	AccessToken  string   `json:"accessToken"`
-	RefreshToken string   `json:"refreshToken"`
+	Account           *Account `json:"account,omitempty"`
+	AccessToken       *string  `json:"accessToken,omitempty"`
+	RefreshToken      *string  `json:"refreshToken,omitempty"`
+	MfaChallengeToken *string  `json:"mfaChallengeToken,omitempty"`
+}
+
+type MfaEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauthUri"`
 }
 
 type Mutation struct {
@@ -40,8 +152,8 @@ type Order struct {
 
 type OrderInput struct {
 	AccountID    string               `json:"account_id"`
-	AccessToken  string               `json:"accessToken"`
-	RefreshToken string               `json:"refreshToken"`
+	AccessToken  *string              `json:"accessToken,omitempty"`
+	RefreshToken *string              `json:"refreshToken,omitempty"`
 	Products     []*OrderProductInput `json:"products"`
 }
 
@@ -59,11 +171,22 @@ type OrderedProduct struct {
 	ImageURL    string  `json:"imageUrl"`
 }
 
+type PageInfo struct {
+	HasNextPage bool    `json:"hasNextPage"`
+	EndCursor   *string `json:"endCursor,omitempty"`
+}
+
 type PaginationInput struct {
 	Skip *int `json:"skip,omitempty"`
 	Take *int `json:"take,omitempty"`
 }
 
+type PriceRangeBucket struct {
+	From  *float64 `json:"from,omitempty"`
+	To    *float64 `json:"to,omitempty"`
+	Count int      `json:"count"`
+}
+
 type Product struct {
 	ID           string   `json:"id"`
 	Name         string   `json:"name"`
@@ -74,6 +197,21 @@ type Product struct {
 	Tags         []string `json:"tags,omitempty"`
 	Availability bool     `json:"availability"`
 	Stock        int      `json:"stock"`
+	Deleted      bool     `json:"deleted"`
+}
+
+type ProductFacets struct {
+	Categories  []*FacetBucket      `json:"categories"`
+	Tags        []*FacetBucket      `json:"tags"`
+	PriceRanges []*PriceRangeBucket `json:"priceRanges"`
+}
+
+type ProductFilterInput struct {
+	Categories []string `json:"categories,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	MinPrice   *float64 `json:"minPrice,omitempty"`
+	MaxPrice   *float64 `json:"maxPrice,omitempty"`
+	Available  *bool    `json:"available,omitempty"`
 }
 
 type ProductInput struct {
@@ -87,8 +225,9 @@ type ProductInput struct {
 }
 
 type ProductListResponse struct {
-	Items      []*Product `json:"items"`
-	TotalCount int        `json:"totalCount"`
+	Items      []*Product     `json:"items"`
+	TotalCount int            `json:"totalCount"`
+	Facets     *ProductFacets `json:"facets,omitempty"`
 }
 
 type ProductSortInput struct {
@@ -96,6 +235,16 @@ type ProductSortInput struct {
 	Direction SortDirection    `json:"direction"`
 }
 
+type ProductSuggestion struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type ProductSuggestions struct {
+	Products   []*ProductSuggestion `json:"products"`
+	Categories []string             `json:"categories"`
+}
+
 type Query struct {
 }
 
@@ -103,24 +252,131 @@ type RefreshTokenInput struct {
 	RefreshToken string `json:"refreshToken"`
 }
 
+type RefreshTokenResponse struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+}
+
 type ResetPasswordInput struct {
-	ID       string `json:"id"`
-	Email    string `json:"email"`
+	Token    string `json:"token"`
 	Password string `json:"password"`
 }
 
+type RoleDefinition struct {
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+type UpdateProductInput struct {
+	Name        *string  `json:"name,omitempty"`
+	Description *string  `json:"description,omitempty"`
+	Price       *float64 `json:"price,omitempty"`
+	Category    *string  `json:"category,omitempty"`
+	ImageURL    *string  `json:"imageUrl,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
 type UpdateProductStockInput struct {
-	AccessToken  string `json:"accessToken"`
-	RefreshToken string `json:"refreshToken"`
-	ProductID    string `json:"productId"`
-	NewStock     int    `json:"newStock"`
-	AccountID    string `json:"accountId"`
+	AccessToken  *string `json:"accessToken,omitempty"`
+	RefreshToken *string `json:"refreshToken,omitempty"`
+	ProductID    string  `json:"productId"`
+	NewStock     int     `json:"newStock"`
+	AccountID    string  `json:"accountId"`
 }
 
 type UpdateProductStockResponse struct {
 	Product *Product `json:"product,omitempty"`
 }
 
+type UpdateProfileInput struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+type AccountStatus string
+
+const (
+	AccountStatusActive    AccountStatus = "active"
+	AccountStatusSuspended AccountStatus = "suspended"
+	AccountStatusClosed    AccountStatus = "closed"
+)
+
+var AllAccountStatus = []AccountStatus{
+	AccountStatusActive,
+	AccountStatusSuspended,
+	AccountStatusClosed,
+}
+
+func (e AccountStatus) IsValid() bool {
+	switch e {
+	case AccountStatusActive, AccountStatusSuspended, AccountStatusClosed:
+		return true
+	}
+	return false
+}
+
+func (e AccountStatus) String() string {
+	return string(e)
+}
+
+func (e *AccountStatus) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = AccountStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid AccountStatus", str)
+	}
+	return nil
+}
+
+func (e AccountStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+type AddressUsage string
+
+const (
+	AddressUsageShipping AddressUsage = "SHIPPING"
+	AddressUsageBilling  AddressUsage = "BILLING"
+)
+
+var AllAddressUsage = []AddressUsage{
+	AddressUsageShipping,
+	AddressUsageBilling,
+}
+
+func (e AddressUsage) IsValid() bool {
+	switch e {
+	case AddressUsageShipping, AddressUsageBilling:
+		return true
+	}
+	return false
+}
+
+func (e AddressUsage) String() string {
+	return string(e)
+}
+
+func (e *AddressUsage) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = AddressUsage(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid AddressUsage", str)
+	}
+	return nil
+}
+
+func (e AddressUsage) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
 type ProductSortField string
 
 const (
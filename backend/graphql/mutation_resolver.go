@@ -6,6 +6,9 @@ import (
 	"log"
 	"time"
 
+	"github.com/JonathanNithi/ecommerce/backend/account"
+	"github.com/JonathanNithi/ecommerce/backend/authz"
+	"github.com/JonathanNithi/ecommerce/backend/catalog"
 	"github.com/JonathanNithi/ecommerce/backend/order"
 )
 
@@ -27,13 +30,7 @@ func (r *mutationResolver) CreateAccount(ctx context.Context, in AccountInput) (
 		return nil, err
 	}
 
-	return &Account{
-		ID:           a.ID,
-		FirstName:    a.FirstName,
-		LastName:     a.LastName,
-		Email:        a.Email,
-		PasswordHash: a.PasswordHash,
-	}, nil
+	return accountNode(a, authz.TokenFromContext(ctx)), nil
 }
 
 func (r *mutationResolver) CreateProduct(ctx context.Context, in ProductInput) (*Product, error) {
@@ -73,7 +70,8 @@ func (r *mutationResolver) CreateOrder(ctx context.Context, in OrderInput) (*Ord
 			Quantity: uint32(p.Quantity),
 		})
 	}
-	o, err := r.server.orderClient.PostOrder(ctx, in.AccountID, in.AccessToken, in.RefreshToken, products)
+	ctx = withTokenArgument(ctx, in.AccessToken)
+	o, err := r.server.orderClient.PostOrder(ctx, in.AccountID, products)
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -90,77 +88,69 @@ func (r *mutationResolver) Login(ctx context.Context, email string, password str
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	// Capture all return values from accountClient.Login
-	account, accessToken, refreshToken, err := r.server.accountClient.Login(ctx, email, password)
+	res, err := r.server.accountClient.Login(ctx, email, password, clientIPFromContext(ctx))
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
 
-	// Return the Account along with AccessToken and RefreshToken
-	return &LoginResponse{
-		Account: &Account{
-			ID:           account.ID,
-			FirstName:    account.FirstName,
-			LastName:     account.LastName,
-			Email:        account.Email,
-			PasswordHash: account.PasswordHash,
-			Role:         Role(account.Role),
-		},
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-	}, nil
+	return loginResponse(res), nil
 }
 
-func (r *mutationResolver) SetAccountAsAdmin(ctx context.Context, accessToken string, refreshToken string, userId string) (*Account, error) {
+func (r *mutationResolver) CompleteMfaLogin(ctx context.Context, challengeToken string, code string) (*LoginResponse, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	// Capture all return values from accountClient.SetAccountAsAdmin
-	a, newAccessToken, newRefreshToken, err := r.server.accountClient.SetAccountAsAdmin(ctx, accessToken, refreshToken, userId)
+	res, err := r.server.accountClient.CompleteMfaLogin(ctx, challengeToken, code)
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
 
-	_ = newAccessToken
-	_ = newRefreshToken
+	return loginResponse(res), nil
+}
 
-	return &Account{
-		ID:           a.ID,
-		FirstName:    a.FirstName,
-		LastName:     a.LastName,
-		Email:        a.Email,
-		PasswordHash: a.PasswordHash,
-		Role:         Role(a.Role),
-	}, nil
+// loginResponse only carries the challenge token while a second factor is outstanding
+func loginResponse(res *account.LoginResult) *LoginResponse {
+	if res.MfaChallengeToken != "" {
+		return &LoginResponse{MfaChallengeToken: &res.MfaChallengeToken}
+	}
+
+	a := res.Account
+	return &LoginResponse{
+		Account:      accountNode(a, res.AccessToken),
+		AccessToken:  &res.AccessToken,
+		RefreshToken: &res.RefreshToken,
+	}
 }
 
-func (r *mutationResolver) UpdateStock(ctx context.Context, input UpdateProductStockInput) (*UpdateProductStockResponse, error) {
+func (r *mutationResolver) SetAccountAsAdmin(ctx context.Context, accessToken *string, refreshToken *string, userId string) (*Account, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	// 1. Authenticate and Authorize the user based on the provided tokens
-	accountResp, _, _, err := r.server.accountClient.GetAccount(ctx, input.AccountID, input.AccessToken, input.RefreshToken)
+	ctx = withTokenArgument(ctx, accessToken)
+	a, err := r.server.accountClient.SetAccountAsAdmin(ctx, userId)
 	if err != nil {
-		log.Println("Authentication failed:", err)
+		log.Println(err)
 		return nil, err
 	}
 
-	// 2. Check if the user has the 'admin' role
-	if accountResp.Role != "admin" {
-		log.Println("Unauthorized access attempt to update product stock.")
-		return nil, err
-	}
+	return accountNode(a, authz.TokenFromContext(ctx)), nil
+}
+
+func (r *mutationResolver) UpdateStock(ctx context.Context, input UpdateProductStockInput) (*UpdateProductStockResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
 
-	// 3. Call the Catalog service to update the stock
+	// 1. Call the Catalog service to update the stock, it checks the caller may adjust inventory
+	ctx = withTokenArgument(ctx, input.AccessToken)
 	updateResp, err := r.server.catalogClient.UpdateStock(ctx, input.ProductID, int64(input.NewStock))
 	if err != nil {
 		log.Println("Error updating product stock via gRPC:", err)
 		return nil, err
 	}
 
-	// 4. Return the updated product in the UpdateProductStockResponse
+	// 2. Return the updated product in the UpdateProductStockResponse
 	return &UpdateProductStockResponse{
 		Product: &Product{
 			ID:           updateResp.ID,
@@ -176,55 +166,455 @@ func (r *mutationResolver) UpdateStock(ctx context.Context, input UpdateProductS
 	}, nil
 }
 
-func (r *mutationResolver) ForgotPassword(ctx context.Context, in ForgotPasswordInput) (*Account, error) {
+// UpdateProduct changes the fields given in input, the mask sent to the catalog service names them
+func (r *mutationResolver) UpdateProduct(ctx context.Context, id string, input UpdateProductInput) (*Product, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	changes := catalog.Product{}
+	mask := []string{}
+	if input.Name != nil {
+		changes.Name = *input.Name
+		mask = append(mask, catalog.ProductFieldName)
+	}
+	if input.Description != nil {
+		changes.Description = *input.Description
+		mask = append(mask, catalog.ProductFieldDescription)
+	}
+	if input.Price != nil {
+		changes.Price = *input.Price
+		mask = append(mask, catalog.ProductFieldPrice)
+	}
+	if input.Category != nil {
+		changes.Category = *input.Category
+		mask = append(mask, catalog.ProductFieldCategory)
+	}
+	if input.ImageURL != nil {
+		changes.ImageURL = *input.ImageURL
+		mask = append(mask, catalog.ProductFieldImageURL)
+	}
+	if input.Tags != nil {
+		changes.Tags = input.Tags
+		mask = append(mask, catalog.ProductFieldTags)
+	}
+
+	p, err := r.server.catalogClient.UpdateProduct(ctx, id, changes, mask)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &Product{
+		ID:           p.ID,
+		Name:         p.Name,
+		Description:  p.Description,
+		Price:        p.Price,
+		Category:     p.Category,
+		ImageURL:     p.ImageURL,
+		Tags:         p.Tags,
+		Availability: p.Availability,
+		Stock:        int(p.Stock),
+		Deleted:      p.Deleted,
+	}, nil
+}
+
+func (r *mutationResolver) DeleteProduct(ctx context.Context, id string, hard *bool) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := r.server.catalogClient.DeleteProduct(ctx, id, hard != nil && *hard); err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ForgotPassword always reports success so callers cannot probe which emails are registered
+func (r *mutationResolver) ForgotPassword(ctx context.Context, in ForgotPasswordInput) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := r.server.accountClient.ForgotPassword(ctx, in.Email); err != nil {
+		log.Println(err)
+	}
+
+	return true, nil
+}
+
+func (r *mutationResolver) ResetPassword(ctx context.Context, in ResetPasswordInput) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := r.server.accountClient.ResetPassword(ctx, in.Token, in.Password); err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RefreshToken returns the rotated refresh token too, the one presented can no longer be used
+func (r *mutationResolver) RefreshToken(ctx context.Context, input RefreshTokenInput) (*RefreshTokenResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	accessToken, refreshToken, err := r.server.accountClient.RefreshToken(ctx, input.RefreshToken)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &RefreshTokenResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+func (r *mutationResolver) Logout(ctx context.Context, refreshToken string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := r.server.accountClient.Logout(ctx, refreshToken); err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *mutationResolver) LogoutEverywhere(ctx context.Context, accessToken *string, refreshToken *string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	ctx = withTokenArgument(ctx, accessToken)
+	if err := r.server.accountClient.RevokeAllSessions(ctx); err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *mutationResolver) UnlockAccount(ctx context.Context, accessToken *string, refreshToken *string, userId string) (*Account, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	ctx = withTokenArgument(ctx, accessToken)
+	a, err := r.server.accountClient.UnlockAccount(ctx, userId)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return accountNode(a, authz.TokenFromContext(ctx)), nil
+}
+
+func (r *mutationResolver) DemoteAdmin(ctx context.Context, accessToken *string, refreshToken *string, userId string) (*Account, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	ctx = withTokenArgument(ctx, accessToken)
+	a, err := r.server.accountClient.DemoteAdmin(ctx, userId)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return accountNode(a, authz.TokenFromContext(ctx)), nil
+}
+
+func (r *mutationResolver) CreateRole(ctx context.Context, accessToken *string, refreshToken *string, name string, permissions []string) (*RoleDefinition, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	ctx = withTokenArgument(ctx, accessToken)
+	role, err := r.server.accountClient.CreateRole(ctx, name, permissions)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &RoleDefinition{
+		Name:        role.Name,
+		Permissions: role.Permissions,
+	}, nil
+}
+
+func (r *mutationResolver) GrantRole(ctx context.Context, accessToken *string, refreshToken *string, userId string, role string) (*Account, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	ctx = withTokenArgument(ctx, accessToken)
+	a, err := r.server.accountClient.GrantRole(ctx, userId, role)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return accountNode(a, authz.TokenFromContext(ctx)), nil
+}
+
+func (r *mutationResolver) RevokeRole(ctx context.Context, accessToken *string, refreshToken *string, userId string, role string) (*Account, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	ctx = withTokenArgument(ctx, accessToken)
+	a, err := r.server.accountClient.RevokeRole(ctx, userId, role)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return accountNode(a, authz.TokenFromContext(ctx)), nil
+}
+
+func (r *mutationResolver) UpdateProfile(ctx context.Context, input UpdateProfileInput) (*Account, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	a, err := r.server.accountClient.UpdateProfile(ctx, input.FirstName, input.LastName)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return accountNode(a, authz.TokenFromContext(ctx)), nil
+}
+
+// ChangeEmail only sends a confirmation link to the new address, confirmEmailChange applies it
+func (r *mutationResolver) ChangeEmail(ctx context.Context, input ChangeEmailInput) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := r.server.accountClient.ChangeEmail(ctx, input.NewEmail, input.Password); err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *mutationResolver) ConfirmEmailChange(ctx context.Context, token string) (*Account, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	account, err := r.server.accountClient.ForgotPassword(ctx, in.Email, in.FirstName, in.LastName)
+	a, err := r.server.accountClient.ConfirmEmailChange(ctx, token)
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
 
-	return &Account{
-		ID:           account.ID,
-		FirstName:    account.FirstName,
-		LastName:     account.LastName,
-		Email:        account.Email,
-		PasswordHash: account.PasswordHash,
-		Role:         Role(account.Role),
+	return accountNode(a, authz.TokenFromContext(ctx)), nil
+}
+
+func (r *mutationResolver) ChangePassword(ctx context.Context, input ChangePasswordInput) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := r.server.accountClient.ChangePassword(ctx, input.CurrentPassword, input.NewPassword); err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *mutationResolver) VerifyEmail(ctx context.Context, token string) (*Account, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	a, err := r.server.accountClient.VerifyEmail(ctx, token)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return accountNode(a, authz.TokenFromContext(ctx)), nil
+}
+
+func (r *mutationResolver) ResendVerificationEmail(ctx context.Context, email string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := r.server.accountClient.ResendVerificationEmail(ctx, email); err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *mutationResolver) EnrollMfa(ctx context.Context) (*MfaEnrollment, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	secret, uri, err := r.server.accountClient.EnrollMfa(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &MfaEnrollment{
+		Secret:     secret,
+		OtpauthURI: uri,
 	}, nil
 }
 
-func (r *mutationResolver) ResetPassword(ctx context.Context, in ResetPasswordInput) (*Account, error) {
+func (r *mutationResolver) ConfirmMfa(ctx context.Context, code string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	codes, err := r.server.accountClient.ConfirmMfa(ctx, code)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (r *mutationResolver) AddAddress(ctx context.Context, input AddressInput) (*Address, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	a, err := r.server.accountClient.AddAddress(ctx, addressFromInput("", input))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return toAddress(a), nil
+}
+
+func (r *mutationResolver) UpdateAddress(ctx context.Context, id string, input AddressInput) (*Address, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	a, err := r.server.accountClient.UpdateAddress(ctx, addressFromInput(id, input))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return toAddress(a), nil
+}
+
+func (r *mutationResolver) DeleteAddress(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := r.server.accountClient.DeleteAddress(ctx, id); err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *mutationResolver) SetDefaultAddress(ctx context.Context, id string, usage AddressUsage) ([]*Address, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	account, err := r.server.accountClient.ResetPassword(ctx, in.ID, in.Email, in.Password)
+	addresses, err := r.server.accountClient.SetDefaultAddress(ctx, id, addressUsage(usage))
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
 
-	return &Account{
-		ID:           account.ID,
-		FirstName:    account.FirstName,
-		LastName:     account.LastName,
-		Email:        account.Email,
-		PasswordHash: account.PasswordHash,
-		Role:         Role(account.Role),
+	return toAddresses(addresses), nil
+}
+
+func (r *mutationResolver) CreateAPIKey(ctx context.Context, input CreateAPIKeyInput) (*CreatedAPIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	k, key, err := r.server.accountClient.CreateAPIKey(ctx, input.AccountID, input.Name, input.Permissions, input.ExpiresAt)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return &CreatedAPIKey{APIKey: toAPIKey(k), Key: key}, nil
+}
+
+func (r *mutationResolver) RevokeAPIKey(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := r.server.accountClient.RevokeAPIKey(ctx, id); err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *mutationResolver) CloseAccount(ctx context.Context, password string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := r.server.accountClient.CloseAccount(ctx, password); err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *mutationResolver) SuspendAccount(ctx context.Context, userId string, reason string) (*Account, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	a, err := r.server.accountClient.SuspendAccount(ctx, userId, reason)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	return accountNode(a, authz.TokenFromContext(ctx)), nil
+}
+
+func (r *mutationResolver) ImpersonateAccount(ctx context.Context, userID string, reason string) (*Impersonation, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	i, err := r.server.accountClient.ImpersonateAccount(ctx, userID, reason)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	a := i.Account
+	return &Impersonation{
+		AccessToken: i.AccessToken,
+		ExpiresAt:   i.ExpiresAt,
+		SessionID:   i.SessionID,
+		Account:     accountNode(a, i.AccessToken),
 	}, nil
 }
 
-func (r *mutationResolver) RefreshToken(ctx context.Context, input RefreshTokenInput) (string, error) {
+func (r *mutationResolver) EndImpersonation(ctx context.Context) (bool, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	accessToken, err := r.server.accountClient.RefreshToken(ctx, input.RefreshToken)
+	if err := r.server.accountClient.EndImpersonation(ctx); err != nil {
+		log.Println(err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *mutationResolver) ReactivateAccount(ctx context.Context, userId string, reason string) (*Account, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	a, err := r.server.accountClient.ReactivateAccount(ctx, userId, reason)
 	if err != nil {
 		log.Println(err)
-		return "", err
+		return nil, err
 	}
 
-	return accessToken, nil
+	return accountNode(a, authz.TokenFromContext(ctx)), nil
 }
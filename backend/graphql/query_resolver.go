@@ -3,68 +3,116 @@ package main
 import (
 	"context"
 	"log"
+	"sort"
 	"time"
 
+	"github.com/JonathanNithi/ecommerce/backend/account"
+	"github.com/JonathanNithi/ecommerce/backend/audit"
+	"github.com/JonathanNithi/ecommerce/backend/authz"
+	"github.com/JonathanNithi/ecommerce/backend/catalog"
 	"github.com/JonathanNithi/ecommerce/backend/catalog/pb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type queryResolver struct {
 	server *Server
 }
 
-func (r *queryResolver) Accounts(ctx context.Context, pagination *PaginationInput, id *string, accessToken string, refreshToken string) ([]*Account, error) {
+func (r *queryResolver) Accounts(ctx context.Context, first *int, after *string, filter *AccountFilter, id *string, accessToken *string, refreshToken *string) (*AccountConnection, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
+	ctx = withTokenArgument(ctx, accessToken)
 
 	// Get single
 	if id != nil {
-		acc, newAccessToken, newRefreshToken, err := r.server.accountClient.GetAccount(ctx, *id, accessToken, refreshToken)
+		acc, err := r.server.accountClient.GetAccount(ctx, *id)
 		if err != nil {
 			log.Println(err)
 			return nil, err
 		}
 
-		_ = newAccessToken
-		_ = newRefreshToken
-		return []*Account{{
-			ID:        acc.ID,
-			FirstName: acc.FirstName,
-			LastName:  acc.LastName,
-			Email:     acc.Email,
-			Role:      Role(acc.Role),
-		}}, nil
+		return &AccountConnection{
+			Edges: []*AccountEdge{{
+				Cursor: account.AccountCursor(acc.ID),
+				Node:   accountNode(acc, authz.TokenFromContext(ctx)),
+			}},
+			PageInfo:   &PageInfo{},
+			TotalCount: 1,
+		}, nil
 	}
 
-	skip, take := uint64(0), uint64(0)
-	if pagination != nil {
-		skip, take = pagination.bounds()
+	var f account.AccountFilter
+	if filter != nil {
+		f.CreatedAfter = filter.CreatedAfter
+		f.CreatedBefore = filter.CreatedBefore
+		if filter.Query != nil {
+			f.Query = *filter.Query
+		}
+		if filter.Role != nil {
+			f.Role = *filter.Role
+		}
+		if filter.Status != nil {
+			f.Status = string(*filter.Status)
+		}
+	}
+	pageSize := uint32(0)
+	if first != nil {
+		if *first < 0 {
+			return nil, ErrInvalidParameter
+		}
+		pageSize = uint32(*first)
 	}
-	//Get all
-	accountList, newAccessToken, newRefreshToken, err := r.server.accountClient.GetAccounts(ctx, skip, take, accessToken, refreshToken)
+	cursor := ""
+	if after != nil {
+		cursor = *after
+	}
+
+	res, err := r.server.accountClient.SearchAccounts(ctx, f, pageSize, cursor)
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
 
-	_ = newAccessToken
-	_ = newRefreshToken
-
-	var accounts []*Account
-	for _, a := range accountList {
-		account := &Account{
-			ID:        a.ID,
-			FirstName: a.FirstName,
-			LastName:  a.LastName,
-			Email:     a.Email,
-			Role:      Role(a.Role),
-		}
-		accounts = append(accounts, account)
+	conn := &AccountConnection{
+		Edges:      []*AccountEdge{},
+		PageInfo:   &PageInfo{HasNextPage: res.HasNextPage},
+		TotalCount: int(res.TotalCount),
+	}
+	if res.EndCursor != "" {
+		conn.PageInfo.EndCursor = &res.EndCursor
 	}
+	for _, e := range res.Edges {
+		conn.Edges = append(conn.Edges, &AccountEdge{
+			Cursor: e.Cursor,
+			Node:   accountNode(e.Account, authz.TokenFromContext(ctx)),
+		})
+	}
+
+	return conn, nil
+}
 
-	return accounts, nil
+// accountNode is the only conversion from an account to its node so that no resolver leaves
+// out fields. It keeps the access token so the orders and addresses of the account can be resolved.
+func accountNode(a *account.Account, accessToken string) *Account {
+	return &Account{
+		ID:            a.ID,
+		FirstName:     a.FirstName,
+		LastName:      a.LastName,
+		Email:         a.Email,
+		EmailVerified: a.EmailVerified,
+		MfaEnabled:    a.MfaEnabled,
+		Status:        AccountStatus(a.Status),
+		StatusReason:  a.StatusReason,
+		CreatedAt:     a.CreatedAt,
+		Role:          Role(a.Role),
+		Roles:         a.Roles,
+		Permissions:   a.Permissions,
+		accessToken:   accessToken,
+	}
 }
 
-func (r *queryResolver) Products(ctx context.Context, pagination *PaginationInput, query *string, id *string, category *string, sort *ProductSortInput) (*ProductListResponse, error) {
+func (r *queryResolver) Products(ctx context.Context, pagination *PaginationInput, query *string, id *string, category *string, filter *ProductFilterInput, sort *ProductSortInput) (*ProductListResponse, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
@@ -100,9 +148,19 @@ func (r *queryResolver) Products(ctx context.Context, pagination *PaginationInpu
 	if query != nil {
 		q = *query
 	}
-	categoryValue := ""
-	if category != nil {
-		categoryValue = *category
+	f := catalog.ProductFilter{}
+	if filter != nil {
+		f = catalog.ProductFilter{
+			Categories: filter.Categories,
+			Tags:       filter.Tags,
+			MinPrice:   filter.MinPrice,
+			MaxPrice:   filter.MaxPrice,
+			Available:  filter.Available,
+		}
+	}
+	// category predates filter and is kept for existing clients
+	if category != nil && *category != "" {
+		f.Categories = append(f.Categories, *category)
 	}
 
 	var sortBy *pb.ProductSortInput
@@ -113,7 +171,7 @@ func (r *queryResolver) Products(ctx context.Context, pagination *PaginationInpu
 		}
 	}
 
-	productList, totalCount, err := r.server.catalogClient.GetProducts(ctx, skip, take, nil, q, categoryValue, sortBy)
+	productList, totalCount, facets, err := r.server.catalogClient.SearchProducts(ctx, skip, take, q, f, sortBy)
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -139,9 +197,63 @@ func (r *queryResolver) Products(ctx context.Context, pagination *PaginationInpu
 	return &ProductListResponse{
 		Items:      products,
 		TotalCount: int(totalCount), // Get the total count from the gRPC response
+		Facets:     toProductFacets(facets),
 	}, nil
 }
 
+func toProductFacets(f *catalog.Facets) *ProductFacets {
+	if f == nil {
+		return nil
+	}
+	toBuckets := func(buckets []catalog.FacetBucket) []*FacetBucket {
+		res := []*FacetBucket{}
+		for _, b := range buckets {
+			res = append(res, &FacetBucket{Value: b.Value, Count: int(b.Count)})
+		}
+		return res
+	}
+	facets := &ProductFacets{
+		Categories:  toBuckets(f.Categories),
+		Tags:        toBuckets(f.Tags),
+		PriceRanges: []*PriceRangeBucket{},
+	}
+	for _, b := range f.PriceRanges {
+		facets.PriceRanges = append(facets.PriceRanges, &PriceRangeBucket{From: b.From, To: b.To, Count: int(b.Count)})
+	}
+	return facets
+}
+
+// suggestionTimeout is the latency budget of productSuggestions, typeahead results that
+// arrive later are stale by the time they would be shown.
+const suggestionTimeout = 300 * time.Millisecond
+
+func (r *queryResolver) ProductSuggestions(ctx context.Context, prefix string, first *int) (*ProductSuggestions, error) {
+	ctx, cancel := context.WithTimeout(ctx, suggestionTimeout)
+	defer cancel()
+
+	limit := uint32(0)
+	if first != nil && *first > 0 {
+		limit = uint32(*first)
+	}
+
+	res := &ProductSuggestions{Products: []*ProductSuggestion{}, Categories: []string{}}
+	suggestions, err := r.server.catalogClient.SuggestProducts(ctx, prefix, limit)
+	if status.Code(err) == codes.DeadlineExceeded {
+		log.Printf("Suggestions for %q exceeded %v", prefix, suggestionTimeout)
+		return res, nil
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	for _, p := range suggestions.Products {
+		res.Products = append(res.Products, &ProductSuggestion{ID: p.ID, Name: p.Name})
+	}
+	res.Categories = append(res.Categories, suggestions.Categories...)
+	return res, nil
+}
+
 // generate a function for productsWithIds similar to the above function
 func (r *queryResolver) ProductsByID(ctx context.Context, ids []string) ([]*Product, error) {
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
@@ -166,6 +278,7 @@ func (r *queryResolver) ProductsByID(ctx context.Context, ids []string) ([]*Prod
 				Tags:         a.Tags,
 				Availability: a.Availability,
 				Stock:        int(a.Stock),
+				Deleted:      a.Deleted,
 			},
 		)
 	}
@@ -173,6 +286,86 @@ func (r *queryResolver) ProductsByID(ctx context.Context, ids []string) ([]*Prod
 	return products, nil
 }
 
+func (r *queryResolver) APIKeys(ctx context.Context, accountID string) ([]*APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	keys, err := r.server.accountClient.ListAPIKeys(ctx, accountID)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	res := []*APIKey{}
+	for i := range keys {
+		res = append(res, toAPIKey(&keys[i]))
+	}
+	return res, nil
+}
+
+func (r *queryResolver) AuditLog(ctx context.Context, filter *AuditFilter, first *int) ([]*AuditEvent, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	f := audit.Filter{}
+	if filter != nil {
+		if filter.Actor != nil {
+			f.Actor = *filter.Actor
+		}
+		if filter.Target != nil {
+			f.Target = *filter.Target
+		}
+		if filter.Action != nil {
+			f.Action = *filter.Action
+		}
+		f.Since = filter.Since
+		f.Until = filter.Until
+	}
+	if first != nil {
+		f.Limit = *first
+	}
+
+	// Each service returns its newest events, the newest of all of them make up the page
+	events := []audit.Event{}
+	for _, c := range r.server.auditClients {
+		serviceEvents, err := c.ListEvents(ctx, f)
+		if err != nil {
+			log.Println(err)
+			return nil, err
+		}
+		events = append(events, serviceEvents...)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].CreatedAt.After(events[j].CreatedAt)
+	})
+	if len(events) > f.PageSize() {
+		events = events[:f.PageSize()]
+	}
+
+	result := []*AuditEvent{}
+	for _, e := range events {
+		result = append(result, &AuditEvent{
+			ID:        e.ID,
+			Actor:     e.Actor,
+			Action:    e.Action,
+			Target:    e.Target,
+			Before:    auditSnapshot(e.Before),
+			After:     auditSnapshot(e.After),
+			RequestID: e.RequestID,
+			CreatedAt: e.CreatedAt,
+		})
+	}
+	return result, nil
+}
+
+func auditSnapshot(b []byte) *string {
+	if len(b) == 0 {
+		return nil
+	}
+	s := string(b)
+	return &s
+}
+
 func (p PaginationInput) bounds() (uint64, uint64) {
 	skipValue := uint64(0)
 	takeValue := uint64(100)
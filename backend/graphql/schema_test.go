@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+// Credentials stay inside the account service, no type a client can query may carry them.
+// Inputs are exempt since clients have to send passwords to log in, and so are the root
+// types whose fields name operations rather than data.
+func TestSchema_OutputTypesHaveNoCredentialFields(t *testing.T) {
+	for name, def := range parsedSchema.Types {
+		if def.Kind != ast.Object && def.Kind != ast.Interface || strings.HasPrefix(name, "__") {
+			continue
+		}
+		if def == parsedSchema.Query || def == parsedSchema.Mutation || def == parsedSchema.Subscription {
+			continue
+		}
+		for _, field := range def.Fields {
+			lower := strings.ToLower(field.Name)
+			if strings.Contains(lower, "password") || strings.Contains(lower, "hash") {
+				t.Errorf("%s.%s exposes credentials", name, field.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+var errUsage = errors.New("usage: migrate [up | down [steps] | status]")
+
+// Command runs the migrate subcommand of a service binary with the arguments following
+// it: up applies pending migrations and is the default, down reverts the last steps
+// migrations (one unless given) and status lists them.
+func Command(ctx context.Context, m *Migrator, args []string, out io.Writer) error {
+	action := "up"
+	if len(args) > 0 {
+		action, args = args[0], args[1:]
+	}
+
+	switch action {
+	case "up":
+		if len(args) > 0 {
+			return errUsage
+		}
+		done, err := m.Up(ctx)
+		for _, migration := range done {
+			fmt.Fprintf(out, "applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Fprintln(out, "no pending migrations")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			return errUsage
+		}
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return fmt.Errorf("steps must be a positive number, got %s", args[0])
+			}
+			steps = n
+		}
+		done, err := m.Down(ctx, steps)
+		for _, migration := range done {
+			fmt.Fprintf(out, "reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+		return err
+	case "status":
+		if len(args) > 0 {
+			return errUsage
+		}
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied " + s.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(out, "%04d_%s\t%s\n", s.Version, s.Name, state)
+		}
+		return nil
+	}
+	return errUsage
+}
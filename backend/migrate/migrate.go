@@ -0,0 +1,278 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// lockName identifies the advisory lock held while migrating, so replicas starting
+// together apply each migration once.
+const lockName = "schema_migrations"
+
+var (
+	ErrNoDownMigration = errors.New("migration has no down migration")
+
+	fileNamePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+)
+
+// Migration is one numbered schema change, read from <version>_<name>.up.sql and the
+// optional <version>_<name>.down.sql. Each runs in a transaction, so statements that
+// cannot, such as CREATE INDEX CONCURRENTLY, do not belong in a migration.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and when it was applied, AppliedAt is nil while it is pending.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Load reads the migrations at the root of fsys, ordered by version.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+		match := fileNamePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s, expected <version>_<name>.up.sql or .down.sql", entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", entry.Name(), err)
+		}
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up migration", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// pending returns the migrations not yet applied, in the order they are to be applied.
+// Applied versions unknown to this build are left alone, they belong to a newer release.
+func pending(migrations []Migration, applied map[int64]time.Time) []Migration {
+	res := []Migration{}
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			res = append(res, m)
+		}
+	}
+	return res
+}
+
+// rollbacks returns the last steps applied migrations, newest first.
+func rollbacks(migrations []Migration, applied map[int64]time.Time, steps int) ([]Migration, error) {
+	res := []Migration{}
+	for i := len(migrations) - 1; i >= 0 && len(res) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if m.Down == "" {
+			return nil, fmt.Errorf("%w: %d_%s", ErrNoDownMigration, m.Version, m.Name)
+		}
+		res = append(res, m)
+	}
+	return res, nil
+}
+
+// Migrator applies the migrations of one service to its database.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// NewPostgresMigrator connects to the database of the owning service and loads its
+// migrations from fsys.
+func NewPostgresMigrator(url string, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Migrator{db, migrations}, nil
+}
+
+func (m *Migrator) Close() {
+	m.db.Close()
+}
+
+// Up applies all pending migrations and returns them.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		for _, migration := range pending(m.migrations, applied) {
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations(version, name, applied_at) VALUES ($1, $2, $3)", migration.Version, migration.Name, time.Now().UTC())
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("error applying migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the last steps applied migrations and returns them, newest first.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]time.Time) error {
+		migrations, err := rollbacks(m.migrations, applied, steps)
+		if err != nil {
+			return err
+		}
+		for _, migration := range migrations {
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("error reverting migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Status lists every known migration and whether it was applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var res []Status
+	err := m.locked(ctx, func(_ *sql.Conn, applied map[int64]time.Time) error {
+		for _, migration := range m.migrations {
+			s := Status{Migration: migration}
+			if at, ok := applied[migration.Version]; ok {
+				s.AppliedAt = &at
+			}
+			res = append(res, s)
+		}
+		return nil
+	})
+	return res, err
+}
+
+// locked runs f holding the advisory lock on a single connection, since the lock belongs
+// to the session that took it, and passes it the versions applied so far.
+func (m *Migrator) locked(ctx context.Context, f func(conn *sql.Conn, applied map[int64]time.Time) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	h := fnv.New64a()
+	h.Write([]byte(lockName))
+	lockID := int64(h.Sum64())
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+		return fmt.Errorf("error acquiring migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockID)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+  version BIGINT PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  applied_at TIMESTAMP WITH TIME ZONE NOT NULL
+)`)
+	if err != nil {
+		return err
+	}
+
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+	applied := map[int64]time.Time{}
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			rows.Close()
+			return err
+		}
+		applied[version] = at
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return f(conn, applied)
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, f func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
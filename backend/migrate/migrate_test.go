@@ -0,0 +1,89 @@
+package migrate
+
+import (
+	"context"
+	"io"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"0002_add_index.up.sql":   {Data: []byte("CREATE INDEX a_idx ON a (b);")},
+		"0002_add_index.down.sql": {Data: []byte("DROP INDEX a_idx;")},
+		"0001_init.up.sql":        {Data: []byte("CREATE TABLE a (b INT);")},
+		"0003_seed.up.sql":        {Data: []byte("INSERT INTO a VALUES (1);")},
+		"README.md":               {Data: []byte("not a migration")},
+	}
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(testFS())
+
+	assert.NoError(t, err)
+	if assert.Len(t, migrations, 3) {
+		assert.Equal(t, Migration{Version: 1, Name: "init", Up: "CREATE TABLE a (b INT);"}, migrations[0])
+		assert.Equal(t, int64(2), migrations[1].Version)
+		assert.Equal(t, "DROP INDEX a_idx;", migrations[1].Down)
+		assert.Equal(t, "seed", migrations[2].Name)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+		err  string
+	}{
+		{"bad name", fstest.MapFS{"init.sql": {Data: []byte("SELECT 1;")}}, "invalid migration file name init.sql, expected <version>_<name>.up.sql or .down.sql"},
+		{"down only", fstest.MapFS{"0001_init.down.sql": {Data: []byte("SELECT 1;")}}, "migration 1_init has no up migration"},
+		{"name mismatch", fstest.MapFS{
+			"0001_init.up.sql":  {Data: []byte("SELECT 1;")},
+			"0001_other.up.sql": {Data: []byte("SELECT 1;")},
+		}, "migration 1 is named both init and other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.fsys)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestPending(t *testing.T) {
+	migrations, _ := Load(testFS())
+
+	// Version 9 was applied by a newer release and is ignored
+	res := pending(migrations, map[int64]time.Time{1: time.Now(), 9: time.Now()})
+
+	if assert.Len(t, res, 2) {
+		assert.Equal(t, int64(2), res[0].Version)
+		assert.Equal(t, int64(3), res[1].Version)
+	}
+}
+
+func TestRollbacks(t *testing.T) {
+	migrations, _ := Load(testFS())
+
+	res, err := rollbacks(migrations, map[int64]time.Time{1: time.Now(), 2: time.Now()}, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, res, 1) {
+		assert.Equal(t, int64(2), res[0].Version)
+	}
+
+	_, err = rollbacks(migrations, map[int64]time.Time{1: time.Now(), 2: time.Now(), 3: time.Now()}, 1)
+	assert.ErrorIs(t, err, ErrNoDownMigration)
+}
+
+func TestCommand_Usage(t *testing.T) {
+	for _, args := range [][]string{{"sideways"}, {"up", "2"}, {"down", "1", "2"}, {"status", "all"}} {
+		err := Command(context.Background(), nil, args, io.Discard)
+		assert.ErrorIs(t, err, errUsage, args)
+	}
+
+	err := Command(context.Background(), nil, []string{"down", "0"}, io.Discard)
+	assert.EqualError(t, err, "steps must be a positive number, got 0")
+}
@@ -0,0 +1,326 @@
+package notification
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockRepository for testing
+type MockRepository struct {
+	mock.Mock
+}
+
+func (m *MockRepository) PutMessage(ctx context.Context, msg Message) error {
+	args := m.Called(ctx, msg)
+	return args.Error(0)
+}
+
+func (m *MockRepository) ClaimMessages(ctx context.Context, limit int, lease time.Duration) ([]Message, error) {
+	args := m.Called(ctx, limit, lease)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]Message), args.Error(1)
+}
+
+func (m *MockRepository) MarkSent(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockRepository) MarkFailed(ctx context.Context, id string, nextAttemptAt time.Time, lastError string) error {
+	args := m.Called(ctx, id, nextAttemptAt, lastError)
+	return args.Error(0)
+}
+
+func (m *MockRepository) MarkDead(ctx context.Context, id string, lastError string) error {
+	args := m.Called(ctx, id, lastError)
+	return args.Error(0)
+}
+
+func (m *MockRepository) Close() {
+}
+
+// fakeSender records sent messages and fails while err is set
+type fakeSender struct {
+	sent []Message
+	err  error
+}
+
+func (s *fakeSender) Send(ctx context.Context, m Message) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+func TestRenderer_PasswordReset(t *testing.T) {
+	renderer, err := NewRenderer("http://shop.test")
+	require.NoError(t, err)
+
+	subject, text, html, err := renderer.Render(TemplatePasswordReset, PasswordResetData{FirstName: "<Jane>", Token: "abc123"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Reset your password", subject)
+	assert.Contains(t, text, "Hello <Jane>,")
+	assert.Contains(t, text, "http://shop.test/forgot-password?token=abc123")
+	assert.Contains(t, html, "Hello &lt;Jane&gt;,")
+	assert.Contains(t, html, `href="http://shop.test/forgot-password?token=abc123"`)
+}
+
+func TestRenderer_EmailVerification(t *testing.T) {
+	renderer, err := NewRenderer("http://shop.test")
+	require.NoError(t, err)
+
+	subject, text, html, err := renderer.Render(TemplateEmailVerification, EmailVerificationData{FirstName: "Jane", Token: "abc123"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Verify your email address", subject)
+	assert.Contains(t, text, "http://shop.test/verify-email?token=abc123")
+	assert.Contains(t, html, `href="http://shop.test/verify-email?token=abc123"`)
+}
+
+func TestRenderer_EmailChange(t *testing.T) {
+	renderer, err := NewRenderer("http://shop.test")
+	require.NoError(t, err)
+
+	subject, text, html, err := renderer.Render(TemplateEmailChange, EmailChangeData{FirstName: "Jane", NewEmail: "jane@new.test", Token: "abc123"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Confirm your new email address", subject)
+	assert.Contains(t, text, "to jane@new.test.")
+	assert.Contains(t, text, "http://shop.test/confirm-email?token=abc123")
+	assert.Contains(t, html, `href="http://shop.test/confirm-email?token=abc123"`)
+}
+
+func TestRenderer_OrderConfirmation(t *testing.T) {
+	renderer, err := NewRenderer("http://shop.test")
+	require.NoError(t, err)
+
+	data := OrderConfirmationData{
+		OrderID:    "order1",
+		TotalPrice: 25,
+		Products:   []OrderLine{{Name: "Product 1", Price: 10, Quantity: 2}, {Name: "Product 2", Price: 5, Quantity: 1}},
+	}
+	subject, text, html, err := renderer.Render(TemplateOrderConfirmation, data)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Your order order1 has been placed", subject)
+	assert.Contains(t, text, "- 2 x Product 1 @ 10.00")
+	assert.Contains(t, text, "Total: 25.00")
+	assert.Contains(t, html, "<li>1 x Product 2 @ 5.00</li>")
+}
+
+func TestRenderer_UnknownTemplate(t *testing.T) {
+	renderer, err := NewRenderer("http://shop.test")
+	require.NoError(t, err)
+
+	_, _, _, err = renderer.Render("does_not_exist", nil)
+
+	assert.Error(t, err)
+}
+
+func TestOutboxNotifier_Notify_StoresRenderedMessage(t *testing.T) {
+	mockRepo := new(MockRepository)
+	renderer, err := NewRenderer("http://shop.test")
+	require.NoError(t, err)
+	notifier := NewOutboxNotifier(mockRepo, renderer)
+	ctx := context.Background()
+
+	mockRepo.On("PutMessage", ctx, mock.MatchedBy(func(m Message) bool {
+		return m.ID != "" &&
+			m.To == "jane@example.com" &&
+			m.Subject == "[Admin alert] Low stock" &&
+			strings.Contains(m.Text, "Only 2 left") &&
+			m.Attempts == 0 &&
+			!m.NextAttemptAt.After(time.Now())
+	})).Return(nil).Once()
+
+	err = notifier.Notify(ctx, "jane@example.com", TemplateAdminAlert, AdminAlertData{Title: "Low stock", Body: "Only 2 left"})
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestWorker_ProcessBatch_Success(t *testing.T) {
+	mockRepo := new(MockRepository)
+	sender := &fakeSender{}
+	worker := NewWorker(mockRepo, sender)
+	ctx := context.Background()
+
+	messages := []Message{{ID: "1", To: "a@example.com"}, {ID: "2", To: "b@example.com"}}
+	mockRepo.On("ClaimMessages", ctx, defaultBatchSize, claimLease).Return(messages, nil).Once()
+	mockRepo.On("MarkSent", ctx, "1").Return(nil).Once()
+	mockRepo.On("MarkSent", ctx, "2").Return(nil).Once()
+
+	sent, err := worker.processBatch(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Len(t, sender.sent, 2)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestWorker_ProcessBatch_RetriesWithBackoff(t *testing.T) {
+	mockRepo := new(MockRepository)
+	sender := &fakeSender{err: errors.New("connection refused")}
+	worker := NewWorker(mockRepo, sender)
+	ctx := context.Background()
+
+	messages := []Message{{ID: "1", To: "a@example.com", Attempts: 2}}
+	mockRepo.On("ClaimMessages", ctx, defaultBatchSize, claimLease).Return(messages, nil).Once()
+	mockRepo.On("MarkFailed", ctx, "1", mock.MatchedBy(func(next time.Time) bool {
+		// Third failure waits 2 minutes
+		return next.Sub(time.Now()) > 115*time.Second && next.Sub(time.Now()) <= 2*time.Minute
+	}), "connection refused").Return(nil).Once()
+
+	sent, err := worker.processBatch(ctx)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sent)
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "MarkSent", mock.Anything, mock.Anything)
+}
+
+func TestWorker_ProcessBatch_GivesUpAfterMaxAttempts(t *testing.T) {
+	mockRepo := new(MockRepository)
+	sender := &fakeSender{err: errors.New("mailbox unavailable")}
+	worker := NewWorker(mockRepo, sender)
+	ctx := context.Background()
+
+	messages := []Message{{ID: "1", To: "a@example.com", Attempts: defaultMaxAttempts - 1}}
+	mockRepo.On("ClaimMessages", ctx, defaultBatchSize, claimLease).Return(messages, nil).Once()
+	mockRepo.On("MarkDead", ctx, "1", "mailbox unavailable").Return(nil).Once()
+
+	_, err := worker.processBatch(ctx)
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "MarkFailed", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 30*time.Second, backoff(1))
+	assert.Equal(t, 1*time.Minute, backoff(2))
+	assert.Equal(t, 2*time.Minute, backoff(3))
+	assert.Equal(t, maxBackoff, backoff(20))
+}
+
+func TestWriterSender_Send(t *testing.T) {
+	var buf bytes.Buffer
+	sender := NewWriterSender(&buf, "shop@example.com")
+
+	err := sender.Send(context.Background(), Message{ID: "1", To: "jane@example.com", Subject: "Hello", Text: "plain body", HTML: "<p>html body</p>"})
+
+	assert.NoError(t, err)
+	out := buf.String()
+	assert.Contains(t, out, "From: shop@example.com")
+	assert.Contains(t, out, "To: jane@example.com")
+	assert.Contains(t, out, "Content-Type: multipart/alternative")
+	assert.Contains(t, out, "plain body")
+	assert.Contains(t, out, "<p>html body</p>")
+}
+
+func TestSMTPSender_SendsToFakeServer(t *testing.T) {
+	addr, received := startFakeSMTPServer(t)
+	sender := NewSMTPSender(addr, "", "", "shop@example.com")
+
+	err := sender.Send(context.Background(), Message{ID: "1", To: "jane@example.com", Subject: "Your order", Text: "plain body", HTML: "<p>html body</p>"})
+	require.NoError(t, err)
+
+	select {
+	case mail := <-received:
+		assert.Equal(t, "shop@example.com", mail.from)
+		assert.Equal(t, []string{"jane@example.com"}, mail.to)
+		assert.Contains(t, mail.data, "Subject: Your order")
+		assert.Contains(t, mail.data, "plain body")
+		assert.Contains(t, mail.data, "<p>html body</p>")
+	case <-time.After(5 * time.Second):
+		t.Fatal("fake SMTP server did not receive the message")
+	}
+}
+
+type fakeMail struct {
+	from string
+	to   []string
+	data string
+}
+
+// startFakeSMTPServer accepts a single SMTP session on a random local port.
+func startFakeSMTPServer(t *testing.T) (string, <-chan fakeMail) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { lis.Close() })
+
+	received := make(chan fakeMail, 1)
+	go func() {
+		conn, err := lis.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		tp := textproto.NewConn(conn)
+		mail := fakeMail{}
+		tp.PrintfLine("220 fake.smtp ready")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				tp.PrintfLine("250 fake.smtp")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				mail.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+				tp.PrintfLine("250 OK")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				mail.to = append(mail.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+				tp.PrintfLine("250 OK")
+			case cmd == "DATA":
+				tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+				data, err := readDotLines(tp.R)
+				if err != nil {
+					return
+				}
+				mail.data = data
+				tp.PrintfLine("250 OK")
+				received <- mail
+			case cmd == "QUIT":
+				tp.PrintfLine("221 Bye")
+				return
+			default:
+				tp.PrintfLine("502 Command not implemented")
+			}
+		}
+	}()
+
+	return lis.Addr().String(), received
+}
+
+func readDotLines(r *bufio.Reader) (string, error) {
+	var b strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		if line == ".\r\n" {
+			return b.String(), nil
+		}
+		b.WriteString(line)
+	}
+}
@@ -0,0 +1,151 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/segmentio/ksuid"
+)
+
+const (
+	TemplatePasswordReset     = "password_reset"
+	TemplateOrderConfirmation = "order_confirmation"
+	TemplateAdminAlert        = "admin_alert"
+	TemplateEmailChange       = "email_change"
+	TemplateEmailVerification = "email_verification"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+// Notifier queues a templated message for delivery to a single recipient.
+type Notifier interface {
+	Notify(ctx context.Context, to string, template string, data interface{}) error
+}
+
+// Message is a rendered email waiting in, or taken from, the outbox.
+type Message struct {
+	ID            string    `json:"id"`
+	To            string    `json:"to"`
+	Subject       string    `json:"subject"`
+	Text          string    `json:"text"`
+	HTML          string    `json:"html"`
+	Attempts      int       `json:"attempts"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type PasswordResetData struct {
+	FirstName string
+	Token     string
+}
+
+type EmailVerificationData struct {
+	FirstName string
+	Token     string
+}
+
+type EmailChangeData struct {
+	FirstName string
+	NewEmail  string
+	Token     string
+}
+
+type OrderConfirmationData struct {
+	OrderID    string
+	TotalPrice float64
+	Products   []OrderLine
+}
+
+type OrderLine struct {
+	Name     string
+	Price    float64
+	Quantity uint32
+}
+
+type AdminAlertData struct {
+	Title string
+	Body  string
+}
+
+// Renderer turns a template name and its data into the subject, text and HTML parts of a message.
+// Each template is defined as "<name>.subject", "<name>.text" and "<name>.html" in templates/.
+type Renderer struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// NewRenderer parses the embedded templates. appURL is the storefront base URL used in links.
+func NewRenderer(appURL string) (*Renderer, error) {
+	funcs := map[string]interface{}{
+		"appURL": func() string { return appURL },
+	}
+
+	text, err := texttemplate.New("").Funcs(funcs).ParseFS(templateFS, "templates/*.txt.tmpl")
+	if err != nil {
+		return nil, fmt.Errorf("error parsing text templates: %w", err)
+	}
+	html, err := htmltemplate.New("").Funcs(funcs).ParseFS(templateFS, "templates/*.html.tmpl")
+	if err != nil {
+		return nil, fmt.Errorf("error parsing html templates: %w", err)
+	}
+
+	return &Renderer{text, html}, nil
+}
+
+func (r *Renderer) Render(name string, data interface{}) (subject string, text string, html string, err error) {
+	var buf bytes.Buffer
+	if err = r.text.ExecuteTemplate(&buf, name+".subject", data); err != nil {
+		return "", "", "", fmt.Errorf("error rendering %s subject: %w", name, err)
+	}
+	subject = buf.String()
+
+	buf.Reset()
+	if err = r.text.ExecuteTemplate(&buf, name+".text", data); err != nil {
+		return "", "", "", fmt.Errorf("error rendering %s text body: %w", name, err)
+	}
+	text = buf.String()
+
+	buf.Reset()
+	if err = r.html.ExecuteTemplate(&buf, name+".html", data); err != nil {
+		return "", "", "", fmt.Errorf("error rendering %s html body: %w", name, err)
+	}
+	html = buf.String()
+
+	return subject, text, html, nil
+}
+
+type outboxNotifier struct {
+	repository Repository
+	renderer   *Renderer
+}
+
+// NewOutboxNotifier returns a Notifier that renders messages and stores them in the outbox.
+// Delivery happens asynchronously through a Worker.
+func NewOutboxNotifier(r Repository, renderer *Renderer) Notifier {
+	return &outboxNotifier{r, renderer}
+}
+
+func (n *outboxNotifier) Notify(ctx context.Context, to string, template string, data interface{}) error {
+	subject, text, html, err := n.renderer.Render(template, data)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	m := Message{
+		ID:            ksuid.New().String(),
+		To:            to,
+		Subject:       subject,
+		Text:          text,
+		HTML:          html,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+	return n.repository.PutMessage(ctx, m)
+}
@@ -0,0 +1,117 @@
+package notification
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// Repository is the outbox of messages waiting to be delivered.
+type Repository interface {
+	Close()
+	PutMessage(ctx context.Context, m Message) error
+	ClaimMessages(ctx context.Context, limit int, lease time.Duration) ([]Message, error)
+	MarkSent(ctx context.Context, id string) error
+	MarkFailed(ctx context.Context, id string, nextAttemptAt time.Time, lastError string) error
+	MarkDead(ctx context.Context, id string, lastError string) error
+}
+
+type postgresRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresRepository connects to the database of the owning service, every service keeps
+// its outbox next to its own data. The migrations of the service create the outbox table.
+func NewPostgresRepository(url string) (Repository, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		return nil, err
+	}
+
+	return &postgresRepository{db}, nil
+}
+
+func (r *postgresRepository) Close() {
+	r.db.Close()
+}
+
+func (r *postgresRepository) PutMessage(ctx context.Context, m Message) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO notification_outbox(id, recipient, subject, text_body, html_body, attempts, next_attempt_at, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		m.ID, m.To, m.Subject, m.Text, m.HTML, m.Attempts, m.NextAttemptAt, m.CreatedAt,
+	)
+	return err
+}
+
+// ClaimMessages leases up to limit due messages by pushing their next attempt into the future,
+// so concurrent workers never pick up the same message.
+func (r *postgresRepository) ClaimMessages(ctx context.Context, limit int, lease time.Duration) ([]Message, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`UPDATE notification_outbox SET next_attempt_at = $2
+    WHERE id IN (
+      SELECT id FROM notification_outbox
+      WHERE sent_at IS NULL AND dead_at IS NULL AND next_attempt_at <= now()
+      ORDER BY next_attempt_at
+      LIMIT $1
+      FOR UPDATE SKIP LOCKED
+    )
+    RETURNING id, recipient, subject, text_body, html_body, attempts, next_attempt_at, created_at`,
+		limit, time.Now().UTC().Add(lease),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		m := Message{}
+		if err = rows.Scan(&m.ID, &m.To, &m.Subject, &m.Text, &m.HTML, &m.Attempts, &m.NextAttemptAt, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func (r *postgresRepository) MarkSent(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE notification_outbox SET sent_at = now(), attempts = attempts + 1, last_error = NULL WHERE id = $1",
+		id,
+	)
+	return err
+}
+
+func (r *postgresRepository) MarkFailed(ctx context.Context, id string, nextAttemptAt time.Time, lastError string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE notification_outbox SET attempts = attempts + 1, next_attempt_at = $2, last_error = $3 WHERE id = $1",
+		id, nextAttemptAt, lastError,
+	)
+	return err
+}
+
+// MarkDead gives up on a message after too many failed attempts.
+func (r *postgresRepository) MarkDead(ctx context.Context, id string, lastError string) error {
+	_, err := r.db.ExecContext(
+		ctx,
+		"UPDATE notification_outbox SET dead_at = now(), attempts = attempts + 1, last_error = $2 WHERE id = $1",
+		id, lastError,
+	)
+	return err
+}
@@ -0,0 +1,145 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Sender delivers a rendered message over a concrete transport.
+type Sender interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// Config selects and configures the transport used by the outbox worker.
+type Config struct {
+	Sink         string `envconfig:"NOTIFICATION_SINK" default:"stdout"` // smtp, file or stdout
+	FilePath     string `envconfig:"NOTIFICATION_FILE" default:"notifications.log"`
+	SMTPHost     string `envconfig:"SMTP_HOST" default:"localhost"`
+	SMTPPort     int    `envconfig:"SMTP_PORT" default:"1025"`
+	SMTPUsername string `envconfig:"SMTP_USERNAME"`
+	SMTPPassword string `envconfig:"SMTP_PASSWORD"`
+	From         string `envconfig:"MAIL_FROM" default:"no-reply@ecommerce.local"`
+	AppURL       string `envconfig:"APP_URL" default:"http://localhost:3000"`
+}
+
+func NewSender(cfg Config) (Sender, error) {
+	switch cfg.Sink {
+	case "smtp":
+		return NewSMTPSender(net.JoinHostPort(cfg.SMTPHost, strconv.Itoa(cfg.SMTPPort)), cfg.SMTPUsername, cfg.SMTPPassword, cfg.From), nil
+	case "file":
+		return NewFileSender(cfg.FilePath, cfg.From)
+	case "stdout", "":
+		return NewWriterSender(os.Stdout, cfg.From), nil
+	default:
+		return nil, fmt.Errorf("unknown notification sink %q", cfg.Sink)
+	}
+}
+
+type smtpSender struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPSender sends mail through the SMTP server at addr. Authentication is only
+// attempted when a username is configured, which keeps local stand-in servers simple.
+func NewSMTPSender(addr string, username string, password string, from string) Sender {
+	var auth smtp.Auth
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &smtpSender{addr, auth, from}
+}
+
+func (s *smtpSender) Send(ctx context.Context, m Message) error {
+	body, err := buildMIME(s.from, m)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{m.To}, body)
+}
+
+type writerSender struct {
+	mu   sync.Mutex
+	w    io.Writer
+	from string
+}
+
+// NewWriterSender writes every message in MIME form to w instead of sending it.
+func NewWriterSender(w io.Writer, from string) Sender {
+	return &writerSender{w: w, from: from}
+}
+
+// NewFileSender appends every message to the file at path.
+func NewFileSender(path string, from string) (Sender, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening notification file: %w", err)
+	}
+	return NewWriterSender(f, from), nil
+}
+
+func (s *writerSender) Send(ctx context.Context, m Message) error {
+	body, err := buildMIME(s.from, m)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = fmt.Fprintf(s.w, "----- message %s -----\r\n%s\r\n", m.ID, body)
+	return err
+}
+
+// buildMIME encodes m as a multipart/alternative email with a text and an HTML part.
+func buildMIME(from string, m Message) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	}
+	for _, p := range parts {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(pw, p.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", m.To)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "Message-ID: <%s@ecommerce.local>\r\n", m.ID)
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
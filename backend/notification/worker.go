@@ -0,0 +1,98 @@
+package notification
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	defaultPollInterval = 5 * time.Second
+	defaultBatchSize    = 20
+	defaultMaxAttempts  = 8
+	claimLease          = 5 * time.Minute
+	baseBackoff         = 30 * time.Second
+	maxBackoff          = 1 * time.Hour
+)
+
+// Worker drains the outbox, retrying failed deliveries with exponential backoff.
+type Worker struct {
+	repository   Repository
+	sender       Sender
+	pollInterval time.Duration
+	batchSize    int
+	maxAttempts  int
+}
+
+func NewWorker(r Repository, s Sender) *Worker {
+	return &Worker{
+		repository:   r,
+		sender:       s,
+		pollInterval: defaultPollInterval,
+		batchSize:    defaultBatchSize,
+		maxAttempts:  defaultMaxAttempts,
+	}
+}
+
+// Run polls the outbox until ctx is cancelled.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := w.processBatch(ctx); err != nil {
+			log.Println("Error processing notification outbox:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processBatch delivers one batch of due messages and returns how many were sent.
+func (w *Worker) processBatch(ctx context.Context) (int, error) {
+	messages, err := w.repository.ClaimMessages(ctx, w.batchSize, claimLease)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, m := range messages {
+		sendErr := w.sender.Send(ctx, m)
+		if sendErr == nil {
+			if err := w.repository.MarkSent(ctx, m.ID); err != nil {
+				return sent, err
+			}
+			sent++
+			continue
+		}
+
+		attempts := m.Attempts + 1
+		log.Printf("Delivering notification %s failed (attempt %d): %v", m.ID, attempts, sendErr)
+		if attempts >= w.maxAttempts {
+			err = w.repository.MarkDead(ctx, m.ID, sendErr.Error())
+		} else {
+			err = w.repository.MarkFailed(ctx, m.ID, time.Now().UTC().Add(backoff(attempts)), sendErr.Error())
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+
+	return sent, nil
+}
+
+// backoff returns the delay before retrying a message that has failed attempts times.
+func backoff(attempts int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return d
+}
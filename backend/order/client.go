@@ -2,9 +2,11 @@ package order
 
 import (
 	"context"
+	"encoding/json"
 	"log"
 	"time"
 
+	"github.com/JonathanNithi/ecommerce/backend/authz"
 	"github.com/JonathanNithi/ecommerce/backend/order/pb"
 	"google.golang.org/grpc"
 )
@@ -14,8 +16,10 @@ type Client struct {
 	service pb.OrderServiceClient
 }
 
+// NewClient connects to the order service. Calls are authenticated with the
+// access token attached to their context by authz.ContextWithToken.
 func NewClient(url string) (*Client, error) {
-	conn, err := grpc.Dial(url, grpc.WithInsecure())
+	conn, err := grpc.Dial(url, append(authz.DialOptions(), grpc.WithInsecure())...)
 	if err != nil {
 		return nil, err
 	}
@@ -30,7 +34,6 @@ func (c *Client) Close() {
 func (c *Client) PostOrder(
 	ctx context.Context,
 	accountID string,
-	accessToken, refreshToken string,
 	products []OrderedProduct,
 ) (*Order, error) {
 	protoProducts := []*pb.PostOrderRequest_OrderProduct{}
@@ -43,10 +46,8 @@ func (c *Client) PostOrder(
 	r, err := c.service.PostOrder(
 		ctx,
 		&pb.PostOrderRequest{
-			AccountId:    accountID,
-			AccessToken:  accessToken,
-			RefreshToken: refreshToken,
-			Products:     protoProducts,
+			AccountId: accountID,
+			Products:  protoProducts,
 		},
 	)
 	if err != nil {
@@ -67,6 +68,15 @@ func (c *Client) PostOrder(
 	}, nil
 }
 
+// ExportOrders returns the orders of an account as JSON for the account service's data exports.
+func (c *Client) ExportOrders(ctx context.Context, accountID string) (json.RawMessage, error) {
+	orders, err := c.GetOrdersForAccount(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(orders)
+}
+
 func (c *Client) GetOrdersForAccount(ctx context.Context, accountID string) ([]Order, error) {
 	r, err := c.service.GetOrdersForAccount(ctx, &pb.GetOrdersForAccountRequest{
 		AccountId: accountID,
@@ -0,0 +1,14 @@
+-- Services used to create the outbox when starting, hence IF NOT EXISTS
+CREATE TABLE IF NOT EXISTS notification_outbox (
+  id CHAR(27) PRIMARY KEY,
+  recipient VARCHAR(255) NOT NULL,
+  subject TEXT NOT NULL,
+  text_body TEXT NOT NULL,
+  html_body TEXT NOT NULL,
+  attempts INT NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  last_error TEXT,
+  sent_at TIMESTAMP WITH TIME ZONE,
+  dead_at TIMESTAMP WITH TIME ZONE,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
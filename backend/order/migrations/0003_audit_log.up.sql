@@ -0,0 +1,26 @@
+-- Services used to create the audit log when starting, hence IF NOT EXISTS
+CREATE TABLE IF NOT EXISTS audit_log (
+  id CHAR(27) PRIMARY KEY,
+  actor VARCHAR(255) NOT NULL,
+  action VARCHAR(64) NOT NULL,
+  target VARCHAR(255) NOT NULL,
+  before JSONB,
+  after JSONB,
+  request_id VARCHAR(128) NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_created_at_idx ON audit_log (created_at);
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor, created_at);
+CREATE INDEX IF NOT EXISTS audit_log_target_idx ON audit_log (target, created_at);
+
+-- Rejecting updates and deletes keeps the log append-only
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE ON audit_log
+  FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
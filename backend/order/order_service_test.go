@@ -6,8 +6,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/JonathanNithi/ecommerce/backend/migrate"
+	"github.com/JonathanNithi/ecommerce/backend/order/pb"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // MockRepository for testing
@@ -29,6 +33,11 @@ func (m *MockRepository) GetOrdersForAccount(ctx context.Context, accountID stri
 	return orders, args.Error(1)
 }
 
+func (m *MockRepository) DeleteOrder(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
 func (m *MockRepository) Close() {
 }
 
@@ -162,3 +171,64 @@ func assertNil(t *testing.T, actual interface{}) {
 func assertEmpty(t *testing.T, actual interface{}) {
 	assert.Empty(t, actual)
 }
+
+func TestOrderService_DeleteOrder(t *testing.T) {
+	mockRepo := new(MockRepository)
+	service := NewService(mockRepo)
+	ctx := context.Background()
+
+	mockRepo.On("DeleteOrder", ctx, "orderID").Return(nil).Once()
+
+	assert.NoError(t, service.DeleteOrder(ctx, "orderID"))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCommitWithRetry(t *testing.T) {
+	ctx := context.Background()
+
+	// Failures that may pass are retried
+	calls := 0
+	err := commitWithRetry(ctx, func(ctx context.Context) error {
+		calls++
+		if calls < commitAttempts {
+			return status.Error(codes.Unavailable, "catalog unavailable")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, commitAttempts, calls)
+
+	calls = 0
+	err = commitWithRetry(ctx, func(ctx context.Context) error {
+		calls++
+		return status.Error(codes.Aborted, "conflict")
+	})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.Equal(t, commitAttempts, calls)
+
+	// An expired reservation stays expired
+	calls = 0
+	err = commitWithRetry(ctx, func(ctx context.Context) error {
+		calls++
+		return status.Error(codes.FailedPrecondition, "reservation has expired")
+	})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Equal(t, 1, calls)
+}
+
+func TestMigrations(t *testing.T) {
+	migrations, err := migrate.Load(Migrations())
+
+	assert.NoError(t, err)
+	if assert.Len(t, migrations, 3) {
+		assert.Equal(t, int64(1), migrations[0].Version)
+		assert.Contains(t, migrations[0].Up, "CREATE TABLE IF NOT EXISTS orders")
+		assert.Contains(t, migrations[1].Up, "CREATE TABLE IF NOT EXISTS notification_outbox")
+		assert.Contains(t, migrations[2].Up, "CREATE TABLE IF NOT EXISTS audit_log")
+	}
+}
+
+func TestPolicy_PostOrderRejectsImpersonation(t *testing.T) {
+	assert.True(t, policy[pb.OrderService_PostOrder_FullMethodName].DenyImpersonation)
+	assert.False(t, policy[pb.OrderService_GetOrdersForAccount_FullMethodName].DenyImpersonation)
+}
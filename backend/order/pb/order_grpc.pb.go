@@ -26,6 +26,10 @@ const (
 // OrderServiceClient is the client API for OrderService service.
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Both RPCs read the access token from "authorization: Bearer <token>" metadata.
+// The accessToken/refreshToken fields of PostOrderRequest are only honoured as a
+// fallback for old clients.
 type OrderServiceClient interface {
 	PostOrder(ctx context.Context, in *PostOrderRequest, opts ...grpc.CallOption) (*PostOrderResponse, error)
 	GetOrdersForAccount(ctx context.Context, in *GetOrdersForAccountRequest, opts ...grpc.CallOption) (*GetOrdersForAccountResponse, error)
@@ -62,6 +66,10 @@ func (c *orderServiceClient) GetOrdersForAccount(ctx context.Context, in *GetOrd
 // OrderServiceServer is the server API for OrderService service.
 // All implementations must embed UnimplementedOrderServiceServer
 // for forward compatibility.
+//
+// Both RPCs read the access token from "authorization: Bearer <token>" metadata.
+// The accessToken/refreshToken fields of PostOrderRequest are only honoured as a
+// fallback for old clients.
 type OrderServiceServer interface {
 	PostOrder(context.Context, *PostOrderRequest) (*PostOrderResponse, error)
 	GetOrdersForAccount(context.Context, *GetOrdersForAccountRequest) (*GetOrdersForAccountResponse, error)
@@ -3,14 +3,29 @@ package order
 import (
 	"context"
 	"database/sql"
+	"embed"
+	"io/fs"
 
 	"github.com/lib/pq"
 )
 
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// Migrations returns the schema migrations of the order database, applied with the migrate package.
+func Migrations() fs.FS {
+	sub, err := fs.Sub(migrationFS, "migrations")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
 type Repository interface {
 	Close()
 	PutOrder(ctx context.Context, o Order) error
 	GetOrdersForAccount(ctx context.Context, accountID string) ([]Order, error)
+	DeleteOrder(ctx context.Context, id string) error
 }
 
 type postgresRepository struct {
@@ -76,6 +91,12 @@ func (r *postgresRepository) PutOrder(ctx context.Context, o Order) (err error)
 	return
 }
 
+// DeleteOrder removes the order together with its products.
+func (r *postgresRepository) DeleteOrder(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM orders WHERE id = $1", id)
+	return err
+}
+
 func (r *postgresRepository) GetOrdersForAccount(ctx context.Context, accountID string) ([]Order, error) {
 	rows, err := r.db.QueryContext(
 		ctx,
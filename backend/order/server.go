@@ -6,12 +6,19 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"time"
 
 	account "github.com/JonathanNithi/ecommerce/backend/account"
+	"github.com/JonathanNithi/ecommerce/backend/audit"
+	auditpb "github.com/JonathanNithi/ecommerce/backend/audit/pb"
+	"github.com/JonathanNithi/ecommerce/backend/authz"
 	catalog "github.com/JonathanNithi/ecommerce/backend/catalog"
+	"github.com/JonathanNithi/ecommerce/backend/notification"
 	"github.com/JonathanNithi/ecommerce/backend/order/pb"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
 type grpcServer struct {
@@ -19,9 +26,62 @@ type grpcServer struct {
 	service       Service
 	accountClient *account.Client
 	catalogClient *catalog.Client
+	notifier      notification.Notifier
+	verification  account.VerificationPolicy
+	audit         audit.Recorder
 }
 
-func ListenGRPC(s Service, accountURL, catalogURL string, port int) error {
+// AuditPlaceOrderForAccount is recorded when staff allowed to manage accounts order on behalf of someone else
+const AuditPlaceOrderForAccount = "order.place_for_account"
+
+// commitAttempts bounds how often committing the reservation of an order is tried
+const commitAttempts = 3
+
+// commitWithRetry commits a reservation, retrying failures that may pass since committing
+// again is safe. Reservations that cannot be committed, for instance because they expired,
+// are not retried.
+func commitWithRetry(ctx context.Context, commit func(ctx context.Context) error) error {
+	var err error
+	for attempt := 0; attempt < commitAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(time.Duration(attempt) * 100 * time.Millisecond):
+			}
+		}
+		err = commit(ctx)
+		switch status.Code(err) {
+		case codes.OK:
+			return nil
+		case codes.FailedPrecondition, codes.InvalidArgument, codes.NotFound, codes.PermissionDenied, codes.Unauthenticated:
+			return err
+		}
+	}
+	return err
+}
+
+// abandonOrder deletes an order whose stock could not be deducted and releases whatever its
+// reservation still holds, so that the customer can order again. Failures are only logged.
+func (s *grpcServer) abandonOrder(ctx context.Context, orderID string, reservationID string) {
+	if err := s.service.DeleteOrder(ctx, orderID); err != nil {
+		log.Printf("Error deleting order %s whose stock could not be deducted: %v", orderID, err)
+	}
+	if err := s.catalogClient.ReleaseReservation(ctx, reservationID); err != nil {
+		log.Printf("Error releasing reservation %s of order %s: %v", reservationID, orderID, err)
+	}
+}
+
+// policy requires a caller for every RPC. Which account's orders they may touch is
+// checked by the handlers against the account service. Staff impersonating a customer
+// may look at their orders but not place any.
+var policy = authz.Policy{
+	pb.OrderService_PostOrder_FullMethodName:            authz.Authenticated().WithoutImpersonation(),
+	pb.OrderService_GetOrdersForAccount_FullMethodName:  authz.Authenticated(),
+	auditpb.AuditService_ListAuditEvents_FullMethodName: audit.PolicyRule,
+}
+
+func ListenGRPC(s Service, n notification.Notifier, a audit.Repository, v *authz.Verifier, verification account.VerificationPolicy, accountURL, catalogURL string, port int) error {
 	accountClient, err := account.NewClient(accountURL)
 	if err != nil {
 		return err
@@ -40,27 +100,51 @@ func ListenGRPC(s Service, accountURL, catalogURL string, port int) error {
 		return err
 	}
 
-	serv := grpc.NewServer()
+	serv := grpc.NewServer(authz.ServerOptions(v, policy)...)
 	pb.RegisterOrderServiceServer(serv, &grpcServer{
 		UnimplementedOrderServiceServer: pb.UnimplementedOrderServiceServer{},
 		service:                         s,
 		accountClient:                   accountClient,
 		catalogClient:                   catalogClient,
+		notifier:                        n,
+		verification:                    verification,
+		audit:                           a,
 	})
+	audit.RegisterServer(serv, a)
 	reflection.Register(serv)
 
 	return serv.Serve(lis)
 }
 
+// accountForCaller loads the account through the account service with the caller's
+// token, so it fails unless the account belongs to the caller or they may manage accounts.
+func (s *grpcServer) accountForCaller(ctx context.Context, accountID string) (*account.Account, error) {
+	acc, err := s.accountClient.GetAccount(ctx, accountID)
+	if err != nil {
+		switch status.Code(err) {
+		case codes.Unauthenticated, codes.PermissionDenied:
+			return nil, err
+		}
+		log.Println("Error getting account: ", err)
+		return nil, errors.New("account not found")
+	}
+	return acc, nil
+}
+
 func (s *grpcServer) PostOrder(
 	ctx context.Context,
 	r *pb.PostOrderRequest,
 ) (*pb.PostOrderResponse, error) {
-	// Check if account exists - I need to provide access token and refresh token
-	_, _, _, err := s.accountClient.GetAccount(ctx, r.AccountId, r.AccessToken, r.RefreshToken)
+	// Check if account exists and belongs to the caller, the token is forwarded from ctx
+	acc, err := s.accountForCaller(ctx, r.AccountId)
 	if err != nil {
-		log.Println("Error getting account: ", err)
-		return nil, errors.New("account not found")
+		return nil, err
+	}
+	if err := account.CheckAccountStatus(acc); err != nil {
+		return nil, err
+	}
+	if !s.verification.CanOrder(acc) {
+		return nil, account.ErrEmailNotVerified
 	}
 
 	// Get ordered products
@@ -96,23 +180,69 @@ func (s *grpcServer) PostOrder(
 		}
 	}
 
+	// Reserve the stock of every product before the order is stored, so that an order
+	// either gets all of its products or fails without holding any stock
+	items := []catalog.ReservationItem{}
+	for _, p := range products {
+		items = append(items, catalog.ReservationItem{ProductID: p.ID, Quantity: int64(p.Quantity)})
+	}
+	reservation, err := s.catalogClient.ReserveStock(ctx, items)
+	if err != nil {
+		log.Println("Error reserving stock: ", err)
+		// Tell the customer which product ran out rather than that something failed
+		switch status.Code(err) {
+		case codes.FailedPrecondition, codes.InvalidArgument:
+			return nil, err
+		}
+		return nil, errors.New("could not reserve stock")
+	}
+
 	// Call service implementation
 	order, err := s.service.PostOrder(ctx, r.AccountId, products)
 	if err != nil {
 		log.Println("Error posting order: ", err)
+		if err := s.catalogClient.ReleaseReservation(context.WithoutCancel(ctx), reservation.ID); err != nil {
+			// The reservation expires in any case
+			log.Println("Error releasing reservation: ", err)
+		}
 		return nil, errors.New("could not post order")
 	}
 
-	//deduct the quantity from the stock
-	for _, p := range products {
-		log.Printf("Order Service: Deducting stock for product ID: %s, quantity: %d", p.ID, p.Quantity)
-		err = s.catalogClient.DeductStock(ctx, p.ID, int64(p.Quantity))
-		log.Printf("Order Service: DeductStock returned error for product ID %s: %v", p.ID, err)
-		if err != nil {
-			log.Println("Error deducting stock: ", err)
-			return nil, errors.New("could not deduct stock")
+	// The order is stored by now, it only stands once its stock is deducted
+	err = commitWithRetry(ctx, func(ctx context.Context) error {
+		return s.catalogClient.CommitReservation(ctx, reservation.ID)
+	})
+	if err != nil {
+		log.Printf("Error committing reservation %s of order %s: %v", reservation.ID, order.ID, err)
+		s.abandonOrder(context.WithoutCancel(ctx), order.ID, reservation.ID)
+		// The reservation expired and its stock went to someone else
+		if status.Code(err) == codes.FailedPrecondition {
+			return nil, err
 		}
-		log.Printf("Order Service: Successfully attempted to deduct stock for product ID: %s", p.ID)
+		return nil, errors.New("could not deduct stock")
+	}
+
+	// Like the confirmation below this must not fail an order that has already been placed
+	if acc.Email != authz.ClaimsFromContext(ctx).Username {
+		if err := audit.Record(ctx, s.audit, AuditPlaceOrderForAccount, order.ID, nil, order); err != nil {
+			log.Println("Error recording audit event: ", err)
+		}
+	}
+
+	// Queue the confirmation email, the order stands even if this fails
+	confirmation := notification.OrderConfirmationData{
+		OrderID:    order.ID,
+		TotalPrice: order.TotalPrice,
+	}
+	for _, p := range order.Products {
+		confirmation.Products = append(confirmation.Products, notification.OrderLine{
+			Name:     p.Name,
+			Price:    p.Price,
+			Quantity: p.Quantity,
+		})
+	}
+	if err := s.notifier.Notify(ctx, acc.Email, notification.TemplateOrderConfirmation, confirmation); err != nil {
+		log.Println("Error queueing order confirmation: ", err)
 	}
 
 	// Make response order
@@ -142,6 +272,13 @@ func (s *grpcServer) GetOrdersForAccount(
 	ctx context.Context,
 	r *pb.GetOrdersForAccountRequest,
 ) (*pb.GetOrdersForAccountResponse, error) {
+	// Staff may read any account's orders, everyone else only their own
+	if !authz.ClaimsFromContext(ctx).Can(authz.OrdersReadAll) {
+		if _, err := s.accountForCaller(ctx, r.AccountId); err != nil {
+			return nil, err
+		}
+	}
+
 	// Get orders for account
 	accountOrders, err := s.service.GetOrdersForAccount(ctx, r.AccountId)
 	if err != nil {
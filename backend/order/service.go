@@ -10,23 +10,24 @@ import (
 type Service interface {
 	PostOrder(ctx context.Context, accountID string, products []OrderedProduct) (*Order, error)
 	GetOrdersForAccount(ctx context.Context, accountID string) ([]Order, error)
+	DeleteOrder(ctx context.Context, id string) error
 }
 
 type Order struct {
-	ID         string
-	CreatedAt  time.Time
-	TotalPrice float64
-	AccountID  string
-	Products   []OrderedProduct
+	ID         string           `json:"id"`
+	CreatedAt  time.Time        `json:"created_at"`
+	TotalPrice float64          `json:"total_price"`
+	AccountID  string           `json:"account_id"`
+	Products   []OrderedProduct `json:"products"`
 }
 
 type OrderedProduct struct {
-	ID          string
-	Name        string
-	Description string
-	Price       float64
-	Quantity    uint32
-	ImageUrl    string
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+	Quantity    uint32  `json:"quantity"`
+	ImageUrl    string  `json:"image_url"`
 }
 
 type orderService struct {
@@ -63,3 +64,8 @@ func (s orderService) PostOrder(
 func (s orderService) GetOrdersForAccount(ctx context.Context, accountID string) ([]Order, error) {
 	return s.repository.GetOrdersForAccount(ctx, accountID)
 }
+
+// DeleteOrder removes an order that cannot be completed, such as one whose stock could not be deducted.
+func (s orderService) DeleteOrder(ctx context.Context, id string) error {
+	return s.repository.DeleteOrder(ctx, id)
+}
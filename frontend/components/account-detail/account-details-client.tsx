@@ -78,7 +78,7 @@ const AccountDetailsClient = () => {
         );
     }
 
-    if (!data?.accounts || data.accounts.length === 0) {
+    if (!data?.accounts || data.accounts.edges.length === 0) {
         return (
             <div>
                 <Navbar />
@@ -90,7 +90,7 @@ const AccountDetailsClient = () => {
         );
     }
 
-    const accountDetailsData = data.accounts[0]; // Now 'accountDetailsData' is of type 'accountDetails'
+    const accountDetailsData = data.accounts.edges[0].node; // Now 'accountDetailsData' is of type 'accountDetails'
     const orderHistory = accountDetailsData.orders || []; // 'orderHistory' is of type 'Order[]'
 
 
@@ -28,7 +28,10 @@ export const LOGIN_MUTATION = gql`
 
 export const REFRESH_TOKEN_MUTATION = gql`
   mutation RefreshToken($refreshToken: String!) {
-    refreshToken(input: { refreshToken: $refreshToken })
+    refreshToken(input: { refreshToken: $refreshToken }) {
+      accessToken
+      refreshToken
+    }
   }
 `;
 
@@ -7,18 +7,22 @@ import { Order } from '@/types/orders';
 export const GET_ACCOUNT_DETAILS = gql`
   query GetAccountDetails($id: String, $refreshToken: String!, $accessToken: String!) {
     accounts(id: $id, refreshToken: $refreshToken, accessToken: $accessToken) {
-      first_name
-      last_name
-      email
-      orders {
-        id
-        createdAt
-        totalPrice
-        products {
-          name
-          quantity
-          price
-          imageUrl
+      edges {
+        node {
+          first_name
+          last_name
+          email
+          orders {
+            id
+            createdAt
+            totalPrice
+            products {
+              name
+              quantity
+              price
+              imageUrl
+            }
+          }
         }
       }
     }
@@ -28,6 +32,15 @@ export const GET_ACCOUNT_DETAILS = gql`
 // Define the TypeScript interface for the account details response
 export interface AccountDetailsResponse {
   accounts: {
+    edges: {
+      node: AccountDetails;
+      __typename?: 'AccountEdge';
+    }[];
+    __typename?: 'AccountConnection';
+  };
+}
+
+export interface AccountDetails {
     first_name: string;
     last_name: string;
     email: string;
@@ -45,7 +58,6 @@ export interface AccountDetailsResponse {
       __typename?: 'Order';
     }[];
     __typename?: 'Account';
-  }[];
 }
 
 // Define the TypeScript interface for the variables required by the query